@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"math/rand"
 	"strings"
 	"sync"
 	"time"
@@ -41,44 +42,74 @@ type AssertionImpl struct {
 	zoneMap                *safeHashMap.Map
 	entriesPerAssertionMap map[string]int //a.Hash() -> int
 	mux                    sync.Mutex     //protects entriesPerAssertionMap from simultaneous access
+	//expiryJitter is the size of the window before an entry's expiration in which it is removed
+	//from the cache. Entries with the same expiration are thus refreshed at different times.
+	expiryJitter time.Duration
+	//expiries orders the cached assertions by their expiration.
+	expiries expiryIndex
+	//zoneMux is held for writing while a zone is replaced and for reading by Get such that a
+	//lookup observes either the old or the new zone but never a mix of both.
+	zoneMux sync.RWMutex
 }
 
-func NewAssertion(maxSize int) *AssertionImpl {
+//NewAssertion returns a new assertion cache holding at most maxSize assertions. The expiration of
+//each added entry is advanced by a random amount of at most expiryJitter.
+func NewAssertion(maxSize int, expiryJitter time.Duration) *AssertionImpl {
 	return &AssertionImpl{
 		cache:                  lruCache.New(),
 		counter:                safeCounter.New(maxSize),
 		zoneMap:                safeHashMap.New(),
 		entriesPerAssertionMap: make(map[string]int),
+		expiryJitter:           expiryJitter,
 	}
 }
 
-func mergeSubjectZone(subject, zone string) string {
-	if zone == "." {
-		return fmt.Sprintf("%s.", subject)
+//jitteredExpiration returns expiration advanced by a random number of seconds in
+//[0,c.expiryJitter]. The result is never before the current time unless expiration is.
+func (c *AssertionImpl) jitteredExpiration(expiration int64) int64 {
+	window := int64(c.expiryJitter / time.Second)
+	if remaining := expiration - time.Now().Unix(); remaining < window {
+		window = remaining
+	}
+	if window <= 0 {
+		return expiration
 	}
-	if subject == "" {
+	return expiration - rand.Int63n(window+1)
+}
+
+func mergeSubjectZone(subject, zone string) string {
+	if section.IsApex(subject) {
 		return zone
 	}
+	if zone == section.RootZone {
+		return fmt.Sprintf("%s.", subject)
+	}
 	return fmt.Sprintf("%s.%s", subject, zone)
 }
 
 //assertionCacheMapKey returns the key for AssertionImpl.cache based on the assertion
 func assertionCacheMapKey(name, zone, context string, oType object.Type) string {
-	key := fmt.Sprintf("%s %s %d", mergeSubjectZone(name, zone), context, oType)
+	key := fmt.Sprintf("%s %s %d", mergeSubjectZone(name, zone), section.CanonicalContext(context),
+		oType)
 	log.Debug("assertionCacheMapKey", "key", key)
 	return key
 }
 
 func assertionCacheMapKeyFQDN(fqdn, context string, oType object.Type) string {
-	key := fmt.Sprintf("%s %s %d", fqdn, context, oType)
+	key := fmt.Sprintf("%s %s %d", fqdn, section.CanonicalContext(context), oType)
 	log.Debug("assertionCacheMapKeyFQDN", "key", key)
 	return key
 }
 
 //Add adds an assertion together with an expiration time (number of seconds since 01.01.1970) to
-//the cache. It returns false if the cache is full and an element was removed according to least
+//the cache. The expiration is randomly advanced according to the cache's expiry jitter but never
+//postponed. It returns false if the cache is full and an element was removed according to least
 //recently used strategy. It also adds the shard to the consistency cache.
 func (c *AssertionImpl) Add(a *section.Assertion, expiration int64, isInternal bool) bool {
+	return c.add(a, c.jitteredExpiration(expiration), isInternal)
+}
+
+func (c *AssertionImpl) add(a *section.Assertion, expiration int64, isInternal bool) bool {
 	isFull := false
 	for _, o := range a.Content {
 		key := assertionCacheMapKey(a.SubjectName, a.SubjectZone, a.Context, o.Type)
@@ -92,7 +123,7 @@ func (c *AssertionImpl) Add(a *section.Assertion, expiration int64, isInternal b
 		value.mux.Lock()
 		if value.deleted {
 			value.mux.Unlock()
-			return c.Add(a, expiration, isInternal)
+			return c.add(a, expiration, isInternal)
 		}
 		if new {
 			val, _ := c.zoneMap.GetOrAdd(a.SubjectZone, safeHashMap.New())
@@ -100,6 +131,7 @@ func (c *AssertionImpl) Add(a *section.Assertion, expiration int64, isInternal b
 		}
 		if _, ok := value.assertions[a.Hash()]; !ok {
 			value.assertions[a.Hash()] = assertionExpiration{assertion: a, expiration: expiration}
+			c.expiries.add(value, a.Hash(), expiration)
 			c.mux.Lock()
 			c.entriesPerAssertionMap[a.Hash()]++
 			c.mux.Unlock()
@@ -154,6 +186,8 @@ func zoneHierarchy(fqdn string) []string {
 // Otherwise, a search up the domain name hierarchy is performed to get the topmost match.
 func (c *AssertionImpl) Get(fqdn, context string, objType object.Type, strict bool) ([]*section.Assertion, bool) {
 	log.Debug("get", "fqdn", fqdn)
+	c.zoneMux.RLock()
+	defer c.zoneMux.RUnlock()
 	var v interface{}
 	var ok bool
 	if strict {
@@ -186,36 +220,35 @@ func (c *AssertionImpl) Get(fqdn, context string, objType object.Type, strict bo
 	return assertions, len(assertions) > 0
 }
 
-//RemoveExpiredValues goes through the cache and removes all expired assertions from the
-//assertionCache and the consistency cache.
+//RemoveExpiredValues removes all expired assertions from the assertionCache and the consistency
+//cache. Only expired entries are touched as they are taken from an index ordered by expiration.
 func (c *AssertionImpl) RemoveExpiredValues() {
-	for _, v := range c.cache.GetAll() {
-		value := v.(*assertionCacheValue)
-		deleteCount := 0
+	for _, e := range c.expiries.popExpired(time.Now().Unix()) {
+		value := e.value.(*assertionCacheValue)
 		value.mux.Lock()
-		if value.deleted {
-			value.mux.Unlock()
-			continue
-		}
-		for key, va := range value.assertions {
-			if va.expiration < time.Now().Unix() {
-				c.mux.Lock()
-				c.entriesPerAssertionMap[va.assertion.Hash()]--
-				c.mux.Unlock()
-				delete(value.assertions, key)
-				deleteCount++
-			}
-		}
-		if len(value.assertions) == 0 {
-			value.deleted = true
-			c.cache.Remove(value.cacheKey)
-			if set, ok := c.zoneMap.Get(value.zone); ok {
-				set.(*safeHashMap.Map).Remove(value.cacheKey)
+		if va, ok := value.assertions[e.hash]; ok && !value.deleted && va.expiration == e.expiration {
+			c.mux.Lock()
+			c.entriesPerAssertionMap[e.hash]--
+			c.mux.Unlock()
+			delete(value.assertions, e.hash)
+			c.counter.Dec()
+			if len(value.assertions) == 0 {
+				value.deleted = true
+				c.cache.Remove(value.cacheKey)
+				if set, ok := c.zoneMap.Get(value.zone); ok {
+					set.(*safeHashMap.Map).Remove(value.cacheKey)
+				}
 			}
 		}
 		value.mux.Unlock()
-		c.counter.Sub(deleteCount)
 	}
+	c.expiries.compact(c.counter.Value(), func(e expiryEntry) bool {
+		value := e.value.(*assertionCacheValue)
+		value.mux.RLock()
+		defer value.mux.RUnlock()
+		va, ok := value.assertions[e.hash]
+		return ok && !value.deleted && va.expiration == e.expiration
+	})
 }
 
 //RemoveZone deletes all assertions in the assertionCache and consistencyCache of the given zone.
@@ -243,6 +276,66 @@ func (c *AssertionImpl) RemoveZone(zone string) {
 	}
 }
 
+//ReplaceZone atomically replaces the assertions of old with the ones of new. Assertions of old
+//which are not part of new are removed and the others are added together with expiration as in
+//Add. Concurrent lookups observe either all assertions of old or all of new. old might be nil in
+//which case the assertions of new are just added. The content of both zones must have their
+//context and subject zone set. It returns false if the cache is full and an element was removed
+//according to least recently used strategy.
+func (c *AssertionImpl) ReplaceZone(old, new *section.Zone, expiration int64, isInternal bool) bool {
+	c.zoneMux.Lock()
+	defer c.zoneMux.Unlock()
+	kept := make(map[string]bool)
+	for _, a := range new.Content {
+		kept[a.Hash()] = true
+	}
+	if old != nil {
+		removed := make(map[expiryEntry]bool)
+		for _, a := range old.Content {
+			if !kept[a.Hash()] {
+				c.remove(a, removed)
+			}
+		}
+		c.expiries.remove(removed)
+	}
+	isFull := false
+	for _, a := range new.Content {
+		if !c.add(a, c.jitteredExpiration(expiration), isInternal) {
+			isFull = true
+		}
+	}
+	return !isFull
+}
+
+//remove deletes a from the cache. Cache values which become empty are removed as well. The index
+//entries of the removed assertions are added to removed.
+func (c *AssertionImpl) remove(a *section.Assertion, removed map[expiryEntry]bool) {
+	for _, o := range a.Content {
+		v, ok := c.cache.Get(assertionCacheMapKey(a.SubjectName, a.SubjectZone, a.Context, o.Type))
+		if !ok {
+			continue
+		}
+		value := v.(*assertionCacheValue)
+		value.mux.Lock()
+		if va, ok := value.assertions[a.Hash()]; ok && !value.deleted {
+			removed[expiryEntry{expiration: va.expiration, value: value, hash: a.Hash()}] = true
+			c.mux.Lock()
+			c.entriesPerAssertionMap[a.Hash()]--
+			c.mux.Unlock()
+			delete(value.assertions, a.Hash())
+			c.counter.Dec()
+			if len(value.assertions) == 0 {
+				value.deleted = true
+				c.cache.Remove(value.cacheKey)
+				if set, ok := c.zoneMap.Get(value.zone); ok {
+					set.(*safeHashMap.Map).Remove(value.cacheKey)
+				}
+			}
+		}
+		value.mux.Unlock()
+	}
+}
+
 //Checkpoint returns all cached assertions
 func (c *AssertionImpl) Checkpoint() (assertions []section.Section) {
 	entries := c.cache.GetAll()
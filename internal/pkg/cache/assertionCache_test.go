@@ -3,6 +3,7 @@ package cache
 import (
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
 )
 
 func TestAssertionCache(t *testing.T) {
@@ -153,3 +155,97 @@ func TestAssertionCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestAssertionExpiryJitter(t *testing.T) {
+	jitter := 10 * time.Minute
+	var tests = []struct {
+		validFor time.Duration
+		window   int64
+	}{
+		{time.Hour, int64(jitter / time.Second)},
+		{time.Minute, 60}, //jitter is bounded by the remaining validity
+	}
+	for i, test := range tests {
+		c := NewAssertion(1000, jitter)
+		validUntil := time.Now().Add(test.validFor).Unix()
+		for j := 0; j < 100; j++ {
+			a := &section.Assertion{SubjectName: fmt.Sprintf("name%d", j), SubjectZone: "ch.",
+				Context: ".", Content: []object.Object{object.NameObject()}}
+			c.Add(a, validUntil, false)
+		}
+		expirations := make(map[int64]bool)
+		min := validUntil
+		for _, v := range c.cache.GetAll() {
+			for _, ae := range v.(*assertionCacheValue).assertions {
+				if ae.expiration > validUntil || ae.expiration < validUntil-test.window {
+					t.Errorf("%d: expiration outside of jitter window expected=[%d,%d] actual=%d", i,
+						validUntil-test.window, validUntil, ae.expiration)
+				}
+				expirations[ae.expiration] = true
+				if ae.expiration < min {
+					min = ae.expiration
+				}
+			}
+		}
+		if len(expirations) < 10 || min > validUntil-test.window/2 {
+			t.Errorf("%d: expirations are not spread across the jitter window: %v", i, expirations)
+		}
+	}
+}
+
+func TestAssertionReplaceZone(t *testing.T) {
+	//zoneVersion returns version v of zone ch. where every assertion carries v as its address.
+	zoneVersion := func(v int) *section.Zone {
+		z := &section.Zone{SubjectZone: "ch.", Context: "."}
+		for _, name := range []string{"a", "b"} {
+			z.Content = append(z.Content, &section.Assertion{SubjectName: name, SubjectZone: "ch.",
+				Context: ".", Content: []object.Object{
+					object.Object{Type: object.OTIP4Addr, Value: fmt.Sprintf("192.0.2.%d", v)}}})
+		}
+		return z
+	}
+	c := NewAssertion(1000, 0)
+	expiration := time.Now().Add(time.Hour).Unix()
+	zones := []*section.Zone{zoneVersion(0), zoneVersion(1)}
+	if ok := c.ReplaceZone(nil, zones[0], expiration, false); !ok || c.Len() != 2 {
+		t.Fatalf("initial zone not added correctly len=%d", c.Len())
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, name := range []string{"a.ch.", "b.ch."} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				assertions, ok := c.Get(name, ".", object.OTIP4Addr, true)
+				if !ok || len(assertions) != 1 {
+					t.Errorf("mixed-version read of %s: %v", name, assertions)
+					return
+				}
+			}
+		}(name)
+	}
+	for i := 0; i < 1000; i++ {
+		c.ReplaceZone(zones[i%2], zones[(i+1)%2], expiration, false)
+	}
+	close(done)
+	wg.Wait()
+	if c.Len() != 2 {
+		t.Errorf("wrong number of cached assertions after replacements expected=2 actual=%d", c.Len())
+	}
+	if c.expiries.len() != 2 {
+		t.Errorf("replaced assertions were not removed from the expiry index expected=2 actual=%d",
+			c.expiries.len())
+	}
+	for _, name := range []string{"a.ch.", "b.ch."} {
+		assertions, _ := c.Get(name, ".", object.OTIP4Addr, true)
+		if len(assertions) != 1 || assertions[0].Content[0].Value != "192.0.2.0" {
+			t.Errorf("%s does not hold the last zone version: %v", name, assertions)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//NoStore is a Store without any assertions. It is the default store of a server such that every
+//cache miss is final.
+type NoStore struct{}
+
+//GetAssertion always returns false.
+func (NoStore) GetAssertion(context, zone, name string, objType object.Type) (
+	[]*section.Assertion, bool) {
+	return nil, false
+}
+
+//ReadThrough returns true and the assertions about name in zone and context containing an object
+//of type objType. They are taken from c if it has some. Otherwise, they are looked up in store and
+//added to c as external entries which expire with the assertions' validity.
+func ReadThrough(c Assertion, store Store, context, zone, name string, objType object.Type) (
+	[]*section.Assertion, bool) {
+	fqdn := (&section.Assertion{SubjectName: name, SubjectZone: zone}).FQDN()
+	if assertions, ok := c.Get(fqdn, context, objType, true); ok {
+		return assertions, true
+	}
+	assertions, ok := store.GetAssertion(context, zone, name, objType)
+	if !ok {
+		return nil, false
+	}
+	for _, a := range assertions {
+		c.Add(a, a.ValidUntil(), false)
+	}
+	return assertions, true
+}
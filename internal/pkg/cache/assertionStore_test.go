@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestReadThrough(t *testing.T) {
+	delegations := getExampleDelgations("ch")[:1]
+	store := &fakeStore{assertions: map[string][]*section.Assertion{"ch.": delegations}}
+	c := NewAssertion(10, 0)
+	var tests = []struct {
+		name    string
+		objType object.Type
+		want    []*section.Assertion
+		lookups int
+	}{
+		{"ch", object.OTDelegation, delegations, 1}, //cache miss served from the store
+		{"ch", object.OTDelegation, delegations, 1}, //cached by the previous lookup
+		{"ch", object.OTIP4Addr, nil, 2},
+		{"org", object.OTDelegation, nil, 3},
+	}
+	for i, test := range tests {
+		assertions, ok := ReadThrough(c, store, ".", ".", test.name, test.objType)
+		if ok != (test.want != nil) || !reflect.DeepEqual(assertions, test.want) {
+			t.Errorf("%d: wrong assertions expected=%v actual=%v", i, test.want, assertions)
+		}
+		if store.lookups != test.lookups {
+			t.Errorf("%d: wrong number of store lookups expected=%d actual=%d", i, test.lookups,
+				store.lookups)
+		}
+	}
+	if c.Len() != 1 {
+		t.Errorf("only the assertion from the store must be cached actual=%d", c.Len())
+	}
+	if _, ok := ReadThrough(NewAssertion(10, 0), NoStore{}, ".", ".", "ch", object.OTDelegation); ok {
+		t.Error("NoStore must not serve any assertion")
+	}
+}
+
+//fakeStore serves the assertions stored under their fully qualified name and counts the lookups.
+type fakeStore struct {
+	assertions map[string][]*section.Assertion
+	lookups    int
+}
+
+func (s *fakeStore) GetAssertion(context, zone, name string, objType object.Type) (
+	[]*section.Assertion, bool) {
+	s.lookups++
+	var result []*section.Assertion
+	for _, a := range s.assertions[(&section.Assertion{SubjectName: name, SubjectZone: zone}).FQDN()] {
+		for _, o := range a.Content {
+			if o.Type == objType && a.Context == context {
+				result = append(result, a)
+				break
+			}
+		}
+	}
+	return result, len(result) > 0
+}
@@ -15,6 +15,42 @@ import (
 	"golang.org/x/crypto/ed25519"
 )
 
+func TestGlobalContextLookup(t *testing.T) {
+	now := time.Now()
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}}}
+	assertions := NewAssertion(10, 0)
+	assertions.Add(a, now.Add(time.Hour).Unix(), false)
+	pub, _, _ := ed25519.GenerateKey(nil)
+	key := keys.PublicKey{
+		PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeySpace: keys.RainsKeySpace},
+		ValidSince:  now.Unix(),
+		ValidUntil:  now.Add(time.Hour).Unix(),
+		Key:         pub,
+	}
+	zoneKeys := NewZoneKey(10, 10, 10, 0)
+	zoneKeys.Add(a, key, false)
+	negAssertions := NewNegAssertion(10)
+	negAssertions.AddZone(&section.Zone{SubjectZone: "ch.", Context: "."}, now.Add(time.Hour).Unix(),
+		false)
+	meta := signature.MetaData{PublicKeyID: key.PublicKeyID, ValidSince: key.ValidSince,
+		ValidUntil: key.ValidUntil}
+	for i, ctx := range []string{".", "", section.GlobalContext} {
+		if _, ok := assertions.Get("ethz.ch.", ctx, object.OTIP4Addr, true); !ok {
+			t.Errorf("%d: assertion in the global context not found with context %q", i, ctx)
+		}
+		if _, _, ok := zoneKeys.Get("ethz.ch.", ctx, meta); !ok {
+			t.Errorf("%d: public key in the global context not found with context %q", i, ctx)
+		}
+		if _, ok := negAssertions.Get("ch.", ctx, section.TotalInterval{}); !ok {
+			t.Errorf("%d: zone in the global context not found with context %q", i, ctx)
+		}
+	}
+	if _, ok := assertions.Get("ethz.ch.", "cx-example.com.", object.OTIP4Addr, true); ok {
+		t.Error("assertion in the global context found in another context")
+	}
+}
+
 func TestZoneCtxKey(t *testing.T) {
 	var tests = []struct {
 		zone    string
@@ -26,12 +26,17 @@ type connCacheValue struct {
 type ConnectionImpl struct {
 	cache   *lruCache.Cache
 	counter *safeCounter.Counter
+	//maxPerServer is the maximal number of connections cached per destination. 0 means no limit.
+	maxPerServer int
 }
 
-func NewConnection(maxSize int) *ConnectionImpl {
+//NewConnection returns a connection cache holding at most maxSize connections of which at most
+//maxPerServer go to the same destination. maxPerServer 0 disables the per destination limit.
+func NewConnection(maxSize, maxPerServer int) *ConnectionImpl {
 	return &ConnectionImpl{
-		cache:   lruCache.New(),
-		counter: safeCounter.New(maxSize),
+		cache:        lruCache.New(),
+		counter:      safeCounter.New(maxSize),
+		maxPerServer: maxPerServer,
 	}
 }
 
@@ -39,12 +44,18 @@ func networkAddr(addr net.Addr) string {
 	return fmt.Sprintf("%s %s", addr.Network(), addr.String())
 }
 
-//AddConnection adds conn to the cache. If the cache is full the least recently used connection is removed.
-func (c *ConnectionImpl) AddConnection(conn net.Conn) {
+//AddConnection adds conn to the cache and returns true. If the cache is full the least recently
+//used connection is removed. It returns false and does not add conn if the cache already holds
+//maxPerServer connections to conn's destination.
+func (c *ConnectionImpl) AddConnection(conn net.Conn) bool {
 	v := &connCacheValue{connections: []net.Conn{}}
 	e, _ := c.cache.GetOrAdd(networkAddr(conn.RemoteAddr()), v, false)
 	value := e.(*connCacheValue)
 	value.mux.Lock()
+	if c.maxPerServer > 0 && len(value.connections) >= c.maxPerServer {
+		value.mux.Unlock()
+		return false
+	}
 	value.connections = append(value.connections, conn)
 	value.mux.Unlock()
 	if c.counter.Inc() {
@@ -70,6 +81,7 @@ func (c *ConnectionImpl) AddConnection(conn net.Conn) {
 			break
 		}
 	}
+	return true
 }
 
 //AddCapability adds capabilities to the destAddr entry. It returns false if there is no entry in
@@ -94,6 +94,48 @@ func TestConnectionCache(t *testing.T) {
 	}
 }
 
+func TestConnectionCacheMaxPerServer(t *testing.T) {
+	c := NewConnection(10, 2)
+	server1 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 55553}
+	server2 := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 55553}
+	for i := 0; i < 5; i++ {
+		added := c.AddConnection(&addrConn{remote: server1})
+		if added != (i < 2) {
+			t.Errorf("%d: wrong result when adding a connection to server1 expected=%v actual=%v",
+				i, i < 2, added)
+		}
+	}
+	if conns, ok := c.GetConnection(server1); !ok || len(conns) != 2 {
+		t.Errorf("server1 must hold 2 connections actual=%v", conns)
+	}
+	if !c.AddConnection(&addrConn{remote: server2}) {
+		t.Error("server2 must be dialable when server1 is at its limit")
+	}
+	if c.Len() != 3 {
+		t.Errorf("size is incorrect expected=3 actual=%d", c.Len())
+	}
+	//a removed connection frees a slot for its destination.
+	conns, _ := c.GetConnection(server1)
+	c.CloseAndRemoveConnection(conns[0])
+	if !c.AddConnection(&addrConn{remote: server1}) {
+		t.Error("server1 must accept a connection after one was removed")
+	}
+}
+
+//addrConn is a connection to remote which does not send or receive anything.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *addrConn) Close() error {
+	return nil
+}
+
 func mockServer(tcpAddr string, t *testing.T) {
 	ln, err := net.Listen("tcp", tcpAddr)
 	if err != nil {
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+/*
+ *	Delegation cache implementation
+ */
+type DelegationImpl struct {
+	cache   *lruCache.Cache
+	maxSize int
+	//size is the number of non internal delegations in the cache.
+	size int
+	//pinned counts for each zone the pins protecting its delegation from removal.
+	pinned map[string]int
+	mux    sync.Mutex
+	//now returns the current time. It is used to determine the age of cached delegations.
+	now func() time.Time
+}
+
+type delegationValue struct {
+	zone      string
+	assertion *section.Assertion
+	internal  bool
+	//added is the time at which the delegation has been added to the cache.
+	added time.Time
+}
+
+//NewDelegation returns a delegation cache holding at most maxSize non internal delegations, unless
+//more of them are pinned.
+func NewDelegation(maxSize int) *DelegationImpl {
+	return &DelegationImpl{
+		cache:   lruCache.New(),
+		maxSize: maxSize,
+		pinned:  make(map[string]int),
+		now:     time.Now,
+	}
+}
+
+//SetClock replaces the function the cache uses to obtain the current time. It is intended for
+//tests.
+func (c *DelegationImpl) SetClock(now func() time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = now
+}
+
+//Add adds the delegation assertion a for zone to the cache, replacing a cached delegation for
+//zone. It returns false if the cache is full and the least recently used delegation which is
+//neither internal nor pinned has been removed. Internal delegations are only removed when they are
+//replaced. A delegation which is not internal and not yet valid is not added.
+func (c *DelegationImpl) Add(zone string, a *section.Assertion, isInternal bool) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !isInternal && a.ValidSince() > c.now().Unix() {
+		log.Debug("not caching delegation which is not yet valid", "zone", zone, "validSince",
+			a.ValidSince())
+		return true
+	}
+	if v, ok := c.cache.Remove(zone); ok && !v.(*delegationValue).internal {
+		c.size--
+	}
+	c.cache.GetOrAdd(zone, &delegationValue{zone: zone, assertion: a, internal: isInternal,
+		added: c.now()}, isInternal)
+	if isInternal {
+		return true
+	}
+	c.size++
+	return !c.evict()
+}
+
+//evict removes least recently used delegations which are not pinned until the cache is within its
+//capacity. Pinned delegations are moved to the front of the lru list when they are skipped. It
+//returns true if a delegation has been removed.
+func (c *DelegationImpl) evict() bool {
+	evicted := false
+	for tries := c.size; c.size > c.maxSize && tries > 0; tries-- {
+		zone, _ := c.cache.GetLeastRecentlyUsed()
+		if c.pinned[zone] > 0 {
+			c.cache.Get(zone)
+			continue
+		}
+		c.cache.Remove(zone)
+		c.size--
+		evicted = true
+	}
+	return evicted
+}
+
+//Get returns true and the delegation for zone if it is cached and valid by now. Otherwise nil and
+//false is returned.
+func (c *DelegationImpl) Get(zone string) (*section.Assertion, bool) {
+	a, _, ok := c.GetWithAge(zone)
+	return a, ok
+}
+
+//GetWithAge behaves like Get but additionally returns the time which has passed since the
+//delegation has been added to the cache.
+func (c *DelegationImpl) GetWithAge(zone string) (*section.Assertion, time.Duration, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if v, ok := c.cache.Get(zone); ok {
+		v := v.(*delegationValue)
+		if now := c.now(); v.assertion.ValidSince() <= now.Unix() {
+			return v.assertion, now.Sub(v.added), true
+		}
+	}
+	return nil, 0, false
+}
+
+//Pin protects the delegation for zone from being removed until it is unpinned.
+func (c *DelegationImpl) Pin(zone string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.pinned[zone]++
+}
+
+//Unpin releases a pin on the delegation for zone. Delegations exceeding the capacity which could
+//not be removed while zone was pinned are removed now.
+func (c *DelegationImpl) Unpin(zone string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.pinned[zone]--; c.pinned[zone] <= 0 {
+		delete(c.pinned, zone)
+		c.evict()
+	}
+}
+
+//Len returns the number of delegations currently in the cache.
+func (c *DelegationImpl) Len() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.cache.Len()
+}
+
+//Snapshot returns the delegations currently in the cache sorted by zone. The returned assertions
+//are copies such that the snapshot is not affected by later changes to the cache.
+func (c *DelegationImpl) Snapshot() []DelegationEntry {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	entries := []DelegationEntry{}
+	for _, v := range c.cache.GetAll() {
+		v := v.(*delegationValue)
+		a := v.assertion.Copy(v.assertion.Context, v.assertion.SubjectZone)
+		a.Content = append([]object.Object{}, a.Content...)
+		entries = append(entries, DelegationEntry{
+			Zone:       v.zone,
+			Assertion:  a,
+			ValidSince: a.ValidSince(),
+			ValidUntil: a.ValidUntil(),
+			Internal:   v.internal,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Zone < entries[j].Zone })
+	return entries
+}
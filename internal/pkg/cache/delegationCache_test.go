@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestDelegationCacheEviction(t *testing.T) {
+	c := NewDelegation(2)
+	root := &section.Assertion{SubjectZone: ".", SubjectName: "@"}
+	c.Add(".", root, true)
+	for _, zone := range []string{"ch.", "com."} {
+		if !c.Add(zone, &section.Assertion{SubjectName: zone}, false) {
+			t.Fatalf("delegation %s must be added without eviction", zone)
+		}
+	}
+	c.Get("ch.") //ch. is now more recently used than com.
+	if c.Add("org.", &section.Assertion{SubjectName: "org."}, false) {
+		t.Error("exceeding the capacity must evict a delegation")
+	}
+	c.Pin("ch.")
+	c.Add("net.", &section.Assertion{SubjectName: "net."}, false) //evicts org., ch. is pinned
+	var tests = []struct {
+		zone   string
+		cached bool
+	}{
+		{".", true},
+		{"ch.", true},
+		{"com.", false},
+		{"org.", false},
+		{"net.", true},
+	}
+	for i, test := range tests {
+		if _, ok := c.Get(test.zone); ok != test.cached {
+			t.Errorf("%d: wrong cache content for %s expected=%v actual=%v", i, test.zone, test.cached,
+				ok)
+		}
+	}
+	if c.Len() != 3 {
+		t.Errorf("wrong cache size expected=3 actual=%d", c.Len())
+	}
+	c.Unpin("ch.")
+	c.Add("org.", &section.Assertion{SubjectName: "org."}, false) //evicts ch.
+	if _, ok := c.Get("ch."); ok {
+		t.Error("unpinned delegation must be evicted")
+	}
+	if a, ok := c.Get("."); !ok || a != root {
+		t.Error("internal delegation must not be evicted")
+	}
+}
+
+func TestDelegationEntryStaleBy(t *testing.T) {
+	entry := DelegationEntry{Zone: "ch.", ValidSince: 1000, ValidUntil: 2000}
+	var tests = []struct {
+		now  int64
+		want time.Duration
+	}{
+		{1500, 0},                      //fresh
+		{2000, 0},                      //valid until the end of validUntil
+		{2001, time.Second},            //just expired
+		{2000 + 86400, 24 * time.Hour}, //long expired
+	}
+	for i, test := range tests {
+		if got := entry.StaleBy(test.now); got != test.want {
+			t.Errorf("%d: wrong staleness expected=%v actual=%v", i, test.want, got)
+		}
+	}
+}
+
+func TestDelegationCacheNotBefore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewDelegation(10)
+	c.SetClock(func() time.Time { return now })
+	root := &section.Assertion{SubjectZone: ".", SubjectName: "@"}
+	root.SetValidSince(1100)
+	c.Add(".", root, true)
+	future := &section.Assertion{SubjectZone: ".", SubjectName: "ch"}
+	future.SetValidSince(1100)
+	c.Add("ch.", future, false)
+	if c.Len() != 1 {
+		t.Errorf("a delegation which is not yet valid must not be cached, cache size=%d", c.Len())
+	}
+	if _, ok := c.Get("."); ok {
+		t.Error("a delegation must not be served before its validity starts")
+	}
+	now = time.Unix(1100, 0)
+	if a, _, ok := c.GetWithAge("."); !ok || a != root {
+		t.Error("a delegation must be served once its validity started")
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"container/heap"
+	"sync"
+)
+
+//minExpiryCompaction is the number of stale entries an expiry index may hold in addition to twice
+//the number of cached entries before it is compacted.
+const minExpiryCompaction = 1024
+
+//expiryEntry references the section with the given hash stored in value which expires at
+//expiration.
+type expiryEntry struct {
+	expiration int64
+	value      interface{}
+	hash       string
+}
+
+//expiryHeap is a min-heap of expiry entries ordered by their expiration. It implements
+//heap.Interface.
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiration < h[j].expiration }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x interface{}) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+//expiryIndex orders the entries of a cache by their expiration such that expired entries are found
+//without scanning the whole cache. Entries are not removed from the index when they are evicted
+//from the cache. The cache ignores such stale entries when they are popped and compacts the index
+//when it holds too many of them. It is safe for concurrent use.
+type expiryIndex struct {
+	mux  sync.Mutex
+	heap expiryHeap
+}
+
+//add inserts an entry for the section with hash stored in value.
+func (i *expiryIndex) add(value interface{}, hash string, expiration int64) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	heap.Push(&i.heap, expiryEntry{expiration: expiration, value: value, hash: hash})
+}
+
+//popExpired removes and returns all entries which expire before now.
+func (i *expiryIndex) popExpired(now int64) []expiryEntry {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	var expired []expiryEntry
+	for len(i.heap) > 0 && i.heap[0].expiration < now {
+		expired = append(expired, heap.Pop(&i.heap).(expiryEntry))
+	}
+	return expired
+}
+
+//remove deletes the given entries from the index.
+func (i *expiryIndex) remove(entries map[expiryEntry]bool) {
+	if len(entries) == 0 {
+		return
+	}
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	kept := i.heap[:0]
+	for _, e := range i.heap {
+		if !entries[e] {
+			kept = append(kept, e)
+		}
+	}
+	i.heap = kept
+	heap.Init(&i.heap)
+}
+
+//len returns the number of entries in the index including stale ones.
+func (i *expiryIndex) len() int {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	return len(i.heap)
+}
+
+//compact removes all entries for which isLive returns false if the index holds more than twice
+//cached entries plus minExpiryCompaction. isLive is called without holding the index's lock such
+//that it can acquire the lock of the referenced cache value. Entries added in the mean time are
+//kept. compact must not run concurrently with popExpired.
+func (i *expiryIndex) compact(cached int, isLive func(e expiryEntry) bool) {
+	i.mux.Lock()
+	if len(i.heap) <= 2*cached+minExpiryCompaction {
+		i.mux.Unlock()
+		return
+	}
+	entries := i.heap
+	i.heap = nil
+	i.mux.Unlock()
+	live := expiryHeap{}
+	for _, e := range entries {
+		if isLive(e) {
+			live = append(live, e)
+		}
+	}
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	i.heap = append(live, i.heap...)
+	heap.Init(&i.heap)
+}
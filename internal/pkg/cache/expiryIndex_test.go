@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestExpiryIndex(t *testing.T) {
+	index := expiryIndex{}
+	for _, exp := range []int64{50, 10, 40, 20, 30} {
+		index.add(nil, strconv.FormatInt(exp, 10), exp)
+	}
+	expired := index.popExpired(35)
+	if len(expired) != 3 || index.len() != 2 {
+		t.Fatalf("wrong number of expired entries expired=%v remaining=%d", expired, index.len())
+	}
+	for i, want := range []int64{10, 20, 30} {
+		if expired[i].expiration != want || expired[i].hash != strconv.FormatInt(want, 10) {
+			t.Errorf("%d: entries are not popped in order of expiration: %v", i, expired)
+		}
+	}
+	//compaction only happens when there are enough stale entries.
+	index.compact(0, func(e expiryEntry) bool { return false })
+	if index.len() != 2 {
+		t.Errorf("index was compacted below the threshold actual=%d", index.len())
+	}
+	for i := 0; i < minExpiryCompaction; i++ {
+		index.add(nil, "stale", 100)
+	}
+	index.compact(0, func(e expiryEntry) bool { return e.hash != "stale" })
+	if expired := index.popExpired(101); index.len() != 0 || len(expired) != 2 ||
+		expired[0].expiration != 40 || expired[1].expiration != 50 {
+		t.Errorf("compaction did not keep exactly the live entries in order: %v", expired)
+	}
+}
+
+func TestRemoveExpiredValuesEvicted(t *testing.T) {
+	c := NewAssertion(3, 0)
+	expired := time.Now().Add(-time.Minute).Unix()
+	valid := time.Now().Add(time.Hour).Unix()
+	a := benchAssertion(0)
+	c.Add(a, expired, false)
+	//the expired assertion is evicted by the lru strategy and added again with a later expiration.
+	c.Add(benchAssertion(1), valid, false)
+	c.Add(benchAssertion(2), valid, false)
+	c.Add(a, valid, false)
+	c.RemoveExpiredValues()
+	if _, ok := c.Get(a.FQDN(), a.Context, object.OTIP4Addr, false); !ok || c.Len() != 2 {
+		t.Errorf("a stale index entry removed a valid assertion len=%d", c.Len())
+	}
+	c.Add(benchAssertion(3), expired, false)
+	c.RemoveExpiredValues()
+	if _, ok := c.Get(benchAssertion(3).FQDN(), ".", object.OTIP4Addr, false); ok {
+		t.Error("expired assertion was not removed")
+	}
+}
+
+func benchAssertion(i int) *section.Assertion {
+	return &section.Assertion{SubjectName: "name" + strconv.Itoa(i), SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}}}
+}
+
+//scanExpiredValues removes the expired assertions of c by scanning all entries as the cache did
+//before it had an expiry index.
+func scanExpiredValues(c *AssertionImpl) {
+	for _, v := range c.cache.GetAll() {
+		value := v.(*assertionCacheValue)
+		deleteCount := 0
+		value.mux.Lock()
+		if value.deleted {
+			value.mux.Unlock()
+			continue
+		}
+		for key, va := range value.assertions {
+			if va.expiration < time.Now().Unix() {
+				c.mux.Lock()
+				c.entriesPerAssertionMap[va.assertion.Hash()]--
+				c.mux.Unlock()
+				delete(value.assertions, key)
+				deleteCount++
+			}
+		}
+		if len(value.assertions) == 0 {
+			value.deleted = true
+			c.cache.Remove(value.cacheKey)
+		}
+		value.mux.Unlock()
+		c.counter.Sub(deleteCount)
+	}
+}
+
+//BenchmarkRemoveExpiredValues reaps 100 expired assertions from a cache holding 100k valid ones.
+func BenchmarkRemoveExpiredValues(b *testing.B) {
+	reapers := []struct {
+		name string
+		reap func(c *AssertionImpl)
+	}{
+		{"Scan", scanExpiredValues},
+		{"Heap", func(c *AssertionImpl) { c.RemoveExpiredValues() }},
+	}
+	for _, r := range reapers {
+		b.Run(r.name, func(b *testing.B) {
+			c := NewAssertion(200000, 0)
+			valid := time.Now().Add(time.Hour).Unix()
+			for i := 0; i < 100000; i++ {
+				c.Add(benchAssertion(i), valid, false)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				expired := time.Now().Add(-time.Minute).Unix()
+				for j := 0; j < 100; j++ {
+					c.Add(benchAssertion(100000+j), expired, false)
+				}
+				b.StartTimer()
+				r.reap(c)
+			}
+		})
+	}
+}
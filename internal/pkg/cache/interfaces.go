@@ -2,6 +2,7 @@ package cache
 
 import (
 	"net"
+	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
@@ -14,9 +15,11 @@ import (
 
 //Connection stores persistent stream-oriented network connections.
 type Connection interface {
-	//AddConnection adds conn to the cache. If the cache capacity is reached, a connection from the cache will be
-	//chosen by some metric, closed and removed.
-	AddConnection(conn net.Conn)
+	//AddConnection adds conn to the cache and returns true. If the cache capacity is reached, a connection from the
+	//cache will be chosen by some metric, closed and removed. It returns false and does not add conn if the cache
+	//already holds the configured maximum of connections to conn's destination. The caller should then use one of
+	//those connections instead.
+	AddConnection(conn net.Conn) bool
 	//AddCapability adds capabilities to the destAddr entry. It returns false if there is no entry
 	//in the cache for dstAddr. If there is already a capability list associated with destAddr, it
 	//will be overwritten.
@@ -75,11 +78,15 @@ type ZonePublicKey interface {
 
 type PendingKey interface {
 	//Add adds ss to the cache together with the token and expiration time of the query sent to the
-	//host with the addr defined in ss.
-	Add(ss util.MsgSectionSender, t token.Token, expiration int64)
+	//host with the addr defined in ss. It returns true if the delegation query with token t can be
+	//sent. Otherwise, the maximum number of outstanding delegation queries is reached and ss is
+	//parked until Unpark returns it.
+	Add(ss util.MsgSectionSender, t token.Token, expiration int64) bool
 	//GetAndRemove returns util.MsgSectionSender which corresponds to token and true, and deletes it from
 	//the cache. False is returned if no util.MsgSectionSender matched token.
 	GetAndRemove(t token.Token) (util.MsgSectionSender, bool)
+	//Unpark removes and returns the oldest parked sections for which a delegation query can be sent.
+	Unpark() []util.MsgSectionSender
 	//ContainsToken returns true if t is cached
 	ContainsToken(t token.Token) bool
 	//RemoveExpiredValues deletes all expired entries. It logs the host's addr which was not able to
@@ -119,12 +126,24 @@ type Assertion interface {
 	//RemoveZone deletes all assertions in the assertionCache and consistencyCache of the given
 	//zone.
 	RemoveZone(zone string)
+	//ReplaceZone atomically replaces the assertions of old with the ones of new such that
+	//concurrent lookups observe either all assertions of old or all of new. old might be nil. It
+	//returns false if the cache is full and a non internal element has been removed.
+	ReplaceZone(old, new *section.Zone, expiration int64, isInternal bool) bool
 	//Checkpoint returns all cached assertions
 	Checkpoint() []section.Section
 	//Len returns the number of elements in the cache.
 	Len() int
 }
 
+//Store is a persistent store of assertions backing an assertion cache. An authoritative server
+//whose zones do not fit into its assertion cache reads through it when an assertion is not cached.
+type Store interface {
+	//GetAssertion returns true and the assertions about name in zone and context which contain an
+	//object of type objType. It returns false if there are none.
+	GetAssertion(context, zone, name string, objType object.Type) ([]*section.Assertion, bool)
+}
+
 type NegativeAssertion interface {
 	//Add adds shard together with an expiration time (number of seconds since 01.01.1970) to
 	//the cache. It returns false if the cache is full and a non internal element has been removed
@@ -153,3 +172,47 @@ type NegativeAssertion interface {
 	//Len returns the number of elements in the cache.
 	Len() int
 }
+
+//Delegation stores the delegation assertions a resolver has learned, indexed by the name of the
+//delegated zone.
+type Delegation interface {
+	//Add adds the delegation assertion a for zone to the cache, replacing a cached delegation for
+	//zone. It returns false if the cache is full and the least recently used delegation which is
+	//neither internal nor pinned has been removed. Internal delegations are only removed when
+	//they are replaced. A delegation which is not internal and not yet valid is not added.
+	Add(zone string, a *section.Assertion, isInternal bool) bool
+	//Get returns true and the delegation for zone if it is cached and its validity has started.
+	//Otherwise nil and false is returned.
+	Get(zone string) (*section.Assertion, bool)
+	//GetWithAge behaves like Get but additionally returns the time which has passed since the
+	//delegation has been added to the cache.
+	GetWithAge(zone string) (*section.Assertion, time.Duration, bool)
+	//Pin protects the delegation for zone from being removed until it is unpinned. zone does not
+	//have to be cached yet. Pins are counted, each call to Pin must be followed by one to Unpin.
+	Pin(zone string)
+	//Unpin releases a pin on the delegation for zone.
+	Unpin(zone string)
+	//Len returns the number of delegations currently in the cache.
+	Len() int
+	//Snapshot returns the delegations currently in the cache sorted by zone.
+	Snapshot() []DelegationEntry
+}
+
+//DelegationEntry describes a cached delegation.
+type DelegationEntry struct {
+	Zone       string
+	Assertion  *section.Assertion
+	ValidSince int64
+	ValidUntil int64
+	//Internal is true for delegations which are never evicted, e.g. the root delegation.
+	Internal bool
+}
+
+//StaleBy returns the time which has passed at now since the delegation expired. It returns 0 if
+//the delegation is still valid at now. now is a unix timestamp in seconds.
+func (e DelegationEntry) StaleBy(now int64) time.Duration {
+	if now <= e.ValidUntil {
+		return 0
+	}
+	return time.Duration(now-e.ValidUntil) * time.Second
+}
@@ -35,6 +35,8 @@ type NegAssertionImpl struct {
 	cache   *lruCache.Cache
 	counter *safeCounter.Counter
 	zoneMap *safeHashMap.Map
+	//expiries orders the cached sections by their expiration.
+	expiries expiryIndex
 }
 
 func NewNegAssertion(maxSize int) *NegAssertionImpl {
@@ -71,7 +73,7 @@ func (c *NegAssertionImpl) AddZone(zone *section.Zone, expiration int64, isInter
 //recently used strategy.
 func add(c *NegAssertionImpl, s section.WithSigForward, expiration int64, isInternal bool) bool {
 	isFull := false
-	key := zoneCtxKey(s.GetSubjectZone(), s.GetContext())
+	key := zoneCtxKey(s.GetSubjectZone(), section.CanonicalContext(s.GetContext()))
 	cacheValue := negAssertionCacheValue{
 		sections: make(map[string]sectionExpiration),
 		cacheKey: key,
@@ -90,6 +92,7 @@ func add(c *NegAssertionImpl, s section.WithSigForward, expiration int64, isInte
 	}
 	if _, ok := value.sections[s.Hash()]; !ok {
 		value.sections[s.Hash()] = sectionExpiration{section: s, expiration: expiration}
+		c.expiries.add(value, s.Hash(), expiration)
 		isFull = c.counter.Inc()
 	}
 	value.mux.Unlock()
@@ -119,7 +122,7 @@ func add(c *NegAssertionImpl, s section.WithSigForward, expiration int64, isInte
 //Get returns true and a set of assertions matching the given key if there exist some. Otherwise
 //nil and false is returned.
 func (c *NegAssertionImpl) Get(zone, context string, interval section.Interval) ([]section.WithSigForward, bool) {
-	key := zoneCtxKey(zone, context)
+	key := zoneCtxKey(zone, section.CanonicalContext(context))
 	v, ok := c.cache.Get(key)
 	if !ok {
 		return nil, false
@@ -139,32 +142,32 @@ func (c *NegAssertionImpl) Get(zone, context string, interval section.Interval)
 	return secs, len(secs) > 0
 }
 
-//RemoveExpiredValues goes through the cache and removes all expired shards and zones.
+//RemoveExpiredValues removes all expired shards and zones. Only expired entries are touched as
+//they are taken from an index ordered by expiration.
 func (c *NegAssertionImpl) RemoveExpiredValues() {
-	for _, v := range c.cache.GetAll() {
-		value := v.(*negAssertionCacheValue)
-		deleteCount := 0
+	for _, e := range c.expiries.popExpired(time.Now().Unix()) {
+		value := e.value.(*negAssertionCacheValue)
 		value.mux.Lock()
-		if value.deleted {
-			value.mux.Unlock()
-			continue
-		}
-		for key, va := range value.sections {
-			if va.expiration < time.Now().Unix() {
-				delete(value.sections, key)
-				deleteCount++
-			}
-		}
-		if len(value.sections) == 0 {
-			value.deleted = true
-			c.cache.Remove(value.cacheKey)
-			if set, ok := c.zoneMap.Get(value.zone); ok {
-				set.(*safeHashMap.Map).Remove(value.cacheKey)
+		if va, ok := value.sections[e.hash]; ok && !value.deleted && va.expiration == e.expiration {
+			delete(value.sections, e.hash)
+			c.counter.Dec()
+			if len(value.sections) == 0 {
+				value.deleted = true
+				c.cache.Remove(value.cacheKey)
+				if set, ok := c.zoneMap.Get(value.zone); ok {
+					set.(*safeHashMap.Map).Remove(value.cacheKey)
+				}
 			}
 		}
 		value.mux.Unlock()
-		c.counter.Sub(deleteCount)
 	}
+	c.expiries.compact(c.counter.Value(), func(e expiryEntry) bool {
+		value := e.value.(*negAssertionCacheValue)
+		value.mux.RLock()
+		defer value.mux.RUnlock()
+		va, ok := value.sections[e.hash]
+		return ok && !value.deleted && va.expiration == e.expiration
+	})
 }
 
 //RemoveZone deletes all shards and zones in the assertionCache and consistencyCache of the given
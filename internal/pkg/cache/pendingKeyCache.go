@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"sync"
 	"time"
 
 	log "github.com/inconshreveable/log15"
@@ -22,40 +23,89 @@ type PendingKeyImpl struct {
 	tokenMap *safeHashMap.Map
 	//counter holds the number of sectionSender objects stored in the cache
 	counter *safeCounter.Counter
+	//maxOutstanding is the maximum number of delegation queries which can be outstanding at the
+	//same time. There is no limit if it is not positive.
+	maxOutstanding int
+	//mux protects counter, outstanding and parked
+	mux sync.Mutex
+	//outstanding is the number of entries in tokenMap
+	outstanding int
+	//parked contains the entries for which no delegation query has been sent yet in the order
+	//they have been added.
+	parked []pkcValue
 }
 
-func NewPendingKey(maxSize int) *PendingKeyImpl {
+func NewPendingKey(maxSize, maxOutstanding int) *PendingKeyImpl {
 	return &PendingKeyImpl{
-		tokenMap: safeHashMap.New(),
-		counter:  safeCounter.New(maxSize),
+		tokenMap:       safeHashMap.New(),
+		counter:        safeCounter.New(maxSize),
+		maxOutstanding: maxOutstanding,
 	}
 }
 
 //Add adds ss to the cache together with the token and expiration time of the query sent to the
-//host with the addr defined in ss.
-func (c *PendingKeyImpl) Add(ss util.MsgSectionSender, t token.Token, expiration int64) {
+//host with the addr defined in ss. It returns true if the delegation query with token t can be
+//sent. Otherwise, the maximum number of outstanding delegation queries is reached and ss is
+//parked until Unpark returns it.
+func (c *PendingKeyImpl) Add(ss util.MsgSectionSender, t token.Token, expiration int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	if c.counter.IsFull() {
 		log.Error("Pending key cache is full")
-		return
+		return false
+	}
+	if c.maxOutstanding > 0 && c.outstanding >= c.maxOutstanding {
+		c.parked = append(c.parked, pkcValue{mss: ss, expiration: expiration})
+		c.counter.Inc()
+		return false
 	}
 	if ok := c.tokenMap.Add(t.String(), pkcValue{mss: ss, expiration: expiration}); !ok {
 		log.Warn("Token already in key cache. Random source of Token generator no random enough?")
-		return
+		return false
 	}
+	c.outstanding++
 	c.counter.Inc()
+	return true
 }
 
 //GetAndRemove returns util.MsgSectionSender which corresponds to token and true, and deletes it from
 //the cache. False is returned if no util.MsgSectionSender matched token.
 func (c *PendingKeyImpl) GetAndRemove(t token.Token) (util.MsgSectionSender, bool) {
-	if val, present := c.tokenMap.Get(t.String()); present {
-		c.tokenMap.Remove(t.String())
-		c.counter.Dec()
+	if val, present := c.tokenMap.Remove(t.String()); present {
+		c.release()
 		return val.(pkcValue).mss, true
 	}
 	return util.MsgSectionSender{}, false
 }
 
+//Unpark removes and returns the oldest parked sections for which a delegation query can be sent.
+func (c *PendingKeyImpl) Unpark() []util.MsgSectionSender {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n := len(c.parked)
+	if c.maxOutstanding > 0 && c.maxOutstanding-c.outstanding < n {
+		n = c.maxOutstanding - c.outstanding
+	}
+	if n <= 0 {
+		return nil
+	}
+	result := []util.MsgSectionSender{}
+	for _, val := range c.parked[:n] {
+		result = append(result, val.mss)
+		c.counter.Dec()
+	}
+	c.parked = c.parked[n:]
+	return result
+}
+
+//release frees the slot of an outstanding delegation query.
+func (c *PendingKeyImpl) release() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.outstanding--
+	c.counter.Dec()
+}
+
 //ContainsToken returns true if t is cached
 func (c *PendingKeyImpl) ContainsToken(t token.Token) bool {
 	_, present := c.tokenMap.Get(t.String())
@@ -69,16 +119,32 @@ func (c *PendingKeyImpl) RemoveExpiredValues() {
 	for _, key := range keys {
 		if val, present := c.tokenMap.Get(key); present {
 			if val := val.(pkcValue); val.expiration < time.Now().Unix() {
-				c.tokenMap.Remove(key)
-				c.counter.Dec()
-				log.Warn("No response to delegation query received before expiration",
-					"sectionSender", val.mss)
+				if _, present := c.tokenMap.Remove(key); present {
+					c.release()
+					log.Warn("No response to delegation query received before expiration",
+						"sectionSender", val.mss)
+				}
 			}
 		}
 	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	parked := c.parked[:0]
+	for _, val := range c.parked {
+		if val.expiration < time.Now().Unix() {
+			c.counter.Dec()
+			log.Warn("Parked section expired before a delegation query was sent",
+				"sectionSender", val.mss)
+			continue
+		}
+		parked = append(parked, val)
+	}
+	c.parked = parked
 }
 
 //Len returns the number of sections in the cache
 func (c *PendingKeyImpl) Len() int {
-	return c.tokenMap.Len()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.tokenMap.Len() + len(c.parked)
 }
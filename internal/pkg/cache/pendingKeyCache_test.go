@@ -2,6 +2,7 @@ package cache
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -87,3 +88,55 @@ func TestPendingKeyCacheCounter(t *testing.T) {
 		}
 	}
 }
+
+func TestPendingKeyCacheMaxOutstanding(t *testing.T) {
+	mss, _ := getQueries()
+	maxOutstanding := 3
+	c := NewPendingKey(100, maxOutstanding)
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	var sent []token.Token
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tok := token.New()
+			if c.Add(mss[i%len(mss)], tok, time.Now().Add(time.Hour).Unix()) {
+				mux.Lock()
+				sent = append(sent, tok)
+				mux.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(sent) != maxOutstanding || c.Len() != 20 {
+		t.Fatalf("unexpected number of outstanding queries expected=%d actual=%d len=%d",
+			maxOutstanding, len(sent), c.Len())
+	}
+	if parked := c.Unpark(); len(parked) != 0 {
+		t.Fatalf("parked sections returned although no slot is free: %v", parked)
+	}
+	//Test that a parked section is retried when a slot frees up
+	if _, ok := c.GetAndRemove(sent[0]); !ok {
+		t.Fatal("outstanding query was not found")
+	}
+	parked := c.Unpark()
+	if len(parked) != 1 || c.Len() != 18 {
+		t.Fatalf("expected one parked section to be retried actual=%d len=%d", len(parked), c.Len())
+	}
+	if !c.Add(parked[0], token.New(), time.Now().Add(time.Hour).Unix()) {
+		t.Fatal("retried section did not obtain the free slot")
+	}
+	if c.Add(mss[0], token.New(), time.Now().Add(time.Hour).Unix()) {
+		t.Fatal("query can be sent although the maximum number of outstanding queries is reached")
+	}
+	//Test that an expired outstanding query frees its slot
+	c.GetAndRemove(sent[1])
+	if !c.Add(mss[1], token.New(), time.Now().Add(-time.Hour).Unix()) {
+		t.Fatal("section did not obtain the free slot")
+	}
+	c.RemoveExpiredValues()
+	if parked := c.Unpark(); len(parked) != 1 {
+		t.Fatalf("expected expired query to free a slot actual=%d", len(parked))
+	}
+}
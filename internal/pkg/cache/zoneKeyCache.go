@@ -53,22 +53,33 @@ type ZoneKeyImpl struct {
 	//maxPublicKeysPerZone defines the number of keys per zone after which a message is logged that
 	//this zone uses too many public keys.
 	maxPublicKeysPerZone int
+	//gracePeriod is the time an expired public key is retained and still returned.
+	gracePeriod time.Duration
 
 	mux sync.Mutex
 	//keysPerContextZone counts the number of public keys stored per zone and context
 	keysPerContextZone map[string]int //key=zone,context
 }
 
-func NewZoneKey(maxSize, warnSize, maxKeysPerZone int) *ZoneKeyImpl {
+//NewZoneKey returns a zone key cache holding at most maxSize public keys. An expired public key is
+//retained and returned for gracePeriod after its expiration such that sections signed shortly
+//before a key rollover remain verifiable.
+func NewZoneKey(maxSize, warnSize, maxKeysPerZone int, gracePeriod time.Duration) *ZoneKeyImpl {
 	return &ZoneKeyImpl{
 		cache:                lruCache.New(),
 		counter:              safeCounter.New(maxSize),
 		warnSize:             warnSize,
 		maxPublicKeysPerZone: maxKeysPerZone,
+		gracePeriod:          gracePeriod,
 		keysPerContextZone:   make(map[string]int),
 	}
 }
 
+//expired returns true if key expired more than the grace period ago.
+func (c *ZoneKeyImpl) expired(key keys.PublicKey) bool {
+	return key.ValidUntil+int64(c.gracePeriod/time.Second) < time.Now().Unix()
+}
+
 //Add adds publicKey together with the assertion containing it to the cache. Returns false if
 //the cache exceeds a configured (during initialization of the cache) amount of entries. If the
 //cache is full it removes a public key according to some metric. The cache logs a message when
@@ -78,11 +89,8 @@ func NewZoneKey(maxSize, warnSize, maxKeysPerZone int) *ZoneKeyImpl {
 func (c *ZoneKeyImpl) Add(assertion *section.Assertion, publicKey keys.PublicKey, internal bool) bool {
 	log.Info("Adding key to cache", "publicKey", publicKey, "assertion", assertion)
 	subjectName := assertion.FQDN()
-	if assertion.SubjectName == "@" {
-		subjectName = assertion.SubjectZone
-	}
 	cacheValue := &zoneKeyCacheValue{publicKeys: safeHashMap.New(), zone: subjectName,
-		context: assertion.Context, algorithmType: publicKey.Algorithm, keyPhase: publicKey.KeyPhase}
+		context: section.CanonicalContext(assertion.Context), algorithmType: publicKey.Algorithm, keyPhase: publicKey.KeyPhase}
 	e, _ := c.cache.GetOrAdd(cacheValue.getCacheKey(), cacheValue, internal)
 	v := e.(*zoneKeyCacheValue)
 	v.mux.Lock() //This lock assures that the lru removal of another add method or the reap
@@ -136,19 +144,21 @@ func (c *ZoneKeyImpl) Add(assertion *section.Assertion, publicKey keys.PublicKey
 	return c.counter.Value() < c.warnSize
 }
 
-//Get returns true and a valid public key matching zone and publicKeyID. It returns false if
-//there exists no valid public key in the cache.
+//Get returns true and a valid public key matching zone and publicKeyID. A key which expired less
+//than the grace period ago is still returned. It returns false if there exists no valid public key
+//in the cache.
 func (c *ZoneKeyImpl) Get(zone, context string, sigMetaData signature.MetaData) (
 	keys.PublicKey, *section.Assertion, bool) {
-	e, ok := c.cache.Get(fmt.Sprintf("%s,%s,%d,%d", zone, context, sigMetaData.Algorithm, sigMetaData.KeyPhase))
+	e, ok := c.cache.Get(fmt.Sprintf("%s,%s,%d,%d", zone, section.CanonicalContext(context),
+		sigMetaData.Algorithm, sigMetaData.KeyPhase))
 	if !ok {
 		return keys.PublicKey{}, nil, false
 	}
 	values := e.(*zoneKeyCacheValue).publicKeys.GetAll()
 	for _, v := range values {
 		key := v.(publicKeyAssertion).publicKey
-		if key.ValidUntil > time.Now().Unix() {
-			//key is non expired and valid
+		if !c.expired(key) {
+			//key is non expired or within its grace period
 			if key.ValidSince <= sigMetaData.ValidUntil && key.ValidUntil >= sigMetaData.ValidSince {
 				return key, v.(publicKeyAssertion).assertion, true
 			}
@@ -157,14 +167,15 @@ func (c *ZoneKeyImpl) Get(zone, context string, sigMetaData signature.MetaData)
 	return keys.PublicKey{}, nil, false
 }
 
-//RemoveExpiredKeys deletes all expired public keys from the cache.
+//RemoveExpiredKeys deletes all public keys from the cache which expired more than the grace period
+//ago.
 func (c *ZoneKeyImpl) RemoveExpiredKeys() {
 	values := c.cache.GetAll()
 	for _, value := range values {
 		val := value.(*zoneKeyCacheValue)
 		keys := val.publicKeys.GetAllKeys()
 		for _, key := range keys {
-			if k, ok := val.publicKeys.Get(key); ok && k.(publicKeyAssertion).publicKey.ValidUntil < time.Now().Unix() {
+			if k, ok := val.publicKeys.Get(key); ok && c.expired(k.(publicKeyAssertion).publicKey) {
 				if _, ok := val.publicKeys.Remove(key); ok {
 					c.counter.Dec()
 					c.mux.Lock()
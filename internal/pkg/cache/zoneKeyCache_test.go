@@ -3,10 +3,12 @@ package cache
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeCounter"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/lruCache"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
 
 func TestZoneKeyCache(t *testing.T) {
@@ -127,3 +129,33 @@ func TestCheckpoint(t *testing.T) {
 		}
 	}
 }
+
+func TestZoneKeyCacheGracePeriod(t *testing.T) {
+	var tests = []struct {
+		gracePeriod time.Duration
+		retained    bool
+	}{
+		{0, false},
+		{time.Hour, true},
+	}
+	for i, test := range tests {
+		c := NewZoneKey(5, 4, 2, test.gracePeriod)
+		a := getExampleDelgations("ch")[0]
+		pkey := a.Content[0].Value.(keys.PublicKey)
+		pkey.ValidSince = time.Now().Add(-2 * time.Hour).Unix()
+		pkey.ValidUntil = time.Now().Add(-time.Minute).Unix()
+		a.Content[0].Value = pkey
+		c.Add(a, pkey, false)
+		c.RemoveExpiredKeys()
+		if c.Len() != 1 && test.retained || c.Len() != 0 && !test.retained {
+			t.Errorf("%d: wrong number of keys after reaping retained=%v actual=%d", i,
+				test.retained, c.Len())
+		}
+		//signature created while the key was still valid
+		sig := signature.MetaData{PublicKeyID: pkey.PublicKeyID,
+			ValidSince: time.Now().Add(-time.Hour).Unix(), ValidUntil: time.Now().Add(time.Hour).Unix()}
+		if _, _, ok := c.Get("ch.", ".", sig); ok != test.retained {
+			t.Errorf("%d: wrong result of Get expected=%v actual=%v", i, test.retained, ok)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package cbor
 
 import (
+	"bytes"
+	"errors"
 	"io"
 
 	"github.com/britram/borat"
 )
 
+//ErrBrokenStream is returned by a writer whose output has previously accepted only a part of an
+//encoding. The peer cannot resynchronize on such a stream and it must be closed.
+var ErrBrokenStream = errors.New("cbor stream contains a partially written value")
+
 //Writer defines all functions necessary to encode a message or section in cbor
 type Writer interface {
 	Marshal(x interface{}) error
@@ -21,12 +27,145 @@ type Reader interface {
 	ReadIntMapUntagged() (map[int]interface{}, error)
 }
 
-//NewWriter returns a new cbor writer which writes to out.
+//NewWriter returns a new cbor writer which writes to out. Each value is encoded completely before
+//it is written to out in a single call such that a value which fails to encode never leaves a
+//partial encoding on out.
 func NewWriter(out io.Writer) Writer {
-	return borat.NewCBORWriter(out)
+	return &writer{out: out}
+}
+
+//writer is a Writer which writes each value atomically to out.
+type writer struct {
+	out    io.Writer
+	broken bool
+}
+
+// Marshal implements the Writer interface.
+func (w *writer) Marshal(x interface{}) error {
+	return w.write(func(cw *borat.CBORWriter) error { return cw.Marshal(x) })
+}
+
+// WriteIntMap implements the Writer interface.
+func (w *writer) WriteIntMap(m map[int]interface{}) error {
+	return w.write(func(cw *borat.CBORWriter) error { return cw.WriteIntMap(m) })
+}
+
+// WriteTag implements the Writer interface.
+func (w *writer) WriteTag(t borat.CBORTag) error {
+	return w.write(func(cw *borat.CBORWriter) error { return cw.WriteTag(t) })
+}
+
+// WriteArray implements the Writer interface.
+func (w *writer) WriteArray(a []interface{}) error {
+	return w.write(func(cw *borat.CBORWriter) error { return cw.WriteArray(a) })
 }
 
-//NewWriter returns a new cbor writer which writes to out.
+//write encodes a value with encode into a buffer and writes the buffer to w.out. Nothing is written
+//if encode fails. After a partial write, w refuses all further writes with ErrBrokenStream.
+func (w *writer) write(encode func(*borat.CBORWriter) error) error {
+	if w.broken {
+		return ErrBrokenStream
+	}
+	encoding := new(bytes.Buffer)
+	if err := encode(borat.NewCBORWriter(encoding)); err != nil {
+		return err
+	}
+	n, err := w.out.Write(encoding.Bytes())
+	if n > 0 && n < encoding.Len() {
+		w.broken = true
+	}
+	return err
+}
+
+//NewReader returns a new cbor reader which reads from in. It fails on data items exceeding
+//MaxItemBytes or MaxItemDepth before they are decoded.
 func NewReader(in io.Reader) Reader {
-	return borat.NewCBORReader(in)
+	return borat.NewCBORReader(&guard{in: in})
+}
+
+//Major types of CBOR data items.
+const (
+	MajorUint   byte = 0
+	MajorNegInt byte = 1
+	MajorBytes  byte = 2
+	MajorString byte = 3
+	MajorArray  byte = 4
+	MajorMap    byte = 5
+	MajorTag    byte = 6
+	MajorSimple byte = 7
+)
+
+//Head returns the shortest encoding of the head of a data item of major type major with argument
+//arg, e.g. the head of an array with arg elements.
+func Head(major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return []byte{major | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major | 24, byte(arg)}
+	case arg <= 0xffff:
+		return []byte{major | 25, byte(arg >> 8), byte(arg)}
+	case arg <= 0xffffffff:
+		return []byte{major | 26, byte(arg >> 24), byte(arg >> 16), byte(arg >> 8), byte(arg)}
+	}
+	head := []byte{major | 27}
+	for shift := 56; shift >= 0; shift -= 8 {
+		head = append(head, byte(arg>>uint(shift)))
+	}
+	return head
+}
+
+//ReadHead decodes the head of the data item data starts with. It returns the item's major type,
+//its argument and the length of the head. Items of indefinite length are not supported.
+func ReadHead(data []byte) (major byte, arg uint64, n int, err error) {
+	if len(data) == 0 {
+		return 0, 0, 0, errors.New("cbor data item is empty")
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), 1, nil
+	case info <= 27:
+		n = 1 + 1<<(info-24)
+		if len(data) < n {
+			return 0, 0, 0, errors.New("cbor data item head is truncated")
+		}
+		for _, b := range data[1:n] {
+			arg = arg<<8 | uint64(b)
+		}
+		return major, arg, n, nil
+	}
+	return 0, 0, 0, errors.New("cbor data items of indefinite length are not supported")
+}
+
+//ItemLen returns the length of the encoding of the data item data starts with, including all
+//nested items.
+func ItemLen(data []byte) (int, error) {
+	major, arg, n, err := ReadHead(data)
+	if err != nil {
+		return 0, err
+	}
+	var items uint64
+	switch major {
+	case MajorBytes, MajorString:
+		if arg > uint64(len(data)-n) {
+			return 0, errors.New("cbor data item is truncated")
+		}
+		return n + int(arg), nil
+	case MajorArray:
+		items = arg
+	case MajorMap:
+		items = 2 * arg
+	case MajorTag:
+		items = 1
+	}
+	for ; items > 0; items-- {
+		l, err := ItemLen(data[n:])
+		if err != nil {
+			return 0, err
+		}
+		n += l
+	}
+	return n, nil
 }
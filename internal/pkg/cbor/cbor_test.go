@@ -0,0 +1,114 @@
+package cbor
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/britram/borat"
+)
+
+//failingValue writes a partial encoding and then fails.
+type failingValue struct{}
+
+func (failingValue) MarshalCBOR(w *borat.CBORWriter) error {
+	if err := w.WriteIntMap(map[int]interface{}{1: "partial"}); err != nil {
+		return err
+	}
+	return errors.New("injected failure")
+}
+
+//shortWriter accepts only the first n bytes of the first write.
+type shortWriter struct {
+	out *bytes.Buffer
+	n   int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if w.n < len(p) {
+		w.out.Write(p[:w.n])
+		n := w.n
+		w.n = len(p)
+		return n, errors.New("injected short write")
+	}
+	return w.out.Write(p)
+}
+
+func TestWriterAtomic(t *testing.T) {
+	stream := new(bytes.Buffer)
+	w := NewWriter(stream)
+	if err := w.Marshal(failingValue{}); err == nil {
+		t.Fatal("failing value must return an error")
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("failing value was partially written: %x", stream.Bytes())
+	}
+	want := map[int]interface{}{1: "complete"}
+	if err := w.WriteIntMap(want); err != nil {
+		t.Fatalf("Was not able to write value after failure: %v", err)
+	}
+	got, err := borat.NewCBORReader(stream).ReadIntMapUntagged()
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("stream is corrupted expected=%v actual=%v err=%v", want, got, err)
+	}
+}
+
+func TestWriterBrokenStream(t *testing.T) {
+	stream := new(bytes.Buffer)
+	w := NewWriter(&shortWriter{out: stream, n: 2})
+	if err := w.WriteIntMap(map[int]interface{}{1: "partial"}); err == nil {
+		t.Fatal("short write must return an error")
+	}
+	written := stream.Len()
+	if err := w.WriteIntMap(map[int]interface{}{1: "complete"}); err != ErrBrokenStream {
+		t.Fatalf("write after partial write must fail expected=%v actual=%v", ErrBrokenStream, err)
+	}
+	if stream.Len() != written {
+		t.Errorf("value was written to a broken stream: %x", stream.Bytes())
+	}
+}
+
+func TestHead(t *testing.T) {
+	var tests = []uint64{0, 23, 24, 0xff, 0x100, 0xffff, 0x10000, 0xffffffff, 0x100000000}
+	for i, arg := range tests {
+		head := Head(MajorArray, arg)
+		major, actual, n, err := ReadHead(append(head, 0))
+		if err != nil || major != MajorArray || actual != arg || n != len(head) {
+			t.Errorf("%d: wrong head expected=(%d,%d,%d) actual=(%d,%d,%d) err=%v", i, MajorArray,
+				arg, len(head), major, actual, n, err)
+		}
+	}
+}
+
+func TestItemLen(t *testing.T) {
+	var tests = []interface{}{
+		5,
+		-1000,
+		"string",
+		[]byte{1, 2, 3},
+		[]interface{}{1, "a", []interface{}{300, true}},
+		map[int]interface{}{0: []interface{}{}, 23: []interface{}{"b", []byte{4}}},
+	}
+	for i, value := range tests {
+		encoding := new(bytes.Buffer)
+		w := NewWriter(encoding)
+		var err error
+		if m, ok := value.(map[int]interface{}); ok {
+			err = w.WriteIntMap(m)
+		} else {
+			err = w.Marshal(value)
+		}
+		if err != nil {
+			t.Fatalf("%d: Was not able to marshal value: %v", i, err)
+		}
+		want := encoding.Len()
+		encoding.WriteByte(0xff)
+		if n, err := ItemLen(encoding.Bytes()); err != nil || n != want {
+			t.Errorf("%d: wrong item length expected=%d actual=%d err=%v", i, want, n, err)
+		}
+		if _, err := ItemLen(encoding.Bytes()[:want-1]); err == nil {
+			t.Errorf("%d: truncated item must return an error", i)
+		}
+	}
+}
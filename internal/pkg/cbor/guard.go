@@ -0,0 +1,148 @@
+package cbor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	//MaxItemBytes is the maximal length of the encoding of a data item read by a Reader, e.g. of a
+	//message. Larger zones must be transferred in chunks.
+	MaxItemBytes = 16 << 20
+	//MaxItemDepth is the maximal nesting depth of arrays, maps and tags within a data item read
+	//by a Reader.
+	MaxItemDepth = 64
+)
+
+//guard is an io.Reader passing the encoding read from in to a decoder. The decoder allocates
+//memory according to the lengths declared in the heads of the data items before their content
+//arrives. guard inspects each head before the decoder sees it and fails if the declared lengths
+//cannot fit into MaxItemBytes or if items are nested deeper than MaxItemDepth. The memory the
+//decoder allocates is thus bounded by the input it can receive.
+type guard struct {
+	in  io.Reader
+	err error
+	//head accumulates the head of a data item which is split across reads.
+	head []byte
+	//skip is the number of content bytes of a byte or text string which are not inspected.
+	skip uint64
+	//items holds for each open array, map and tag the number of data items it still contains.
+	items []uint64
+	//read is the number of bytes of the current top-level data item which have been read.
+	read uint64
+	//need is the minimal number of bytes needed to complete the current top-level data item.
+	need uint64
+}
+
+//Read reads from g.in and returns an error instead of data which would exceed the limits.
+func (g *guard) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	n, err := g.in.Read(p)
+	if g.err = g.inspect(p[:n]); g.err != nil {
+		return 0, g.err
+	}
+	return n, err
+}
+
+//inspect advances g's view of the encoding by data.
+func (g *guard) inspect(data []byte) error {
+	for len(data) > 0 {
+		if g.skip > 0 {
+			k := g.skip
+			if k > uint64(len(data)) {
+				k = uint64(len(data))
+			}
+			g.consume(k)
+			g.skip -= k
+			data = data[k:]
+			if g.skip == 0 {
+				g.itemDone()
+			}
+			continue
+		}
+		if len(g.head) == 0 && len(g.items) == 0 {
+			//a new top-level data item starts.
+			g.read, g.need = 0, 1
+		}
+		g.head = append(g.head, data[0])
+		data = data[1:]
+		g.consume(1)
+		info := g.head[0] & 0x1f
+		if info > 27 {
+			return errors.New("cbor data items of indefinite length are not supported")
+		}
+		headLen := 1
+		if info >= 24 {
+			headLen += 1 << (info - 24)
+		}
+		if len(g.head) == 1 {
+			g.need += uint64(headLen - 1)
+		}
+		if len(g.head) < headLen {
+			continue
+		}
+		major, arg, _, err := ReadHead(g.head)
+		if err != nil {
+			return err
+		}
+		g.head = g.head[:0]
+		if err := g.open(major, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//open accounts for a data item of type major with argument arg whose head has been read.
+func (g *guard) open(major byte, arg uint64) error {
+	var content uint64
+	switch major {
+	case MajorBytes, MajorString, MajorArray:
+		content = arg
+	case MajorMap:
+		content = 2 * arg
+		if arg > MaxItemBytes {
+			content = arg
+		}
+	case MajorTag:
+		content = 1
+	}
+	if content > MaxItemBytes || g.read+g.need+content > MaxItemBytes {
+		return fmt.Errorf("cbor data item declares %d more bytes than the limit of %d allows",
+			content, MaxItemBytes)
+	}
+	g.need += content
+	switch {
+	case content == 0:
+		g.itemDone()
+	case major == MajorBytes || major == MajorString:
+		g.skip = content
+	default:
+		if len(g.items) == MaxItemDepth {
+			return fmt.Errorf("cbor data items are nested deeper than %d", MaxItemDepth)
+		}
+		g.items = append(g.items, content)
+	}
+	return nil
+}
+
+//consume accounts for k bytes which have been read.
+func (g *guard) consume(k uint64) {
+	g.read += k
+	g.need -= k
+}
+
+//itemDone accounts for a completed data item. It completes all enclosing items of which it is the
+//last contained item.
+func (g *guard) itemDone() {
+	for len(g.items) > 0 {
+		g.items[len(g.items)-1]--
+		if g.items[len(g.items)-1] > 0 {
+			return
+		}
+		g.items = g.items[:len(g.items)-1]
+	}
+}
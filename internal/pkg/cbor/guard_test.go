@@ -0,0 +1,44 @@
+package cbor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestGuard(t *testing.T) {
+	//nested returns the encoding of an integer nested into depth arrays.
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat(Head(MajorArray, 1), depth), 0)
+	}
+	var tests = []struct {
+		encoding []byte
+		errMsg   string
+	}{
+		{append(Head(MajorString, 3), "abc"...), ""},
+		{append(Head(MajorArray, 2), append(Head(MajorBytes, 300), make([]byte, 301)...)...), ""},
+		{append(Head(MajorMap, 1), 0x01, 0x80), ""},
+		{nested(MaxItemDepth), ""},
+		{nested(MaxItemDepth + 1), "nested deeper"},
+		{Head(MajorBytes, MaxItemBytes), "limit"},
+		{Head(MajorBytes, 1<<63), "limit"},
+		{Head(MajorArray, MaxItemBytes), "limit"},
+		{Head(MajorMap, 1<<62), "limit"},
+		//the lengths of nested items must fit into the item together.
+		{append(Head(MajorArray, MaxItemBytes/2), Head(MajorArray, MaxItemBytes/2)...), "limit"},
+		{[]byte{MajorArray<<5 | 31}, "indefinite length"},
+	}
+	for i, test := range tests {
+		//the item is followed by a second one to check that the limits apply per item.
+		encoding := append(append([]byte{}, test.encoding...), test.encoding...)
+		read, err := ioutil.ReadAll(&guard{in: iotest.OneByteReader(bytes.NewReader(encoding))})
+		if test.errMsg == "" && (err != nil || !bytes.Equal(read, encoding)) {
+			t.Errorf("%d: valid items were not passed read=%x err=%v", i, read, err)
+		}
+		if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%d: wrong error expected=%s actual=%v", i, test.errMsg, err)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"bytes"
+	"io"
 	"io/ioutil"
 
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
@@ -66,6 +67,22 @@ func UnmarshalNetAddr(data []byte) (Type, net.Addr, error) {
 		if err = json.Unmarshal(addrData, &value); err != nil {
 			return -1, nil, err
 		}
+	case "Unix":
+		value = reflect.New(reflect.TypeOf(net.UnixAddr{})).Interface()
+		t = Unix
+		if _, ok := m["UnixAddr"]; !ok {
+			return -1, nil, errors.New("UnixAddr key not found in JSON config")
+		}
+		addrData, err := json.Marshal(m["UnixAddr"])
+		if err != nil {
+			return -1, nil, err
+		}
+		if err = json.Unmarshal(addrData, &value); err != nil {
+			return -1, nil, err
+		}
+		if network := value.(*net.UnixAddr).Net; network != "unix" && network != "unixpacket" {
+			return -1, nil, fmt.Errorf("unsupported Unix network: %s", network)
+		}
 	case "SCION":
 		if _, ok := m["SCIONAddr"]; !ok {
 			return -1, nil, errors.New("local address is required for SCION")
@@ -98,14 +115,28 @@ type Type int
 const (
 	TCP Type = iota + 1
 	SCION
+	Unix
 )
 
 //CreateConnection returns a newly created connection with connInfo or an error
 func CreateConnection(addr net.Addr) (conn net.Conn, err error) {
+	return CreateConnectionVia(addr, nil)
+}
+
+//CreateConnectionVia behaves like CreateConnection but opens TCP connections with dialer, e.g.
+//through a proxy. Connections to Unix sockets are opened directly. A connection to a SCION address
+//cannot be opened through dialer. If dialer is nil, all connections are opened directly.
+func CreateConnectionVia(addr net.Addr, dialer Dialer) (conn net.Conn, err error) {
 	switch addr.(type) {
 	case *net.TCPAddr:
+		if dialer != nil {
+			return dialTLS(dialer, addr)
+		}
 		return tls.Dial(addr.Network(), addr.String(), &tls.Config{InsecureSkipVerify: true})
 	case *snet.Addr:
+		if dialer != nil {
+			return nil, fmt.Errorf("a connection to SCION address %s cannot be opened by a dialer", addr)
+		}
 		addr := addr.(*snet.Addr)
 		rawIA, err := ioutil.ReadFile(fmt.Sprintf("%s/gen/ia", os.Getenv("SC")))
 		if err != nil {
@@ -132,11 +163,68 @@ func CreateConnection(addr net.Addr) (conn net.Conn, err error) {
 			addr.NextHop, _ = pathEntry.HostInfo.Overlay()
 		}
 		return snet.DialSCION("udp4", srcAddr, addr)
+	case *net.UnixAddr:
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			return nil, err
+		}
+		return NewUnixConn(conn, nil), nil
 	default:
 		return nil, fmt.Errorf("unsupported Network address type: %s", addr)
 	}
 }
 
+//maxUnixPacketBytes is the size of the buffer into which a packet of a unixpacket connection is
+//read. A message sent over such a connection must fit into a single packet.
+const maxUnixPacketBytes = 1 << 16
+
+//UnixConn is a connection over a Unix domain socket. A unixpacket socket preserves packet
+//boundaries and discards the part of a packet not fitting into a read. UnixConn therefore reads
+//whole packets and hands them out in as many reads as the caller needs, which keeps the cbor
+//framing unchanged. A message is always written in a single call and thus in a single packet.
+type UnixConn struct {
+	net.Conn
+	remote  net.Addr
+	buf     []byte
+	pending []byte
+}
+
+//NewUnixConn returns conn wrapped in a UnixConn. If remote is not nil it is returned as the remote
+//address of the connection. A server sets it as the clients of a Unix socket are usually unnamed
+//and would otherwise share the same address.
+func NewUnixConn(conn net.Conn, remote net.Addr) *UnixConn {
+	c := &UnixConn{Conn: conn, remote: remote}
+	if conn.LocalAddr().Network() == "unixpacket" {
+		c.buf = make([]byte, maxUnixPacketBytes)
+	}
+	return c
+}
+
+//Read implements the io.Reader interface.
+func (c *UnixConn) Read(p []byte) (int, error) {
+	if c.buf == nil {
+		return c.Conn.Read(p)
+	}
+	if len(c.pending) == 0 {
+		n, err := c.Conn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		c.pending = c.buf[:n]
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+//RemoteAddr returns the remote address of the connection.
+func (c *UnixConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
 // choosePathSCION is a naive implementation of a path selection algorithm that
 // chooses the first available path.
 func choosePathSCION(ctx context.Context, local, remote *snet.Addr) (*sd.PathReplyEntry, error) {
@@ -169,34 +257,107 @@ func getLocalIP() (net.IP, error) {
 }
 
 func Listen(conn net.Conn, tok token.Token, done chan<- message.Message, ec chan<- error) {
+	msg, _, err := Receive(conn, tok)
+	if err != nil {
+		ec <- err
+		return
+	}
+	done <- msg
+}
+
+//Receive reads the response to the message with token tok from conn. It returns the decoded
+//message together with its encoding as it has been read from conn. If the response is a zone
+//split into chunks, all chunks are read and a message containing the reassembled zone is returned
+//without an encoding.
+func Receive(conn net.Conn, tok token.Token) (message.Message, []byte, error) {
+	msg, data, err := receive(conn, tok)
+	if err != nil {
+		return msg, nil, err
+	}
+	chunk, ok := zoneChunk(msg)
+	if !ok {
+		return msg, data, nil
+	}
+	chunks := []*section.ZoneChunk{chunk}
+	for len(chunks) < chunk.Total {
+		next, _, err := receive(conn, tok)
+		if err != nil {
+			return msg, nil, fmt.Errorf("failed to receive zone chunk: %v", err)
+		}
+		c, ok := zoneChunk(next)
+		if !ok {
+			return msg, nil, errors.New("expected a zone chunk but received other content")
+		}
+		chunks = append(chunks, c)
+	}
+	zone, err := section.AssembleZone(chunks)
+	if err != nil {
+		return msg, nil, err
+	}
+	msg.Content = []section.Section{zone}
+	return msg, nil, nil
+}
+
+//zoneChunk returns the zone chunk msg consists of and true. It returns false if msg does not
+//consist of a single zone chunk.
+func zoneChunk(msg message.Message) (*section.ZoneChunk, bool) {
+	if len(msg.Content) != 1 {
+		return nil, false
+	}
+	c, ok := msg.Content[0].(*section.ZoneChunk)
+	return c, ok
+}
+
+//receive reads a single message with token tok from conn.
+func receive(conn net.Conn, tok token.Token) (message.Message, []byte, error) {
+	msg, data, err := readMessage(conn)
+	if err != nil {
+		return msg, nil, err
+	}
+	if !answers(msg, tok) {
+		return msg, nil, fmt.Errorf("token response mismatch: got %v, want %v", msg.Token, tok)
+	}
+	return msg, data, nil
+}
+
+//readMessage reads a single message from conn and returns it together with its encoding.
+func readMessage(conn net.Conn) (message.Message, []byte, error) {
 	var msg message.Message
+	var data []byte
 	switch conn.LocalAddr().(type) {
-	case *net.TCPAddr:
-		reader := cbor.NewReader(conn)
+	case *net.TCPAddr, *net.UnixAddr:
+		encoding := new(bytes.Buffer)
+		reader := cbor.NewReader(io.TeeReader(conn, encoding))
 		if err := reader.Unmarshal(&msg); err != nil {
 			if err.Error() == "failed to read tag: EOF" {
-				ec <- fmt.Errorf("connection has been closed: %v", err)
-			} else {
-				ec <- fmt.Errorf("failed to unmarshal response: %v", err)
+				return msg, nil, fmt.Errorf("connection has been closed: %v", err)
 			}
-			return
+			return msg, nil, fmt.Errorf("failed to unmarshal response: %v", err)
 		}
+		data = encoding.Bytes()
 	case *snet.Addr:
 		buf := make([]byte, MaxUDPPacketBytes)
 		n, _, err := conn.(snet.Conn).ReadFromSCION(buf)
 		if err != nil {
-			ec <- fmt.Errorf("Failed to ReadFromSCION: %v", err)
+			return msg, nil, fmt.Errorf("Failed to ReadFromSCION: %v", err)
 		}
-		data := buf[:n]
+		data = buf[:n]
 		if err := cbor.NewReader(bytes.NewReader(data)).Unmarshal(&msg); err != nil {
-			ec <- fmt.Errorf("failed to unmarshal CBOR: %v", err)
+			return msg, nil, fmt.Errorf("failed to unmarshal CBOR: %v", err)
 		}
 	}
-	if msg.Token != tok {
-		if n, ok := msg.Content[0].(*section.Notification); !ok || n.Token != tok {
-			ec <- fmt.Errorf("token response mismatch: got %v, want %v", msg.Token, tok)
-			return
-		}
+	return msg, data, nil
+}
+
+//answers returns true if msg is the response to the message with token tok, i.e. if msg or the
+//notification it starts with carries tok.
+func answers(msg message.Message, tok token.Token) bool {
+	if msg.Token == tok {
+		return true
 	}
-	done <- msg
+	if len(msg.Content) == 0 {
+		return false
+	}
+	n, ok := msg.Content[0].(*section.Notification)
+	return ok && n.Token == tok
 }
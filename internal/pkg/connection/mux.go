@@ -0,0 +1,176 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//ErrMuxClosed is returned by queries on a multiplexer which has been closed.
+var ErrMuxClosed = errors.New("connection multiplexer is closed")
+
+//Mux multiplexes concurrent queries over a single connection. A read loop routes each received
+//message to the query whose token it carries such that a query neither waits for the answers to
+//earlier queries nor receives an answer to another query. Messages for which no query is waiting
+//are dropped.
+type Mux struct {
+	conn    net.Conn
+	wmux    sync.Mutex //serializes writes to conn
+	writer  cbor.Writer
+	mux     sync.Mutex //protects waiters and err
+	waiters map[token.Token]chan muxResponse
+	err     error
+}
+
+type muxResponse struct {
+	msg  message.Message
+	data []byte
+	err  error
+}
+
+//NewMux returns a multiplexer for conn and starts reading responses from it. The multiplexer owns
+//conn from then on and closes it when it fails or is closed.
+func NewMux(conn net.Conn) *Mux {
+	m := &Mux{
+		conn:    conn,
+		writer:  cbor.NewWriter(conn),
+		waiters: make(map[token.Token]chan muxResponse),
+	}
+	go m.readLoop()
+	return m
+}
+
+//Query sends msg and returns the answer carrying msg's token together with its encoding as it has
+//been received. A zone split into chunks is reassembled and returned without an encoding. It is
+//safe to call Query concurrently but the tokens of concurrent queries must differ.
+func (m *Mux) Query(msg message.Message, timeout time.Duration) (message.Message, []byte, error) {
+	response := make(chan muxResponse, 1)
+	m.mux.Lock()
+	if m.err != nil {
+		m.mux.Unlock()
+		return message.Message{}, nil, m.err
+	}
+	if _, ok := m.waiters[msg.Token]; ok {
+		m.mux.Unlock()
+		return message.Message{}, nil, fmt.Errorf("query with token %v is already in flight", msg.Token)
+	}
+	m.waiters[msg.Token] = response
+	m.mux.Unlock()
+	defer m.remove(msg.Token, response)
+
+	m.wmux.Lock()
+	err := m.writer.Marshal(&msg)
+	m.wmux.Unlock()
+	if err != nil {
+		err = fmt.Errorf("failed to marshal message: %v", err)
+		m.fail(err)
+		return message.Message{}, nil, err
+	}
+	select {
+	case r := <-response:
+		return r.msg, r.data, r.err
+	case <-time.After(timeout):
+		return message.Message{}, nil, fmt.Errorf("timeout after %v waiting for the answer", timeout)
+	}
+}
+
+//Err returns the error upon which the multiplexer stopped. It is nil while the multiplexer works.
+func (m *Mux) Err() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.err
+}
+
+//Close closes the connection. Pending and later queries fail with ErrMuxClosed.
+func (m *Mux) Close() error {
+	m.fail(ErrMuxClosed)
+	return nil
+}
+
+//readLoop reads messages from the connection and hands them to the waiting queries until reading
+//fails.
+func (m *Mux) readLoop() {
+	chunks := make(map[token.Token][]*section.ZoneChunk)
+	for {
+		msg, data, err := readMessage(m.conn)
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		tok, ok := m.waitingToken(msg)
+		if !ok {
+			delete(chunks, msg.Token)
+			continue
+		}
+		if c, ok := zoneChunk(msg); ok {
+			chunks[tok] = append(chunks[tok], c)
+			if len(chunks[tok]) < chunks[tok][0].Total {
+				continue
+			}
+			zone, err := section.AssembleZone(chunks[tok])
+			delete(chunks, tok)
+			msg.Content, data = []section.Section{zone}, nil
+			m.deliver(tok, muxResponse{msg: msg, err: err})
+			continue
+		}
+		m.deliver(tok, muxResponse{msg: msg, data: data})
+	}
+}
+
+//waitingToken returns the token of the query waiting for msg and true. It returns false if no
+//query is waiting for msg.
+func (m *Mux) waitingToken(msg message.Message) (token.Token, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, ok := m.waiters[msg.Token]; ok {
+		return msg.Token, true
+	}
+	if len(msg.Content) > 0 {
+		if n, ok := msg.Content[0].(*section.Notification); ok {
+			_, ok := m.waiters[n.Token]
+			return n.Token, ok
+		}
+	}
+	return token.Token{}, false
+}
+
+//deliver hands r to the query waiting with token tok.
+func (m *Mux) deliver(tok token.Token, r muxResponse) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if response, ok := m.waiters[tok]; ok {
+		delete(m.waiters, tok)
+		response <- r
+	}
+}
+
+//remove stops waiting for an answer with token tok.
+func (m *Mux) remove(tok token.Token, response chan muxResponse) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.waiters[tok] == response {
+		delete(m.waiters, tok)
+	}
+}
+
+//fail stops the multiplexer with err, closes the connection and hands err to all waiting queries.
+func (m *Mux) fail(err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	m.conn.Close()
+	for tok, response := range m.waiters {
+		delete(m.waiters, tok)
+		response <- muxResponse{err: err}
+	}
+}
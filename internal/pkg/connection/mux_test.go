@@ -0,0 +1,111 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+//reverseServer reads n queries from the first connection accepted by l and answers them in
+//reverse order with a notification containing the queried name.
+func reverseServer(l net.Listener, n int) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := cbor.NewReader(conn)
+	var queries []message.Message
+	for len(queries) < n {
+		msg := message.Message{}
+		if err := reader.Unmarshal(&msg); err != nil {
+			return
+		}
+		queries = append(queries, msg)
+	}
+	writer := cbor.NewWriter(conn)
+	for i := len(queries) - 1; i >= 0; i-- {
+		q := queries[i].Content[0].(*query.Name)
+		answer := message.Message{Token: queries[i].Token, Content: []section.Section{
+			&section.Notification{Type: section.NTNoAssertionsExist, Data: q.Name}}}
+		if err := writer.Marshal(&answer); err != nil {
+			return
+		}
+	}
+}
+
+func TestMuxConcurrentQueries(t *testing.T) {
+	const nofQueries = 50
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer l.Close()
+	go reverseServer(l, nofQueries)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Was not able to connect: %v", err)
+	}
+	mux := NewMux(conn)
+	defer mux.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, nofQueries)
+	for i := 0; i < nofQueries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("name%d.ch.", i)
+			msg := message.Message{Token: token.New(), Content: []section.Section{&query.Name{
+				Name: name, Context: "."}}}
+			answer, data, err := mux.Query(msg, 2*time.Second)
+			if err != nil {
+				errs <- fmt.Errorf("query %d failed: %v", i, err)
+				return
+			}
+			n, ok := answer.Content[0].(*section.Notification)
+			if answer.Token != msg.Token || !ok || n.Data != name || len(data) == 0 {
+				errs <- fmt.Errorf("query %d received a wrong answer: %v", i, answer)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMuxFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Was not able to connect: %v", err)
+	}
+	mux := NewMux(conn)
+	msg := message.Message{Token: token.New(), Content: []section.Section{&query.Name{Name: "ch."}}}
+	if _, _, err := mux.Query(msg, 2*time.Second); err == nil || mux.Err() == nil {
+		t.Fatalf("query on a closed connection must fail err=%v", err)
+	}
+	mux.Close()
+	if _, _, err := mux.Query(msg, time.Second); err != mux.Err() {
+		t.Errorf("query after failure must return the failure expected=%v actual=%v", mux.Err(), err)
+	}
+}
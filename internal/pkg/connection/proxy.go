@@ -0,0 +1,130 @@
+package connection
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+//Dialer opens connections to a network address. Its method set equals the one of
+//golang.org/x/net/proxy.Dialer such that dialers of that package can be used.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+//SOCKS5 is a Dialer opening TCP connections through the SOCKS5 proxy listening at Addr. It does not
+//authenticate to the proxy.
+type SOCKS5 struct {
+	Addr string
+}
+
+//Dial connects to address through the proxy.
+func (s SOCKS5) Dial(network, address string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("unsupported network for a SOCKS5 proxy: %s", network)
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", portStr)
+	}
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := socks5Connect(conn, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s failed to connect to %s: %v", s.Addr, address, err)
+	}
+	return conn, nil
+}
+
+//socks5Connect requests the SOCKS5 proxy at the other end of rw to connect to host and port.
+func socks5Connect(rw io.ReadWriter, host string, port uint16) error {
+	//version 5 offering a single authentication method: no authentication.
+	if _, err := rw.Write([]byte{5, 1, 0}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(rw, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		return errors.New("proxy requires authentication")
+	}
+	req := []byte{5, 1, 0} //version 5, connect, reserved
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 1)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := rw.Write(req); err != nil {
+		return err
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(rw, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("connection refused by proxy with code %d", head[1])
+	}
+	addrLen := 0
+	switch head[3] {
+	case 1:
+		addrLen = net.IPv4len
+	case 4:
+		addrLen = net.IPv6len
+	case 3:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(rw, l); err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return fmt.Errorf("unknown address type %d in proxy reply", head[3])
+	}
+	//the address and port the proxy bound are not needed.
+	_, err := io.ReadFull(rw, make([]byte, addrLen+2))
+	return err
+}
+
+//proxiedConn is a connection opened through a proxy. Its remote address is the one of the peer
+//instead of the proxy's such that it is cached and looked up by the peer's address.
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+//RemoteAddr returns the address of the peer behind the proxy.
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+//dialTLS opens a TLS connection to addr over a connection obtained from dialer.
+func dialTLS(dialer Dialer, addr net.Addr) (net.Conn, error) {
+	conn, err := dialer.Dial(addr.Network(), addr.String())
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &proxiedConn{Conn: tlsConn, remote: addr}, nil
+}
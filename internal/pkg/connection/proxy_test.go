@@ -0,0 +1,114 @@
+package connection
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//socksServer serves the first SOCKS5 connect request accepted by l. It sends the requested
+//address to targets and, if the address is not refused, relays the connection to it.
+func socksServer(l net.Listener, refused string, targets chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	var host string
+	switch head[3] {
+	case 1:
+		ip := make([]byte, net.IPv4len)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		l := make([]byte, 1)
+		io.ReadFull(conn, l)
+		name := make([]byte, l[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	targets <- target
+	if target == refused {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestSOCKS5Dial(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	var tests = []struct {
+		address string
+		errMsg  string
+	}{
+		{echo.Addr().String(), ""},
+		{"localhost:" + strings.Split(echo.Addr().String(), ":")[1], ""},
+		{"127.0.0.1:1", "connection refused by proxy with code 5"},
+	}
+	for i, test := range tests {
+		proxy, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("%d: Was not able to listen: %v", i, err)
+		}
+		targets := make(chan string, 1)
+		go socksServer(proxy, "127.0.0.1:1", targets)
+		conn, err := SOCKS5{Addr: proxy.Addr().String()}.Dial("tcp", test.address)
+		if target := <-targets; target != test.address {
+			t.Errorf("%d: wrong target requested from the proxy expected=%s actual=%s", i,
+				test.address, target)
+		}
+		if test.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("%d: wrong error expected=%s actual=%v", i, test.errMsg, err)
+			}
+			proxy.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: Was not able to dial through the proxy: %v", i, err)
+		}
+		conn.Write([]byte("ping"))
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+			t.Errorf("%d: connection is not relayed by the proxy reply=%s err=%v", i, reply, err)
+		}
+		conn.Close()
+		proxy.Close()
+	}
+}
@@ -11,11 +11,13 @@ var (
 	_TypeNameToValue = map[string]Type{
 		"TCP":   TCP,
 		"SCION": SCION,
+		"Unix":  Unix,
 	}
 
 	_TypeValueToName = map[Type]string{
 		TCP:   "TCP",
 		SCION: "SCION",
+		Unix:  "Unix",
 	}
 )
 
@@ -25,6 +27,7 @@ func init() {
 		_TypeNameToValue = map[string]Type{
 			interface{}(TCP).(fmt.Stringer).String():   TCP,
 			interface{}(SCION).(fmt.Stringer).String(): SCION,
+			interface{}(Unix).(fmt.Stringer).String():  Unix,
 		}
 	}
 }
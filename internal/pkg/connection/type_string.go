@@ -4,9 +4,9 @@ package connection
 
 import "strconv"
 
-const _Type_name = "TCPSCION"
+const _Type_name = "TCPSCIONUnix"
 
-var _Type_index = [...]uint8{0, 3, 8}
+var _Type_index = [...]uint8{0, 3, 8, 12}
 
 func (i Type) String() string {
 	i -= 1
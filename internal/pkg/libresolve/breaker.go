@@ -0,0 +1,63 @@
+package libresolve
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+//breakerState holds the consecutive failures of a server.
+type breakerState struct {
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time
+}
+
+//breakerStore is a circuit breaker per server. A server whose consecutive failures reach a
+//threshold within a window is considered unavailable until a cooldown has passed. Afterwards it is
+//probed again: a single failure makes it unavailable for another cooldown whereas a success resets
+//it. It is safe for concurrent use and its zero value is ready to use.
+type breakerStore struct {
+	mu      sync.Mutex
+	servers map[string]*breakerState
+}
+
+//failure records a failed query to addr at now. Failures older than window do not count towards
+//threshold unless window is 0. It returns true if addr is considered unavailable from now on.
+func (b *breakerStore) failure(addr net.Addr, now time.Time, threshold int, window,
+	cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.servers == nil {
+		b.servers = make(map[string]*breakerState)
+	}
+	s, ok := b.servers[addr.String()]
+	if !ok {
+		s = &breakerState{}
+		b.servers[addr.String()] = s
+	}
+	if s.failures < threshold && (s.failures == 0 || window > 0 && now.Sub(s.firstFailure) > window) {
+		s.failures, s.firstFailure = 0, now
+	}
+	s.failures++
+	if s.failures >= threshold {
+		s.openUntil = now.Add(cooldown)
+		return true
+	}
+	return false
+}
+
+//success records a successful query to addr which resets its failures.
+func (b *breakerStore) success(addr net.Addr) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.servers, addr.String())
+}
+
+//open returns true if addr must not be contacted at now.
+func (b *breakerStore) open(addr net.Addr, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.servers[addr.String()]
+	return ok && now.Before(s.openUntil)
+}
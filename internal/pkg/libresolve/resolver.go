@@ -2,18 +2,23 @@
 package libresolve
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
-	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
@@ -38,8 +43,58 @@ const (
 	udpScionPrefix                     = "_udpscion"
 	Recursive           ResolutionMode = iota
 	Forward
+	defaultDelegWorkers = 10
+	defaultDelegQueue   = 100
+	defaultBusyBackoff  = 30 * time.Second
+	//delegPollInterval is the duration a delegation query worker waits for a message on a
+	//connection before it turns to the next one.
+	delegPollInterval = 10 * time.Millisecond
+	//delegReadTimeout is the duration within which a peer must complete a started message.
+	delegReadTimeout = 5 * time.Second
+	//defaultFailureThreshold is the number of consecutive failures after which a server is
+	//considered unavailable.
+	defaultFailureThreshold = 3
+	defaultFailureWindow    = time.Minute
+	defaultFailureCooldown  = 30 * time.Second
+	//defaultMaxDelegations is the number of learned delegations a resolver caches.
+	defaultMaxDelegations = 10000
+	defaultMaxPrefetch    = 2
+	defaultMaxRedirects   = 32
+	//defaultMaxConnsPerServer is the number of connections the resolver caches per server.
+	defaultMaxConnsPerServer = 4
+	//defaultReadIdleTimeout is the duration after which an idle peer is disconnected.
+	defaultReadIdleTimeout = 5 * time.Minute
+	//defaultRTTProbeRate is the fraction of lookups which start at a server not having the lowest
+	//round-trip time.
+	defaultRTTProbeRate = 0.05
+	//defaultMaxAnswerSections is the number of sections of a response the resolver processes.
+	defaultMaxAnswerSections = 1000
+	//maxGlueQueries is the number of queries sent to look up the servers of a delegated zone.
+	maxGlueQueries = 8
+	//muxIdleTimeout is the duration after which a multiplexed connection without queries in
+	//flight is closed.
+	muxIdleTimeout = time.Minute
 )
 
+//notificationBehavior defines how the resolver reacts to a notification received from a server.
+type notificationBehavior int
+
+const (
+	//tryNextServer discards the response and continues the lookup at the next server.
+	tryNextServer notificationBehavior = iota + 1
+	//backoffServer behaves like tryNextServer and additionally avoids the server for BusyBackoff.
+	backoffServer
+)
+
+//notificationBehaviors maps the type of a notification received in response to a query to the
+//resolver's reaction. NTMsgTooLarge is handled by retrying the query over TCP.
+var notificationBehaviors = map[section.NotificationType]notificationBehavior{
+	section.NTServerTooBusy:    backoffServer,
+	section.NTUnspecServerErr:  tryNextServer,
+	section.NTServerNotCapable: tryNextServer,
+	section.NTNoAssertionAvail: tryNextServer,
+}
+
 var AllowedAddrTypes = map[object.Type]bool{
 	object.OTIP6Addr:    true,
 	object.OTIP4Addr:    true,
@@ -55,14 +110,24 @@ var AllowedRedirectTypes = map[object.Type]bool{
 	object.OTName:        true,
 }
 
+//glueTypes are the types asked for when looking up a name a redirect refers to.
+var glueTypes = []object.Type{object.OTServiceInfo, object.OTName, object.OTIP6Addr,
+	object.OTIP4Addr, object.OTScionAddr6, object.OTScionAddr4}
+
 // some of these types are not "method expressions" but will be invoked as such
 // they (or an interface-based approach) are needed to decouple logic and run tests on different
 // parts of the Resolver type
 
-type querySender func(msg message.Message, addr net.Addr, timeout time.Duration) (message.Message, error)
+type querySender func(msg message.Message, addr net.Addr, timeout time.Duration) (
+	message.Message, []byte, error)
 type answerHandler func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
-	isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string]object.ServiceInfo,
-	ipMap map[string]string, nameMap map[string]object.Name)
+	isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+	ipMap map[string][]string, nameMap map[string]object.Name)
+
+//AnswerFilter post-processes the answer of a lookup after it has been obtained and validated. It
+//returns the answer which replaces msg or an error if it rejects the answer. msg's sections may be
+//shared with the resolver's cache and must not be modified in place.
+type AnswerFilter func(msg *message.Message) (*message.Message, error)
 
 // Resolver provides methods to resolve names in RAINS.
 type Resolver struct {
@@ -72,12 +137,113 @@ type Resolver struct {
 	InsecureTLS       bool
 	DialTimeout       time.Duration
 	FailFast          bool
-	Delegations       *safeHashMap.Map
+	Delegations       cache.Delegation //bounded, the root delegation is never removed
 	Connections       cache.Connection
 	MaxCacheValidity  util.MaxCacheValidity
 	MaxRecursiveCount int
-	sendQuery         querySender
-	handleAnswer      answerHandler
+	//MaxDelegWorkers is the number of workers answering delegation queries on connections opened
+	//by this resolver. Each worker answers one message at a time and then turns to the next
+	//connection.
+	MaxDelegWorkers int
+	//DelegQueueSize is the number of connections on which delegation queries are answered in
+	//addition to MaxDelegWorkers. Connections exceeding it are closed.
+	DelegQueueSize int
+	//ReadIdleTimeout is the duration after which a connection on which delegation queries are
+	//answered is closed if the peer did not send a message. It is reset on each received message.
+	//0 disables the timeout.
+	ReadIdleTimeout time.Duration
+	//BusyBackoff is the duration during which a server which responded that it is too busy is
+	//not contacted.
+	BusyBackoff time.Duration
+	//FailureThreshold is the number of consecutive failed queries to a server within FailureWindow
+	//after which the server is considered unavailable and not contacted for FailureCooldown.
+	//Afterwards it is probed again. 0 disables the limit.
+	FailureThreshold int
+	//FailureWindow is the duration within which the failures of a server must occur to count
+	//towards FailureThreshold. 0 counts all consecutive failures.
+	FailureWindow time.Duration
+	//FailureCooldown is the duration during which a server which is considered unavailable is not
+	//contacted.
+	FailureCooldown time.Duration
+	//AllowOutOfBailiwick allows following redirects to servers whose names are not in the zone
+	//which delegated to the redirecting zone.
+	AllowOutOfBailiwick bool
+	//RequireSignedDelegations ignores delegation assertions which are neither signed themselves
+	//nor contained in a signed zone. They are not cached and the lookup fails if the delegation
+	//is only obtainable unsigned.
+	RequireSignedDelegations bool
+	//MaxPrefetch is the maximal number of delegations of redirect targets which are looked up
+	//concurrently to the next step of a recursive lookup. 0 disables prefetching.
+	MaxPrefetch int
+	//MaxRedirects is the maximal number of redirect targets which are tried during a lookup. The
+	//lookup fails when it is exceeded. 0 disables the limit.
+	MaxRedirects int
+	//MaxAnswerSections is the maximal number of sections of a response which are processed during
+	//a recursive lookup. The assertions contained in a shard or zone count as sections as well.
+	//Excess sections are dropped such that a single response cannot make the resolver store an
+	//unbounded amount of data. 0 disables the limit.
+	MaxAnswerSections int
+	//Minimize sends a server of an intermediate zone of a recursive lookup only a query for the
+	//delegation of the zone one label below it on the way to the queried name instead of the full
+	//name (query name minimization). The full query is only sent to the servers of the zone which
+	//does not delegate the next label.
+	Minimize bool
+	//MaxDelegationStaleness is the duration for which a cached delegation is still served after it
+	//expired. A delegation which expired longer ago is discarded and looked up anew. 0 disables
+	//the limit.
+	MaxDelegationStaleness time.Duration
+	//Multiplex sends the queries to a server over a single connection which is kept open and
+	//shared by concurrent lookups instead of opening a connection per query.
+	Multiplex bool
+	//OnConnError is called with the peer's address and the error if the resolver fails to open,
+	//write to or read from a connection. It is optional and must not block.
+	OnConnError func(addr net.Addr, err error)
+	//RTTProbeRate is the fraction of lookups in which a randomly chosen forwarder or root server is
+	//contacted first instead of the one with the lowest round-trip time. It keeps the round-trip
+	//times of the other servers up to date.
+	RTTProbeRate float64
+	//SearchList contains the domain suffixes LookupWithSearch appends in turn to a name which is
+	//not absolute, e.g. []string{"corp.", "example."}.
+	SearchList []string
+	//RelayReceivedEncodings retains the encoding of each section of an answer as it has been
+	//received and relays it unchanged when the answer is forwarded to a client by ServerLookup.
+	//The sections' signatures then remain valid even if the authority's encoding was not
+	//canonical. It complements LookupRaw which relays the whole answer.
+	RelayReceivedEncodings bool
+	//AnswerFilters are applied in order to the answer of a lookup by Lookup, ClientLookup and
+	//ServerLookup before it is returned. Each filter receives the answer returned by the previous
+	//one.
+	AnswerFilters []AnswerFilter
+	//Proxy opens the resolver's TCP connections to servers, e.g. through a SOCKS5 proxy. Any
+	//golang.org/x/net/proxy.Dialer can be used. If it is nil, connections are opened directly.
+	Proxy connection.Dialer
+	//Logger receives the log records of the resolver such that an embedding application can route
+	//them into its own logger or filter them. If it is nil, the global log15 logger is used.
+	Logger log.Logger
+	//AddrResolver maps the host and port of a server a redirect points to to the address which
+	//is dialed. It allows tests to direct lookups to stub servers and deployments to apply their
+	//own policy, e.g. for split-horizon. It defaults to DefaultAddrResolver.
+	AddrResolver func(host string, port uint16) (net.Addr, error)
+	sendQuery    querySender
+	handleAnswer answerHandler
+	delegConns   chan *delegConn
+	delegOnce    sync.Once
+	backoffs     sync.Map
+	//prefetches maps a zone to a channel which is closed when the prefetch of its delegation ends.
+	prefetches sync.Map
+	//muxes maps a server address to the multiplexer of the connection to it.
+	muxes   map[string]*muxEntry
+	muxesMu sync.Mutex
+	//rtts holds the smoothed round-trip times of the contacted servers.
+	rtts rttStore
+	//stats holds the counters returned by Stats.
+	stats statsCounters
+	//breakers tracks the consecutive failures of the contacted servers.
+	breakers breakerStore
+	//delegOpen is the number of connections on which delegation queries are answered. It is
+	//bounded by delegCap.
+	delegOpen atomic.Int64
+	delegCap  int64
 }
 
 //New creates a resolver with the given parameters and default settings
@@ -90,19 +256,30 @@ func New(rootNS, forwarders []net.Addr, rootKeyPath string, mode ResolutionMode,
 		InsecureTLS:       defaultInsecureTLS,
 		DialTimeout:       defaultTimeout,
 		FailFast:          defaultFailFast,
-		Delegations:       safeHashMap.New(),
-		Connections:       cache.NewConnection(maxConn),
+		Delegations:       cache.NewDelegation(defaultMaxDelegations),
+		Connections:       cache.NewConnection(maxConn, defaultMaxConnsPerServer),
 		MaxCacheValidity:  maxCacheValidity,
 		MaxRecursiveCount: maxRecursiveCount,
+		MaxDelegWorkers:   defaultDelegWorkers,
+		DelegQueueSize:    defaultDelegQueue,
+		ReadIdleTimeout:   defaultReadIdleTimeout,
+		BusyBackoff:       defaultBusyBackoff,
+		FailureThreshold:  defaultFailureThreshold,
+		FailureWindow:     defaultFailureWindow,
+		FailureCooldown:   defaultFailureCooldown,
+		MaxPrefetch:       defaultMaxPrefetch,
+		MaxRedirects:      defaultMaxRedirects,
+		RTTProbeRate:      defaultRTTProbeRate,
+		MaxAnswerSections: defaultMaxAnswerSections,
 		// now the pointers to functions
-		sendQuery:    util.SendQuery,
 		handleAnswer: handleAnswer,
 	}
+	r.sendQuery = r.sendQueryRaw
 	// load the root zone public key and store it as a delegation:
 	a := new(section.Assertion)
 	err := util.Load(rootKeyPath, a)
 	if err != nil {
-		log.Warn("Failed to load root zone public key", "err", err)
+		r.logger().Warn("Failed to load root zone public key", "err", err)
 		return nil, err
 	}
 	since, until := util.GetOverlapValidityForSignatures(a.AllSigs())
@@ -111,139 +288,827 @@ func New(rootNS, forwarders []net.Addr, rootKeyPath string, mode ResolutionMode,
 	pk.ValidSince = a.ValidSince()
 	pk.ValidUntil = a.ValidUntil()
 	a.Content[0].Value = pk
-	r.Delegations.Add(a.FQDN(), a)
+	r.Delegations.Add(a.FQDN(), a, true)
 	return r, nil
 }
 
+//Result contains the answer of a lookup together with the servers which have been contacted to
+//obtain it.
+type Result struct {
+	//Answer is the message answering the query.
+	Answer *message.Message
+	//Server is the address of the server which sent Answer. It is nil if the answer was served
+	//from the resolver's cache.
+	Server net.Addr
+	//Servers contains the addresses of all servers contacted in the lookup in the order they
+	//have been contacted. The last entry is Server.
+	Servers []net.Addr
+	//Raw is the encoding of Answer as it has been received from Server. It is nil if the answer
+	//was served from the resolver's cache.
+	Raw []byte
+	//Age is the time for which the resolver has held Answer in its cache. It is 0 if the answer
+	//has been obtained from a server. A client caching the answer subtracts it from the answer's
+	//remaining lifetime.
+	Age time.Duration
+	//Status tells whether Answer answers the query or proves that the queried name or the
+	//requested types do not exist.
+	Status AnswerStatus
+}
+
+//AnswerStatus classifies the answer to a query.
+type AnswerStatus int
+
+const (
+	//Undetermined is the status of an answer which neither answers the query nor proves that
+	//there is no answer, e.g. a notification.
+	Undetermined AnswerStatus = iota
+	//Answered is the status of an answer containing an assertion for the queried name with an
+	//object of a requested type.
+	Answered
+	//NoData is the status of an answer containing assertions for the queried name but none with
+	//an object of a requested type. The name exists.
+	NoData
+	//NXDomain is the status of an answer containing a shard or zone covering the queried name
+	//without an assertion for it. The name does not exist.
+	NXDomain
+)
+
 //ClientLookup forwards the query to the specified forwarders or performs a recursive lookup starting at
-//the specified root servers. It returns the received information.
-func (r *Resolver) ClientLookup(query *query.Name) (*message.Message, error) {
+//the specified root servers. It returns the received information. The query's name is normalized
+//before the lookup and an error is returned if it is invalid or if its options contradict each
+//other. The given options are added to the query and sent to the servers. The resolver itself
+//honors the following options: QOMaxFreshness bypasses the resolver's cache and
+//QOCachedAnswersOnly prevents a recursive lookup if there is no cached answer. The returned message
+//does not carry how long an answer served from the resolver's cache has been held. A client
+//deriving its own cache lifetime from the answer uses Lookup, whose Result.Age reports it.
+func (r *Resolver) ClientLookup(query *query.Name, opts ...query.Option) (*message.Message, error) {
+	result, err := r.Lookup(query, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return result.Answer, nil
+}
+
+//Lookup behaves like ClientLookup but additionally returns the addresses of the contacted servers
+//and, for answers served from the resolver's cache, their age.
+func (r *Resolver) Lookup(query *query.Name, opts ...query.Option) (*Result, error) {
+	r.stats.inc(&r.stats.lookups)
+	result, err := r.lookup(query, opts...)
+	if err != nil {
+		r.stats.inc(&r.stats.failedLookups)
+	}
+	return result, err
+}
+
+//lookup implements Lookup.
+func (r *Resolver) lookup(query *query.Name, opts ...query.Option) (*Result, error) {
+	query, err := query.Normalized()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts) > 0 {
+		query = query.WithOptions(opts...)
+	}
+	if err := query.OptionsConsistent(); err != nil {
+		return nil, err
+	}
+	var result *Result
 	switch r.Mode {
 	case Recursive:
-		return r.recursiveResolve(query, 0)
+		result, err = r.recursiveResolve(query, 0)
 	case Forward:
-		return r.forwardQuery(query)
+		result, err = r.forwardQuery(query)
 	default:
 		return nil, fmt.Errorf("Unsupported resolution mode: %v", r.Mode)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return r.filterAnswer(result, query)
+}
+
+//filterAnswer applies the resolver's answer filters to the answer of result. It returns a copy of
+//result with the filtered answer whose status is determined anew. As the answer is not the one
+//received anymore, its encoding is dropped. An error is returned if a filter rejects the answer.
+func (r *Resolver) filterAnswer(result *Result, q *query.Name) (*Result, error) {
+	if len(r.AnswerFilters) == 0 || result == nil || result.Answer == nil {
+		return result, nil
+	}
+	answer := result.Answer
+	for i, filter := range r.AnswerFilters {
+		filtered, err := filter(answer)
+		if err != nil {
+			return nil, fmt.Errorf("answer filter %d rejected the answer to query %s: %v", i, q.Name, err)
+		}
+		if filtered == nil {
+			return nil, fmt.Errorf("answer filter %d returned no answer to query %s", i, q.Name)
+		}
+		answer = filtered
+	}
+	filtered := *result
+	filtered.Answer = answer
+	filtered.Raw = nil
+	filtered.Status = answerStatus(answer, q)
+	return &filtered, nil
+}
+
+//LookupRaw behaves like ClientLookup but returns the encoding of the answer exactly as it has been
+//received from the server. This allows a proxy to relay the signed answer without re-encoding it.
+//Answers served from the resolver's cache are encoded by the resolver. LookupRaw returns an error
+//if ctx is done before the lookup has finished.
+func (r *Resolver) LookupRaw(ctx context.Context, query *query.Name) ([]byte, error) {
+	result, err := r.lookupContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if result.Raw != nil {
+		return result.Raw, nil
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(result.Answer); err != nil {
+		return nil, fmt.Errorf("failed to encode cached answer: %v", err)
+	}
+	return encoding.Bytes(), nil
+}
+
+//lookupContext behaves like Lookup but returns an error if ctx is done before the lookup has
+//finished.
+func (r *Resolver) lookupContext(ctx context.Context, query *query.Name) (*Result, error) {
+	type response struct {
+		result *Result
+		err    error
+	}
+	done := make(chan response, 1)
+	go func() {
+		result, err := r.Lookup(query)
+		done <- response{result: result, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case resp := <-done:
+		return resp.result, resp.err
+	}
+}
+
+//SearchError is returned by LookupWithSearch if none of the names it tried resolved. Errors holds
+//the reason for each name in Names.
+type SearchError struct {
+	Names  []string
+	Errors []error
+}
+
+func (e *SearchError) Error() string {
+	reasons := make([]string, len(e.Names))
+	for i, name := range e.Names {
+		reasons[i] = fmt.Sprintf("%s: %v", name, e.Errors[i])
+	}
+	return fmt.Sprintf("no name of the search list resolved [%s]", strings.Join(reasons, "; "))
+}
+
+//LookupWithSearch resolves shortName in the global context by appending the suffixes of
+//SearchList in turn. It returns the answer of the first name which exists and has an assertion of
+//one of types. A name ending in a dot is absolute and resolved as is. If no name resolves, a
+//*SearchError is returned. LookupWithSearch returns an error if ctx is done before it finished.
+func (r *Resolver) LookupWithSearch(ctx context.Context, shortName string, types []object.Type) (
+	*message.Message, error) {
+	names := []string{shortName}
+	if !strings.HasSuffix(shortName, ".") && len(r.SearchList) > 0 {
+		names = names[:0]
+		for _, suffix := range r.SearchList {
+			suffix = strings.TrimPrefix(suffix, ".")
+			if !strings.HasSuffix(suffix, ".") {
+				suffix += "."
+			}
+			names = append(names, fmt.Sprintf("%s.%s", shortName, suffix))
+		}
+	}
+	searchErr := &SearchError{}
+	for _, name := range names {
+		q := &query.Name{
+			Name:       name,
+			Context:    section.GlobalContext,
+			Types:      types,
+			Expiration: time.Now().Add(r.DialTimeout * time.Millisecond).Unix(),
+		}
+		result, err := r.lookupContext(ctx, q)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil && result.Status != Answered {
+			err = fmt.Errorf("no assertion of the requested types exists for %s", q.Name)
+		}
+		if err == nil {
+			return result.Answer, nil
+		}
+		searchErr.Names = append(searchErr.Names, name)
+		searchErr.Errors = append(searchErr.Errors, err)
+	}
+	return nil, searchErr
+}
+
+//answerStatus classifies msg as answer to q. An assertion of a shard or zone is considered with
+//the shard's or zone's context and subject zone.
+func answerStatus(msg *message.Message, q *query.Name) AnswerStatus {
+	if msg == nil {
+		return Undetermined
+	}
+	var assertions []*section.Assertion
+	covered := false
+	for _, sec := range msg.Content {
+		switch s := sec.(type) {
+		case *section.Assertion:
+			assertions = append(assertions, s)
+		case *section.Shard:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+			if subjectName, ok := section.EnclosingZone(q.Name, s.SubjectZone); ok &&
+				s.InRange(subjectName) {
+				covered = true
+			}
+		case *section.Zone:
+			for _, a := range s.Content {
+				assertions = append(assertions, a.Copy(s.Context, s.SubjectZone))
+			}
+			if _, ok := section.EnclosingZone(q.Name, s.SubjectZone); ok {
+				covered = true
+			}
+		}
+	}
+	status := Undetermined
+	for _, a := range assertions {
+		if section.AssertionAnswers(a, q) {
+			return Answered
+		}
+		if a.FQDN() == q.Name {
+			status = NoData
+		}
+	}
+	if status == Undetermined && covered {
+		return NXDomain
+	}
+	return status
 }
 
 //ServerLookup forwards the query to the specified forwarders or performs a recursive lookup
-//starting at the specified root servers. It sends the received information to conInfo.
+//starting at the specified root servers. It sends the received information to addr. If the lookup
+//fails, a notification with the query's token is sent to addr instead.
 func (r *Resolver) ServerLookup(query *query.Name, addr net.Addr, token token.Token) {
-	var msg *message.Message
+	var result *Result
 	var err error
-	log.Info("recResolver received query", "query", query, "token", token)
+	r.logger().Info("recResolver received query", "query", query, "token", token)
+	r.stats.inc(&r.stats.lookups)
 	switch r.Mode {
 	case Recursive:
-		msg, err = r.recursiveResolve(query, 0)
+		result, err = r.recursiveResolve(query, 0)
 	case Forward:
-		msg, err = r.forwardQuery(query)
+		result, err = r.forwardQuery(query)
 	default:
-		log.Error("Unsupported resolution mode", "mode", r.Mode)
+		r.logger().Error("Unsupported resolution mode", "mode", r.Mode)
+		r.stats.inc(&r.stats.failedLookups)
+		r.answer(addr, notificationMsg(token, section.NTServerNotCapable,
+			fmt.Sprintf("unsupported resolution mode: %v", r.Mode)))
 		return
 	}
+	if err == nil && (result == nil || result.Answer == nil) {
+		err = errors.New("resolution returned no answer")
+	}
+	if err == nil {
+		result, err = r.filterAnswer(result, query)
+	}
 	if err != nil {
-		log.Error("Query failed", "query failure", err)
+		r.logger().Error("Query failed", "query failure", err)
+		r.stats.inc(&r.stats.failedLookups)
+		r.answer(addr, notificationMsg(token, section.NTUnspecServerErr, err.Error()))
 		return
 	}
+	msg := result.Answer
 	msg.Token = token
-	if conn, ok := r.Connections.GetConnection(addr); ok {
-		log.Info("recResolver answers query", "answer", msg, "token", token, "conn",
+	r.answer(addr, msg)
+}
+
+//DumpDelegations returns a snapshot of the delegations the resolver has cached, sorted by zone. It
+//is intended for debugging trust issues and safe to call concurrently to lookups.
+func (r *Resolver) DumpDelegations() []cache.DelegationEntry {
+	return r.Delegations.Snapshot()
+}
+
+//notificationMsg returns a message with the given token containing a single notification of type t
+//about the message with this token.
+func notificationMsg(tok token.Token, t section.NotificationType, data string) *message.Message {
+	return &message.Message{Token: tok, Content: []section.Section{
+		&section.Notification{Type: t, Token: tok, Data: data}}}
+}
+
+//answer sends msg to addr over a cached connection if there is one and over a new one otherwise.
+func (r *Resolver) answer(addr net.Addr, msg *message.Message) {
+	if conn, ok := r.Connections.GetConnection(addr); ok && len(conn) > 0 {
+		r.logger().Info("recResolver answers query", "answer", msg, "token", msg.Token, "conn",
 			conn[0].RemoteAddr(), "resolver", conn[0].LocalAddr())
-		writer := cbor.NewWriter(conn[0])
-		if err := writer.Marshal(msg); err != nil {
-			r.createConnAndWrite(addr, msg) //Connection has been closed in the mean time
+		err := writeMessage(conn[0], msg)
+		if err == nil {
+			return
 		}
-	} else {
-		r.createConnAndWrite(addr, msg)
+		//Connection has been closed in the mean time
+		r.connError(addr, err)
+		r.Connections.CloseAndRemoveConnection(conn[0])
 	}
+	r.createConnAndWrite(addr, msg)
 }
 
+//createConnAndWrite sends msg to addr over a cached connection. It only opens a new connection if
+//there is none such that the cache's limit of connections per server is respected.
 func (r *Resolver) createConnAndWrite(addr net.Addr, msg *message.Message) {
-	conn, err := connection.CreateConnection(addr)
+	if conns, ok := r.Connections.GetConnection(addr); ok && len(conns) > 0 {
+		if err := writeMessage(conns[0], msg); err != nil {
+			r.connError(addr, err)
+			r.Connections.CloseAndRemoveConnection(conns[0])
+		}
+		return
+	}
+	conn, err := connection.CreateConnectionVia(addr, r.Proxy)
 	if err != nil {
-		log.Error("Was not able to open a connection", "dst", addr)
+		r.logger().Error("Was not able to open a connection", "dst", addr, "error", err)
+		r.connError(addr, err)
 		return
 	}
-	go r.answerDelegQueries(conn)
-
 	switch conn.LocalAddr().(type) {
-	case *net.TCPAddr:
-		r.Connections.AddConnection(conn)
-		writer := cbor.NewWriter(conn)
-		if err := writer.Marshal(&msg); err != nil {
-			log.Error("failed to marshal message", err)
+	case *net.TCPAddr, *net.UnixAddr:
+		if !r.Connections.AddConnection(conn) {
+			//the cache is at its limit for addr, another lookup opened a connection in the mean time.
+			conn.Close()
+			conns, ok := r.Connections.GetConnection(conn.RemoteAddr())
+			if !ok {
+				r.logger().Error("Was not able to cache or reuse a connection", "dst", addr)
+				return
+			}
+			if err := writeMessage(conns[0], msg); err != nil {
+				r.connError(addr, err)
+				r.Connections.CloseAndRemoveConnection(conns[0])
+			}
+			return
+		}
+		if err := writeMessage(conn, msg); err != nil {
+			//a failed write might have left a partial message on conn.
+			r.logger().Error("failed to marshal message", "error", err)
+			r.connError(addr, err)
 			r.Connections.CloseAndRemoveConnections(addr)
+			return
 		}
 	case *snet.Addr:
-		encoding := new(bytes.Buffer)
-		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
-			log.Error("failed to marshal message to conn:", err)
-		}
-		if _, err := conn.Write(encoding.Bytes()); err != nil {
-			log.Error("unable to write encoded message to connection:", err)
+		if err := writeMessage(conn, msg); err != nil {
+			r.logger().Error("unable to write encoded message to connection:", "error", err)
+			r.connError(addr, err)
+			conn.Close()
+			return
 		}
 	}
+	r.dispatchDelegQueries(conn)
 }
 
-func (r *Resolver) forwardQuery(q *query.Name) (*message.Message, error) {
+//writeMessage encodes msg and writes it to w in a single write. Sections of msg whose encoding has
+//been retained are written as they have been received.
+func writeMessage(w io.Writer, msg *message.Message) error {
+	encoding, err := msg.EncodeRetained()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoding)
+	return err
+}
+
+func (r *Resolver) forwardQuery(q *query.Name) (*Result, error) {
 	if len(r.Forwarders) == 0 {
 		return nil, errors.New("forwarders must be specified to use this mode")
 	}
-	for _, forwarder := range r.Forwarders {
-		msg := message.Message{Token: token.New(), Content: []section.Section{q}}
-		answer, err := r.sendQuery(msg, forwarder, r.DialTimeout*time.Millisecond)
+	r.stats.inc(&r.stats.cacheMisses)
+	servers := []net.Addr{}
+	queryMsg := message.Message{Content: []section.Section{q}}
+	for _, forwarder := range r.rtts.order(r.Forwarders, r.RTTProbeRate) {
+		if r.backingOff(forwarder) {
+			r.logger().Debug("skip forwarder during backoff", "serverAddr", forwarder)
+			continue
+		}
+		msg := queryMsg.WithNewToken()
+		servers = append(servers, forwarder)
+		answer, raw, err := r.send(msg, forwarder)
 		if err == nil {
-			return &answer, nil
+			return &Result{Answer: &answer, Server: forwarder, Servers: servers, Raw: raw,
+				Status: answerStatus(&answer, q)}, nil
 		}
 	}
 	return nil, fmt.Errorf("could not connect to any of the specified resolver: %v", r.Forwarders)
 }
 
+//send sends msg to addr and returns the answer. If the answer was truncated by the server because
+//of the transport's size restriction, the query is re-issued over TCP. The resolver announces
+//that it reassembles zones which are split into chunks. It also returns the encoding of the answer
+//as it has been received. An error is returned if the server responded with a
+//notification upon which the lookup must be continued at another server. The round-trip time of
+//the query is recorded for addr. A server which did not answer is accounted with the timeout and
+//the failure counts towards FailureThreshold.
+func (r *Resolver) send(msg message.Message, addr net.Addr) (message.Message, []byte, error) {
+	msg.Capabilities = withCapability(msg.Capabilities, message.ChunkedZones)
+	sendQuery := r.sendQuery
+	if r.Multiplex {
+		sendQuery = r.sendMultiplexed
+	}
+	start := time.Now()
+	r.stats.inc(&r.stats.queries)
+	answer, raw, err := sendQuery(msg, addr, r.DialTimeout*time.Millisecond)
+	if err != nil {
+		r.stats.inc(&r.stats.queryErrors)
+		r.rtts.observe(addr, r.DialTimeout*time.Millisecond)
+		r.serverFailed(addr)
+	} else {
+		r.rtts.observe(addr, time.Since(start))
+		r.breakers.success(addr)
+	}
+	if err == nil && answer.IsTruncated() {
+		tcpAddr, ok := tcpFallbackAddr(addr)
+		if !ok {
+			return answer, raw, fmt.Errorf("answer from %s was truncated and no TCP fallback is available", addr)
+		}
+		r.logger().Info("answer was truncated. Retry over TCP", "serverAddr", addr, "tcpAddr", tcpAddr)
+		msg = msg.WithNewToken()
+		r.stats.inc(&r.stats.queries)
+		if answer, raw, err = sendQuery(msg, tcpAddr, r.DialTimeout*time.Millisecond); err != nil {
+			r.stats.inc(&r.stats.queryErrors)
+		}
+	}
+	if err != nil {
+		return answer, raw, err
+	}
+	if r.RelayReceivedEncodings && raw != nil {
+		if err := answer.RetainEncodings(raw); err != nil {
+			r.logger().Warn("Was not able to retain the encodings of the answer", "serverAddr", addr,
+				"error", err)
+		}
+	}
+	return answer, raw, r.checkNotifications(answer, msg.Token, addr)
+}
+
+//sendQueryRaw sends msg to addr over a new connection which is opened through Proxy if it is set.
+func (r *Resolver) sendQueryRaw(msg message.Message, addr net.Addr, timeout time.Duration) (
+	message.Message, []byte, error) {
+	return util.SendQueryVia(msg, addr, timeout, r.Proxy)
+}
+
+//sendMultiplexed sends msg to addr over the shared connection to addr and returns the answer. A
+//connection which failed is discarded such that the next query opens a new one.
+func (r *Resolver) sendMultiplexed(msg message.Message, addr net.Addr, timeout time.Duration) (
+	message.Message, []byte, error) {
+	entry, err := r.mux(addr)
+	if err != nil {
+		r.connError(addr, err)
+		return message.Message{}, nil, err
+	}
+	answer, raw, err := entry.mux.Query(msg, timeout)
+	failed := err != nil && entry.mux.Err() != nil
+	r.muxesMu.Lock()
+	entry.inflight--
+	entry.lastUsed = time.Now()
+	if failed && r.muxes[addr.String()] == entry {
+		delete(r.muxes, addr.String())
+	}
+	r.muxesMu.Unlock()
+	if failed {
+		r.connError(addr, err)
+	}
+	return answer, raw, err
+}
+
+//muxEntry holds the multiplexer of the connection to a server. ready is closed as soon as the
+//connection is open or opening it failed with err. The remaining fields are protected by
+//Resolver.muxesMu.
+type muxEntry struct {
+	ready    chan struct{}
+	mux      *connection.Mux
+	err      error
+	inflight int
+	lastUsed time.Time
+}
+
+//usable returns true if the connection is being opened or is open and has not failed.
+func (e *muxEntry) usable() bool {
+	select {
+	case <-e.ready:
+		return e.err == nil && e.mux.Err() == nil
+	default:
+		return true
+	}
+}
+
+//idle returns true if the connection is open and no query has been in flight on it since
+//muxIdleTimeout.
+func (e *muxEntry) idle(now time.Time) bool {
+	select {
+	case <-e.ready:
+		return e.inflight == 0 && now.Sub(e.lastUsed) >= muxIdleTimeout
+	default:
+		return false
+	}
+}
+
+//mux returns the entry of the connection to addr and counts a query in flight on it. It opens a
+//connection if there is none. The connection is opened outside of muxesMu and only once if
+//several queries to addr arrive concurrently. Connections which have been idle for
+//muxIdleTimeout are closed.
+func (r *Resolver) mux(addr net.Addr) (*muxEntry, error) {
+	key := addr.String()
+	now := time.Now()
+	r.muxesMu.Lock()
+	idle := r.removeMuxes(func(e *muxEntry) bool { return e.idle(now) })
+	entry, ok := r.muxes[key]
+	dial := !ok || !entry.usable()
+	if dial {
+		entry = &muxEntry{ready: make(chan struct{}), lastUsed: now}
+		if r.muxes == nil {
+			r.muxes = make(map[string]*muxEntry)
+		}
+		r.muxes[key] = entry
+	}
+	r.muxesMu.Unlock()
+	closeMuxes(idle)
+	if dial {
+		conn, err := connection.CreateConnectionVia(addr, r.Proxy)
+		r.muxesMu.Lock()
+		if err != nil {
+			entry.err = err
+			if r.muxes[key] == entry {
+				delete(r.muxes, key)
+			}
+		} else {
+			entry.mux = connection.NewMux(conn)
+		}
+		close(entry.ready)
+		r.muxesMu.Unlock()
+	}
+	<-entry.ready
+	r.muxesMu.Lock()
+	defer r.muxesMu.Unlock()
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	entry.inflight++
+	return entry, nil
+}
+
+//removeMuxes removes the entries for which remove returns true and returns them. muxesMu must be
+//held.
+func (r *Resolver) removeMuxes(remove func(*muxEntry) bool) []*muxEntry {
+	var removed []*muxEntry
+	for key, entry := range r.muxes {
+		if remove(entry) {
+			removed = append(removed, entry)
+			delete(r.muxes, key)
+		}
+	}
+	return removed
+}
+
+//closeMuxes closes the connections of entries once they have been opened.
+func closeMuxes(entries []*muxEntry) {
+	for _, entry := range entries {
+		<-entry.ready
+		if entry.mux != nil {
+			entry.mux.Close()
+		}
+	}
+}
+
+//Close closes the connections the resolver holds open to multiplex queries. Queries in flight on
+//them fail. The resolver remains usable and opens new connections as needed.
+func (r *Resolver) Close() error {
+	r.muxesMu.Lock()
+	entries := r.removeMuxes(func(*muxEntry) bool { return true })
+	r.muxesMu.Unlock()
+	closeMuxes(entries)
+	return nil
+}
+
+//withCapability returns caps with c appended unless caps already contains it. caps itself is not
+//modified.
+func withCapability(caps []message.Capability, c message.Capability) []message.Capability {
+	for _, capability := range caps {
+		if capability == c {
+			return caps
+		}
+	}
+	return append(append(make([]message.Capability, 0, len(caps)+1), caps...), c)
+}
+
+//checkNotifications returns an error if answer contains a notification for the query with token
+//tok upon which the lookup must be continued at another server. A server which is too busy is
+//additionally avoided for BusyBackoff.
+func (r *Resolver) checkNotifications(answer message.Message, tok token.Token, addr net.Addr) error {
+	for _, sec := range answer.Content {
+		n, ok := sec.(*section.Notification)
+		if !ok || n.Token != tok {
+			continue
+		}
+		switch notificationBehaviors[n.Type] {
+		case backoffServer:
+			r.stats.inc(&r.stats.serverErrors)
+			r.logger().Info("server is too busy. Back off", "serverAddr", addr, "backoff", r.BusyBackoff)
+			r.backoffs.Store(addr.String(), time.Now().Add(r.BusyBackoff))
+			return fmt.Errorf("server %s is too busy: %s", addr, n.Data)
+		case tryNextServer:
+			r.stats.inc(&r.stats.serverErrors)
+			return fmt.Errorf("server %s responded with %s: %s", addr, n.Type, n.Data)
+		}
+	}
+	return nil
+}
+
+//serverFailed records that a query to the server at addr failed. The server is considered
+//unavailable if it failed FailureThreshold times in a row.
+func (r *Resolver) serverFailed(addr net.Addr) {
+	if r.FailureThreshold <= 0 {
+		return
+	}
+	if r.breakers.failure(addr, time.Now(), r.FailureThreshold, r.FailureWindow, r.FailureCooldown) {
+		r.logger().Warn("server is unavailable after consecutive failures", "serverAddr", addr,
+			"cooldown", r.FailureCooldown)
+	}
+}
+
+//logger returns the logger to which the resolver writes its log records.
+func (r *Resolver) logger() log.Logger {
+	if r.Logger == nil {
+		return log.Root()
+	}
+	return r.Logger
+}
+
+//backingOff returns true if the server at addr must currently not be contacted because it is too
+//busy or considered unavailable.
+func (r *Resolver) backingOff(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	if r.FailureThreshold > 0 && r.breakers.open(addr, time.Now()) {
+		return true
+	}
+	until, ok := r.backoffs.Load(addr.String())
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until.(time.Time)) {
+		return true
+	}
+	r.backoffs.Delete(addr.String())
+	return false
+}
+
+//tcpFallbackAddr returns the TCP address of the server listening on addr. It assumes that the
+//server listens for TCP connections on the same host and port.
+func tcpFallbackAddr(addr net.Addr) (*net.TCPAddr, bool) {
+	if a, ok := addr.(*snet.Addr); ok && a.Host != nil && a.Host.L3 != nil && a.Host.L4 != nil {
+		return &net.TCPAddr{IP: a.Host.L3.IP(), Port: int(a.Host.L4.Port())}, true
+	}
+	return nil, false
+}
+
+//servable returns true if the cached delegation a expired at most MaxDelegationStaleness ago.
+func (r *Resolver) servable(a *section.Assertion) bool {
+	if r.MaxDelegationStaleness == 0 {
+		return true
+	}
+	entry := cache.DelegationEntry{Zone: a.FQDN(), Assertion: a, ValidSince: a.ValidSince(),
+		ValidUntil: a.ValidUntil()}
+	staleBy := entry.StaleBy(time.Now().Unix())
+	if staleBy > r.MaxDelegationStaleness {
+		r.logger().Debug("discard stale cached delegation", "delegation", a, "staleBy", staleBy)
+		return false
+	}
+	return true
+}
+
 // recursiveResolve starts at the root and follows delegations until it receives an answer.
 // It aborts if called more than "recurseCount" times recursively.
-func (r *Resolver) recursiveResolve(q *query.Name, recurseCount int) (*message.Message, error) {
+func (r *Resolver) recursiveResolve(q *query.Name, recurseCount int) (*Result, error) {
+	return r.resolveKeyPhase(q, recurseCount, false)
+}
+
+//resolveKeyPhase is recursiveResolve. If phaseSpecific is set, a cached delegation only answers a
+//delegation query if it contains a key of the query's key phase. Otherwise, e.g. for prefetches and
+//clients' queries, any cached delegation answers it.
+func (r *Resolver) resolveKeyPhase(q *query.Name, recurseCount int, phaseSpecific bool) (*Result, error) {
 	if recurseCount >= r.MaxRecursiveCount {
 		return nil, fmt.Errorf("Maximum number of recursive calls reached at %d. Aborting", recurseCount)
 	}
 	//Check for cached delegation assertion
 	for _, t := range q.Types {
-		if t == object.OTDelegation {
-			if a, ok := r.Delegations.Get(q.Name); ok {
-				log.Info("respond with a cached delegation", "delegation", a, "query", q)
-				return &message.Message{Content: []section.Section{a.(*section.Assertion)}}, nil
+		if t == object.OTDelegation && !q.ContainsOption(query.QOMaxFreshness) {
+			if a, age, ok := r.Delegations.GetWithAge(q.Name); ok &&
+				(!phaseSpecific || a.DelegatesKeyPhase(q.KeyPhase)) && r.servable(a) {
+				r.logger().Info("respond with a cached delegation", "delegation", a, "query", q, "age", age)
+				r.stats.inc(&r.stats.cacheHits)
+				return &Result{Answer: &message.Message{Content: []section.Section{a}}, Age: age,
+					Status: Answered}, nil
 			}
 			break
 		}
 	}
+	r.stats.inc(&r.stats.cacheMisses)
+	if q.ContainsOption(query.QOCachedAnswersOnly) {
+		return nil, fmt.Errorf("no cached answer for query: %s", q.String())
+	}
 	//Start recursive lookup
-	for _, root := range r.RootNameServers {
-		log.Debug("connecting to root server", "serverAddr", root, "query", q)
-		addr := root
-		for {
-			msg := message.Message{Token: token.New(), Content: []section.Section{q}}
-			answer, err := r.sendQuery(msg, addr, r.DialTimeout*time.Millisecond)
+	servers := []net.Addr{}
+	redirects := 0
+	for _, root := range r.rtts.order(r.RootNameServers, r.RTTProbeRate) {
+		r.logger().Debug("connecting to root server", "serverAddr", root, "query", q)
+		//candidates holds the servers of zone, the zone the lookup was last redirected to. The next
+		//one is tried if a server does not answer.
+		candidates := []net.Addr{root}
+		zone := section.RootZone
+		minimize := r.Minimize
+		for len(candidates) > 0 {
+			addr := candidates[0]
+			candidates = candidates[1:]
+			if r.backingOff(addr) {
+				r.logger().Debug("skip server during backoff", "serverAddr", addr)
+				continue
+			}
+			hopQuery := q
+			if minimize {
+				hopQuery = minimizedQuery(q, zone)
+			}
+			queryMsg := message.Message{Content: []section.Section{hopQuery}}
+			msg := queryMsg.WithNewToken()
+			servers = append(servers, addr)
+			answer, raw, err := r.send(msg, addr)
 			if err != nil || len(answer.Content) == 0 {
-				log.Debug("error in send query", "err", err)
-				break
+				r.logger().Debug("error in send query", "err", err)
+				continue
 			}
-			log.Info("recursive resolver rcv answer", "answer", answer, "query", q)
-			isFinal, isRedir, redirMap, srvMap, ipMap, nameMap := r.handleAnswer(r, answer, q, recurseCount)
-			log.Info("handling answer in recursive lookup", "serverAddr", addr, "isFinal",
+			//the answer returned must only contain the sections which have been processed.
+			if r.limitSections(&answer, hopQuery) {
+				raw = nil
+			}
+			r.logger().Info("recursive resolver rcv answer", "answer", answer, "query", hopQuery)
+			isFinal, isRedir, redirMap, srvMap, ipMap, nameMap := r.handleAnswer(r, answer, hopQuery,
+				recurseCount)
+			r.logger().Info("handling answer in recursive lookup", "serverAddr", addr, "isFinal",
 				isFinal, "isRedir", isRedir, "redirMap", redirMap, "srvMap", srvMap, "ipMap", ipMap,
 				"nameMap", nameMap)
-			if isFinal {
-				return &answer, nil
+			if hopQuery != q && !isRedir {
+				if _, ok := r.Delegations.Get(hopQuery.Name); ok {
+					//A server answers a delegation query with the delegation only. The servers of
+					//the delegated zone are looked up separately.
+					redirMap, srvMap, ipMap, nameMap = r.lookupGlue(addr, hopQuery, recurseCount)
+					isRedir = len(redirMap) > 0
+				}
+			}
+			if hopQuery != q && !isRedir {
+				//zone does not delegate the next label. Its servers are authoritative for q.
+				r.logger().Debug("no delegation for minimized query, sending full query", "query", hopQuery)
+				minimize = false
+				candidates = append([]net.Addr{addr}, candidates...)
+				continue
+			}
+			if isFinal && hopQuery == q {
+				return &Result{Answer: &answer, Server: addr, Servers: servers, Raw: raw,
+					Status: answerStatus(&answer, q)}, nil
 			} else if isRedir {
-				for _, name := range redirMap {
-					addr, err = r.handleRedirect(name, srvMap, ipMap, nameMap, AllowedRedirectTypes)
-					if err == nil {
+				r.prefetchDelegations(redirMap, q, recurseCount)
+				followed := false
+				var loopErr error
+				for redirName, name := range redirMap {
+					//a zone delegating to itself or to a zone which is not below it would make the
+					//lookup loop, independent of the address it delegates to.
+					if redirName == zone || !inBailiwick(redirName, zone) {
+						loopErr = fmt.Errorf("zone %s delegates to %s which is not below it", zone,
+							redirName)
+						r.logger().Warn("Ignoring delegation", "error", loopErr)
+						continue
+					}
+					if r.MaxRedirects > 0 && redirects >= r.MaxRedirects {
+						return nil, fmt.Errorf("Maximum number of redirects reached at %d for query: %s",
+							redirects, q.String())
+					}
+					redirects++
+					var next []net.Addr
+					if next, err = r.handleRedirect(name, parentZone(redirName), srvMap, ipMap,
+						nameMap, AllowedRedirectTypes); err == nil {
+						candidates, zone, followed = next, redirName, true
+						minimize = r.Minimize
 						break
 					}
 				}
+				if !followed && loopErr != nil {
+					return nil, fmt.Errorf("misconfigured delegation: %v. Recursive lookup aborted for query: %s",
+						loopErr, q.String())
+				}
+				if !followed {
+					r.logger().Warn("Was not able to follow redirect", "authServer", addr, "error", err)
+					break
+				}
 			} else {
-				log.Warn("received unexpected answer to query. Recursive lookup cannot be continued",
+				r.logger().Warn("received unexpected answer to query. Recursive lookup cannot be continued",
 					"authServer", addr)
 				break
 			}
@@ -253,36 +1118,168 @@ func (r *Resolver) recursiveResolve(q *query.Name, recurseCount int) (*message.M
 		q.String())
 }
 
+//minimizedQuery returns a copy of q asking for the delegation of the zone one label below zone on
+//the way to q's name. q is returned if its name is at most one label below zone.
+func minimizedQuery(q *query.Name, zone string) *query.Name {
+	subjectName, ok := section.EnclosingZone(q.Name, zone)
+	if !ok || !strings.Contains(subjectName, ".") {
+		return q
+	}
+	label := subjectName[strings.LastIndex(subjectName, ".")+1:]
+	minimized := *q
+	minimized.Name = label + "." + zone
+	if zone == section.RootZone {
+		minimized.Name = label + zone
+	}
+	minimized.Types = []object.Type{object.OTDelegation}
+	return &minimized
+}
+
+//lookupGlue asks addr, a server of the zone delegating to the zone named by the minimized query
+//mq, for the redirect of mq's zone and the service infos and addresses the redirect refers to. At
+//most maxGlueQueries are sent, each asking for a single name such that only names on the way to
+//the servers of mq's zone are revealed.
+func (r *Resolver) lookupGlue(addr net.Addr, mq *query.Name, recurseCount int) (
+	redirMap map[string]string, srvMap map[string][]object.ServiceInfo, ipMap map[string][]string,
+	nameMap map[string]object.Name) {
+	redirMap = make(map[string]string)
+	srvMap = make(map[string][]object.ServiceInfo)
+	ipMap = make(map[string][]string)
+	nameMap = make(map[string]object.Name)
+	pending := []string{mq.Name}
+	queried := make(map[string]bool)
+	for len(pending) > 0 && len(queried) < maxGlueQueries {
+		name := pending[0]
+		pending = pending[1:]
+		if queried[name] {
+			continue
+		}
+		queried[name] = true
+		glueQuery := *mq
+		glueQuery.Name = name
+		glueQuery.Types = glueTypes
+		if name == mq.Name {
+			glueQuery.Types = []object.Type{object.OTRedirection}
+		}
+		queryMsg := message.Message{Content: []section.Section{&glueQuery}}
+		answer, _, err := r.send(queryMsg.WithNewToken(), addr)
+		if err != nil {
+			r.logger().Debug("error in send glue query", "query", &glueQuery, "err", err)
+			return
+		}
+		_, _, redirs, srvs, ips, names := r.handleAnswer(r, answer, &glueQuery, recurseCount)
+		for n, target := range redirs {
+			redirMap[n] = target
+			pending = append(pending, target)
+		}
+		for n, services := range srvs {
+			for _, si := range services {
+				addService(srvMap, n, si)
+				pending = append(pending, si.Name)
+			}
+		}
+		for n, addrs := range ips {
+			for _, a := range addrs {
+				addAddr(ipMap, n, a)
+			}
+		}
+		for n, nameVal := range names {
+			nameMap[n] = nameVal
+			pending = append(pending, nameVal.Name)
+		}
+	}
+	return
+}
+
+//prefetchDelegations concurrently looks up the delegations of at most MaxPrefetch zones of
+//redirMap which are neither cached nor already being prefetched. The answers of the zones' servers
+//can then be verified without an additional lookup.
+func (r *Resolver) prefetchDelegations(redirMap map[string]string, q *query.Name, recurseCount int) {
+	n := 0
+	for zone := range redirMap {
+		if n >= r.MaxPrefetch {
+			return
+		}
+		if _, ok := r.Delegations.Get(zone); ok {
+			continue
+		}
+		done := make(chan struct{})
+		if _, ok := r.prefetches.LoadOrStore(zone, done); ok {
+			continue
+		}
+		n++
+		keyQuery := &query.Name{
+			Name:        zone,
+			Context:     q.Context,
+			Expiration:  q.Expiration,
+			CurrentTime: q.CurrentTime,
+			Types:       []object.Type{object.OTDelegation},
+		}
+		go func() {
+			defer func() {
+				r.prefetches.Delete(keyQuery.Name)
+				close(done)
+			}()
+			if _, err := r.recursiveResolve(keyQuery, recurseCount+1); err != nil {
+				r.logger().Debug("Was not able to prefetch delegation", "zone", keyQuery.Name, "error", err)
+			}
+		}()
+	}
+}
+
+//awaitPrefetch blocks until an ongoing prefetch of zone's delegation has finished, but at most for
+//DialTimeout such that prefetches depending on each other cannot block forever.
+func (r *Resolver) awaitPrefetch(zone string) {
+	if done, ok := r.prefetches.Load(zone); ok {
+		select {
+		case <-done.(chan struct{}):
+		case <-time.After(r.DialTimeout * time.Millisecond):
+		}
+	}
+}
+
 // handleAnswer stores delegation assertions in the delegationCache. It informs the caller if msg
 // answers q. It also returns if the msg contains a redirect assertion which indicates that
 // another lookup must be performed. Information that is relevant for the next lookup are returned in
 // maps.
 func handleAnswer(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (isFinal bool, isRedir bool,
-	redirMap map[string]string, srvMap map[string]object.ServiceInfo, ipMap map[string]string, nameMap map[string]object.Name) {
+	redirMap map[string]string, srvMap map[string][]object.ServiceInfo, ipMap map[string][]string, nameMap map[string]object.Name) {
 	types := make(map[object.Type]bool)
 	redirMap = make(map[string]string)
-	srvMap = make(map[string]object.ServiceInfo)
-	ipMap = make(map[string]string)
+	srvMap = make(map[string][]object.ServiceInfo)
+	ipMap = make(map[string][]string)
 	nameMap = make(map[string]object.Name)
-	for _, t := range q.Types {
+	for _, t := range q.ObjectTypes() {
 		types[t] = true
 	}
+	//The delegations used to verify the answer must not be evicted while the keys of the remaining
+	//sections are fetched.
+	var pinned []string
+	defer func() {
+		for _, zone := range pinned {
+			r.Delegations.Unpin(zone)
+		}
+	}()
+	r.limitSections(&msg, q)
 	for _, sec := range msg.Content {
 		signed, ok := sec.(section.WithSigForward)
 		if !ok {
-			log.Error("Unexpected Section in Message not of type WithSigForward", "section", sec)
+			r.logger().Error("Unexpected Section in Message not of type WithSigForward", "section", sec)
 			return
 		}
+		r.Delegations.Pin(signed.GetSubjectZone())
+		pinned = append(pinned, signed.GetSubjectZone())
+		r.awaitPrefetch(signed.GetSubjectZone())
 		key, ok := r.Delegations.Get(signed.GetSubjectZone())
-		if !ok {
-			// key is missing
-			keyPhase := 0
-			if len(signed.Sigs(keys.RainsKeySpace)) > 0 {
-				keyPhase = signed.Sigs(keys.RainsKeySpace)[0].KeyPhase
-			} else {
-				log.Error("Section does not contain RAINS signatures", "section", sec)
+		sigs := signed.Sigs(keys.RainsKeySpace)
+		if !ok || len(sigs) > 0 && !key.DelegatesKeyPhase(sigs[0].KeyPhase) {
+			//key is missing or the zone rolled over to a key phase which is not cached. The query
+			//requests the signature's key phase such that the authority returns the needed key.
+			if len(sigs) == 0 {
+				r.logger().Error("Section does not contain RAINS signatures", "section", sec)
 				return
 			}
+			keyPhase := sigs[0].KeyPhase
 			keyQuery := query.Name{
 				Name:        signed.GetSubjectZone(),
 				Context:     signed.GetContext(),
@@ -291,139 +1288,268 @@ func handleAnswer(r *Resolver, msg message.Message, q *query.Name, recurseCount
 				Types:       []object.Type{object.OTDelegation},
 				KeyPhase:    keyPhase,
 			}
-			m, err := r.recursiveResolve(&keyQuery, recurseCount+1)
+			m, err := r.resolveKeyPhase(&keyQuery, recurseCount+1, true)
 			if err != nil {
-				log.Error("Error trying to obtain public key", "query", keyQuery, "error", err)
+				r.logger().Error("Error trying to obtain public key", "query", keyQuery, "error", err)
 				return
 			}
 			// verify we do have now the key in the cache
 			key, ok = r.Delegations.Get(signed.GetSubjectZone())
-			if !ok {
-				log.Error("Error trying to obtain public key", "subject zone", signed.GetSubjectZone(), "answer", m)
+			if !ok || !key.DelegatesKeyPhase(keyPhase) {
+				r.logger().Error("Error trying to obtain public key", "subject zone", signed.GetSubjectZone(), "answer", m)
 				return
 			}
 		}
 		// we have ensured that key is now an Assertion containing the delegation
 		pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
-		for _, k := range (key.(*section.Assertion)).Content {
+		for _, k := range key.Content {
 			pk, isPublicKey := k.Value.(keys.PublicKey)
 			if isPublicKey {
 				pkeys[pk.PublicKeyID] = append(pkeys[pk.PublicKeyID], pk)
 			}
 		}
 		if !siglib.CheckSectionSignatures(signed, pkeys, r.MaxCacheValidity) {
-			log.Error("Section signature invalid!", "section", signed, "public keys", pkeys)
+			r.logger().Error("Section signature invalid!", "section", signed, "public keys", pkeys)
 			return
 		}
+		//Only verified signatures remain on the section after the check.
+		verified := len(signed.Sigs(keys.RainsKeySpace)) > 0
 		switch s := sec.(type) {
 		case *section.Assertion:
-			r.handleAssertion(s, redirMap, srvMap, ipMap, nameMap, types, q.Name, &isFinal, &isRedir)
+			r.handleAssertion(s, verified, redirMap, srvMap, ipMap, nameMap, types, q, &isFinal, &isRedir)
 		case *section.Shard:
 			r.handleShard(s, types, q.Name, &isFinal)
 		case *section.Zone:
-			r.handleZone(s, redirMap, srvMap, ipMap, nameMap, types, q.Name, &isFinal, &isRedir)
+			r.handleZone(s, verified, redirMap, srvMap, ipMap, nameMap, types, q, &isFinal, &isRedir)
 		}
 	}
 	return
 }
 
-func (r *Resolver) handleAssertion(a *section.Assertion, redirMap map[string]string,
-	srvMap map[string]object.ServiceInfo, ipMap map[string]string, nameMap map[string]object.Name,
-	types map[object.Type]bool, name string, isFinal, isRedir *bool) {
+//limitSections drops the sections of msg, a response to q, exceeding MaxAnswerSections. The
+//assertions contained in a shard or zone are counted as well. A shard or zone exceeding the limit is
+//dropped as a whole as its signature does not cover a part of its content. It returns true if
+//sections have been dropped.
+func (r *Resolver) limitSections(msg *message.Message, q *query.Name) bool {
+	if r.MaxAnswerSections <= 0 {
+		return false
+	}
+	n := 0
+	for i, sec := range msg.Content {
+		n += sectionCount(sec)
+		if n > r.MaxAnswerSections {
+			r.logger().Warn("Dropping excess sections of response", "sections", len(msg.Content),
+				"kept", i, "limit", r.MaxAnswerSections, "query", q)
+			msg.Content = msg.Content[:i]
+			return true
+		}
+	}
+	return false
+}
+
+//sectionCount returns the number of sections sec consists of, i.e. one plus the number of
+//assertions it contains.
+func sectionCount(sec section.Section) int {
+	switch sec := sec.(type) {
+	case *section.Shard:
+		return 1 + len(sec.Content)
+	case *section.Zone:
+		return 1 + len(sec.Content)
+	}
+	return 1
+}
+
+//handleAssertion extracts the information relevant for the lookup from a. signed reports whether a
+//or its enclosing section carries a verified signature.
+func (r *Resolver) handleAssertion(a *section.Assertion, signed bool, redirMap map[string]string,
+	srvMap map[string][]object.ServiceInfo, ipMap map[string][]string, nameMap map[string]object.Name,
+	types map[object.Type]bool, q *query.Name, isFinal, isRedir *bool) {
+	if r.RequireSignedDelegations && !signed && containsDelegation(a) {
+		r.logger().Warn("Ignoring unsigned delegation", "assertion", a)
+		return
+	}
+	if section.AssertionAnswers(a, q) {
+		*isFinal = true
+	}
+	if containsDelegation(a) {
+		if d := r.usableDelegation(a); d != nil {
+			r.Delegations.Add(a.FQDN(), d, false)
+		}
+	}
 	for _, o := range a.Content {
 		switch o.Type {
 		case object.OTRedirection:
 			redirMap[a.FQDN()] = o.Value.(string)
-			if _, ok := types[object.OTRedirection]; !ok || a.FQDN() != name {
+			if _, ok := types[object.OTRedirection]; !ok || a.FQDN() != q.Name {
 				*isRedir = true
 			}
-		case object.OTDelegation:
-			// copy the valid times from the assertion to all public keys contained here:
-			for i, pk := range a.Content {
-				pk, ok := pk.Value.(keys.PublicKey)
-				if ok {
-					pk.ValidSince = a.ValidSince()
-					pk.ValidUntil = a.ValidUntil()
-					a.Content[i].Value = pk
-				}
-			}
-			r.Delegations.Add(a.FQDN(), a)
 		case object.OTServiceInfo:
-			srvMap[a.FQDN()] = o.Value.(object.ServiceInfo)
-		case object.OTIP6Addr:
-			ipMap[a.FQDN()] = o.Value.(net.IP).String()
-		case object.OTIP4Addr:
-			ipMap[a.FQDN()] = o.Value.(net.IP).String()
-		case object.OTScionAddr6:
-			ipMap[a.FQDN()] = o.Value.(*object.SCIONAddress).String()
-		case object.OTScionAddr4:
-			ipMap[a.FQDN()] = o.Value.(*object.SCIONAddress).String()
+			addService(srvMap, a.FQDN(), o.Value.(object.ServiceInfo))
+		case object.OTIP6Addr, object.OTIP4Addr:
+			addAddr(ipMap, a.FQDN(), o.Value.(net.IP).String())
+		case object.OTScionAddr6, object.OTScionAddr4:
+			addAddr(ipMap, a.FQDN(), o.Value.(*object.SCIONAddress).String())
 		case object.OTName:
 			nameMap[a.FQDN()] = o.Value.(object.Name)
 		}
-		if _, ok := types[o.Type]; ok && a.FQDN() == name {
-			*isFinal = true
+	}
+}
+
+//addAddr appends addr to the addresses of name in ipMap unless it is already present.
+func addAddr(ipMap map[string][]string, name, addr string) {
+	for _, a := range ipMap[name] {
+		if a == addr {
+			return
+		}
+	}
+	ipMap[name] = append(ipMap[name], addr)
+}
+
+//addService inserts si into the service infos of name in srvMap unless it is already present. The
+//service infos are kept sorted by priority, a lower value denoting a higher priority. Service infos
+//of equal priority are sorted canonically such that the order does not depend on the order of the
+//received records.
+func addService(srvMap map[string][]object.ServiceInfo, name string, si object.ServiceInfo) {
+	services := srvMap[name]
+	i := sort.Search(len(services), func(i int) bool {
+		if services[i].Priority != si.Priority {
+			return services[i].Priority > si.Priority
+		}
+		return services[i].CompareTo(si) >= 0
+	})
+	if i < len(services) && services[i] == si {
+		return
+	}
+	services = append(services, object.ServiceInfo{})
+	copy(services[i+1:], services[i:])
+	services[i] = si
+	srvMap[name] = services
+}
+
+//containsDelegation returns true if a contains a delegation object.
+func containsDelegation(a *section.Assertion) bool {
+	for _, o := range a.Content {
+		if o.Type == object.OTDelegation {
+			return true
+		}
+	}
+	return false
+}
+
+//usableDelegation copies the validity of a to the public keys it contains and returns the
+//assertion to cache for its delegations. Delegations to unusable keys are left out of a copy of a
+//whose signatures are removed as they do not cover the remaining content. It returns nil if none of
+//a's delegations is usable.
+func (r *Resolver) usableDelegation(a *section.Assertion) *section.Assertion {
+	for i, o := range a.Content {
+		if pk, ok := o.Value.(keys.PublicKey); ok {
+			pk.ValidSince = a.ValidSince()
+			pk.ValidUntil = a.ValidUntil()
+			a.Content[i].Value = pk
+		}
+	}
+	content := make([]object.Object, 0, len(a.Content))
+	usable := false
+	for _, o := range a.Content {
+		if o.Type == object.OTDelegation {
+			if err := object.ValidateDelegationObject(o, a.FQDN()); err != nil {
+				r.logger().Warn("Ignoring unusable delegation", "assertion", a, "error", err)
+				continue
+			}
+			usable = true
 		}
+		content = append(content, o)
 	}
+	if !usable {
+		return nil
+	}
+	if len(content) == len(a.Content) {
+		return a
+	}
+	filtered := a.Copy(a.Context, a.SubjectZone)
+	filtered.Content = content
+	filtered.DeleteAllSigs()
+	return filtered
 }
 
 //handleShard checks if s is an answer to the query. Note that a shard containing a positive answer
 //for the query is considered answering it although this is not allowed by the protocol. The caller
 //is responsible for checking this property.
 func (r *Resolver) handleShard(s *section.Shard, types map[object.Type]bool, name string, isFinal *bool) {
-	if strings.HasSuffix(name, s.SubjectZone) && s.InRange(strings.TrimSuffix(name, s.SubjectZone)) {
+	if subjectName, ok := section.EnclosingZone(name, s.SubjectZone); ok && s.InRange(subjectName) {
 		*isFinal = true
 	}
 }
 
-//handleZone checks if z or the contained assertions are an answer to the query.
-func (r *Resolver) handleZone(z *section.Zone, redirMap map[string]string,
-	srvMap map[string]object.ServiceInfo, ipMap map[string]string, nameMap map[string]object.Name,
-	types map[object.Type]bool, name string, isFinal, isRedir *bool) {
+//handleZone checks if z or the contained assertions are an answer to the query. signed reports
+//whether z carries a verified signature.
+func (r *Resolver) handleZone(z *section.Zone, signed bool, redirMap map[string]string,
+	srvMap map[string][]object.ServiceInfo, ipMap map[string][]string, nameMap map[string]object.Name,
+	types map[object.Type]bool, q *query.Name, isFinal, isRedir *bool) {
 	for _, sec := range z.Content {
-		r.handleAssertion(sec, redirMap, srvMap, ipMap, nameMap, types, name, isFinal, isRedir)
+		r.handleAssertion(sec, signed || len(sec.Sigs(keys.RainsKeySpace)) > 0, redirMap, srvMap,
+			ipMap, nameMap, types, q, isFinal, isRedir)
 	}
-	if strings.HasSuffix(name, z.SubjectZone) {
+	if _, ok := section.EnclosingZone(q.Name, z.SubjectZone); ok {
 		*isFinal = true
 	}
 }
 
-func (r *Resolver) handleRedirect(name string, srvMap map[string]object.ServiceInfo,
-	ipMap map[string]string, nameMap map[string]object.Name, allowedTypes map[object.Type]bool) (
-	net.Addr, error) {
-	var err error
+//handleRedirect returns the addresses of the servers name refers to in the order in which they were
+//received. The servers of several service infos are returned in the order of the services'
+//priority such that a lookup falls through to a service of lower priority if the servers of the
+//preferred one do not resolve or respond. All names followed must be in the bailiwick of authority, i.e. the zone which delegated
+//to the redirecting zone, unless AllowOutOfBailiwick is set. This prevents a compromised zone from
+//redirecting lookups for names outside of its authority.
+func (r *Resolver) handleRedirect(name, authority string, srvMap map[string][]object.ServiceInfo,
+	ipMap map[string][]string, nameMap map[string]object.Name, allowedTypes map[object.Type]bool) (
+	[]net.Addr, error) {
+	if !r.AllowOutOfBailiwick && !inBailiwick(name, authority) {
+		return nil, fmt.Errorf("redirect target %s is not in the bailiwick of %s", name, authority)
+	}
 	if allowedTypes[object.OTIP6Addr] || allowedTypes[object.OTIP4Addr] || allowedTypes[object.OTScionAddr6] || allowedTypes[object.OTScionAddr4] {
-		if ipAddr, ok := ipMap[name]; ok {
-			var addr net.Addr
-			var tcpErr error
-			addr, tcpErr = net.ResolveTCPAddr("", fmt.Sprintf("%s:%d", ipAddr, rainsPort))
-			if tcpErr != nil {
-				addr, err = snet.AddrFromString(fmt.Sprintf("%s:%d", ipAddr, rainsPort))
+		if ipAddrs, ok := ipMap[name]; ok {
+			var addrs []net.Addr
+			for _, ipAddr := range ipAddrs {
+				addr, err := r.resolveAddr(ipAddr, rainsPort)
 				if err != nil {
-					log.Error("Not an IP addr nor a SCION addr at handleRedirect OTXAddrX", "addr", addr, "tcpErr", tcpErr, "scionErr", err)
+					r.logger().Warn("Was not able to resolve redirect address", "addr", ipAddr, "error", err)
+					continue
 				}
+				addrs = append(addrs, addr)
+			}
+			if len(addrs) > 0 {
+				return addrs, nil
 			}
-			return addr, err
 		}
 	}
 	if allowedTypes[object.OTServiceInfo] && strings.HasPrefix(name, rainsPrefix) {
-		if srvVal, ok := srvMap[name]; ok {
-			var addr net.Addr
-			var tcpErr error
-			if addr, err = r.handleRedirect(srvVal.Name, srvMap, ipMap, nameMap,
-				AllowedAddrTypes); err == nil {
-				portSep := strings.LastIndex(addr.String(), ":")
-				ip := addr.String()[:portSep]
-				addr, tcpErr = net.ResolveTCPAddr("", fmt.Sprintf("%s:%d", ip, srvVal.Port))
-				if tcpErr != nil {
-					addr, err = snet.AddrFromString(fmt.Sprintf("%s:%d", ip, srvVal.Port))
-					if err != nil {
-						log.Error("Not and IP addr nor a SCION addr at handleRedirect OTXAddrX", "addr", addr, "tcpErr", tcpErr, "scionErr", err)
-					}
+		var addrs []net.Addr
+		for _, srvVal := range srvMap[name] {
+			hosts, err := r.handleRedirect(srvVal.Name, authority, srvMap, ipMap, nameMap,
+				AllowedAddrTypes)
+			if err != nil {
+				r.logger().Debug("Was not able to resolve service", "service", srvVal, "error", err)
+				continue
+			}
+			for _, host := range hosts {
+				portSep := strings.LastIndex(host.String(), ":")
+				if portSep < 0 {
+					//the address does not have a port, e.g. it is a Unix socket.
+					addrs = append(addrs, host)
+					continue
 				}
-				return addr, err
+				addr, err := r.resolveAddr(host.String()[:portSep], srvVal.Port)
+				if err != nil {
+					r.logger().Warn("Was not able to resolve redirect address", "addr", host, "error", err)
+					continue
+				}
+				addrs = append(addrs, addr)
 			}
 		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
 	}
 	if allowedTypes[object.OTName] {
 		if nameVal, ok := nameMap[name]; ok {
@@ -431,81 +1557,214 @@ func (r *Resolver) handleRedirect(name string, srvMap map[string]object.ServiceI
 			for _, t := range nameVal.Types {
 				allowTypes[t] = true
 			}
-			if as, err := r.handleRedirect(nameVal.Name, srvMap, ipMap, nameMap,
+			if addrs, err := r.handleRedirect(nameVal.Name, authority, srvMap, ipMap, nameMap,
 				allowTypes); err == nil {
-				if as == nil {
-					log.Error("Nil addr at handleRedirect OTName", "as", as, "err", err)
-				}
-				return as, err
+				return addrs, nil
 			}
 		}
 	}
 	return nil, fmt.Errorf("redir name did not end in a host addr. redirName=%s", name)
 }
 
-//answerDelegQueries answers delegation queries on conn from its cache. The cache is populated
-//through delegations received in a recursive lookup.
-func (r *Resolver) answerDelegQueries(conn net.Conn) {
-	reader := cbor.NewReader(conn)
-	writer := cbor.NewWriter(conn)
-	buf := make([]byte, connection.MaxUDPPacketBytes)
-
-	breaking := false
-	for {
-		var msg message.Message
-		switch conn.LocalAddr().(type) {
-		case *net.TCPAddr:
-			if err := reader.Unmarshal(&msg); err != nil {
-				if err.Error() == "failed to read tag: EOF" {
-					log.Info("Connection has been closed", "remoteAddr", conn.RemoteAddr())
-				} else {
-					log.Warn(fmt.Sprintf("failed to read from client: %v", err))
+//resolveAddr returns the address of the server listening on port at host by calling AddrResolver.
+//It falls back to DefaultAddrResolver if AddrResolver is not set.
+func (r *Resolver) resolveAddr(host string, port uint16) (net.Addr, error) {
+	if r.AddrResolver != nil {
+		return r.AddrResolver(host, port)
+	}
+	return DefaultAddrResolver(host, port)
+}
+
+//DefaultAddrResolver returns the TCP address of host and port. If host is not an IP address, it is
+//parsed as a SCION address.
+func DefaultAddrResolver(host string, port uint16) (net.Addr, error) {
+	addr, tcpErr := net.ResolveTCPAddr("", fmt.Sprintf("%s:%d", host, port))
+	if tcpErr == nil {
+		return addr, nil
+	}
+	scionAddr, err := snet.AddrFromString(fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		log.Error("Not an IP addr nor a SCION addr", "host", host, "tcpErr", tcpErr, "scionErr", err)
+		return nil, err
+	}
+	return scionAddr, nil
+}
+
+//inBailiwick returns true if name is equal to zone or a subdomain of it.
+func inBailiwick(name, zone string) bool {
+	_, ok := section.EnclosingZone(name, zone)
+	return ok
+}
+
+//parentZone returns the zone containing the fully qualified domain name fqdn.
+func parentZone(fqdn string) string {
+	if i := strings.Index(fqdn, "."); i >= 0 && i < len(fqdn)-1 {
+		return fqdn[i+1:]
+	}
+	return "."
+}
+
+//delegConn is a connection on which delegation queries are answered.
+type delegConn struct {
+	conn net.Conn
+	//buf buffers the stream of a TCP or Unix connection such that a worker can check whether a
+	//message has arrived without consuming it.
+	buf    *bufio.Reader
+	reader cbor.Reader
+	writer cbor.Writer
+	//packet holds a message received on a SCION connection.
+	packet []byte
+	//lastRead is the time at which the last message has been received on conn.
+	lastRead time.Time
+}
+
+//dispatchDelegQueries hands conn to the delegation query workers. The number of workers is bounded
+//by MaxDelegWorkers such that a flood of connections does not result in an unbounded number of
+//goroutines. A worker answers a single message and then turns to the next connection such that
+//long-lived connections do not starve the others. A connection is served by at most one worker
+//at a time which preserves the order of the responses on it. Connections exceeding
+//MaxDelegWorkers+DelegQueueSize are closed.
+func (r *Resolver) dispatchDelegQueries(conn net.Conn) {
+	r.delegOnce.Do(func() {
+		workers, queueSize := r.MaxDelegWorkers, r.DelegQueueSize
+		if workers <= 0 {
+			workers = defaultDelegWorkers
+		}
+		if queueSize < 0 {
+			queueSize = defaultDelegQueue
+		}
+		r.delegCap = int64(workers + queueSize)
+		//the channel holds all served connections such that a worker never blocks when it
+		//returns a connection.
+		r.delegConns = make(chan *delegConn, r.delegCap)
+		for i := 0; i < workers; i++ {
+			go func() {
+				for dc := range r.delegConns {
+					if r.answerDelegQuery(dc) {
+						r.delegConns <- dc
+					} else {
+						r.delegOpen.Add(-1)
+					}
 				}
-				r.Connections.CloseAndRemoveConnection(conn)
-				breaking = true
+			}()
+		}
+	})
+	if r.delegOpen.Add(1) > r.delegCap {
+		r.delegOpen.Add(-1)
+		r.logger().Warn("Delegation query queue is full. Close connection",
+			"remoteAddr", conn.RemoteAddr())
+		r.Connections.CloseAndRemoveConnection(conn)
+		return
+	}
+	dc := &delegConn{conn: conn, writer: cbor.NewWriter(conn), lastRead: time.Now()}
+	if _, ok := conn.LocalAddr().(*snet.Addr); ok {
+		dc.packet = make([]byte, connection.MaxUDPPacketBytes)
+	} else {
+		dc.buf = bufio.NewReader(conn)
+		dc.reader = cbor.NewReader(dc.buf)
+	}
+	r.delegConns <- dc
+}
+
+//answerDelegQuery answers the next delegation query on dc from its cache if the peer sent one
+//within delegPollInterval. The cache is populated through delegations received in a recursive
+//lookup. It returns false if dc must not be served anymore because it has been closed, failed or
+//the peer did not send a message within ReadIdleTimeout in which case the connection is closed.
+func (r *Resolver) answerDelegQuery(dc *delegConn) bool {
+	conn := dc.conn
+	var msg message.Message
+	conn.SetReadDeadline(time.Now().Add(delegPollInterval))
+	switch conn.LocalAddr().(type) {
+	case *net.TCPAddr, *net.UnixAddr:
+		_, err := dc.buf.Peek(1)
+		if err != nil && isTimeout(err) && !r.isIdle(dc) {
+			return true
+		}
+		if err == nil {
+			//the peer started to send a message, wait for the rest of it.
+			conn.SetReadDeadline(time.Now().Add(delegReadTimeout))
+			err = dc.reader.Unmarshal(&msg)
+		}
+		if err != nil {
+			if err == io.EOF {
+				r.logger().Info("Connection has been closed", "remoteAddr", conn.RemoteAddr())
+			} else if isTimeout(err) && r.isIdle(dc) {
+				r.logger().Info("Peer is idle. Close connection", "remoteAddr", conn.RemoteAddr(),
+					"idleTimeout", r.ReadIdleTimeout)
+			} else {
+				r.logger().Warn(fmt.Sprintf("failed to read from client: %v", err))
+				r.connError(conn.RemoteAddr(), err)
 			}
-		case *snet.Addr:
-			n, _, err := conn.(snet.Conn).ReadFromSCION(buf)
-			if err != nil {
-				log.Warn("Failed to ReadFromSCION", "err", err)
-				breaking = true
+			r.Connections.CloseAndRemoveConnection(conn)
+			return false
+		}
+	case *snet.Addr:
+		n, _, err := conn.(snet.Conn).ReadFromSCION(dc.packet)
+		if err != nil {
+			if !isTimeout(err) {
+				r.logger().Warn("Failed to ReadFromSCION", "err", err)
+				r.connError(conn.RemoteAddr(), err)
+				return false
 			}
-			data := buf[:n]
-			if err := cbor.NewReader(bytes.NewReader(data)).Unmarshal(&msg); err != nil {
-				log.Warn("failed to unmarshal CBOR", "err", err)
-				breaking = true
+			if r.isIdle(dc) {
+				r.logger().Info("Peer is idle. Stop reading", "remoteAddr", conn.RemoteAddr(),
+					"idleTimeout", r.ReadIdleTimeout)
+				return false
 			}
+			return true
 		}
-		if breaking {
-			break
+		if err := cbor.NewReader(bytes.NewReader(dc.packet[:n])).Unmarshal(&msg); err != nil {
+			r.logger().Warn("failed to unmarshal CBOR", "err", err)
+			r.connError(conn.RemoteAddr(), err)
+			return false
 		}
+	}
+	dc.lastRead = time.Now()
 
-		answer := r.getDelegations(msg)
-		log.Info("received delegation query. Answer with cached assertions", "query", msg, "assertions", answer)
-		msg = message.Message{Token: msg.Token, Content: answer}
+	answer := r.getDelegations(msg)
+	r.logger().Info("received delegation query. Answer with cached assertions", "query", msg, "assertions", answer)
+	msg = message.Message{Token: msg.Token, Content: answer}
 
-		switch conn.LocalAddr().(type) {
-		case *net.TCPAddr:
-			if err := writer.Marshal(&msg); err != nil {
-				log.Error("failed to marshal message", err)
-				r.Connections.CloseAndRemoveConnection(conn)
-				breaking = true
-			}
-		case *snet.Addr:
-			encoding := new(bytes.Buffer)
-			if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
-				log.Error("failed to marshal message to conn", err)
-				breaking = true
-			}
-			if _, err := conn.Write(encoding.Bytes()); err != nil {
-				log.Error("unable to write encoded message to connection", err)
-				breaking = true
-			}
+	switch conn.LocalAddr().(type) {
+	case *net.TCPAddr, *net.UnixAddr:
+		if err := dc.writer.Marshal(&msg); err != nil {
+			r.logger().Error("failed to marshal message", err)
+			r.connError(conn.RemoteAddr(), err)
+			r.Connections.CloseAndRemoveConnection(conn)
+			return false
 		}
-		if breaking {
-			break
+	case *snet.Addr:
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+			r.logger().Error("failed to marshal message to conn", err)
+			return false
+		}
+		if _, err := conn.Write(encoding.Bytes()); err != nil {
+			r.logger().Error("unable to write encoded message to connection", err)
+			r.connError(conn.RemoteAddr(), err)
+			return false
 		}
 	}
+	return true
+}
+
+//isIdle returns true if the peer of dc did not send a message within ReadIdleTimeout.
+func (r *Resolver) isIdle(dc *delegConn) bool {
+	return r.ReadIdleTimeout > 0 && time.Since(dc.lastRead) >= r.ReadIdleTimeout
+}
+
+//isTimeout returns true if err is caused by an expired deadline.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+//connError counts err on the connection to addr and reports it to OnConnError if it is set.
+func (r *Resolver) connError(addr net.Addr, err error) {
+	r.stats.inc(&r.stats.connErrors)
+	if r.OnConnError != nil {
+		r.OnConnError(addr, err)
+	}
 }
 
 //getDelegations returns all cached delegations answering a query in msg.
@@ -516,9 +1775,10 @@ func (r *Resolver) getDelegations(msg message.Message) []section.Section {
 			for _, t := range q.Types {
 				if t == object.OTDelegation {
 					if a, ok := r.Delegations.Get(q.Name); ok {
-						answer = append(answer, a.(*section.Assertion))
+						answer = append(answer, a)
 					} else {
-						log.Warn("requested delegation is not cached. This should never happen")
+						r.logger().Warn("requested delegation is not cached, it might have been evicted",
+							"zone", q.Name)
 					}
 					break
 				}
@@ -1,20 +1,39 @@
 package libresolve
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	log "github.com/inconshreveable/log15"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
 
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
-	"github.com/netsec-ethz/rains/internal/pkg/datastructures/safeHashMap"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/snet"
 )
 
 func newResolver() *Resolver {
@@ -25,8 +44,8 @@ func newResolver() *Resolver {
 		InsecureTLS:     defaultInsecureTLS,
 		DialTimeout:     defaultTimeout,
 		FailFast:        defaultFailFast,
-		Delegations:     safeHashMap.New(),
-		Connections:     cache.NewConnection(1),
+		Delegations:     cache.NewDelegation(10),
+		Connections:     cache.NewConnection(1, 0),
 		MaxCacheValidity: util.MaxCacheValidity{
 			AssertionValidity: 100,
 			ShardValidity:     100,
@@ -69,16 +88,17 @@ func TestRecursiveResolveQueriesRoot(t *testing.T) {
 	resolver := newResolver()
 	resolver.RootNameServers = []net.Addr{&net.IPAddr{IP: net.IPv4(127, 0, 0, 11), Zone: "test-zone"}}
 	numberOfMessagesSent := 0
-	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (message.Message, error) {
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
 		if ipAddr, ok := addr.(*net.IPAddr); !ok || !ipAddr.IP.Equal(net.IPv4(127, 0, 0, 11)) || ipAddr.Zone != "test-zone" {
 			t.Fatalf("Resolver contacted some other server at %v", ipAddr)
 		}
 		numberOfMessagesSent++
-		return message.Message{Content: []section.Section{&assertion}}, nil
+		return message.Message{Content: []section.Section{&assertion}}, nil, nil
 	}
 	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
-		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string]object.ServiceInfo,
-		ipMap map[string]string, nameMap map[string]object.Name) {
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
 		isFinal = true
 		return
 	}
@@ -87,10 +107,2397 @@ func TestRecursiveResolveQueriesRoot(t *testing.T) {
 	if err != nil {
 		t.Fatalf("The call to recursiveResolve finished with an error: %v", err)
 	}
-	if len(ans.Content) != 1 || ans.Content[0].(*section.Assertion) == nil || ans.Content[0].(*section.Assertion).FQDN() != assertion.FQDN() {
+	if len(ans.Answer.Content) != 1 || ans.Answer.Content[0].(*section.Assertion) == nil || ans.Answer.Content[0].(*section.Assertion).FQDN() != assertion.FQDN() {
 		t.Fatalf("Wrong answer received, FQDN: %q", assertion.FQDN())
 	}
 	if numberOfMessagesSent != 1 {
 		t.Fatalf("Should have contacted 1 root server, but did it %d times", numberOfMessagesSent)
 	}
 }
+
+func TestRecursiveResolveTruncatedAnswer(t *testing.T) {
+	assertion := section.Assertion{SubjectZone: ".", SubjectName: strings.Repeat("a", 50)}
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&snet.Addr{Host: &addr.AppAddr{
+		L3: addr.HostIPv4(net.IPv4(127, 0, 0, 11)), L4: addr.NewL4UDPInfo(rainsPort)}}}
+	tcpQueries := 0
+	resolver.sendQuery = func(msg message.Message, a net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		answer := message.Message{Token: msg.Token,
+			Content: []section.Section{&assertion, &assertion, &assertion}}
+		switch a := a.(type) {
+		case *snet.Addr:
+			if _, err := answer.Truncate(150); err != nil {
+				t.Fatalf("Was not able to truncate answer: %v", err)
+			}
+		case *net.TCPAddr:
+			if !a.IP.Equal(net.IPv4(127, 0, 0, 11)) || a.Port != int(rainsPort) {
+				t.Fatalf("Resolver retried over TCP at a wrong address %v", a)
+			}
+			tcpQueries++
+		}
+		return answer, nil, nil
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		isFinal = true
+		return
+	}
+	ans, err := resolver.recursiveResolve(newQuery(), 0)
+	if err != nil {
+		t.Fatalf("The call to recursiveResolve finished with an error: %v", err)
+	}
+	if tcpQueries != 1 {
+		t.Fatalf("Truncated answer should trigger exactly one TCP retry, actual=%d", tcpQueries)
+	}
+	if len(ans.Answer.Content) != 3 || ans.Answer.IsTruncated() {
+		t.Fatalf("Expected the full answer received over TCP, actual=%v", ans)
+	}
+}
+
+func TestDispatchDelegQueriesBounded(t *testing.T) {
+	resolver := newResolver()
+	resolver.MaxDelegWorkers = 2
+	resolver.DelegQueueSize = 5
+	delegation := &section.Assertion{SubjectZone: ".", SubjectName: "ch",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: object.PublicKey()}}}
+	resolver.Delegations.Add(delegation.FQDN(), delegation, false)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer listener.Close()
+	goroutines := runtime.NumGoroutine()
+	var clients []net.Conn
+	for i := 0; i < 10; i++ {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Was not able to dial: %v", err)
+		}
+		defer client.Close()
+		server, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Was not able to accept: %v", err)
+		}
+		clients = append(clients, client)
+		resolver.dispatchDelegQueries(server)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := runtime.NumGoroutine() - goroutines; n > resolver.MaxDelegWorkers {
+		t.Fatalf("Too many goroutines serve delegation queries. budget=%d actual=%d",
+			resolver.MaxDelegWorkers, n)
+	}
+	//the first connection is served by a worker and answers all queries in order.
+	for i := 0; i < 3; i++ {
+		tok := token.New()
+		q := &query.Name{Name: delegation.FQDN(), Context: ".", Types: []object.Type{object.OTDelegation}}
+		msg := message.Message{Token: tok, Content: []section.Section{q}}
+		if err := cbor.NewWriter(clients[0]).Marshal(&msg); err != nil {
+			t.Fatalf("Was not able to send query: %v", err)
+		}
+		answer := message.Message{}
+		if err := cbor.NewReader(clients[0]).Unmarshal(&answer); err != nil {
+			t.Fatalf("Was not able to read answer: %v", err)
+		}
+		if answer.Token != tok || len(answer.Content) != 1 {
+			t.Fatalf("%d: unexpected answer to delegation query %v", i, answer)
+		}
+	}
+}
+
+func TestAnswerDelegQueriesIdleTimeout(t *testing.T) {
+	resolver := newResolver()
+	resolver.Connections = cache.NewConnection(10, 0)
+	resolver.ReadIdleTimeout = 200 * time.Millisecond
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Was not able to dial: %v", err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Was not able to accept: %v", err)
+	}
+	var connErr atomic.Value
+	resolver.OnConnError = func(addr net.Addr, err error) { connErr.Store(err) }
+	start := time.Now()
+	resolver.dispatchDelegQueries(server)
+	//a message received before the timeout resets it.
+	time.Sleep(150 * time.Millisecond)
+	q := &query.Name{Name: "ch.", Context: ".", Types: []object.Type{object.OTDelegation}}
+	msg := message.Message{Token: token.New(), Content: []section.Section{q}}
+	if err := cbor.NewWriter(client).Marshal(&msg); err != nil {
+		t.Fatalf("Was not able to send query: %v", err)
+	}
+	answer := message.Message{}
+	if err := cbor.NewReader(client).Unmarshal(&answer); err != nil || answer.Token != msg.Token {
+		t.Fatalf("query before the idle timeout was not answered %v err=%v", answer, err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("silent peer was not disconnected: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("idle timeout was not reset by the received message, disconnected after %v", elapsed)
+	}
+	if err := connErr.Load(); err != nil {
+		t.Errorf("idle peer must not be reported as connection error: %v", err)
+	}
+}
+
+func TestDispatchDelegQueriesLongLivedConns(t *testing.T) {
+	resolver := newResolver()
+	resolver.Connections = cache.NewConnection(10, 0)
+	resolver.MaxDelegWorkers = 2
+	resolver.DelegQueueSize = 5
+	delegation := &section.Assertion{SubjectZone: ".", SubjectName: "ch",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: object.PublicKey()}}}
+	resolver.Delegations.Add(delegation.FQDN(), delegation, false)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer listener.Close()
+	//more long-lived connections than workers stay open without sending a message, the last
+	//connection sends queries.
+	var clients []net.Conn
+	for i := 0; i <= resolver.MaxDelegWorkers+1; i++ {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Was not able to dial: %v", err)
+		}
+		defer client.Close()
+		server, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Was not able to accept: %v", err)
+		}
+		clients = append(clients, client)
+		resolver.dispatchDelegQueries(server)
+	}
+	for i, client := range []net.Conn{clients[len(clients)-1], clients[0]} {
+		for j := 0; j < 3; j++ {
+			q := &query.Name{Name: delegation.FQDN(), Context: ".", Types: []object.Type{object.OTDelegation}}
+			msg := message.Message{Token: token.New(), Content: []section.Section{q}}
+			if err := cbor.NewWriter(client).Marshal(&msg); err != nil {
+				t.Fatalf("%d.%d: Was not able to send query: %v", i, j, err)
+			}
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			answer := message.Message{}
+			if err := cbor.NewReader(client).Unmarshal(&answer); err != nil {
+				t.Fatalf("%d.%d: delegation query was not answered while other connections are open: %v",
+					i, j, err)
+			}
+			if answer.Token != msg.Token || len(answer.Content) != 1 {
+				t.Fatalf("%d.%d: unexpected answer to delegation query %v", i, j, answer)
+			}
+		}
+	}
+}
+
+func TestRecursiveResolveReportsServers(t *testing.T) {
+	assertion := section.Assertion{SubjectZone: "ch.", SubjectName: "ethz"}
+	root := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{root}
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		return message.Message{Content: []section.Section{&assertion}}, nil, nil
+	}
+	hops := 0
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		hops++
+		if hops == 1 {
+			//the root server redirects to the name server of ch.
+			isRedir = true
+			redirMap = map[string]string{"ch.": "ns.ch."}
+			ipMap = map[string][]string{"ns.ch.": {"127.0.0.12"}}
+			return
+		}
+		isFinal = true
+		return
+	}
+	result, err := resolver.recursiveResolve(newQuery(), 0)
+	if err != nil {
+		t.Fatalf("The call to recursiveResolve finished with an error: %v", err)
+	}
+	final := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 12), Port: int(rainsPort)}
+	if result.Server == nil || result.Server.String() != final.String() {
+		t.Fatalf("Wrong answering server reported expected=%s actual=%v", final, result.Server)
+	}
+	if len(result.Servers) != 2 || result.Servers[0].String() != root.String() ||
+		result.Servers[1].String() != final.String() {
+		t.Fatalf("Wrong contacted servers reported actual=%v", result.Servers)
+	}
+}
+
+func TestForwardQueryPrefersLowRTT(t *testing.T) {
+	slow := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 21), Port: int(rainsPort)}
+	fast := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 22), Port: int(rainsPort)}
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.Forwarders = []net.Addr{slow, fast}
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		if addr.String() == slow.String() {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return message.Message{Token: msg.Token}, nil, nil
+	}
+	var answeredBy []string
+	for i := 0; i < 5; i++ {
+		result, err := resolver.forwardQuery(newQuery())
+		if err != nil {
+			t.Fatalf("%d: forwardQuery failed: %v", i, err)
+		}
+		answeredBy = append(answeredBy, result.Server.String())
+	}
+	//The forwarders are tried in the configured order until both have been measured. Afterwards,
+	//the faster one is preferred.
+	expected := []string{slow.String(), fast.String(), fast.String(), fast.String(), fast.String()}
+	if strings.Join(answeredBy, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong forwarders answered expected=%v actual=%v", expected, answeredBy)
+	}
+	slowRTT, _ := resolver.rtts.get(slow)
+	fastRTT, _ := resolver.rtts.get(fast)
+	if fastRTT >= slowRTT {
+		t.Errorf("faster forwarder must have a lower rtt fast=%v slow=%v", fastRTT, slowRTT)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	server := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 23), Port: int(rainsPort)}
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.Forwarders = []net.Addr{server}
+	resolver.FailureThreshold = 2
+	resolver.FailureCooldown = 50 * time.Millisecond
+	failing, queries := true, 0
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		queries++
+		if failing {
+			return message.Message{}, nil, errors.New("server did not answer")
+		}
+		return message.Message{Token: msg.Token}, nil, nil
+	}
+	var tests = []struct {
+		wait     time.Duration
+		failing  bool
+		queries  int
+		answered bool
+	}{
+		{0, true, 1, false},
+		{0, true, 2, false},                     //threshold reached
+		{0, true, 2, false},                     //skipped during the cooldown
+		{60 * time.Millisecond, true, 3, false}, //probed after the cooldown and failed again
+		{0, false, 3, false},                    //skipped during the new cooldown
+		{60 * time.Millisecond, false, 4, true}, //probed after the cooldown and answered
+		{0, true, 5, false},                     //contacted again as the failures were reset
+	}
+	for i, test := range tests {
+		time.Sleep(test.wait)
+		failing = test.failing
+		_, err := resolver.forwardQuery(newQuery())
+		if (err == nil) != test.answered {
+			t.Errorf("%d: wrong result expected answered=%t actual err=%v", i, test.answered, err)
+		}
+		if queries != test.queries {
+			t.Errorf("%d: wrong number of queries sent expected=%d actual=%d", i, test.queries,
+				queries)
+		}
+	}
+}
+
+func TestBreakerStoreWindow(t *testing.T) {
+	server := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 24), Port: int(rainsPort)}
+	var breakers breakerStore
+	now := time.Now()
+	if breakers.failure(server, now, 2, time.Second, time.Minute) {
+		t.Fatal("server must be available after a single failure")
+	}
+	//The first failure is outside the window and does not count.
+	now = now.Add(2 * time.Second)
+	if breakers.failure(server, now, 2, time.Second, time.Minute) || breakers.open(server, now) {
+		t.Fatal("server must be available if the failures are not within the window")
+	}
+	now = now.Add(time.Second / 2)
+	if !breakers.failure(server, now, 2, time.Second, time.Minute) || !breakers.open(server, now) {
+		t.Fatal("server must be unavailable after two failures within the window")
+	}
+	if breakers.open(server, now.Add(time.Minute)) {
+		t.Error("server must be probed again after the cooldown")
+	}
+	breakers.success(server)
+	if breakers.open(server, now) {
+		t.Error("a success must reset the failures of the server")
+	}
+}
+
+func TestRTTStoreOrder(t *testing.T) {
+	a := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	b := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1}
+	c := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 1}
+	var store rttStore
+	store.observe(a, 30*time.Millisecond)
+	store.observe(b, 10*time.Millisecond)
+	//smoothing keeps a single slow answer from demoting a fast server.
+	store.observe(b, 90*time.Millisecond)
+	if rtt, _ := store.get(b); rtt != 20*time.Millisecond {
+		t.Errorf("wrong smoothed rtt expected=%v actual=%v", 20*time.Millisecond, rtt)
+	}
+	//a server which has not been measured yet is contacted first.
+	if ordered := store.order([]net.Addr{a, b, c}, 0); ordered[0] != c {
+		t.Errorf("unmeasured server must be first actual=%v", ordered)
+	}
+	store.observe(c, 40*time.Millisecond)
+	if ordered := store.order([]net.Addr{c, a, b}, 0); ordered[0] != b || ordered[1] != a ||
+		ordered[2] != c {
+		t.Errorf("wrong order actual=%v", ordered)
+	}
+	if ordered := store.order([]net.Addr{c, a, b}, 1); ordered[0] == b || len(ordered) != 3 {
+		t.Errorf("a probed server must be first actual=%v", ordered)
+	}
+}
+
+func TestAddrResolverOverride(t *testing.T) {
+	assertion := &section.Assertion{SubjectZone: "ch.", SubjectName: "ethz", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("127.0.0.1").To4()}}}
+	cert, err := tls.LoadX509KeyPair("../../../test/integration/testdata/cert/server.crt",
+		"../../../test/integration/testdata/cert/server.key")
+	if err != nil {
+		t.Fatalf("Was not able to load certificate: %v", err)
+	}
+	//stub is the name server of ch. which only exists in this process.
+	stub, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer stub.Close()
+	go func() {
+		conn, err := stub.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		query := message.Message{}
+		if err := cbor.NewReader(conn).Unmarshal(&query); err != nil {
+			return
+		}
+		answer := message.Message{Token: query.Token, Content: []section.Section{assertion}}
+		cbor.NewWriter(conn).Marshal(&answer)
+	}()
+	root := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}
+	resolver := newResolver()
+	resolver.DialTimeout = 1000
+	resolver.RootNameServers = []net.Addr{root}
+	resolver.AddrResolver = func(host string, port uint16) (net.Addr, error) {
+		if host == "ns.ch.test" && port == rainsPort {
+			return stub.Addr(), nil
+		}
+		return DefaultAddrResolver(host, port)
+	}
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		if addr.String() == root.String() {
+			return message.Message{Token: msg.Token, Content: []section.Section{
+				&section.Assertion{SubjectZone: ".", SubjectName: "ch"}}}, nil, nil
+		}
+		return util.SendQueryRaw(msg, addr, timeout)
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		if msg.Content[0].(*section.Assertion).SubjectZone == "." {
+			//the root redirects to a name server with a synthetic address.
+			isRedir = true
+			redirMap = map[string]string{"ch.": "ns.ch."}
+			ipMap = map[string][]string{"ns.ch.": {"ns.ch.test"}}
+			return
+		}
+		isFinal = true
+		return
+	}
+	q := newQuery()
+	q.Name = "ethz.ch."
+	result, err := resolver.recursiveResolve(q, 0)
+	if err != nil {
+		t.Fatalf("recursiveResolve failed: %v", err)
+	}
+	if result.Server.String() != stub.Addr().String() || len(result.Answer.Content) != 1 ||
+		result.Answer.Content[0].(*section.Assertion).CompareTo(assertion) != 0 {
+		t.Errorf("lookup was not answered by the stub server=%s answer=%v", result.Server,
+			result.Answer)
+	}
+}
+
+//redirectDialer is a dialer recording the addresses it is asked to connect to. It connects to
+//target instead.
+type redirectDialer struct {
+	target string
+	dialed []string
+}
+
+func (d *redirectDialer) Dial(network, address string) (net.Conn, error) {
+	d.dialed = append(d.dialed, address)
+	return net.Dial(network, d.target)
+}
+
+func TestProxy(t *testing.T) {
+	assertion := &section.Assertion{SubjectZone: "ch.", SubjectName: "ethz", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("127.0.0.1").To4()}}}
+	cert, err := tls.LoadX509KeyPair("../../../test/integration/testdata/cert/server.crt",
+		"../../../test/integration/testdata/cert/server.key")
+	if err != nil {
+		t.Fatalf("Was not able to load certificate: %v", err)
+	}
+	//stub is the forwarder which is only reachable through the proxy.
+	stub, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer stub.Close()
+	go func() {
+		conn, err := stub.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		query := message.Message{}
+		if err := cbor.NewReader(conn).Unmarshal(&query); err != nil {
+			return
+		}
+		answer := message.Message{Token: query.Token, Content: []section.Section{assertion}}
+		cbor.NewWriter(conn).Marshal(&answer)
+	}()
+	forwarder := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: int(rainsPort)}
+	dialer := &redirectDialer{target: stub.Addr().String()}
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.DialTimeout = 1000
+	resolver.Forwarders = []net.Addr{forwarder}
+	resolver.Proxy = dialer
+	resolver.sendQuery = resolver.sendQueryRaw
+	q := newQuery()
+	q.Name = "ethz.ch."
+	result, err := resolver.Lookup(q)
+	if err != nil {
+		t.Fatalf("Lookup through the proxy failed: %v", err)
+	}
+	if !reflect.DeepEqual(dialer.dialed, []string{forwarder.String()}) {
+		t.Errorf("query was not sent through the proxy dialed=%v", dialer.dialed)
+	}
+	if result.Server.String() != forwarder.String() || len(result.Answer.Content) != 1 ||
+		result.Answer.Content[0].(*section.Assertion).CompareTo(assertion) != 0 {
+		t.Errorf("wrong answer through the proxy server=%s answer=%v", result.Server, result.Answer)
+	}
+}
+
+func TestLookupRaw(t *testing.T) {
+	assertion := &section.Assertion{SubjectZone: "ch.", SubjectName: "ethz", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("127.0.0.1").To4()}}}
+	cert, err := tls.LoadX509KeyPair("../../../test/integration/testdata/cert/server.crt",
+		"../../../test/integration/testdata/cert/server.key")
+	if err != nil {
+		t.Fatalf("Was not able to load certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer listener.Close()
+	sent := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		query := message.Message{}
+		if err := cbor.NewReader(conn).Unmarshal(&query); err != nil {
+			return
+		}
+		answer := message.Message{Token: query.Token, Content: []section.Section{assertion}}
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(&answer); err != nil {
+			return
+		}
+		sent <- encoding.Bytes()
+		conn.Write(encoding.Bytes())
+	}()
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.DialTimeout = 1000
+	resolver.Forwarders = []net.Addr{listener.Addr()}
+	resolver.sendQuery = util.SendQueryRaw
+	q := newQuery()
+	q.Name = "ch."
+	raw, err := resolver.LookupRaw(context.Background(), q)
+	if err != nil {
+		t.Fatalf("LookupRaw finished with an error: %v", err)
+	}
+	if encoding := <-sent; !bytes.Equal(raw, encoding) {
+		t.Fatalf("Returned bytes differ from the received ones expected=%x actual=%x", encoding, raw)
+	}
+	msg := message.Message{}
+	if err := cbor.NewReader(bytes.NewReader(raw)).Unmarshal(&msg); err != nil {
+		t.Fatalf("Was not able to decode returned bytes: %v", err)
+	}
+	if len(msg.Content) != 1 || msg.Content[0].(*section.Assertion).CompareTo(assertion) != 0 {
+		t.Fatalf("Returned bytes decode to a wrong message expected=%v actual=%v", assertion, msg)
+	}
+}
+
+func TestLookupChunkedZone(t *testing.T) {
+	pubKey, privKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	zone := &section.Zone{SubjectZone: "ch.", Context: "."}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+		zone.Content = append(zone.Content, &section.Assertion{SubjectName: name,
+			Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+				Value: net.ParseIP("192.0.2.1").To4()}}})
+	}
+	zone.AddSig(sig)
+	if err := siglib.SignSectionUnsafe(zone, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: privKey}); err != nil {
+		t.Fatalf("Was not able to sign zone: %v", err)
+	}
+	//Find the largest chunk size which splits the zone into three chunks.
+	answer := message.Message{Content: []section.Section{zone}}
+	var chunks []message.Message
+	for maxBytes := 1024; len(chunks) != 3 && maxBytes > 0; maxBytes-- {
+		chunks, _ = answer.Chunk(maxBytes)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("Was not able to split zone into three chunks")
+	}
+	cert, err := tls.LoadX509KeyPair("../../../test/integration/testdata/cert/server.crt",
+		"../../../test/integration/testdata/cert/server.key")
+	if err != nil {
+		t.Fatalf("Was not able to load certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer listener.Close()
+	capabilities := make(chan []message.Capability, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		query := message.Message{}
+		if err := cbor.NewReader(conn).Unmarshal(&query); err != nil {
+			return
+		}
+		capabilities <- query.Capabilities
+		writer := cbor.NewWriter(conn)
+		for i := range chunks {
+			chunks[i].Token = query.Token
+			writer.Marshal(&chunks[i])
+		}
+	}()
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.DialTimeout = 1000
+	resolver.Forwarders = []net.Addr{listener.Addr()}
+	resolver.sendQuery = util.SendQueryRaw
+	q := &query.Name{Name: "ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	result, err := resolver.ClientLookup(q)
+	if err != nil {
+		t.Fatalf("Lookup of chunked zone failed: %v", err)
+	}
+	if caps := <-capabilities; len(caps) != 1 || caps[0] != message.ChunkedZones {
+		t.Errorf("query does not announce chunked zones: %v", caps)
+	}
+	reassembled, ok := result.Content[0].(*section.Zone)
+	if !ok || len(result.Content) != 1 || reassembled.CompareTo(zone) != 0 {
+		t.Fatalf("zone was not correctly reassembled expected=%v actual=%v", zone, result.Content)
+	}
+	pkeys := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         pubKey,
+	}}}
+	if !siglib.CheckSectionSignatures(reassembled, pkeys, util.MaxCacheValidity{ZoneValidity: time.Hour}) {
+		t.Error("signature of the reassembled zone is invalid")
+	}
+}
+
+func TestRecursiveResolveServerTooBusy(t *testing.T) {
+	assertion := section.Assertion{SubjectZone: ".", SubjectName: "ch"}
+	busyRoot := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}
+	root := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 12), Port: int(rainsPort)}
+	resolver := newResolver()
+	resolver.BusyBackoff = time.Minute
+	resolver.RootNameServers = []net.Addr{busyRoot, root}
+	queries := make(map[string]int)
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		queries[addr.String()]++
+		if addr.String() == busyRoot.String() {
+			return message.Message{Token: msg.Token, Content: []section.Section{&section.Notification{
+				Token: msg.Token, Type: section.NTServerTooBusy}}}, nil, nil
+		}
+		return message.Message{Token: msg.Token, Content: []section.Section{&assertion}}, nil, nil
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		if _, ok := msg.Content[0].(*section.Assertion); !ok {
+			t.Fatalf("Notification must not be handled as an answer: %v", msg)
+		}
+		isFinal = true
+		return
+	}
+	for i := 0; i < 2; i++ {
+		result, err := resolver.recursiveResolve(newQuery(), 0)
+		if err != nil {
+			t.Fatalf("%d: The call to recursiveResolve finished with an error: %v", i, err)
+		}
+		if result.Server.String() != root.String() {
+			t.Fatalf("%d: Wrong answering server expected=%s actual=%s", i, root, result.Server)
+		}
+	}
+	if queries[busyRoot.String()] != 1 || queries[root.String()] != 2 {
+		t.Fatalf("Busy root must only be contacted before the backoff actual=%v", queries)
+	}
+}
+
+func TestClientLookupOptions(t *testing.T) {
+	cached := &section.Assertion{SubjectZone: ".", SubjectName: "ch",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: object.PublicKey()}}}
+	fresh := &section.Assertion{SubjectZone: ".", SubjectName: "ch"}
+	var tests = []struct {
+		opts     []query.Option
+		want     *section.Assertion
+		sent     int
+		hasError bool
+	}{
+		{nil, cached, 0, false},
+		{[]query.Option{query.QOMaxFreshness}, fresh, 1, false},
+		{[]query.Option{query.QOCachedAnswersOnly}, cached, 0, false},
+		{[]query.Option{query.QOMaxFreshness, query.QOCachedAnswersOnly}, nil, 0, true},
+		{[]query.Option{query.QOExpiredAssertionsOk, query.QOMaxFreshness}, nil, 0, true},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}}
+		resolver.Delegations.Add(cached.FQDN(), cached, false)
+		sent := 0
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			sent++
+			q := msg.Content[0].(*query.Name)
+			for _, o := range test.opts {
+				if !q.ContainsOption(o) {
+					t.Errorf("%d: option %v was not sent to the server", i, o)
+				}
+			}
+			return message.Message{Token: msg.Token, Content: []section.Section{fresh}}, nil, nil
+		}
+		resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+			isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+			ipMap map[string][]string, nameMap map[string]object.Name) {
+			isFinal = true
+			return
+		}
+		q := &query.Name{Name: cached.FQDN(), Context: ".", Types: []object.Type{object.OTDelegation}}
+		answer, err := resolver.ClientLookup(q, test.opts...)
+		if (err != nil) != test.hasError {
+			t.Fatalf("%d: unexpected error expected=%v actual=%v", i, test.hasError, err)
+		}
+		if sent != test.sent {
+			t.Errorf("%d: wrong number of queries sent expected=%d actual=%d", i, test.sent, sent)
+		}
+		if len(q.Options) != 0 {
+			t.Errorf("%d: options were added to the caller's query: %v", i, q.Options)
+		}
+		if !test.hasError && (len(answer.Content) != 1 || answer.Content[0] != test.want) {
+			t.Errorf("%d: wrong answer expected=%v actual=%v", i, test.want, answer.Content)
+		}
+	}
+}
+
+func TestMaxDelegationStaleness(t *testing.T) {
+	now := time.Now().Unix()
+	var tests = []struct {
+		validUntil int64
+		maxStale   time.Duration
+		sent       int
+	}{
+		{now + 3600, time.Hour, 0},       //fresh
+		{now - 1, time.Hour, 0},          //just expired
+		{now - 2*3600, time.Hour, 1},     //long expired
+		{now - 2*3600, 0, 0},             //no limit
+		{now - 2*3600, 3 * time.Hour, 0}, //within the limit
+	}
+	for i, test := range tests {
+		cached := &section.Assertion{SubjectZone: ".", SubjectName: "ch",
+			Content: []object.Object{object.Object{Type: object.OTDelegation, Value: object.PublicKey()}}}
+		cached.SetValidUntil(test.validUntil)
+		resolver := newResolver()
+		resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}}
+		resolver.MaxDelegationStaleness = test.maxStale
+		resolver.Delegations.Add(cached.FQDN(), cached, false)
+		sent := 0
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			sent++
+			return message.Message{Token: msg.Token, Content: []section.Section{
+				&section.Assertion{SubjectZone: ".", SubjectName: "ch"}}}, nil, nil
+		}
+		resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+			isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+			ipMap map[string][]string, nameMap map[string]object.Name) {
+			isFinal = true
+			return
+		}
+		q := &query.Name{Name: cached.FQDN(), Context: ".", Types: []object.Type{object.OTDelegation}}
+		if _, err := resolver.ClientLookup(q); err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if sent != test.sent {
+			t.Errorf("%d: wrong number of queries sent expected=%d actual=%d", i, test.sent, sent)
+		}
+	}
+}
+
+func TestHandleRedirectBailiwick(t *testing.T) {
+	ipMap := map[string][]string{"ns.ethz.ch.": {"192.0.2.1"}, "ns.evil.com.": {"192.0.2.2"}}
+	srvMap := map[string][]object.ServiceInfo{
+		"_rains._tcp.ethz.ch.":  {{Name: "ns.ethz.ch.", Port: 1000}},
+		"_rains._tcp.other.ch.": {{Name: "ns.evil.com.", Port: 1000}},
+	}
+	var tests = []struct {
+		name      string
+		redirName string
+		allowOOB  bool
+		want      string
+		followed  bool
+	}{
+		{"ns.ethz.ch.", "ethz.ch.", false, "192.0.2.1:55553", true},
+		{"_rains._tcp.ethz.ch.", "ethz.ch.", false, "192.0.2.1:1000", true},
+		{"ns.evil.com.", "ethz.ch.", false, "", false},           //out-of-zone glue
+		{"_rains._tcp.other.ch.", "other.ch.", false, "", false}, //out-of-zone service target
+		{"ns.evil.com.", "ethz.ch.", true, "192.0.2.2:55553", true},
+		{"ns.evil.com.", "ch.", false, "192.0.2.2:55553", true}, //root is authoritative for all names
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.AllowOutOfBailiwick = test.allowOOB
+		addrs, err := resolver.handleRedirect(test.name, parentZone(test.redirName), srvMap, ipMap,
+			map[string]object.Name{}, AllowedRedirectTypes)
+		if (err == nil) != test.followed {
+			t.Fatalf("%d: unexpected bailiwick check result expected=%v err=%v", i, test.followed, err)
+		}
+		if err == nil && (len(addrs) != 1 || addrs[0].String() != test.want) {
+			t.Errorf("%d: wrong redirect address expected=%s actual=%v", i, test.want, addrs)
+		}
+	}
+}
+
+func TestHandleRedirectMultipleAddrs(t *testing.T) {
+	a := &section.Assertion{SubjectName: "ns", SubjectZone: "ethz.ch.", Context: ".",
+		Content: []object.Object{
+			object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")},
+			object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.2")},
+			object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")},
+		}}
+	q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	ipMap := make(map[string][]string)
+	var isFinal, isRedir bool
+	resolver := newResolver()
+	resolver.handleAssertion(a, true, map[string]string{}, map[string][]object.ServiceInfo{}, ipMap,
+		map[string]object.Name{}, map[object.Type]bool{object.OTIP4Addr: true}, q, &isFinal, &isRedir)
+	if want := []string{"192.0.2.1", "192.0.2.2"}; !reflect.DeepEqual(ipMap[a.FQDN()], want) {
+		t.Fatalf("wrong addresses expected=%v actual=%v", want, ipMap[a.FQDN()])
+	}
+	srvMap := map[string][]object.ServiceInfo{
+		"_rains._tcp.ethz.ch.": {{Name: "ns.ethz.ch.", Port: 1000}},
+	}
+	var tests = []struct {
+		name string
+		want []string
+	}{
+		{"ns.ethz.ch.", []string{"192.0.2.1:55553", "192.0.2.2:55553"}},
+		{"_rains._tcp.ethz.ch.", []string{"192.0.2.1:1000", "192.0.2.2:1000"}},
+	}
+	for i, test := range tests {
+		addrs, err := resolver.handleRedirect(test.name, "ch.", srvMap, ipMap,
+			map[string]object.Name{}, AllowedRedirectTypes)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		var actual []string
+		for _, addr := range addrs {
+			actual = append(actual, addr.String())
+		}
+		if !reflect.DeepEqual(actual, test.want) {
+			t.Errorf("%d: wrong redirect addresses expected=%v actual=%v", i, test.want, actual)
+		}
+	}
+}
+
+func TestCachedAnswerAge(t *testing.T) {
+	now := time.Unix(1000, 0)
+	delegations := cache.NewDelegation(10)
+	delegations.SetClock(func() time.Time { return now })
+	resolver := newResolver()
+	resolver.Delegations = delegations
+	cached := &section.Assertion{SubjectZone: ".", SubjectName: "ch",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: object.PublicKey()}}}
+	delegations.Add(cached.FQDN(), cached, false)
+	q := &query.Name{Name: cached.FQDN(), Context: ".", Types: []object.Type{object.OTDelegation}}
+	for i, elapsed := range []time.Duration{0, 5 * time.Second, 65 * time.Second} {
+		now = time.Unix(1000, 0).Add(elapsed)
+		result, err := resolver.Lookup(q)
+		if err != nil {
+			t.Fatalf("%d: lookup failed: %v", i, err)
+		}
+		if result.Server != nil || result.Age != elapsed {
+			t.Errorf("%d: wrong age of cached answer expected=%v actual=%v", i, elapsed, result.Age)
+		}
+		//ClientLookup serves the same cached answer, its age is only reported by Lookup.
+		answer, err := resolver.ClientLookup(q)
+		if err != nil || len(answer.Content) != 1 || answer.Content[0] != result.Answer.Content[0] {
+			t.Errorf("%d: ClientLookup did not return the cached answer err=%v answer=%v", i, err,
+				answer)
+		}
+	}
+	//a delegation added again is fresh.
+	delegations.Add(cached.FQDN(), cached, false)
+	if result, err := resolver.Lookup(q); err != nil || result.Age != 0 {
+		t.Errorf("replaced delegation must have age 0 err=%v result=%v", err, result)
+	}
+}
+
+func TestRecursiveResolveMaxRedirects(t *testing.T) {
+	var tests = []struct {
+		maxRedirects int
+		errMsg       string
+	}{
+		{0, "Was not able to obtain an answer"},
+		{5, "Was not able to obtain an answer"},
+		{3, "Maximum number of redirects reached at 3"},
+		{1, "Maximum number of redirects reached at 1"},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+		resolver.MaxRedirects = test.maxRedirects
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			return message.Message{Token: msg.Token, Content: []section.Section{&section.Assertion{}}},
+				nil, nil
+		}
+		//none of the five redirect targets has an address.
+		resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+			isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+			ipMap map[string][]string, nameMap map[string]object.Name) {
+			redirMap = make(map[string]string)
+			for j := 0; j < 5; j++ {
+				redirMap[fmt.Sprintf("z%d.", j)] = fmt.Sprintf("ns.z%d.", j)
+			}
+			return false, true, redirMap, nil, nil, nil
+		}
+		_, err := resolver.recursiveResolve(newQuery(), 0)
+		if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("%d: unexpected error with MaxRedirects=%d expected=%s actual=%v", i,
+				test.maxRedirects, test.errMsg, err)
+		}
+	}
+}
+
+func TestRecursiveResolveSelfDelegation(t *testing.T) {
+	var tests = []struct {
+		redirects []string //the zone the n-th contacted server redirects to
+		errMsg    string
+	}{
+		{[]string{"ch.", "ethz.ch."}, ""},
+		{[]string{"ch.", "ch."}, "zone ch. delegates to ch. which is not below it"},
+		{[]string{"ch.", "."}, "zone ch. delegates to . which is not below it"},
+		{[]string{"ch.", "ethz.com."}, "zone ch. delegates to ethz.com. which is not below it"},
+		{[]string{"."}, "zone . delegates to . which is not below it"},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+		sent := 0
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			sent++
+			return message.Message{Token: msg.Token, Content: []section.Section{&section.Assertion{}}},
+				nil, nil
+		}
+		resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+			isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+			ipMap map[string][]string, nameMap map[string]object.Name) {
+			if sent > len(test.redirects) {
+				return true, false, nil, nil, nil, nil
+			}
+			zone := test.redirects[sent-1]
+			//each redirect points to a different address.
+			redirMap = map[string]string{zone: "ns." + zone}
+			ipMap = map[string][]string{"ns." + zone: {fmt.Sprintf("127.0.0.%d", 10+sent)}}
+			return false, true, redirMap, nil, ipMap, nil
+		}
+		_, err := resolver.recursiveResolve(newQuery(), 0)
+		if test.errMsg == "" && err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+		if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%d: wrong error expected=%s actual=%v", i, test.errMsg, err)
+		}
+		if sent > len(test.redirects)+1 {
+			t.Errorf("%d: lookup did not stop after the misconfigured delegation sent=%d", i, sent)
+		}
+	}
+}
+
+func TestRecursiveResolveMinimize(t *testing.T) {
+	//servers maps the last byte of a server's address to the zone it redirects to.
+	servers := map[byte]string{10: "ch.", 11: "ethz.ch."}
+	var tests = []struct {
+		minimize bool
+		name     string
+		want     []string //the names sent to the servers in the order they have been sent
+	}{
+		{false, "www.inf.ethz.ch.", []string{"10 www.inf.ethz.ch.", "11 www.inf.ethz.ch.",
+			"12 www.inf.ethz.ch."}},
+		{true, "www.inf.ethz.ch.", []string{"10 ch.", "11 ethz.ch.", "12 inf.ethz.ch.",
+			"12 www.inf.ethz.ch."}},
+		{true, "www.ethz.ch.", []string{"10 ch.", "11 ethz.ch.", "12 www.ethz.ch."}},
+		{true, "ch.", []string{"10 ch."}},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+		resolver.Minimize = test.minimize
+		var sent []string
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			sent = append(sent, fmt.Sprintf("%d %s", addr.(*net.TCPAddr).IP.To4()[3],
+				msg.Content[0].(*query.Name).Name))
+			return message.Message{Token: msg.Token, Content: []section.Section{&section.Assertion{}}},
+				nil, nil
+		}
+		resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+			isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+			ipMap map[string][]string, nameMap map[string]object.Name) {
+			var last byte
+			fmt.Sscanf(sent[len(sent)-1], "%d", &last)
+			zone, ok := servers[last]
+			if !ok || zone == test.name || !inBailiwick(test.name, zone) {
+				//the server is authoritative for the queried name.
+				return q.Name == test.name, false, nil, nil, nil, nil
+			}
+			redirMap = map[string]string{zone: "ns." + zone}
+			ipMap = map[string][]string{"ns." + zone: {fmt.Sprintf("127.0.0.%d", last+1)}}
+			return false, true, redirMap, nil, ipMap, nil
+		}
+		q := newQuery()
+		q.Name = test.name
+		q.Types = []object.Type{object.OTIP4Addr}
+		if test.name == "ch." {
+			q.Types = []object.Type{object.OTDelegation}
+		}
+		if _, err := resolver.recursiveResolve(q, 0); err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(sent, test.want) {
+			t.Errorf("%d: wrong queries sent expected=%v actual=%v", i, test.want, sent)
+		}
+	}
+}
+
+func TestRecursiveResolveMinimizeGlue(t *testing.T) {
+	assertion := func(name, zone string, objects ...object.Object) *section.Assertion {
+		a := &section.Assertion{SubjectName: name, SubjectZone: zone, Context: ".", Content: objects}
+		a.SetValidUntil(time.Now().Add(time.Hour).Unix())
+		return a
+	}
+	delegation := object.Object{Type: object.OTDelegation, Value: object.PublicKey()}
+	ip4 := func(ip string) object.Object {
+		return object.Object{Type: object.OTIP4Addr, Value: net.ParseIP(ip)}
+	}
+	//zones maps the last byte of a server's address to the assertions it is authoritative for.
+	zones := map[byte][]*section.Assertion{
+		10: {
+			assertion("ch", ".", delegation),
+			assertion("ch", ".", object.Object{Type: object.OTRedirection, Value: "ns.ch."}),
+			assertion("ns.ch", ".", ip4("127.0.0.11")),
+		},
+		11: {
+			assertion("ethz", "ch.", delegation),
+			assertion("ethz", "ch.", object.Object{Type: object.OTRedirection,
+				Value: "_rains._tcp.ethz.ch."}),
+			assertion("_rains._tcp.ethz", "ch.", object.Object{Type: object.OTServiceInfo,
+				Value: object.ServiceInfo{Name: "ns.ethz.ch.", Port: rainsPort}}),
+			assertion("ns.ethz", "ch.", ip4("127.0.0.12")),
+		},
+		12: {assertion("www", "ethz.ch.", ip4("192.0.2.1"))},
+	}
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+	resolver.Minimize = true
+	var sent []string
+	//sendQuery answers like a server's assertion cache: it returns the assertions of the queried
+	//name containing a queried type.
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		server := addr.(*net.TCPAddr).IP.To4()[3]
+		q := msg.Content[0].(*query.Name)
+		sent = append(sent, fmt.Sprintf("%d %s", server, q.Name))
+		answer := message.Message{Token: msg.Token}
+		for _, a := range zones[server] {
+			for _, t := range q.Types {
+				if a.FQDN() == q.Name && a.Content[0].Type == t {
+					answer.Content = append(answer.Content, a)
+				}
+			}
+		}
+		if len(answer.Content) == 0 {
+			answer.Content = []section.Section{&section.Notification{Token: msg.Token,
+				Type: section.NTNoAssertionAvail}}
+		}
+		return answer, nil, nil
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		redirMap, srvMap = make(map[string]string), make(map[string][]object.ServiceInfo)
+		ipMap, nameMap = make(map[string][]string), make(map[string]object.Name)
+		types := make(map[object.Type]bool)
+		for _, t := range q.Types {
+			types[t] = true
+		}
+		for _, sec := range msg.Content {
+			r.handleAssertion(sec.(*section.Assertion), true, redirMap, srvMap, ipMap, nameMap, types,
+				q, &isFinal, &isRedir)
+		}
+		return
+	}
+	q := newQuery()
+	q.Name = "www.ethz.ch."
+	q.Types = []object.Type{object.OTIP4Addr}
+	result, err := resolver.recursiveResolve(q, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Answer.Content) != 1 || result.Answer.Content[0] != zones[12][0] {
+		t.Errorf("wrong answer expected=%v actual=%v", zones[12][0], result.Answer.Content)
+	}
+	want := []string{"10 ch.", "10 ch.", "10 ns.ch.", "11 ethz.ch.", "11 ethz.ch.",
+		"11 _rains._tcp.ethz.ch.", "11 ns.ethz.ch.", "12 www.ethz.ch."}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("wrong queries sent expected=%v actual=%v", want, sent)
+	}
+}
+
+func TestHandleAssertionServiceInfoDeterministic(t *testing.T) {
+	srv := func(name string, port uint16, prio uint) object.Object {
+		return object.Object{Type: object.OTServiceInfo,
+			Value: object.ServiceInfo{Name: name, Port: port, Priority: prio}}
+	}
+	want := []object.ServiceInfo{{Name: "ns2.ethz.ch.", Port: 1000, Priority: 0},
+		{Name: "ns1.ethz.ch.", Port: 1000, Priority: 1}, {Name: "ns1.ethz.ch.", Port: 2000, Priority: 1}}
+	var tests = [][]object.Object{
+		{srv("ns1.ethz.ch.", 1000, 1), srv("ns2.ethz.ch.", 1000, 0), srv("ns1.ethz.ch.", 2000, 1)},
+		{srv("ns1.ethz.ch.", 2000, 1), srv("ns2.ethz.ch.", 1000, 0), srv("ns1.ethz.ch.", 1000, 1)},
+		{srv("ns2.ethz.ch.", 1000, 0), srv("ns1.ethz.ch.", 2000, 1), srv("ns1.ethz.ch.", 1000, 1),
+			srv("ns2.ethz.ch.", 1000, 0)},
+	}
+	for i, content := range tests {
+		a := &section.Assertion{SubjectName: "_rains._tcp", SubjectZone: "ethz.ch.", Context: ".",
+			Content: content}
+		q := &query.Name{Name: "www.ethz.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+		srvMap := make(map[string][]object.ServiceInfo)
+		var isFinal, isRedir bool
+		newResolver().handleAssertion(a, true, map[string]string{}, srvMap, map[string][]string{},
+			map[string]object.Name{}, map[object.Type]bool{object.OTIP4Addr: true}, q, &isFinal, &isRedir)
+		if !reflect.DeepEqual(srvMap[a.FQDN()], want) {
+			t.Errorf("%d: service infos depend on the record order expected=%v actual=%v", i, want,
+				srvMap[a.FQDN()])
+		}
+	}
+}
+
+func TestRecursiveResolveServicePriority(t *testing.T) {
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+	var sent []string
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		sent = append(sent, addr.String())
+		if addr.String() == "127.0.0.11:1000" {
+			return message.Message{}, nil, errors.New("server of the preferred service is dead")
+		}
+		return message.Message{Token: msg.Token, Content: []section.Section{&section.Assertion{}}},
+			nil, nil
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		if len(sent) > 1 {
+			return true, false, nil, nil, nil, nil
+		}
+		//the services are published in the opposite order of their priority.
+		a := &section.Assertion{SubjectName: "_rains._tcp", SubjectZone: "ch.", Context: ".",
+			Content: []object.Object{
+				object.Object{Type: object.OTServiceInfo,
+					Value: object.ServiceInfo{Name: "ns2.ch.", Port: 2000, Priority: 20}},
+				object.Object{Type: object.OTServiceInfo,
+					Value: object.ServiceInfo{Name: "ns1.ch.", Port: 1000, Priority: 10}},
+			}}
+		srvMap = make(map[string][]object.ServiceInfo)
+		r.handleAssertion(a, true, map[string]string{}, srvMap, map[string][]string{},
+			map[string]object.Name{}, map[object.Type]bool{}, q, &isFinal, &isRedir)
+		redirMap = map[string]string{"ch.": "_rains._tcp.ch."}
+		ipMap = map[string][]string{"ns1.ch.": {"127.0.0.11"}, "ns2.ch.": {"127.0.0.12"}}
+		return false, true, redirMap, srvMap, ipMap, nil
+	}
+	q := newQuery()
+	q.Name = "www.ch."
+	if _, err := resolver.recursiveResolve(q, 0); err != nil {
+		t.Fatalf("lookup must fall through to the service of lower priority: %v", err)
+	}
+	want := []string{"127.0.0.10:55553", "127.0.0.11:1000", "127.0.0.12:2000"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("services not tried in priority order expected=%v actual=%v", want, sent)
+	}
+}
+
+func TestHandleAssertionUnusableDelegation(t *testing.T) {
+	var tests = []struct {
+		validUntil int64
+		keySpaces  []keys.KeySpaceID
+		cached     int //number of cached delegations, -1 if none must be cached
+	}{
+		{time.Now().Add(time.Hour).Unix(), []keys.KeySpaceID{keys.RainsKeySpace}, 1},
+		{time.Now().Add(-time.Hour).Unix(), []keys.KeySpaceID{keys.RainsKeySpace}, -1},
+		{time.Now().Add(time.Hour).Unix(), []keys.KeySpaceID{keys.KeySpaceID(1)}, -1},
+		{time.Now().Add(time.Hour).Unix(), []keys.KeySpaceID{keys.KeySpaceID(1), keys.RainsKeySpace}, 1},
+		{time.Now().Add(time.Hour).Unix(), []keys.KeySpaceID{keys.RainsKeySpace, keys.KeySpaceID(1)}, 1},
+		{time.Now().Add(time.Hour).Unix(), []keys.KeySpaceID{keys.RainsKeySpace, keys.RainsKeySpace}, 2},
+	}
+	for i, test := range tests {
+		a := &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+			Signatures: []signature.Sig{section.Signature()}}
+		for _, keySpace := range test.keySpaces {
+			pk := object.PublicKey()
+			pk.KeySpace = keySpace
+			a.Content = append(a.Content, object.Object{Type: object.OTDelegation, Value: pk})
+		}
+		a.SetValidUntil(test.validUntil)
+		q := &query.Name{Name: "www.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+		resolver := newResolver()
+		var isFinal, isRedir bool
+		resolver.handleAssertion(a, true, map[string]string{}, map[string][]object.ServiceInfo{},
+			map[string][]string{}, map[string]object.Name{}, map[object.Type]bool{object.OTIP4Addr: true},
+			q, &isFinal, &isRedir)
+		cached, ok := resolver.Delegations.Get("ch.")
+		if ok != (test.cached >= 0) {
+			t.Errorf("%d: wrong caching of delegation expected=%v actual=%v", i, test.cached >= 0, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if len(cached.Content) != test.cached {
+			t.Errorf("%d: wrong number of cached delegations expected=%d actual=%d", i, test.cached,
+				len(cached.Content))
+		}
+		for _, o := range cached.Content {
+			if o.Value.(keys.PublicKey).KeySpace != keys.RainsKeySpace {
+				t.Errorf("%d: unusable delegation cached: %v", i, o)
+			}
+		}
+		if filtered := len(cached.Content) != len(a.Content); filtered == (len(cached.Signatures) != 0) {
+			t.Errorf("%d: a filtered delegation must not keep the signatures: %v", i, cached.Signatures)
+		}
+	}
+}
+
+func TestRequireSignedDelegations(t *testing.T) {
+	rootPub, rootPriv, _ := ed25519.GenerateKey(nil)
+	childPub, _, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	rootKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Add(-time.Hour).Unix(),
+		ValidUntil:  time.Now().Add(48 * time.Hour).Unix(),
+		Key:         rootPub,
+	}
+	var tests = []struct {
+		signed bool
+		strict bool
+		want   bool
+	}{
+		{true, true, true},
+		{true, false, true},
+		{false, false, true},
+		{false, true, false},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+		resolver.MaxCacheValidity.AssertionValidity = time.Hour
+		resolver.RequireSignedDelegations = test.strict
+		resolver.handleAnswer = handleAnswer
+		resolver.Delegations.Add(".", &section.Assertion{SubjectName: "@", SubjectZone: ".", Context: ".",
+			Content: []object.Object{object.Object{Type: object.OTDelegation, Value: rootKey}}}, true)
+		deleg := &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+			Content: []object.Object{object.Object{Type: object.OTDelegation, Value: keys.PublicKey{
+				PublicKeyID: sig.PublicKeyID, Key: childPub}}}}
+		if test.signed {
+			deleg.AddSig(sig)
+			if err := siglib.SignSectionUnsafe(deleg,
+				map[keys.PublicKeyID]interface{}{sig.PublicKeyID: rootPriv}); err != nil {
+				t.Fatalf("%d: Was not able to sign delegation: %v", i, err)
+			}
+		}
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			return message.Message{Token: msg.Token, Content: []section.Section{deleg.Copy(".", ".")}},
+				nil, nil
+		}
+		q := &query.Name{Name: "ch.", Context: ".", Types: []object.Type{object.OTDelegation}}
+		_, err := resolver.recursiveResolve(q, 0)
+		_, cached := resolver.Delegations.Get("ch.")
+		if (err == nil) != test.want || cached != test.want {
+			t.Errorf("%d: signed=%v strict=%v expected delegation followed=%v actual err=%v cached=%v",
+				i, test.signed, test.strict, test.want, err, cached)
+		}
+	}
+}
+
+func TestRecursiveResolveZoneMetadata(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+	resolver.MaxCacheValidity.AssertionValidity = time.Hour
+	resolver.handleAnswer = handleAnswer
+	resolver.Delegations.Add("ch.", &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: keys.PublicKey{
+			PublicKeyID: sig.PublicKeyID,
+			ValidSince:  time.Now().Add(-time.Hour).Unix(),
+			ValidUntil:  time.Now().Add(48 * time.Hour).Unix(),
+			Key:         pub,
+		}}}}, true)
+	metadata := object.ZoneMetadata{PrimaryServer: "ns.ch.", Serial: 42, Refresh: 3600, Retry: 600,
+		Expire: 86400}
+	apex := &section.Assertion{SubjectName: section.ApexName, SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTZoneMetadata, Value: metadata}}}
+	apex.AddSig(sig)
+	if err := siglib.SignSectionUnsafe(apex, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: priv}); err != nil {
+		t.Fatalf("Was not able to sign apex assertion: %v", err)
+	}
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		return message.Message{Token: msg.Token, Content: []section.Section{apex}}, nil, nil
+	}
+	q := &query.Name{Name: "ch.", Context: ".", Types: []object.Type{object.OTZoneMetadata}}
+	result, err := resolver.recursiveResolve(q, 0)
+	if err != nil {
+		t.Fatalf("Was not able to resolve the zone metadata: %v", err)
+	}
+	a, ok := result.Answer.Content[0].(*section.Assertion)
+	if !ok || a.FQDN() != "ch." || len(a.Content) != 1 || a.Content[0].Value != metadata {
+		t.Errorf("wrong zone metadata answer expected=%v actual=%v", metadata, result.Answer.Content)
+	}
+}
+
+func TestLimitSectionsContained(t *testing.T) {
+	assertions := func(n int) []*section.Assertion {
+		var content []*section.Assertion
+		for i := 0; i < n; i++ {
+			content = append(content, &section.Assertion{SubjectName: fmt.Sprintf("host%d", i)})
+		}
+		return content
+	}
+	var tests = []struct {
+		content []section.Section
+		kept    int
+	}{
+		{[]section.Section{&section.Assertion{}, &section.Zone{Content: assertions(1)}}, 2},
+		{[]section.Section{&section.Assertion{}, &section.Zone{Content: assertions(2)}}, 1},
+		{[]section.Section{&section.Zone{Content: assertions(100)}}, 0}, //oversized zone
+		{[]section.Section{&section.Shard{Content: assertions(100)}, &section.Assertion{}}, 0},
+		{[]section.Section{&section.Shard{Content: assertions(2)}, &section.Assertion{}}, 1},
+		{[]section.Section{&section.Assertion{}, &section.Assertion{}, &section.Assertion{}}, 3},
+	}
+	resolver := newResolver()
+	resolver.MaxAnswerSections = 3
+	for i, test := range tests {
+		msg := &message.Message{Content: test.content}
+		dropped := resolver.limitSections(msg, newQuery())
+		if len(msg.Content) != test.kept || dropped != (test.kept < len(test.content)) {
+			t.Errorf("%d: wrong number of kept sections expected=%d actual=%d dropped=%v", i,
+				test.kept, len(msg.Content), dropped)
+		}
+	}
+}
+
+func TestMaxAnswerSections(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+	resolver.MaxCacheValidity.AssertionValidity = time.Hour
+	resolver.MaxAnswerSections = 3
+	resolver.handleAnswer = handleAnswer
+	resolver.Delegations.Add("ch.", &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: keys.PublicKey{
+			PublicKeyID: sig.PublicKeyID,
+			ValidSince:  time.Now().Add(-time.Hour).Unix(),
+			ValidUntil:  time.Now().Add(48 * time.Hour).Unix(),
+			Key:         pub,
+		}}}}, true)
+	//a crafted response containing far more sections than needed to answer the query.
+	response := message.Message{}
+	for i := 0; i < 100; i++ {
+		a := &section.Assertion{SubjectName: fmt.Sprintf("host%d", i), SubjectZone: "ch.", Context: ".",
+			Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: net.IPv4(192, 0, 2, byte(i))}}}
+		a.AddSig(sig)
+		if err := siglib.SignSectionUnsafe(a, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: priv}); err != nil {
+			t.Fatalf("Was not able to sign assertion: %v", err)
+		}
+		response.Content = append(response.Content, a)
+	}
+	q := &query.Name{Name: "host0.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	isFinal, _, _, _, ipMap, _ := handleAnswer(resolver, response, q, 0)
+	if !isFinal || len(ipMap) != resolver.MaxAnswerSections {
+		t.Errorf("wrong number of processed sections expected=%d actual=%d", resolver.MaxAnswerSections,
+			len(ipMap))
+	}
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		response.Token = msg.Token
+		return response, []byte{0}, nil
+	}
+	result, err := resolver.recursiveResolve(q, 0)
+	if err != nil {
+		t.Fatalf("Was not able to resolve: %v", err)
+	}
+	if len(result.Answer.Content) != resolver.MaxAnswerSections || result.Raw != nil {
+		t.Errorf("answer must be truncated to the processed sections actual=%d raw=%v",
+			len(result.Answer.Content), result.Raw)
+	}
+}
+
+func TestCachedDelegationKeyPhase(t *testing.T) {
+	var tests = []struct {
+		phaseSpecific bool
+		queryPhase    int
+		cached        bool
+	}{
+		{false, 0, true},
+		{false, 2, true},
+		{true, 2, true},
+		{true, 0, false},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		pk := object.PublicKey()
+		pk.KeyPhase = 2
+		resolver.Delegations.Add("ch.", &section.Assertion{SubjectName: "ch", SubjectZone: ".",
+			Context: ".", Content: []object.Object{object.Object{Type: object.OTDelegation, Value: pk}}},
+			false)
+		q := &query.Name{Name: "ch.", Context: ".", Types: []object.Type{object.OTDelegation},
+			KeyPhase: test.queryPhase}
+		result, err := resolver.resolveKeyPhase(q, 0, test.phaseSpecific)
+		if cached := err == nil && result.Status == Answered; cached != test.cached {
+			t.Errorf("%d: wrong cache usage expected=%v actual=%v err=%v", i, test.cached, cached, err)
+		}
+	}
+}
+
+func TestKeyPhaseDelegationQuery(t *testing.T) {
+	rootPub, rootPriv, _ := ed25519.GenerateKey(nil)
+	oldPub, _, _ := ed25519.GenerateKey(nil)
+	newPub, newPriv, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	rolled := sig
+	rolled.KeyPhase = 2
+	validKey := func(id keys.PublicKeyID, key ed25519.PublicKey) keys.PublicKey {
+		return keys.PublicKey{PublicKeyID: id, ValidSince: time.Now().Add(-time.Hour).Unix(),
+			ValidUntil: time.Now().Add(48 * time.Hour).Unix(), Key: key}
+	}
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+	resolver.MaxCacheValidity.AssertionValidity = time.Hour
+	resolver.MaxRecursiveCount = 3
+	resolver.handleAnswer = handleAnswer
+	resolver.Delegations.Add(".", &section.Assertion{SubjectName: "@", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation,
+			Value: validKey(sig.PublicKeyID, rootPub)}}}, true)
+	//The cached delegation of ch. only contains the key of the phase before the rollover.
+	resolver.Delegations.Add("ch.", &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation,
+			Value: validKey(sig.PublicKeyID, oldPub)}}}, false)
+	deleg := &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation,
+			Value: keys.PublicKey{PublicKeyID: rolled.PublicKeyID, Key: newPub}}}}
+	deleg.AddSig(sig)
+	apex := &section.Assertion{SubjectName: section.ApexName, SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.NameObject()}}
+	apex.AddSig(rolled)
+	if err := siglib.SignSectionUnsafe(deleg, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: rootPriv}); err != nil {
+		t.Fatalf("Was not able to sign delegation: %v", err)
+	}
+	if err := siglib.SignSectionUnsafe(apex, map[keys.PublicKeyID]interface{}{rolled.PublicKeyID: newPriv}); err != nil {
+		t.Fatalf("Was not able to sign apex assertion: %v", err)
+	}
+	var keyQueries []*query.Name
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		q := msg.Content[0].(*query.Name)
+		if q.Types[0] == object.OTDelegation {
+			keyQueries = append(keyQueries, q)
+			return message.Message{Token: msg.Token, Content: []section.Section{deleg.Copy(".", ".")}},
+				nil, nil
+		}
+		return message.Message{Token: msg.Token, Content: []section.Section{apex.Copy(".", "ch.")}},
+			nil, nil
+	}
+	q := &query.Name{Name: "ch.", Context: ".", Types: []object.Type{object.OTName}}
+	if _, err := resolver.recursiveResolve(q, 0); err != nil {
+		t.Fatalf("Was not able to resolve a name signed with the rolled over key: %v", err)
+	}
+	if len(keyQueries) != 1 || keyQueries[0].Name != "ch." || keyQueries[0].KeyPhase != rolled.KeyPhase {
+		t.Fatalf("expected a single delegation query for key phase %d actual=%v", rolled.KeyPhase,
+			keyQueries)
+	}
+	if cached, ok := resolver.Delegations.Get("ch."); !ok || !cached.DelegatesKeyPhase(rolled.KeyPhase) {
+		t.Errorf("cached delegation must contain the key of the new phase actual=%v", cached)
+	}
+}
+
+func TestRecursiveResolveText(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 10), Port: int(rainsPort)}}
+	resolver.MaxCacheValidity.AssertionValidity = time.Hour
+	resolver.handleAnswer = handleAnswer
+	resolver.Delegations.Add("ch.", &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: keys.PublicKey{
+			PublicKeyID: sig.PublicKeyID,
+			ValidSince:  time.Now().Add(-time.Hour).Unix(),
+			ValidUntil:  time.Now().Add(48 * time.Hour).Unix(),
+			Key:         pub,
+		}}}}, true)
+	texts := []object.Object{object.Object{Type: object.OTText, Value: "verification=42"},
+		object.Object{Type: object.OTText, Value: "contact admin at ethz.ch"}}
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: append([]object.Object{object.Object{Type: object.OTIP4Addr,
+			Value: net.ParseIP("192.0.2.1")}}, texts...)}
+	a.AddSig(sig)
+	if err := siglib.SignSectionUnsafe(a, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: priv}); err != nil {
+		t.Fatalf("Was not able to sign assertion: %v", err)
+	}
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		return message.Message{Token: msg.Token, Content: []section.Section{a}}, nil, nil
+	}
+	q := &query.Name{Name: "ethz.ch.", Context: ".", Types: []object.Type{object.OTText}}
+	result, err := resolver.recursiveResolve(q, 0)
+	if err != nil {
+		t.Fatalf("Was not able to resolve the text records: %v", err)
+	}
+	answer, ok := result.Answer.Content[0].(*section.Assertion)
+	if !ok || answer.FQDN() != q.Name {
+		t.Fatalf("wrong text answer actual=%v", result.Answer.Content)
+	}
+	var got []string
+	for _, o := range answer.Content {
+		if o.Type == object.OTText {
+			got = append(got, o.Value.(string))
+		}
+	}
+	if want := "verification=42|contact admin at ethz.ch"; strings.Join(got, "|") != want {
+		t.Errorf("wrong text records expected=%v actual=%v", want, got)
+	}
+}
+
+func TestHandleShardAndZoneLabelBoundary(t *testing.T) {
+	resolver := newResolver()
+	var tests = []struct {
+		name  string
+		final bool
+	}{
+		{"www.bar.com.", true},
+		{"bar.com.", true},
+		{"foobar.com.", false},
+		{"com.", false},
+	}
+	for i, test := range tests {
+		isFinal := false
+		shard := &section.Shard{SubjectZone: "bar.com.", Context: "."}
+		resolver.handleShard(shard, nil, test.name, &isFinal)
+		if isFinal != test.final {
+			t.Errorf("%d: shard of bar.com. answering %s expected=%v", i, test.name, test.final)
+		}
+		isFinal, isRedir := false, false
+		zone := &section.Zone{SubjectZone: "bar.com.", Context: "."}
+		q := &query.Name{Name: test.name, Context: ".", Types: []object.Type{object.OTIP4Addr}}
+		resolver.handleZone(zone, true, nil, nil, nil, nil, nil, q, &isFinal, &isRedir)
+		if isFinal != test.final {
+			t.Errorf("%d: zone bar.com. answering %s expected=%v", i, test.name, test.final)
+		}
+	}
+}
+
+func TestOnConnErrorDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Was not able to reserve a port: %v", err)
+	}
+	addr := l.Addr()
+	l.Close()
+	resolver := newResolver()
+	resolver.DialTimeout = time.Second
+	var addrs []net.Addr
+	var errs []error
+	resolver.OnConnError = func(a net.Addr, err error) {
+		addrs = append(addrs, a)
+		errs = append(errs, err)
+	}
+	resolver.createConnAndWrite(addr, &message.Message{})
+	if len(addrs) != 1 || addrs[0].String() != addr.String() || errs[0] == nil {
+		t.Fatalf("OnConnError not called for dial failure expected=%s actual=%v errors=%v", addr,
+			addrs, errs)
+	}
+}
+
+func TestServerLookupFailure(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../../../test/integration/testdata/cert/server.crt",
+		"../../../test/integration/testdata/cert/server.key")
+	if err != nil {
+		t.Fatalf("Was not able to load certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer listener.Close()
+	received := make(chan message.Message, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg := message.Message{}
+		if err := cbor.NewReader(conn).Unmarshal(&msg); err != nil {
+			return
+		}
+		received <- msg
+	}()
+	resolver := newResolver()
+	resolver.Connections = cache.NewConnection(10, 0)
+	resolver.MaxRecursiveCount = 0
+	tok := token.New()
+	go resolver.ServerLookup(newQuery(), listener.Addr(), tok)
+	select {
+	case msg := <-received:
+		if msg.Token != tok || len(msg.Content) != 1 {
+			t.Fatalf("wrong failure response expected token=%v actual=%v", tok, msg)
+		}
+		n, ok := msg.Content[0].(*section.Notification)
+		if !ok || n.Type != section.NTUnspecServerErr || n.Token != tok {
+			t.Errorf("failed lookup must be answered with a notification: %v", msg.Content[0])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no response received for a failed lookup")
+	}
+}
+
+func TestLookupMultiplexed(t *testing.T) {
+	const nofLookups = 20
+	cert, err := tls.LoadX509KeyPair("../../../test/integration/testdata/cert/server.crt",
+		"../../../test/integration/testdata/cert/server.key")
+	if err != nil {
+		t.Fatalf("Was not able to load certificate: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer listener.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer conn.Close()
+				reader, writer := cbor.NewReader(conn), cbor.NewWriter(conn)
+				for {
+					msg := message.Message{}
+					if err := reader.Unmarshal(&msg); err != nil {
+						return
+					}
+					q := msg.Content[0].(*query.Name)
+					a := &section.Assertion{SubjectName: strings.TrimSuffix(q.Name, ".ch."),
+						SubjectZone: "ch.", Context: ".", Content: []object.Object{object.Object{
+							Type: object.OTIP4Addr, Value: net.ParseIP("127.0.0.1").To4()}}}
+					answer := message.Message{Token: msg.Token, Content: []section.Section{a}}
+					if err := writer.Marshal(&answer); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.DialTimeout = 2000
+	resolver.Forwarders = []net.Addr{listener.Addr()}
+	resolver.Multiplex = true
+	var wg sync.WaitGroup
+	errs := make(chan error, nofLookups)
+	for i := 0; i < nofLookups; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q := newQuery()
+			q.Name = fmt.Sprintf("name%d.ch.", i)
+			q.Types = []object.Type{object.OTIP4Addr}
+			answer, err := resolver.ClientLookup(q)
+			if err != nil {
+				errs <- fmt.Errorf("lookup %d failed: %v", i, err)
+				return
+			}
+			if a, ok := answer.Content[0].(*section.Assertion); !ok || a.FQDN() != q.Name {
+				errs <- fmt.Errorf("lookup %d received a wrong answer: %v", i, answer)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Errorf("multiplexed lookups must share one connection, opened %d", n)
+	}
+}
+
+func TestClientLookupNormalizesName(t *testing.T) {
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}}
+	var sent []string
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		sent = append(sent, msg.Content[0].(*query.Name).Name)
+		return message.Message{Token: msg.Token, Content: []section.Section{
+			&section.Assertion{SubjectZone: "com.", SubjectName: "example"}}}, nil, nil
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		isFinal = true
+		return
+	}
+	for _, name := range []string{"Example.COM", "example.com."} {
+		q := &query.Name{Name: name, Context: ".", Types: []object.Type{object.OTIP4Addr}}
+		if _, err := resolver.ClientLookup(q); err != nil {
+			t.Fatalf("Lookup of %s failed: %v", name, err)
+		}
+	}
+	if len(sent) != 2 || sent[0] != "example.com." || sent[1] != sent[0] {
+		t.Fatalf("names were not resolved identically: %v", sent)
+	}
+	q := &query.Name{Name: "example..com.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	if _, err := resolver.ClientLookup(q); err == nil || len(sent) != 2 {
+		t.Fatalf("name with an empty label must be rejected before resolution err=%v", err)
+	}
+}
+
+func TestLookupWithSearch(t *testing.T) {
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.Forwarders = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 21), Port: int(rainsPort)}}
+	resolver.SearchList = []string{"corp.", "example"}
+	var sent []string
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		q := msg.Content[0].(*query.Name)
+		sent = append(sent, q.Name)
+		if q.Name == "foo.example." || q.Name == "bar." {
+			zone := strings.TrimPrefix(q.Name[strings.Index(q.Name, "."):], ".")
+			if zone == "" {
+				zone = "."
+			}
+			a := &section.Assertion{SubjectZone: zone, SubjectName: q.Name[:strings.Index(q.Name, ".")],
+				Context: ".", Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+					Value: "192.0.2.1"}}}
+			return message.Message{Token: msg.Token, Content: []section.Section{a}}, nil, nil
+		}
+		return message.Message{Token: msg.Token, Content: []section.Section{&section.Notification{
+			Token: msg.Token, Type: section.NTNoAssertionsExist}}}, nil, nil
+	}
+	var tests = []struct {
+		name     string
+		wantSent []string
+		wantErr  bool
+	}{
+		//the first suffix yields no such name
+		{"foo", []string{"foo.corp.", "foo.example."}, false},
+		//an absolute name bypasses the search list
+		{"bar.", []string{"bar."}, false},
+		{"baz", []string{"baz.corp.", "baz.example."}, true},
+	}
+	types := []object.Type{object.OTIP4Addr}
+	for i, test := range tests {
+		sent = nil
+		answer, err := resolver.LookupWithSearch(context.Background(), test.name, types)
+		if strings.Join(sent, " ") != strings.Join(test.wantSent, " ") {
+			t.Errorf("%d: wrong names tried expected=%v actual=%v", i, test.wantSent, sent)
+		}
+		if test.wantErr {
+			searchErr, ok := err.(*SearchError)
+			if !ok || len(searchErr.Errors) != len(test.wantSent) {
+				t.Errorf("%d: expected an error for each tried name actual=%v", i, err)
+			}
+			continue
+		}
+		if err != nil || len(answer.Content) != 1 {
+			t.Fatalf("%d: lookup of %s failed: %v", i, test.name, err)
+		}
+		if a := answer.Content[0].(*section.Assertion); a.FQDN() != test.wantSent[len(test.wantSent)-1] {
+			t.Errorf("%d: wrong answer actual=%s", i, a)
+		}
+	}
+}
+
+func TestRecursiveResolveAnswerStatus(t *testing.T) {
+	ip := object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")}
+	name := object.Object{Type: object.OTName, Value: object.Name{Name: "b.example.",
+		Types: []object.Type{object.OTIP4Addr}}}
+	var tests = []struct {
+		answer section.Section
+		want   AnswerStatus
+	}{
+		{&section.Assertion{SubjectZone: "example.", SubjectName: "a", Content: []object.Object{ip}},
+			Answered},
+		//the name exists but has no object of the requested type
+		{&section.Assertion{SubjectZone: "example.", SubjectName: "a", Content: []object.Object{name}},
+			NoData},
+		{&section.Shard{SubjectZone: "example.", RangeFrom: "", RangeTo: "", Content: []*section.Assertion{
+			&section.Assertion{SubjectName: "a", Content: []object.Object{name}}}}, NoData},
+		//a covering shard proves that the name does not exist
+		{&section.Shard{SubjectZone: "example.", RangeFrom: "", RangeTo: "b"}, NXDomain},
+		{&section.Zone{SubjectZone: "example.", Content: []*section.Assertion{
+			&section.Assertion{SubjectName: "b", Content: []object.Object{ip}}}}, NXDomain},
+		{&section.Shard{SubjectZone: "example.", RangeFrom: "b", RangeTo: ""}, Undetermined},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}}
+		answer := test.answer
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			return message.Message{Token: msg.Token, Content: []section.Section{answer}}, nil, nil
+		}
+		resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+			isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+			ipMap map[string][]string, nameMap map[string]object.Name) {
+			isFinal = true
+			return
+		}
+		q := &query.Name{Name: "a.example.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+		result, err := resolver.recursiveResolve(q, 0)
+		if err != nil {
+			t.Fatalf("%d: recursiveResolve failed: %v", i, err)
+		}
+		if result.Status != test.want {
+			t.Errorf("%d: wrong answer status expected=%v actual=%v", i, test.want, result.Status)
+		}
+	}
+}
+
+//chainResolver returns a resolver whose servers form a chain of 4 zones below the root. Each server
+//answers after latency. Answers do not contain the delegation of the zone they redirect to, which
+//must be looked up before the answer of the next zone's server can be verified.
+func chainResolver(latency time.Duration, maxPrefetch int) *Resolver {
+	zones := []string{".", "d.", "c.d.", "b.c.d."}
+	server := func(i int) *net.TCPAddr {
+		return &net.TCPAddr{IP: net.IPv4(127, 0, 0, byte(10+i)), Port: int(rainsPort)}
+	}
+	resolver := newResolver()
+	resolver.MaxRecursiveCount = 10
+	resolver.MaxPrefetch = maxPrefetch
+	resolver.RootNameServers = []net.Addr{server(0)}
+	resolver.Delegations.Add(".", &section.Assertion{SubjectZone: ".", SubjectName: "@"}, true)
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		time.Sleep(latency)
+		q := msg.Content[0].(*query.Name)
+		i := int(addr.(*net.TCPAddr).IP.To4()[3]) - 10
+		a := &section.Assertion{SubjectZone: zones[i]}
+		switch {
+		case i+1 < len(zones) && q.Name == zones[i+1] && q.Types[0] == object.OTDelegation:
+			a.SubjectName = strings.TrimSuffix(strings.TrimSuffix(q.Name, zones[i]), ".")
+			a.Content = []object.Object{object.Object{Type: object.OTDelegation}}
+		case i+1 < len(zones):
+			a.SubjectName = strings.TrimSuffix(strings.TrimSuffix(zones[i+1], zones[i]), ".")
+			a.Content = []object.Object{object.Object{Type: object.OTRedirection,
+				Value: "ns." + zones[i+1]}}
+		default:
+			a.SubjectName = "a"
+			a.Content = []object.Object{object.Object{Type: object.OTIP4Addr}}
+		}
+		return message.Message{Token: msg.Token, Content: []section.Section{a}}, nil, nil
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		a := msg.Content[0].(*section.Assertion)
+		r.awaitPrefetch(a.SubjectZone)
+		if _, ok := r.Delegations.Get(a.SubjectZone); !ok {
+			keyQuery := &query.Name{Name: a.SubjectZone, Types: []object.Type{object.OTDelegation}}
+			if _, err := r.recursiveResolve(keyQuery, recurseCount+1); err != nil {
+				return
+			}
+		}
+		switch o := a.Content[0]; o.Type {
+		case object.OTDelegation:
+			r.Delegations.Add(a.FQDN(), a, false)
+			isFinal = true
+		case object.OTRedirection:
+			for i, zone := range zones {
+				if zone == a.FQDN() {
+					isRedir = true
+					redirMap = map[string]string{zone: o.Value.(string)}
+					ipMap = map[string][]string{o.Value.(string): {server(i).IP.String()}}
+				}
+			}
+		default:
+			isFinal = true
+		}
+		return
+	}
+	return resolver
+}
+
+func TestPrefetchDelegations(t *testing.T) {
+	for _, maxPrefetch := range []int{0, 2} {
+		resolver := chainResolver(0, maxPrefetch)
+		q := &query.Name{Name: "a.b.c.d.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+		result, err := resolver.ClientLookup(q)
+		if err != nil {
+			t.Fatalf("lookup with MaxPrefetch=%d failed: %v", maxPrefetch, err)
+		}
+		if a := result.Content[0].(*section.Assertion); a.FQDN() != q.Name {
+			t.Errorf("wrong answer with MaxPrefetch=%d: %v", maxPrefetch, a)
+		}
+	}
+	var sent int32
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 11), Port: int(rainsPort)}}
+	resolver.MaxPrefetch = 2
+	resolver.MaxRecursiveCount = 2
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		atomic.AddInt32(&sent, 1)
+		return message.Message{}, nil, nil
+	}
+	redirMap := map[string]string{"a.": "ns.a.", "b.": "ns.b.", "c.": "ns.c.", "d.": "ns.d."}
+	resolver.prefetchDelegations(redirMap, newQuery(), 0)
+	for zone := range redirMap {
+		resolver.awaitPrefetch(zone)
+	}
+	if n := atomic.LoadInt32(&sent); n != 2 {
+		t.Errorf("wrong number of prefetched delegations expected=2 actual=%d", n)
+	}
+}
+
+func TestAwaitPrefetchNoDuplicateQuery(t *testing.T) {
+	resolver := chainResolver(5*time.Millisecond, 2)
+	resolver.DialTimeout = 1000
+	var mu sync.Mutex
+	delegQueries := make(map[string]int)
+	sendQuery := resolver.sendQuery
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		if q := msg.Content[0].(*query.Name); q.Types[0] == object.OTDelegation {
+			mu.Lock()
+			delegQueries[fmt.Sprintf("%s at %s", q.Name, addr)]++
+			mu.Unlock()
+		}
+		return sendQuery(msg, addr, timeout)
+	}
+	q := &query.Name{Name: "a.b.c.d.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	if _, err := resolver.ClientLookup(q); err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for key, n := range delegQueries {
+		if n != 1 {
+			t.Errorf("delegation of %s queried %d times instead of awaiting the prefetch", key, n)
+		}
+	}
+}
+
+func TestDumpDelegations(t *testing.T) {
+	resolver := chainResolver(0, 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			resolver.DumpDelegations()
+		}
+	}()
+	q := &query.Name{Name: "a.b.c.d.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+	if _, err := resolver.ClientLookup(q); err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	<-done
+	dump := resolver.DumpDelegations()
+	want := []string{".", "b.c.d.", "c.d.", "d."}
+	if len(dump) != len(want) {
+		t.Fatalf("wrong number of delegations expected=%v actual=%v", want, dump)
+	}
+	for i, e := range dump {
+		if e.Zone != want[i] || e.Assertion == nil || e.Internal != (e.Zone == ".") {
+			t.Errorf("%d: wrong delegation expected zone=%s actual=%v", i, want[i], e)
+		}
+		if e.Zone != "." && e.Assertion.FQDN() != e.Zone {
+			t.Errorf("%d: delegation assertion is not about %s: %v", i, e.Zone, e.Assertion)
+		}
+	}
+	dump[1].Assertion.Content = nil
+	if a, _ := resolver.Delegations.Get("b.c.d."); len(a.Content) == 0 {
+		t.Error("modifying the snapshot must not change the cached delegation")
+	}
+}
+
+func BenchmarkRecursiveResolvePrefetch(b *testing.B) {
+	for _, maxPrefetch := range []int{0, 2} {
+		b.Run(fmt.Sprintf("MaxPrefetch=%d", maxPrefetch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				resolver := chainResolver(2*time.Millisecond, maxPrefetch)
+				q := &query.Name{Name: "a.b.c.d.", Context: ".", Types: []object.Type{object.OTIP4Addr}}
+				if _, err := resolver.ClientLookup(q); err != nil {
+					b.Fatalf("lookup failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+//stripIP6 is an answer filter removing all IPv6 address objects from the answer's assertions.
+func stripIP6(msg *message.Message) (*message.Message, error) {
+	filtered := &message.Message{Token: msg.Token, Capabilities: msg.Capabilities}
+	for _, sec := range msg.Content {
+		a, ok := sec.(*section.Assertion)
+		if !ok {
+			filtered.Content = append(filtered.Content, sec)
+			continue
+		}
+		stripped := &section.Assertion{SubjectName: a.SubjectName, SubjectZone: a.SubjectZone,
+			Context: a.Context, Signatures: a.Signatures}
+		for _, o := range a.Content {
+			if o.Type != object.OTIP6Addr {
+				stripped.Content = append(stripped.Content, o)
+			}
+		}
+		filtered.Content = append(filtered.Content, stripped)
+	}
+	return filtered, nil
+}
+
+func TestAnswerFilters(t *testing.T) {
+	ip4 := object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}
+	ip6 := object.Object{Type: object.OTIP6Addr, Value: "2001:db8::1"}
+	reject := func(msg *message.Message) (*message.Message, error) {
+		return nil, errors.New("policy forbids the answer")
+	}
+	var tests = []struct {
+		filters []AnswerFilter
+		content []object.Object
+		status  AnswerStatus
+		errMsg  string
+	}{
+		{nil, []object.Object{ip6, ip4}, Answered, ""},
+		{[]AnswerFilter{stripIP6}, []object.Object{ip4}, Answered, ""},
+		{[]AnswerFilter{stripIP6, stripIP6}, []object.Object{ip4}, Answered, ""},
+		{[]AnswerFilter{stripIP6, reject}, nil, Undetermined,
+			"answer filter 1 rejected the answer to query www.ethz.ch.: policy forbids the answer"},
+	}
+	for i, test := range tests {
+		resolver := newResolver()
+		resolver.Mode = Forward
+		resolver.Forwarders = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 12), Port: int(rainsPort)}}
+		resolver.AnswerFilters = test.filters
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			return message.Message{Token: msg.Token, Content: []section.Section{
+				&section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch.", Context: ".",
+					Content: []object.Object{ip6, ip4}}}}, []byte{0}, nil
+		}
+		q := newQuery()
+		q.Name = "www.ethz.ch."
+		q.Context = "."
+		q.Types = []object.Type{object.OTIP4Addr, object.OTIP6Addr}
+		result, err := resolver.Lookup(q)
+		if test.errMsg != "" {
+			if err == nil || err.Error() != test.errMsg {
+				t.Errorf("%d: wrong error expected=%s actual=%v", i, test.errMsg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		a := result.Answer.Content[0].(*section.Assertion)
+		if !reflect.DeepEqual(a.Content, test.content) {
+			t.Errorf("%d: wrong answer content expected=%v actual=%v", i, test.content, a.Content)
+		}
+		if result.Status != test.status {
+			t.Errorf("%d: wrong status expected=%v actual=%v", i, test.status, result.Status)
+		}
+		if len(test.filters) > 0 && result.Raw != nil {
+			t.Errorf("%d: the encoding of a filtered answer must be dropped", i)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	cached := &section.Assertion{SubjectZone: ".", SubjectName: "ch",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: object.PublicKey()}}}
+	cached.SetValidUntil(time.Now().Add(time.Hour).Unix())
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 12), Port: int(rainsPort)}}
+	resolver.Delegations.Add(cached.FQDN(), cached, false)
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		switch msg.Content[0].(*query.Name).Name {
+		case "down.ch.":
+			return message.Message{}, nil, errors.New("connection refused")
+		case "busy.ch.":
+			return *notificationMsg(msg.Token, section.NTUnspecServerErr, "failure"), nil, nil
+		}
+		return message.Message{Token: msg.Token, Content: []section.Section{
+			&section.Assertion{SubjectZone: "ch.", SubjectName: "www.ethz"}}}, nil, nil
+	}
+	resolver.handleAnswer = func(r *Resolver, msg message.Message, q *query.Name, recurseCount int) (
+		isFinal bool, isRedir bool, redirMap map[string]string, srvMap map[string][]object.ServiceInfo,
+		ipMap map[string][]string, nameMap map[string]object.Name) {
+		isFinal = true
+		return
+	}
+	var tests = []struct {
+		name    string
+		oType   object.Type
+		success bool
+	}{
+		{"ch.", object.OTDelegation, true}, //cached
+		{"www.ethz.ch.", object.OTName, true},
+		{"www.ethz.ch.", object.OTName, true},
+		{"down.ch.", object.OTName, false},
+		{"busy.ch.", object.OTName, false},
+	}
+	var wg sync.WaitGroup
+	for i, test := range tests {
+		wg.Add(1)
+		go func(i int, name string, oType object.Type, success bool) {
+			defer wg.Done()
+			resolver.Stats()
+			q := &query.Name{Name: name, Context: ".", Types: []object.Type{oType}}
+			if _, err := resolver.Lookup(q); (err == nil) != success {
+				t.Errorf("%d: unexpected lookup result expected success=%t err=%v", i, success, err)
+			}
+		}(i, test.name, test.oType, test.success)
+	}
+	wg.Wait()
+	expected := ResolverStats{Lookups: 5, FailedLookups: 2, CacheHits: 1, CacheMisses: 4, Queries: 4,
+		QueryErrors: 1, ServerErrors: 1}
+	if stats := resolver.Stats(); stats != expected {
+		t.Errorf("wrong statistics expected=%+v actual=%+v", expected, stats)
+	}
+}
+
+func TestAnyTypeQuery(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	resolver := newResolver()
+	resolver.RootNameServers = []net.Addr{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 13), Port: int(rainsPort)}}
+	resolver.MaxCacheValidity.AssertionValidity = time.Hour
+	resolver.handleAnswer = handleAnswer
+	resolver.Delegations.Add("ch.", &section.Assertion{SubjectName: "ch", SubjectZone: ".", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTDelegation, Value: keys.PublicKey{
+			PublicKeyID: sig.PublicKeyID,
+			ValidSince:  time.Now().Add(-time.Hour).Unix(),
+			ValidUntil:  time.Now().Add(48 * time.Hour).Unix(),
+			Key:         pub,
+		}}}}, true)
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{
+			object.NameObject(),
+			object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")},
+			object.Object{Type: object.OTRedirection, Value: "ns.ethz.ch."},
+			object.ServiceObject(),
+		}}
+	a.AddSig(sig)
+	if err := siglib.SignSectionUnsafe(a, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: priv}); err != nil {
+		t.Fatalf("Was not able to sign assertion: %v", err)
+	}
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		return message.Message{Token: msg.Token, Content: []section.Section{a}}, nil, nil
+	}
+	q := &query.Name{Name: "ethz.ch.", Context: ".", Types: []object.Type{object.OTAny}}
+	result, err := resolver.recursiveResolve(q, 0)
+	if err != nil {
+		t.Fatalf("Was not able to resolve any type query: %v", err)
+	}
+	if result.Status != Answered {
+		t.Errorf("wrong answer status expected=%v actual=%v", Answered, result.Status)
+	}
+	types := make(map[object.Type]bool)
+	for _, sec := range result.Answer.Content {
+		for _, o := range sec.(*section.Assertion).Content {
+			types[o.Type] = true
+		}
+	}
+	for _, want := range []object.Type{object.OTIP4Addr, object.OTName, object.OTServiceInfo} {
+		if !types[want] {
+			t.Errorf("answer to any type query does not contain an object of type %v: %v", want,
+				result.Answer)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	server := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 25), Port: int(rainsPort)}
+	var mu sync.Mutex
+	var injected, global []string
+	//capture returns a handler appending the messages of the records about server to msgs.
+	capture := func(msgs *[]string) log.Handler {
+		return log.FuncHandler(func(r *log.Record) error {
+			for i := 0; i+1 < len(r.Ctx); i += 2 {
+				if addr, ok := r.Ctx[i+1].(net.Addr); ok && addr.String() == server.String() {
+					mu.Lock()
+					*msgs = append(*msgs, r.Msg)
+					mu.Unlock()
+				}
+			}
+			return nil
+		})
+	}
+	rootHandler := log.Root().GetHandler()
+	defer log.Root().SetHandler(rootHandler)
+	log.Root().SetHandler(capture(&global))
+	resolver := newResolver()
+	resolver.Mode = Forward
+	resolver.Forwarders = []net.Addr{server}
+	resolver.FailureThreshold = 1
+	resolver.FailureCooldown = time.Minute
+	resolver.Logger = log.New()
+	resolver.Logger.SetHandler(capture(&injected))
+	resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+		message.Message, []byte, error) {
+		return message.Message{}, nil, errors.New("server did not answer")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := resolver.forwardQuery(newQuery()); err == nil {
+			t.Fatalf("%d: forwardQuery must fail if the forwarder does not answer", i)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(injected) != 2 {
+		t.Errorf("injected logger must receive the records of the resolver actual=%v", injected)
+	}
+	if len(global) != 0 {
+		t.Errorf("global logger must not receive records of the resolver actual=%v", global)
+	}
+}
+
+//pipeDialer opens connections to an in-memory TLS server. Dials to an address in block wait until
+//its channel is closed.
+type pipeDialer struct {
+	config *tls.Config
+	block  map[string]chan struct{}
+	dialed chan string
+	mu     sync.Mutex
+	dials  map[string]int
+}
+
+func (d *pipeDialer) Dial(network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dials[address]++
+	d.mu.Unlock()
+	d.dialed <- address
+	if block, ok := d.block[address]; ok {
+		<-block
+	}
+	client, server := net.Pipe()
+	go tls.Server(server, d.config).Handshake()
+	return client, nil
+}
+
+func TestMuxDial(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../../../test/integration/testdata/cert/server.crt",
+		"../../../test/integration/testdata/cert/server.key")
+	if err != nil {
+		t.Fatalf("Was not able to load certificate: %v", err)
+	}
+	slow := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022}
+	fast := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5022}
+	release := make(chan struct{})
+	dialer := &pipeDialer{config: &tls.Config{Certificates: []tls.Certificate{cert}},
+		block: map[string]chan struct{}{slow.String(): release}, dialed: make(chan string, 10),
+		dials: make(map[string]int)}
+	r := newResolver()
+	r.Proxy = dialer
+	const nofQueries = 5
+	entries := make(chan *muxEntry, nofQueries)
+	for i := 0; i < nofQueries; i++ {
+		go func() {
+			entry, err := r.mux(slow)
+			if err != nil {
+				t.Errorf("opening a multiplexed connection failed: %v", err)
+			}
+			entries <- entry
+		}()
+	}
+	<-dialer.dialed
+	fastEntry, err := r.mux(fast)
+	if err != nil {
+		t.Fatalf("opening a multiplexed connection failed: %v", err)
+	}
+	<-dialer.dialed
+	close(release)
+	first := <-entries
+	for i := 1; i < nofQueries; i++ {
+		if entry := <-entries; entry != first {
+			t.Error("concurrent queries to the same server use different connections")
+		}
+	}
+	if dialer.dials[slow.String()] != 1 {
+		t.Errorf("wrong number of dials expected=1 actual=%d", dialer.dials[slow.String()])
+	}
+
+	r.muxesMu.Lock()
+	fastEntry.inflight = 0
+	fastEntry.lastUsed = time.Now().Add(-muxIdleTimeout)
+	r.muxesMu.Unlock()
+	if _, err := r.mux(slow); err != nil {
+		t.Fatalf("opening a multiplexed connection failed: %v", err)
+	}
+	if fastEntry.mux.Err() == nil {
+		t.Error("idle connection has not been closed")
+	}
+	if _, ok := r.muxes[fast.String()]; ok {
+		t.Error("idle connection has not been removed")
+	}
+
+	r.Close()
+	if first.mux.Err() == nil {
+		t.Error("connection has not been closed by Close")
+	}
+	if len(r.muxes) != 0 {
+		t.Errorf("connections remain after Close: %d", len(r.muxes))
+	}
+}
+
+//addrConn is a connection with the given local and remote address.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr  { return c.local }
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+
+//failingDialer counts the dials and fails them.
+type failingDialer struct {
+	dials int32
+}
+
+func (d *failingDialer) Dial(network, address string) (net.Conn, error) {
+	atomic.AddInt32(&d.dials, 1)
+	return nil, errors.New("dial not expected")
+}
+
+func TestCreateConnAndWriteCached(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022}
+	client, server := net.Pipe()
+	defer server.Close()
+	dialer := &failingDialer{}
+	resolver := newResolver()
+	resolver.Connections = cache.NewConnection(10, 1)
+	resolver.Proxy = dialer
+	resolver.Connections.AddConnection(&addrConn{Conn: client, remote: addr,
+		local: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5022}})
+	received := make(chan message.Message, 1)
+	go func() {
+		msg := message.Message{}
+		if err := cbor.NewReader(server).Unmarshal(&msg); err == nil {
+			received <- msg
+		}
+	}()
+	tok := token.New()
+	resolver.createConnAndWrite(addr, notificationMsg(tok, section.NTNoAssertionAvail, ""))
+	select {
+	case msg := <-received:
+		if msg.Token != tok {
+			t.Errorf("wrong message received over the cached connection: %v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received over the cached connection")
+	}
+	if dials := atomic.LoadInt32(&dialer.dials); dials != 0 {
+		t.Errorf("connection opened although one is cached: %d dials", dials)
+	}
+}
+
+func TestSendCapabilities(t *testing.T) {
+	var tests = []struct {
+		caps []message.Capability
+		want []message.Capability
+	}{
+		{nil, []message.Capability{message.ChunkedZones}},
+		{[]message.Capability{message.TLSOverTCP},
+			[]message.Capability{message.TLSOverTCP, message.ChunkedZones}},
+		{[]message.Capability{message.ChunkedZones, message.TLSOverTCP},
+			[]message.Capability{message.ChunkedZones, message.TLSOverTCP}},
+	}
+	for i, test := range tests {
+		var sent []message.Capability
+		resolver := newResolver()
+		resolver.sendQuery = func(msg message.Message, addr net.Addr, timeout time.Duration) (
+			message.Message, []byte, error) {
+			sent = msg.Capabilities
+			return message.Message{Token: msg.Token}, nil, nil
+		}
+		caps := append(make([]message.Capability, 0, 4), test.caps...)
+		resolver.send(message.Message{Token: token.New(), Capabilities: caps},
+			&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5022})
+		if !reflect.DeepEqual(sent, test.want) {
+			t.Errorf("%d: wrong capabilities sent expected=%v actual=%v", i, test.want, sent)
+		}
+		if spare := caps[:len(caps)+1][len(caps)]; spare != "" {
+			t.Errorf("%d: capability written into the caller's message: %v", i, spare)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package libresolve
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//rttWeight is the inverse of the weight a new observation has in a server's smoothed round-trip
+//time. It is the value TCP uses for its smoothed RTT.
+const rttWeight = 8
+
+//rttStore keeps an exponentially weighted moving average of the round-trip time per server. It is
+//safe for concurrent use and its zero value is ready to use.
+type rttStore struct {
+	mu   sync.Mutex
+	rtts map[string]time.Duration
+}
+
+//observe updates the smoothed round-trip time of addr with rtt.
+func (s *rttStore) observe(addr net.Addr, rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rtts == nil {
+		s.rtts = make(map[string]time.Duration)
+	}
+	srtt, ok := s.rtts[addr.String()]
+	if !ok {
+		s.rtts[addr.String()] = rtt
+		return
+	}
+	s.rtts[addr.String()] = srtt + (rtt-srtt)/rttWeight
+}
+
+//get returns the smoothed round-trip time of addr and true. It returns false if no round-trip time
+//has been observed for addr.
+func (s *rttStore) get(addr net.Addr) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rtt, ok := s.rtts[addr.String()]
+	return rtt, ok
+}
+
+//order returns a copy of servers sorted by increasing smoothed round-trip time. Servers without an
+//observation come first such that they get measured. Servers with equal round-trip times keep
+//their configured order. With probability probeRate, a randomly chosen server is moved to the
+//front such that the round-trip times of the other servers are refreshed occasionally.
+func (s *rttStore) order(servers []net.Addr, probeRate float64) []net.Addr {
+	ordered := make([]net.Addr, len(servers))
+	copy(ordered, servers)
+	s.mu.Lock()
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return s.rtts[ordered[i].String()] < s.rtts[ordered[j].String()]
+	})
+	s.mu.Unlock()
+	if len(ordered) > 1 && rand.Float64() < probeRate {
+		i := 1 + rand.Intn(len(ordered)-1)
+		probe := ordered[i]
+		copy(ordered[1:i+1], ordered[:i])
+		ordered[0] = probe
+	}
+	return ordered
+}
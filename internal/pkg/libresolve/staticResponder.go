@@ -0,0 +1,69 @@
+package libresolve
+
+import (
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+//Responder answers queries directly from a zone, e.g. one loaded from a zone file, without
+//contacting an authoritative server. It is intended for tests and air-gapped deployments.
+type Responder struct {
+	zone *section.Zone
+}
+
+//NewStaticResponder returns a responder answering queries from zone.
+func NewStaticResponder(zone *section.Zone) *Responder {
+	return &Responder{zone: zone}
+}
+
+//Handle returns the answer to q. If the zone contains assertions answering q, they are returned.
+//Otherwise, the answer contains a shard covering q's name which proves that no such assertion
+//exists. The shard is synthesized from the zone and thus not signed. A notification is returned if
+//q's name is not in the zone. The caller is responsible for setting the answer's token.
+func (r *Responder) Handle(q *query.Name) *message.Message {
+	subjectName, ok := section.EnclosingZone(q.Name, r.zone.SubjectZone)
+	if !ok || !section.EqualContexts(q.Context, r.zone.Context) {
+		return &message.Message{Content: []section.Section{&section.Notification{
+			Type: section.NTNoAssertionAvail,
+			Data: "query is not about the zone of this responder",
+		}}}
+	}
+	answer := &message.Message{}
+	for _, a := range r.zone.Content {
+		a = a.Copy(r.zone.Context, r.zone.SubjectZone)
+		if section.AssertionAnswers(a, q) {
+			answer.Content = append(answer.Content, a)
+		}
+	}
+	if len(answer.Content) == 0 {
+		answer.Content = []section.Section{r.coveringShard(subjectName)}
+	}
+	return answer
+}
+
+//coveringShard returns a shard whose range is delimited by the closest names of the zone around
+//subjectName. It contains all the zone's assertions about subjectName and, as every shard, all
+//apex assertions.
+func (r *Responder) coveringShard(subjectName string) *section.Shard {
+	shard := &section.Shard{
+		SubjectZone: r.zone.SubjectZone,
+		Context:     r.zone.Context,
+		Content:     []*section.Assertion{},
+	}
+	if section.IsApex(subjectName) {
+		subjectName = ""
+	}
+	for _, a := range r.zone.Content {
+		switch name := a.SubjectName; {
+		case section.IsApex(name), name == subjectName:
+			shard.Content = append(shard.Content, a.Copy("", ""))
+		case name < subjectName && name > shard.RangeFrom:
+			shard.RangeFrom = name
+		case name > subjectName && (shard.RangeTo == "" || name < shard.RangeTo):
+			shard.RangeTo = name
+		}
+	}
+	shard.Sort()
+	return shard
+}
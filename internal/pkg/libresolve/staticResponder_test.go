@@ -0,0 +1,74 @@
+package libresolve
+
+import (
+	"net"
+	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+)
+
+func TestStaticResponder(t *testing.T) {
+	ip := object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1").To4()}
+	zone := &section.Zone{
+		SubjectZone: "ethz.ch.",
+		Context:     ".",
+		Content: []*section.Assertion{
+			&section.Assertion{SubjectName: "@", Content: []object.Object{ip}},
+			&section.Assertion{SubjectName: "a", Content: []object.Object{ip}},
+			&section.Assertion{SubjectName: "m", Content: []object.Object{ip}},
+			&section.Assertion{SubjectName: "z", Content: []object.Object{ip}},
+		},
+	}
+	responder := NewStaticResponder(zone)
+	var tests = []struct {
+		name      string
+		qType     object.Type
+		positive  bool
+		rangeFrom string
+		rangeTo   string
+		nofAssert int
+	}{
+		{"m.ethz.ch.", object.OTIP4Addr, true, "", "", 1},
+		{"b.ethz.ch.", object.OTIP4Addr, false, "a", "m", 1}, //non-existent name
+		{"0.ethz.ch.", object.OTIP4Addr, false, "", "a", 1},  //before the first name
+		{"zz.ethz.ch.", object.OTIP4Addr, false, "z", "", 1}, //after the last name
+		{"m.ethz.ch.", object.OTIP6Addr, false, "a", "z", 2}, //type mismatch
+		{"ethz.ch.", object.OTIP4Addr, true, "", "", 1},      //apex
+		{"ethz.ch.", object.OTIP6Addr, false, "", "a", 1},    //apex type mismatch
+	}
+	for i, test := range tests {
+		q := &query.Name{Name: test.name, Context: ".", Types: []object.Type{test.qType}}
+		answer := responder.Handle(q)
+		if len(answer.Content) != 1 {
+			t.Fatalf("%d: wrong number of sections in answer %v", i, answer.Content)
+		}
+		if test.positive {
+			a, ok := answer.Content[0].(*section.Assertion)
+			if !ok || a.FQDN() != test.name || a.Context != "." {
+				t.Errorf("%d: wrong positive answer %v", i, answer.Content[0])
+			}
+			continue
+		}
+		s, ok := answer.Content[0].(*section.Shard)
+		if !ok {
+			t.Fatalf("%d: negative answer is not a shard %v", i, answer.Content[0])
+		}
+		if s.RangeFrom != test.rangeFrom || s.RangeTo != test.rangeTo || len(s.Content) != test.nofAssert {
+			t.Errorf("%d: wrong covering shard expected=[%s,%s] with %d assertions actual=%v", i,
+				test.rangeFrom, test.rangeTo, test.nofAssert, s)
+		}
+		if subject, _ := section.EnclosingZone(test.name, "ethz.ch."); !s.InRange(subject) {
+			t.Errorf("%d: shard does not cover %s: %v", i, subject, s)
+		}
+		if !section.IsApex(s.Content[0].SubjectName) {
+			t.Errorf("%d: covering shard does not contain the apex assertion: %v", i, s)
+		}
+	}
+	answer := responder.Handle(&query.Name{Name: "www.ethz.com.", Context: ".",
+		Types: []object.Type{object.OTIP4Addr}})
+	if n, ok := answer.Content[0].(*section.Notification); !ok || n.Type != section.NTNoAssertionAvail {
+		t.Errorf("query outside of the zone must be answered with a notification %v", answer.Content)
+	}
+}
@@ -0,0 +1,86 @@
+package libresolve
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//ResolverStats is a snapshot of the cumulative counters of a resolver.
+type ResolverStats struct {
+	//Lookups is the number of lookups started through Lookup, which also serves ClientLookup,
+	//LookupRaw and each name tried by LookupWithSearch, or through ServerLookup.
+	Lookups uint64
+	//FailedLookups is the number of lookups which returned an error.
+	FailedLookups uint64
+	//CacheHits is the number of queries answered from the resolver's cache. Queries the resolver
+	//issues itself, e.g. for the delegation of a zone, are included.
+	CacheHits uint64
+	//CacheMisses is the number of queries which could not be answered from the resolver's cache.
+	CacheMisses uint64
+	//Queries is the number of queries sent to servers.
+	Queries uint64
+	//QueryErrors is the number of queries sent to servers which have not been answered.
+	QueryErrors uint64
+	//ServerErrors is the number of answers containing a notification upon which the lookup is
+	//continued at another server.
+	ServerErrors uint64
+	//ConnErrors is the number of failures to open, write to or read from a connection.
+	ConnErrors uint64
+	//ActiveConnections is the number of connections to servers the resolver currently holds open.
+	ActiveConnections int
+}
+
+//statsCounters holds the cumulative counters of a resolver. A counter is incremented atomically
+//while mu is held for reading such that snapshot, which holds mu for writing, observes all
+//counters at the same point in time. Its zero value is ready to use.
+type statsCounters struct {
+	mu            sync.RWMutex
+	lookups       atomic.Uint64
+	failedLookups atomic.Uint64
+	cacheHits     atomic.Uint64
+	cacheMisses   atomic.Uint64
+	queries       atomic.Uint64
+	queryErrors   atomic.Uint64
+	serverErrors  atomic.Uint64
+	connErrors    atomic.Uint64
+}
+
+//inc increments the counter c of s by one.
+func (s *statsCounters) inc(c *atomic.Uint64) {
+	s.mu.RLock()
+	c.Add(1)
+	s.mu.RUnlock()
+}
+
+//snapshot returns the current value of all counters of s.
+func (s *statsCounters) snapshot() ResolverStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ResolverStats{
+		Lookups:       s.lookups.Load(),
+		FailedLookups: s.failedLookups.Load(),
+		CacheHits:     s.cacheHits.Load(),
+		CacheMisses:   s.cacheMisses.Load(),
+		Queries:       s.queries.Load(),
+		QueryErrors:   s.queryErrors.Load(),
+		ServerErrors:  s.serverErrors.Load(),
+		ConnErrors:    s.connErrors.Load(),
+	}
+}
+
+//Stats returns a snapshot of the resolver's cumulative counters and of the number of connections
+//it currently holds open. It is safe to call concurrently to lookups.
+func (r *Resolver) Stats() ResolverStats {
+	stats := r.stats.snapshot()
+	if r.Connections != nil {
+		stats.ActiveConnections = r.Connections.Len()
+	}
+	r.muxesMu.Lock()
+	defer r.muxesMu.Unlock()
+	for _, entry := range r.muxes {
+		if entry.mux != nil && entry.usable() {
+			stats.ActiveConnections++
+		}
+	}
+	return stats
+}
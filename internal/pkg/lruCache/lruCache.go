@@ -61,10 +61,11 @@ func (c *Cache) GetOrAdd(key string, value interface{}, isInternal bool) (interf
 }
 
 //Get returns if the key is present the value associated with it from the map and true. Otherwise
-//the value type's zero value and false is returned
+//the value type's zero value and false is returned. It holds the write lock as it updates the
+//recentness of the element.
 func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mux.RLock()
-	defer c.mux.RUnlock()
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	v, ok := c.hashMap[key]
 	if ok {
 		e := v.Value.(*entry)
@@ -1,11 +1,17 @@
 package message
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"time"
 
 	cbor "github.com/britram/borat"
 
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
@@ -14,6 +20,8 @@ import (
 
 const (
 	rainsTag = 0xE99BA8
+	//TruncatedData is the data of the notification which is added to a truncated message.
+	TruncatedData = "answer truncated"
 )
 
 //Message represents a Message
@@ -27,6 +35,8 @@ type Message struct {
 	Content []section.Section
 	//Signatures authenticate the content of this message. An encoding of Message is signed by the infrastructure key of the originating server.
 	Signatures []signature.Sig
+	//encodings holds the encodings of sections as they have been received.
+	encodings map[section.Section][]byte
 }
 
 func (rm *Message) UnmarshalCBOR(r *cbor.CBORReader) error {
@@ -80,8 +90,8 @@ func (rm *Message) UnmarshalCBOR(r *cbor.CBORReader) error {
 	}
 	for _, elem := range content {
 		elem, ok := elem.([]interface{})
-		if !ok {
-			return errors.New("cbor msg encoding of a content array's entry should be an array")
+		if !ok || len(elem) != 2 {
+			return errors.New("cbor msg encoding of a content array's entry should be an array of length 2")
 		}
 		t, ok := elem[0].(int)
 		if !ok {
@@ -122,6 +132,12 @@ func (rm *Message) UnmarshalCBOR(r *cbor.CBORReader) error {
 				return err
 			}
 			rm.Content = append(rm.Content, q)
+		case 7:
+			c := &section.ZoneChunk{}
+			if err := c.UnmarshalMap(val); err != nil {
+				return err
+			}
+			rm.Content = append(rm.Content, c)
 		case 23:
 			n := &section.Notification{}
 			if err := n.UnmarshalMap(val); err != nil {
@@ -135,6 +151,11 @@ func (rm *Message) UnmarshalCBOR(r *cbor.CBORReader) error {
 
 // MarshalCBOR writes the RAINS message to the provided writer.
 // Implements the CBORMarshaler interface.
+//
+//Empty optional collections, such as signatures and capabilities, are omitted in the encoding of a
+//message and of all its sections unless older decoders require them. Query options and the
+//signatures of zone chunks and zone deltas are thus encoded as empty arrays. In both cases, a nil
+//and an empty collection have the same encoding and yield the same signature.
 func (rm *Message) MarshalCBOR(w *cbor.CBORWriter) error {
 	if err := w.WriteTag(cbor.CBORTag(rainsTag)); err != nil {
 		return err
@@ -150,33 +171,431 @@ func (rm *Message) MarshalCBOR(w *cbor.CBORWriter) error {
 		for i, cap := range rm.Capabilities {
 			caps[i] = string(cap)
 		}
+		//Capabilities form a set. Sorting them makes the encoding independent of their order.
+		sort.Strings(caps)
 		m[1] = caps
 	}
 	m[2] = rm.Token[:]
 
 	msgsect := make([][2]interface{}, 0)
 	for _, sect := range rm.Content {
-		switch sect.(type) {
-		case *section.Assertion:
-			msgsect = append(msgsect, [2]interface{}{1, sect})
-		case *section.Shard:
-			msgsect = append(msgsect, [2]interface{}{2, sect})
-		case *section.Pshard:
-			msgsect = append(msgsect, [2]interface{}{3, sect})
-		case *section.Zone:
-			msgsect = append(msgsect, [2]interface{}{4, sect})
-		case *query.Name:
-			msgsect = append(msgsect, [2]interface{}{5, sect})
-		case *section.Notification:
-			msgsect = append(msgsect, [2]interface{}{23, sect})
-		default:
-			return fmt.Errorf("unknown section type: %T", sect)
+		t, err := sectionType(sect)
+		if err != nil {
+			return err
 		}
+		msgsect = append(msgsect, [2]interface{}{t, sect})
 	}
 	m[23] = msgsect
 	return w.WriteIntMap(m)
 }
 
+//sectionType returns the type by which sect is identified in the encoding of a message.
+func sectionType(sect section.Section) (int, error) {
+	if isNil(sect) {
+		return 0, fmt.Errorf("message contains a nil section of type %T", sect)
+	}
+	switch sect.(type) {
+	case *section.Assertion:
+		return 1, nil
+	case *section.Shard:
+		return 2, nil
+	case *section.Pshard:
+		return 3, nil
+	case *section.Zone:
+		return 4, nil
+	case *query.Name:
+		return 5, nil
+	case *section.ZoneChunk:
+		return 7, nil
+	case *section.Notification:
+		return 23, nil
+	default:
+		return 0, fmt.Errorf("unknown section type: %T", sect)
+	}
+}
+
+//RetainEncodings records the encoding of each section of rm as it occurs in data, the encoding rm
+//has been decoded from with UnmarshalCBOR. The recorded encodings are returned by SectionEncoding
+//and relayed by EncodeRetained. They reference data which must therefore not be modified.
+func (rm *Message) RetainEncodings(data []byte) error {
+	_, _, n, err := rcbor.ReadHead(data)
+	if err != nil {
+		return err
+	}
+	major, pairs, l, err := rcbor.ReadHead(data[n:])
+	if err != nil {
+		return err
+	}
+	if major != rcbor.MajorMap {
+		return errors.New("cbor msg encoding should be a map")
+	}
+	n += l
+	for ; pairs > 0; pairs-- {
+		_, key, l, err := rcbor.ReadHead(data[n:])
+		if err != nil {
+			return err
+		}
+		n += l
+		if key != 23 {
+			if l, err = rcbor.ItemLen(data[n:]); err != nil {
+				return err
+			}
+			n += l
+			continue
+		}
+		encodings, err := contentEncodings(data[n:])
+		if err != nil {
+			return err
+		}
+		rm.encodings = make(map[section.Section][]byte)
+		for _, sect := range rm.Content {
+			t, _ := sectionType(sect)
+			//sections of an unknown type are skipped when decoding.
+			for len(encodings) > 0 && encodings[0].t != t {
+				encodings = encodings[1:]
+			}
+			if len(encodings) == 0 {
+				return errors.New("message content does not match its encoding")
+			}
+			rm.encodings[sect] = encodings[0].data
+			encodings = encodings[1:]
+		}
+		return nil
+	}
+	return errors.New("cbor msg encoding has no content")
+}
+
+//sectionEncoding is the encoding of a section of type t in a message's content.
+type sectionEncoding struct {
+	t    int
+	data []byte
+}
+
+//contentEncodings returns the encodings of the sections in the content array data starts with.
+func contentEncodings(data []byte) ([]sectionEncoding, error) {
+	major, count, n, err := rcbor.ReadHead(data)
+	if err != nil {
+		return nil, err
+	}
+	if major != rcbor.MajorArray {
+		return nil, errors.New("cbor msg encoding of the content should be an array")
+	}
+	var encodings []sectionEncoding
+	for ; count > 0; count-- {
+		_, _, l, err := rcbor.ReadHead(data[n:])
+		if err != nil {
+			return nil, err
+		}
+		n += l
+		_, t, l, err := rcbor.ReadHead(data[n:])
+		if err != nil {
+			return nil, err
+		}
+		n += l
+		if l, err = rcbor.ItemLen(data[n:]); err != nil {
+			return nil, err
+		}
+		encodings = append(encodings, sectionEncoding{t: int(t), data: data[n : n+l]})
+		n += l
+	}
+	return encodings, nil
+}
+
+//SectionEncoding returns the encoding of sect as it has been received and true. It returns false
+//if no encoding of sect has been retained with RetainEncodings.
+func (rm *Message) SectionEncoding(sect section.Section) ([]byte, bool) {
+	data, ok := rm.encodings[sect]
+	return data, ok
+}
+
+//EncodeRetained returns the encoding of rm in which each section is encoded as it has been
+//received if its encoding has been retained with RetainEncodings. The signatures of such sections
+//thus remain valid even if the sender's encoding was not canonical and the sections have been
+//sorted since. Other sections are encoded as by MarshalCBOR.
+func (rm *Message) EncodeRetained() ([]byte, error) {
+	encoding := new(bytes.Buffer)
+	w := cbor.NewCBORWriter(encoding)
+	if len(rm.encodings) == 0 {
+		if err := rm.MarshalCBOR(w); err != nil {
+			return nil, err
+		}
+		return encoding.Bytes(), nil
+	}
+	header := Message{Capabilities: rm.Capabilities, Token: rm.Token, Signatures: rm.Signatures}
+	if err := header.MarshalCBOR(w); err != nil {
+		return nil, err
+	}
+	//The content is encoded last as 23 is the largest key of the message map. The empty content
+	//array of header is replaced by the content of rm.
+	encoding.Truncate(encoding.Len() - 1)
+	encoding.Write(rcbor.Head(rcbor.MajorArray, uint64(len(rm.Content))))
+	for _, sect := range rm.Content {
+		t, err := sectionType(sect)
+		if err != nil {
+			return nil, err
+		}
+		encoding.Write(rcbor.Head(rcbor.MajorArray, 2))
+		if err := w.WriteInt(t); err != nil {
+			return nil, err
+		}
+		if data, ok := rm.encodings[sect]; ok {
+			encoding.Write(data)
+		} else if err := w.Marshal(sect); err != nil {
+			return nil, err
+		}
+	}
+	return encoding.Bytes(), nil
+}
+
+//Canonicalize brings rm into the canonical form over which message signatures are computed. It
+//sorts the content of each section, removes the context and zone from assertions contained in
+//shards and zones, removes duplicate sections and sorts and deduplicates the capabilities. The
+//order of the sections is preserved as it expresses their priority. Nil sections are removed.
+func (rm *Message) Canonicalize() error {
+	seen := make(map[string]bool)
+	content := []section.Section{}
+	for _, sec := range rm.Content {
+		if isNil(sec) {
+			continue
+		}
+		switch s := sec.(type) {
+		case *section.Shard:
+			s.RemoveCtxAndZoneFromContent()
+		case *section.Zone:
+			s.RemoveCtxAndZoneFromContent()
+		}
+		sec.Sort()
+		encoding := new(bytes.Buffer)
+		if err := sec.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%T %s", sec, encoding.Bytes())
+		if !seen[key] {
+			seen[key] = true
+			content = append(content, sec)
+		}
+	}
+	rm.Content = content
+	sort.Slice(rm.Capabilities, func(i, j int) bool { return rm.Capabilities[i] < rm.Capabilities[j] })
+	caps := []Capability{}
+	for i, c := range rm.Capabilities {
+		if i == 0 || c != rm.Capabilities[i-1] {
+			caps = append(caps, c)
+		}
+	}
+	if len(rm.Capabilities) > 0 {
+		rm.Capabilities = caps
+	}
+	return nil
+}
+
+//WithNewToken returns a copy of rm with a freshly generated token such that a query can be re-sent
+//without reusing the token of a previous attempt. The sections are shared with rm but the slices
+//holding them are copied. Signatures are not copied as they do not cover the new token.
+func (rm *Message) WithNewToken() Message {
+	return Message{
+		Token:        token.New(),
+		Capabilities: append([]Capability(nil), rm.Capabilities...),
+		Content:      append([]section.Section(nil), rm.Content...),
+	}
+}
+
+//Truncate removes sections from the end of rm's content until the encoding of rm is at most
+//maxBytes long. Sections at the beginning of the content have the highest priority. If rm is
+//truncated, a notification of type NTMsgTooLarge is appended such that the receiver can retry over
+//a transport without a size restriction. It returns true if rm was truncated.
+func (rm *Message) Truncate(maxBytes int) (bool, error) {
+	if size, err := rm.encodedSize(); err != nil || size <= maxBytes {
+		return false, err
+	}
+	notification := &section.Notification{
+		Token: rm.Token,
+		Type:  section.NTMsgTooLarge,
+		Data:  TruncatedData,
+	}
+	content := rm.Content
+	for i := len(content) - 1; i >= 0; i-- {
+		rm.Content = append(content[:i:i], notification)
+		size, err := rm.encodedSize()
+		if err != nil {
+			return false, err
+		}
+		if size <= maxBytes {
+			return true, nil
+		}
+	}
+	return true, fmt.Errorf("message does not fit into %d bytes even without content", maxBytes)
+}
+
+//IsTruncated returns true if rm contains a notification indicating that the sender truncated rm.
+func (rm *Message) IsTruncated() bool {
+	for _, sec := range rm.Content {
+		if n, ok := sec.(*section.Notification); ok && n != nil && n.Type == section.NTMsgTooLarge &&
+			n.Token == rm.Token && n.Data == TruncatedData {
+			return true
+		}
+	}
+	return false
+}
+
+//isNil returns true if sec is nil or a nil pointer to a section. Content assembled from untrusted
+//input might contain such entries, which must not be dereferenced.
+func isNil(sec section.Section) bool {
+	if sec == nil {
+		return true
+	}
+	v := reflect.ValueOf(sec)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+//Chunk splits rm into several messages if rm consists of a single zone and its encoding is longer
+//than maxBytes. Each message has rm's token and capabilities and contains a chunk of the zone such
+//that its encoding is at most maxBytes long. Chunk boundaries fall between the zone's assertions.
+//If rm does not have to be split, it is returned as the only message. An error is returned if an
+//assertion of the zone does not fit into a chunk.
+func (rm *Message) Chunk(maxBytes int) ([]Message, error) {
+	if len(rm.Content) != 1 {
+		return []Message{*rm}, nil
+	}
+	zone, ok := rm.Content[0].(*section.Zone)
+	if !ok {
+		return []Message{*rm}, nil
+	}
+	size, err := rm.encodedSize()
+	if err != nil {
+		return nil, err
+	}
+	if size <= maxBytes {
+		return []Message{*rm}, nil
+	}
+	//overhead is an upper bound on the encoding of a message containing an empty chunk.
+	empty := Message{Token: rm.Token, Capabilities: rm.Capabilities, Content: []section.Section{
+		&section.ZoneChunk{
+			Signatures:  zone.Signatures,
+			SubjectZone: zone.SubjectZone,
+			Context:     zone.Context,
+			Seq:         section.MaxZoneChunks,
+			Total:       section.MaxZoneChunks,
+		}}}
+	overhead, err := empty.encodedSize()
+	if err != nil {
+		return nil, err
+	}
+	overhead += 8 //the content array's length might be encoded in up to 8 additional bytes
+	sizes := []int{0}
+	chunkSize := overhead
+	for _, a := range zone.Content {
+		encoding := new(bytes.Buffer)
+		if err := a.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+			return nil, err
+		}
+		if overhead+encoding.Len() > maxBytes {
+			return nil, fmt.Errorf("assertion does not fit into a zone chunk of %d bytes: %s",
+				maxBytes, a)
+		}
+		if chunkSize+encoding.Len() > maxBytes {
+			sizes = append(sizes, 0)
+			chunkSize = overhead
+		}
+		sizes[len(sizes)-1]++
+		chunkSize += encoding.Len()
+	}
+	if len(sizes) > section.MaxZoneChunks {
+		return nil, fmt.Errorf("zone must not be split into more than %d chunks",
+			section.MaxZoneChunks)
+	}
+	chunks := section.SplitZone(zone, sizes)
+	msgs := make([]Message, len(chunks))
+	for i, c := range chunks {
+		msgs[i] = Message{Token: rm.Token, Capabilities: rm.Capabilities,
+			Content: []section.Section{c}}
+	}
+	return msgs, nil
+}
+
+//ValidityReport summarizes the validity windows of the signed sections of a message. The window of
+//a section spans from the earliest validSince to the latest validUntil of its signatures.
+type ValidityReport struct {
+	//Sections is the number of signed sections, including signed assertions of shards and zones.
+	Sections      int
+	MinValidSince int64
+	MaxValidSince int64
+	MinValidUntil int64
+	MaxValidUntil int64
+	//Anomalous contains the sections which are not valid at any time between now and the end of
+	//the sanity band, i.e. which are expired or only become valid far in the future.
+	Anomalous []section.WithSig
+}
+
+//ValidityReport returns a summary of the validity windows of rm's signed sections. A section is
+//flagged if its window does not overlap the sanity band starting now and lasting band. A server
+//can use the report to reject or log a suspicious message before verifying its signatures.
+func (rm *Message) ValidityReport(band time.Duration) ValidityReport {
+	report := ValidityReport{}
+	now := time.Now()
+	bandStart, bandEnd := now.Unix(), now.Add(band).Unix()
+	add := func(s section.WithSig) {
+		sigs := s.Sigs(keys.RainsKeySpace)
+		if len(sigs) == 0 {
+			return
+		}
+		since, until := sigs[0].ValidSince, sigs[0].ValidUntil
+		for _, sig := range sigs[1:] {
+			if sig.ValidSince < since {
+				since = sig.ValidSince
+			}
+			if sig.ValidUntil > until {
+				until = sig.ValidUntil
+			}
+		}
+		if report.Sections == 0 || since < report.MinValidSince {
+			report.MinValidSince = since
+		}
+		if report.Sections == 0 || since > report.MaxValidSince {
+			report.MaxValidSince = since
+		}
+		if report.Sections == 0 || until < report.MinValidUntil {
+			report.MinValidUntil = until
+		}
+		if report.Sections == 0 || until > report.MaxValidUntil {
+			report.MaxValidUntil = until
+		}
+		report.Sections++
+		if until < bandStart || since > bandEnd {
+			report.Anomalous = append(report.Anomalous, s)
+		}
+	}
+	for _, sec := range rm.Content {
+		if isNil(sec) {
+			continue
+		}
+		s, ok := sec.(section.WithSig)
+		if !ok {
+			continue
+		}
+		add(s)
+		switch s := s.(type) {
+		case *section.Shard:
+			for _, a := range s.Content {
+				add(a)
+			}
+		case *section.Zone:
+			for _, a := range s.Content {
+				add(a)
+			}
+		}
+	}
+	return report
+}
+
+func (rm *Message) encodedSize() (int, error) {
+	encoding := new(bytes.Buffer)
+	if err := rm.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+		return 0, err
+	}
+	return encoding.Len(), nil
+}
+
 //Capability is a urn of a capability
 type Capability string
 
@@ -185,4 +604,7 @@ const (
 	NoCapability Capability = "urn:x-rains:nocapability"
 	//TLSOverTCP is used when the server listens for tls over tcp connections
 	TLSOverTCP Capability = "urn:x-rains:tlssrv"
+	//ChunkedZones is used when the sender is able to reassemble a zone which is split over
+	//several messages.
+	ChunkedZones Capability = "urn:x-rains:chunkedzones"
 )
@@ -2,12 +2,21 @@ package message
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
+	"time"
 
 	cbor2 "github.com/britram/borat"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
 	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
 )
 
 func TestCBOR(t *testing.T) {
@@ -36,6 +45,19 @@ func TestCBORErrorCases(t *testing.T) {
 	cbor2.NewCBORWriter(encWithRainsTag).WriteTag(cbor2.CBORTag(rainsTag))
 	encWithTag := new(bytes.Buffer)
 	cbor2.NewCBORWriter(encWithTag).WriteTag(cbor2.CBORTag(rainsTag + 1))
+	oversizedToken := make([]interface{}, 20)
+	for i := range oversizedToken {
+		oversizedToken[i] = i
+	}
+	encTokenArray := new(bytes.Buffer)
+	w := cbor2.NewCBORWriter(encTokenArray)
+	w.WriteTag(cbor2.CBORTag(rainsTag))
+	w.WriteIntMap(map[int]interface{}{2: oversizedToken, 23: []interface{}{}})
+	encTokenBytes := new(bytes.Buffer)
+	w = cbor2.NewCBORWriter(encTokenBytes)
+	w.WriteTag(cbor2.CBORTag(rainsTag))
+	w.WriteIntMap(map[int]interface{}{2: make([]byte, 20), 23: []interface{}{}})
+	tokenErr := "cbor message encoding of the token should be a byte array of length 16"
 	var tests = []struct {
 		encoding []byte
 		errMsg   string
@@ -43,6 +65,8 @@ func TestCBORErrorCases(t *testing.T) {
 		{[]byte("Just some nonsense data"), "failed to read tag: invalid CBOR type for typed read"},
 		{encWithTag.Bytes(), "expected tag for RAINS message but got: 15309737"},
 		{append(encWithRainsTag.Bytes(), []byte("Just some nonsense data")...), "failed to read map: invalid CBOR type for typed read"},
+		{encTokenArray.Bytes(), tokenErr},
+		{encTokenBytes.Bytes(), tokenErr},
 	}
 	for i, test := range tests {
 		encoding := bytes.NewBuffer(test.encoding)
@@ -55,6 +79,27 @@ func TestCBORErrorCases(t *testing.T) {
 	}
 }
 
+func TestTruncate(t *testing.T) {
+	msg := GetMessage()
+	size, _ := msg.encodedSize()
+	if truncated, err := msg.Truncate(size); truncated || err != nil || msg.IsTruncated() {
+		t.Fatalf("message fitting into maxBytes must not be truncated, err=%v", err)
+	}
+	nofSections := len(msg.Content)
+	if truncated, err := msg.Truncate(size - 1); !truncated || err != nil {
+		t.Fatalf("oversized message was not truncated, err=%v", err)
+	}
+	if !msg.IsTruncated() || len(msg.Content) > nofSections {
+		t.Fatalf("truncated message has unexpected content %v", msg.Content)
+	}
+	if newSize, _ := msg.encodedSize(); newSize > size-1 {
+		t.Fatalf("truncated message is too large. maxBytes=%d actual=%d", size-1, newSize)
+	}
+	if _, err := msg.Truncate(1); err == nil {
+		t.Fatal("message cannot fit into a single byte")
+	}
+}
+
 func CheckMessage(m1, m2 Message, t *testing.T) {
 	if m1.Token != m2.Token {
 		t.Error("Token mismatch")
@@ -133,3 +178,337 @@ func CheckMessage(m1, m2 Message, t *testing.T) {
 		}
 	}
 }
+
+func TestCanonicalize(t *testing.T) {
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.ServiceObject(), object.NameObject()}}
+	shard := &section.Shard{SubjectZone: "ch.", Context: ".", Content: []*section.Assertion{
+		&section.Assertion{SubjectName: "b", SubjectZone: "ch.", Context: "."},
+		&section.Assertion{SubjectName: "a", SubjectZone: "ch.", Context: "."}}}
+	msg := Message{
+		Capabilities: []Capability{TLSOverTCP, NoCapability, TLSOverTCP},
+		Content:      []section.Section{shard, a, shard.Copy(".", "ch."), a},
+	}
+	if err := msg.Canonicalize(); err != nil {
+		t.Fatalf("Was not able to canonicalize message: %v", err)
+	}
+	if len(msg.Content) != 2 || msg.Content[0] != shard || msg.Content[1] != a {
+		t.Fatalf("duplicates were not removed or order was not preserved: %v", msg.Content)
+	}
+	if a.Content[0].Type != object.OTName || shard.Content[0].SubjectName != "a" {
+		t.Errorf("section content was not sorted: %v", msg.Content)
+	}
+	if shard.Content[0].SubjectZone != "" || shard.Content[0].Context != "" {
+		t.Errorf("context and zone were not removed from shard content: %v", shard)
+	}
+	if len(msg.Capabilities) != 2 || msg.Capabilities[0] > msg.Capabilities[1] {
+		t.Errorf("capabilities were not sorted and deduplicated: %v", msg.Capabilities)
+	}
+}
+
+func TestCanonicalizeNotifications(t *testing.T) {
+	tok := token.New()
+	n := &section.Notification{Token: tok, Type: section.NTNoAssertionsExist, Data: "no entry"}
+	other := &section.Notification{Token: tok, Type: section.NTHeartbeat}
+	msg := Message{Content: []section.Section{n, other, &section.Notification{Token: tok,
+		Type: section.NTNoAssertionsExist, Data: "no entry"}, other}}
+	if err := msg.Canonicalize(); err != nil {
+		t.Fatalf("Was not able to canonicalize message: %v", err)
+	}
+	if len(msg.Content) != 2 || msg.Content[0] != n || msg.Content[1] != other {
+		t.Errorf("identical notifications were not canonicalized to one in a stable order: %v",
+			msg.Content)
+	}
+}
+
+func TestRetainEncodings(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	//the content is not sorted such that the signed encoding of the assertion is not canonical.
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.ServiceObject(), object.NameObject()}}
+	sig := section.Signature()
+	a.DontAddSigInMarshaller()
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(a); err != nil {
+		t.Fatalf("Was not able to marshal assertion: %v", err)
+	}
+	if err := sig.SignData(priv, encoding.Bytes()); err != nil {
+		t.Fatalf("Was not able to sign assertion: %v", err)
+	}
+	a.AddSigInMarshaller()
+	a.AddSig(sig)
+	verifies := func(a *section.Assertion) bool {
+		a.DontAddSigInMarshaller()
+		defer a.AddSigInMarshaller()
+		encoding := new(bytes.Buffer)
+		cbor.NewWriter(encoding).Marshal(a)
+		return len(a.Signatures) == 1 && a.Signatures[0].VerifySignature(pub, encoding.Bytes())
+	}
+	msg := Message{Token: token.New(), Content: []section.Section{section.GetQuery(), a}}
+	encoding.Reset()
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		t.Fatalf("Was not able to marshal message: %v", err)
+	}
+	data := encoding.Bytes()
+	received := Message{}
+	if err := cbor.NewReader(bytes.NewReader(data)).Unmarshal(&received); err != nil {
+		t.Fatalf("Was not able to unmarshal message: %v", err)
+	}
+	if err := received.RetainEncodings(data); err != nil {
+		t.Fatalf("Was not able to retain section encodings: %v", err)
+	}
+	encoding = new(bytes.Buffer)
+	cbor.NewWriter(encoding).Marshal(a)
+	if raw, ok := received.SectionEncoding(received.Content[1]); !ok ||
+		!bytes.Equal(raw, encoding.Bytes()) {
+		t.Fatalf("wrong retained encoding expected=%x actual=%x", encoding.Bytes(), raw)
+	}
+	//a forwarding server sorts the received sections.
+	if err := received.Canonicalize(); err != nil {
+		t.Fatalf("Was not able to canonicalize message: %v", err)
+	}
+	if verifies(received.Content[1].(*section.Assertion)) {
+		t.Fatal("signature must not verify over the re-encoded assertion")
+	}
+	received.Token = token.New()
+	forwarded, err := received.EncodeRetained()
+	if err != nil {
+		t.Fatalf("Was not able to encode message: %v", err)
+	}
+	msg = Message{}
+	if err := cbor.NewReader(bytes.NewReader(forwarded)).Unmarshal(&msg); err != nil {
+		t.Fatalf("Was not able to unmarshal forwarded message: %v", err)
+	}
+	if msg.Token != received.Token || len(msg.Content) != 2 {
+		t.Fatalf("forwarded message is corrupted: %v", msg)
+	}
+	if !verifies(msg.Content[1].(*section.Assertion)) {
+		t.Error("signature of the forwarded assertion does not verify")
+	}
+}
+
+func TestWithNewToken(t *testing.T) {
+	msg := GetMessage()
+	clone := msg.WithNewToken()
+	if clone.Token == msg.Token {
+		t.Errorf("clone must have a fresh token actual=%v", clone.Token)
+	}
+	if !reflect.DeepEqual(clone.Content, msg.Content) ||
+		!reflect.DeepEqual(clone.Capabilities, msg.Capabilities) || clone.Signatures != nil {
+		t.Errorf("clone has different fields expected=%v actual=%v", msg, clone)
+	}
+	clone.Content[0] = nil
+	if msg.Content[0] == nil {
+		t.Error("modifying the clone's content must not modify the original")
+	}
+	if next := clone.WithNewToken(); next.Token == clone.Token {
+		t.Error("each clone must have its own token")
+	}
+}
+
+func TestValidityReport(t *testing.T) {
+	now := time.Now()
+	signed := func(name string, since, until time.Time) *section.Assertion {
+		return &section.Assertion{SubjectName: name, Content: []object.Object{object.NameObject()},
+			Signatures: []signature.Sig{signature.Sig{
+				PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519},
+				ValidSince:  since.Unix(),
+				ValidUntil:  until.Unix(),
+			}}}
+	}
+	farFuture := signed("d", now.Add(365*24*time.Hour), now.Add(366*24*time.Hour))
+	zone := &section.Zone{SubjectZone: "ch.", Context: ".", Content: []*section.Assertion{
+		signed("a", now.Add(-time.Hour), now.Add(time.Hour)),
+		signed("b", now.Add(-2*time.Hour), now.Add(2*time.Hour)),
+		farFuture,
+		&section.Assertion{SubjectName: "e", Content: []object.Object{object.NameObject()}},
+	}}
+	zone.Signatures = []signature.Sig{signature.Sig{
+		PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519},
+		ValidSince:  now.Add(-time.Hour).Unix(),
+		ValidUntil:  now.Add(24 * time.Hour).Unix(),
+	}}
+	msg := Message{Token: token.New(), Content: []section.Section{zone, section.GetQuery()}}
+	report := msg.ValidityReport(7 * 24 * time.Hour)
+	if report.Sections != 4 {
+		t.Errorf("wrong number of signed sections expected=4 actual=%d", report.Sections)
+	}
+	if report.MinValidSince != now.Add(-2*time.Hour).Unix() ||
+		report.MaxValidSince != farFuture.Signatures[0].ValidSince ||
+		report.MinValidUntil != now.Add(time.Hour).Unix() ||
+		report.MaxValidUntil != farFuture.Signatures[0].ValidUntil {
+		t.Errorf("wrong validity summary actual=%+v", report)
+	}
+	if len(report.Anomalous) != 1 || report.Anomalous[0] != farFuture {
+		t.Errorf("only the far future assertion must be flagged actual=%v", report.Anomalous)
+	}
+	//with a band covering the far future assertion, nothing is flagged.
+	if report := msg.ValidityReport(400 * 24 * time.Hour); len(report.Anomalous) != 0 {
+		t.Errorf("no section must be flagged actual=%v", report.Anomalous)
+	}
+}
+
+func TestEmptyOptionalFields(t *testing.T) {
+	tok := token.New()
+	newQuery := func(opts []query.Option) section.Section {
+		return &query.Name{Context: ".", Name: "example.", Types: []object.Type{object.OTIP4Addr},
+			Expiration: 100, Options: opts}
+	}
+	var tests = []struct {
+		nilSec   section.Section
+		emptySec section.Section
+	}{
+		{newQuery(nil), newQuery([]query.Option{})},
+		{&section.ZoneChunk{SubjectZone: "ch.", Context: ".", Seq: 0, Total: 1},
+			&section.ZoneChunk{SubjectZone: "ch.", Context: ".", Seq: 0, Total: 1,
+				Signatures: []signature.Sig{}}},
+		{&section.Assertion{SubjectName: "a", SubjectZone: "ch.", Context: "."},
+			&section.Assertion{SubjectName: "a", SubjectZone: "ch.", Context: ".",
+				Signatures: []signature.Sig{}}},
+	}
+	for i, test := range tests {
+		nilMsg := Message{Token: tok, Content: []section.Section{test.nilSec}}
+		emptyMsg := Message{Token: tok, Content: []section.Section{test.emptySec},
+			Capabilities: []Capability{}, Signatures: []signature.Sig{}}
+		nilEncoding, emptyEncoding := new(bytes.Buffer), new(bytes.Buffer)
+		if err := nilMsg.MarshalCBOR(cbor2.NewCBORWriter(nilEncoding)); err != nil {
+			t.Fatalf("%d: failed to encode message: %v", i, err)
+		}
+		if err := emptyMsg.MarshalCBOR(cbor2.NewCBORWriter(emptyEncoding)); err != nil {
+			t.Fatalf("%d: failed to encode message: %v", i, err)
+		}
+		if !bytes.Equal(nilEncoding.Bytes(), emptyEncoding.Bytes()) {
+			t.Errorf("%d: empty and absent %T fields are encoded differently nil=%x empty=%x", i,
+				test.nilSec, nilEncoding.Bytes(), emptyEncoding.Bytes())
+		}
+		decoded := Message{}
+		if err := decoded.UnmarshalCBOR(cbor2.NewCBORReader(nilEncoding)); err != nil {
+			t.Errorf("%d: message with omitted %T fields cannot be decoded: %v", i, test.nilSec, err)
+		}
+	}
+}
+
+func TestCapabilityOrder(t *testing.T) {
+	tok := token.New()
+	m1 := Message{Token: tok, Capabilities: []Capability{TLSOverTCP, NoCapability, ChunkedZones}}
+	m2 := Message{Token: tok, Capabilities: []Capability{ChunkedZones, TLSOverTCP, NoCapability}}
+	enc1, enc2 := new(bytes.Buffer), new(bytes.Buffer)
+	if err := m1.MarshalCBOR(cbor2.NewCBORWriter(enc1)); err != nil {
+		t.Fatalf("Was not able to marshal message: %v", err)
+	}
+	if err := m2.MarshalCBOR(cbor2.NewCBORWriter(enc2)); err != nil {
+		t.Fatalf("Was not able to marshal message: %v", err)
+	}
+	if !bytes.Equal(enc1.Bytes(), enc2.Bytes()) {
+		t.Errorf("permuted capabilities have different encodings: %x != %x", enc1.Bytes(), enc2.Bytes())
+	}
+	if m1.Capabilities[0] != TLSOverTCP || m2.Capabilities[0] != ChunkedZones {
+		t.Errorf("marshaling must not reorder the capabilities of the message")
+	}
+}
+
+func TestNilSections(t *testing.T) {
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: "."}
+	var tests = []struct {
+		input section.Section
+	}{
+		{nil},
+		{(*section.Assertion)(nil)},
+		{(*section.Shard)(nil)},
+		{(*section.Zone)(nil)},
+		{(*query.Name)(nil)},
+		{(*section.Notification)(nil)},
+	}
+	for i, test := range tests {
+		msg := Message{Token: token.New(), Content: []section.Section{a, test.input}}
+		if err := msg.MarshalCBOR(cbor2.NewCBORWriter(new(bytes.Buffer))); err == nil {
+			t.Errorf("%d: marshaling a nil section must return an error", i)
+		}
+		if msg.IsTruncated() {
+			t.Errorf("%d: message with a nil section is not truncated", i)
+		}
+		if err := msg.Canonicalize(); err != nil || len(msg.Content) != 1 || msg.Content[0] != a {
+			t.Errorf("%d: nil section was not removed err=%v content=%v", i, err, msg.Content)
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	zone := &section.Zone{SubjectZone: "ch.", Context: ".", Signatures: []signature.Sig{
+		signature.Sig{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519}, Data: []byte("sig")}}}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		zone.Content = append(zone.Content, &section.Assertion{SubjectName: name,
+			Content: []object.Object{object.NameObject()}})
+	}
+	msg := Message{Token: token.New(), Content: []section.Section{zone}}
+	size, _ := msg.encodedSize()
+	if msgs, err := msg.Chunk(size); err != nil || len(msgs) != 1 || msgs[0].Content[0] != zone {
+		t.Fatalf("message fitting into maxBytes must not be chunked, err=%v", err)
+	}
+	if _, err := msg.Chunk(10); err == nil {
+		t.Fatal("chunk size smaller than an assertion must return an error")
+	}
+	msgs, err := msg.Chunk(size / 2)
+	if err != nil || len(msgs) < 2 {
+		t.Fatalf("oversized zone was not split msgs=%v err=%v", msgs, err)
+	}
+	chunks := []*section.ZoneChunk{}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(&msgs[i]); err != nil {
+			t.Fatalf("%d: Was not able to marshal chunk, err=%v", i, err)
+		}
+		if encoding.Len() > size/2 {
+			t.Errorf("%d: chunk exceeds maximal size expected<=%d actual=%d", i, size/2, encoding.Len())
+		}
+		decoded := Message{}
+		if err := cbor.NewReader(encoding).Unmarshal(&decoded); err != nil {
+			t.Fatalf("%d: Was not able to unmarshal chunk, err=%v", i, err)
+		}
+		if decoded.Token != msg.Token {
+			t.Errorf("%d: chunk has a wrong token", i)
+		}
+		chunks = append(chunks, decoded.Content[0].(*section.ZoneChunk))
+	}
+	reassembled, err := section.AssembleZone(chunks)
+	if err != nil || reassembled.CompareTo(zone) != 0 {
+		t.Fatalf("zone was not correctly reassembled expected=%v actual=%v err=%v", zone, reassembled, err)
+	}
+	if _, err := section.AssembleZone(chunks[1:]); err == nil {
+		t.Error("zone with a missing chunk must not be reassembled")
+	}
+}
+
+func FuzzUnmarshalCBOR(f *testing.F) {
+	zone := &section.Zone{SubjectZone: "ch.", Context: ".", Content: []*section.Assertion{
+		&section.Assertion{SubjectName: "a", Content: []object.Object{object.NameObject()}}}}
+	seeds := []Message{
+		GetMessage(),
+		Message{Token: token.New(), Content: []section.Section{&query.Name{Name: "ethz.ch.",
+			Context: ".", Types: []object.Type{object.OTIP4Addr}}}},
+		Message{Token: token.New(), Content: []section.Section{&section.Notification{
+			Type: section.NTNoAssertionAvail, Data: "no assertion"}}},
+		Message{Token: token.New(), Capabilities: []Capability{TLSOverTCP}},
+		Message{Token: token.New(), Content: []section.Section{zone}},
+	}
+	for _, seed := range seeds {
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(&seed); err != nil {
+			f.Fatalf("Was not able to marshal seed message, err=%v", err)
+		}
+		f.Add(encoding.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			msg := Message{}
+			cbor.NewReader(bytes.NewReader(data)).Unmarshal(&msg)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Unmarshal did not return within a second for input %x", data)
+		}
+	})
+}
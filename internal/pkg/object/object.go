@@ -7,20 +7,70 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"time"
 
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
 	"github.com/scionproto/scion/go/lib/addr"
 	"github.com/scionproto/scion/go/lib/snet"
 	"golang.org/x/crypto/ed25519"
 )
 
-//Object contains a Value of to the specified Type
+//Object contains a Value of to the specified Type. Signatures are optional per-object signatures
+//which authenticate this object independently of the other objects of the enclosing assertion.
 type Object struct {
-	Type  Type
-	Value interface{}
+	Type       Type
+	Value      interface{}
+	Signatures []signature.Sig
+}
+
+//WithoutSigs returns a copy of o without per-object signatures.
+func (o Object) WithoutSigs() Object {
+	return Object{Type: o.Type, Value: o.Value}
+}
+
+//encodingLen returns the number of array elements of the encoding of an object of type t without
+//per-object signatures. The signatures are encoded as an additional trailing element such that
+//they are ignored by parsers which do not support them.
+func encodingLen(t Type) int {
+	switch t {
+	case OTName:
+		return 3
+	case OTDelegation, OTInfraKey, OTExtraKey, OTServiceInfo:
+		return 4
+	case OTCertInfo:
+		return 5
+	case OTNextKey, OTZoneMetadata:
+		return 6
+	default:
+		return 2
+	}
+}
+
+//unmarshalSigs populates obj's signatures from the optional trailing element of in.
+func (obj *Object) unmarshalSigs(in []interface{}) error {
+	n := encodingLen(obj.Type)
+	if len(in) <= n {
+		return nil
+	}
+	sigs, ok := in[n].([]interface{})
+	if !ok {
+		return errors.New("cbor object encoding of signatures not an array")
+	}
+	obj.Signatures = make([]signature.Sig, len(sigs))
+	for i, sig := range sigs {
+		sigVal, ok := sig.([]interface{})
+		if !ok {
+			return errors.New("cbor object encoding of signature not an array")
+		}
+		if err := obj.Signatures[i].UnmarshalArray(sigVal); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type SCIONAddress struct {
@@ -37,10 +87,16 @@ func (sa *SCIONAddress) String() string {
 
 // UnmarshalArray takes in a CBOR decoded array and populates the object.
 func (obj *Object) UnmarshalArray(in []interface{}) error {
+	if len(in) == 0 {
+		return errors.New("cbor object encoding must not be empty")
+	}
 	t, ok := in[0].(int)
 	if !ok {
 		return errors.New("cbor object encoding first element (type) must be an int")
 	}
+	if len(in) < encodingLen(Type(t)) {
+		return fmt.Errorf("cbor object encoding of type %d too short: %d elements", t, len(in))
+	}
 	switch Type(t) {
 	case OTName:
 		no := Name{Types: make([]Type, 0)}
@@ -181,6 +237,11 @@ func (obj *Object) UnmarshalArray(in []interface{}) error {
 		if !ok {
 			return errors.New("cbor object encoding of serv name not an string")
 		}
+	case OTText:
+		obj.Value, ok = in[1].(string)
+		if !ok {
+			return errors.New("cbor object encoding of text not a string")
+		}
 	case OTInfraKey:
 		alg, ok := in[1].(int)
 		if !ok {
@@ -274,11 +335,34 @@ func (obj *Object) UnmarshalArray(in []interface{}) error {
 			Key:        ed25519.PublicKey(key),
 		}
 		obj.Value = pkey
+	case OTZoneMetadata:
+		primary, ok := in[1].(string)
+		if !ok {
+			return errors.New("cbor object encoding of metadata primary server not a string")
+		}
+		var values [4]int64
+		for i := range values {
+			v, ok := in[2+i].(int)
+			if !ok {
+				return errors.New("cbor object encoding of metadata serial or interval not an int")
+			}
+			values[i] = int64(v)
+		}
+		obj.Value = ZoneMetadata{
+			PrimaryServer: primary,
+			Serial:        values[0],
+			Refresh:       values[1],
+			Retry:         values[2],
+			Expire:        values[3],
+		}
 	default:
 		return errors.New("unknown object type in unmarshalling object")
 	}
-	obj.Type = Type(in[0].(int))
-	return nil
+	obj.Type = Type(t)
+	if err := obj.Validate(); err != nil {
+		return err
+	}
+	return obj.unmarshalSigs(in)
 }
 
 // MarshalCBOR implements a CBORMarshaler.
@@ -358,6 +442,12 @@ func (obj Object) MarshalCBOR(w *cbor.CBORWriter) error {
 			return fmt.Errorf("expected OTRegistrant object to be string but got: %T", obj.Value)
 		}
 		res = []interface{}{OTRegistrant, rstr}
+	case OTText:
+		text, ok := obj.Value.(string)
+		if !ok {
+			return fmt.Errorf("expected OTText object to be string but got: %T", obj.Value)
+		}
+		res = []interface{}{OTText, text}
 	case OTInfraKey:
 		pkey, ok := obj.Value.(keys.PublicKey)
 		if !ok {
@@ -379,9 +469,18 @@ func (obj Object) MarshalCBOR(w *cbor.CBORWriter) error {
 		}
 		b := pubkeyToCBORBytes(pkey)
 		res = []interface{}{OTNextKey, int(pkey.Algorithm), pkey.KeyPhase, b, pkey.ValidSince, pkey.ValidUntil}
+	case OTZoneMetadata:
+		md, ok := obj.Value.(ZoneMetadata)
+		if !ok {
+			return fmt.Errorf("expected OTZoneMetadata value to be ZoneMetadata but got: %T", obj.Value)
+		}
+		res = []interface{}{OTZoneMetadata, md.PrimaryServer, md.Serial, md.Refresh, md.Retry, md.Expire}
 	default:
 		return fmt.Errorf("unknown object type: %v", obj.Type)
 	}
+	if len(obj.Signatures) > 0 {
+		res = append(res, obj.Signatures)
+	}
 	return w.WriteArray(res)
 }
 
@@ -474,12 +573,80 @@ func (o Object) CompareTo(object Object) int {
 			return v1.CompareTo(v2)
 		}
 		logObjectTypeAssertionFailure(object.Type, object.Value)
+	case ZoneMetadata:
+		if v2, ok := object.Value.(ZoneMetadata); ok {
+			return v1.CompareTo(v2)
+		}
+		logObjectTypeAssertionFailure(object.Type, object.Value)
 	default:
 		log.Warn("Unsupported Value type", "type", fmt.Sprintf("%T", o.Value))
 	}
 	return 0
 }
 
+//Validate returns an error if the concrete type of o's value does not match o's type. Functions
+//processing an object rely on this match when they type assert its value.
+func (o Object) Validate() error {
+	var ok bool
+	switch o.Type {
+	case OTName:
+		_, ok = o.Value.(Name)
+	case OTIP6Addr, OTIP4Addr:
+		_, ok = o.Value.(net.IP)
+	case OTRedirection, OTRegistrar, OTRegistrant, OTText:
+		_, ok = o.Value.(string)
+	case OTDelegation, OTInfraKey, OTExtraKey, OTNextKey:
+		_, ok = o.Value.(keys.PublicKey)
+	case OTNameset:
+		_, ok = o.Value.(NamesetExpr)
+	case OTCertInfo:
+		_, ok = o.Value.(Certificate)
+	case OTServiceInfo:
+		_, ok = o.Value.(ServiceInfo)
+	case OTScionAddr6, OTScionAddr4:
+		var addr *SCIONAddress
+		addr, ok = o.Value.(*SCIONAddress)
+		ok = ok && addr != nil
+	case OTZoneMetadata:
+		_, ok = o.Value.(ZoneMetadata)
+	default:
+		return fmt.Errorf("unknown object type: %v", o.Type)
+	}
+	if !ok {
+		return fmt.Errorf("value of object type %v must not be %T", o.Type, o.Value)
+	}
+	return nil
+}
+
+//ValidateDelegationObject returns an error if o is not a delegation to a key which can be used to
+//verify the signatures of zone's sections. The key must be a supported Ed25519 key of the RAINS key
+//space and must not have expired. A key with a ValidUntil of 0 has no known expiration.
+func ValidateDelegationObject(o Object, zone string) error {
+	if o.Type != OTDelegation {
+		return fmt.Errorf("object of type %v is not a delegation for zone %s", o.Type, zone)
+	}
+	pk, ok := o.Value.(keys.PublicKey)
+	if !ok {
+		return fmt.Errorf("delegation for zone %s must not contain a value of type %T", zone, o.Value)
+	}
+	if pk.KeySpace != keys.RainsKeySpace {
+		return fmt.Errorf("delegation for zone %s has unsupported key space %v", zone, pk.KeySpace)
+	}
+	switch pk.Algorithm {
+	case algorithmTypes.Ed25519:
+		if _, ok := pk.Key.(ed25519.PublicKey); !ok {
+			return fmt.Errorf("delegation for zone %s contains an invalid %v key of type %T", zone,
+				pk.Algorithm, pk.Key)
+		}
+	default:
+		return fmt.Errorf("delegation for zone %s has unsupported algorithm %v", zone, pk.Algorithm)
+	}
+	if pk.ValidUntil != 0 && pk.ValidUntil < time.Now().Unix() {
+		return fmt.Errorf("delegation for zone %s expired at %d", zone, pk.ValidUntil)
+	}
+	return nil
+}
+
 //String implements Stringer interface
 func (o Object) String() string {
 	return fmt.Sprintf("OT:%d OV:%v", o.Type, o.Value)
@@ -511,9 +678,18 @@ const (
 	OTNextKey     Type = 13
 	OTScionAddr6  Type = 14
 	OTScionAddr4  Type = 15
+	//OTZoneMetadata is the type of a zone's metadata published at the zone apex.
+	OTZoneMetadata Type = 16
+	//OTText is the type of free-form text published under a name similar to a DNS TXT record.
+	OTText Type = 17
+	//OTAny is only used in queries. A query for it asks for objects of all types of a name. Servers
+	//not knowing it answer such a query with nothing, so it must only be sent to servers which
+	//support it.
+	OTAny Type = 255
 )
 
-//ParseTypes returns the object type(s) specified in qType
+//ParseTypes returns the object type(s) specified in qType. "any" is expanded to all types instead
+//of OTAny such that the query is also answered by servers which do not support OTAny.
 func ParseTypes(qType string) ([]Type, error) {
 	switch qType {
 	case "name":
@@ -546,6 +722,10 @@ func ParseTypes(qType string) ([]Type, error) {
 		return []Type{OTExtraKey}, nil
 	case "next":
 		return []Type{OTNextKey}, nil
+	case "meta":
+		return []Type{OTZoneMetadata}, nil
+	case "text":
+		return []Type{OTText}, nil
 	case "any":
 		return AllTypes(), nil
 	}
@@ -585,16 +765,22 @@ func (t Type) CLIString() string {
 		return "extra"
 	case OTNextKey:
 		return "next"
+	case OTZoneMetadata:
+		return "meta"
+	case OTText:
+		return "text"
+	case OTAny:
+		return "any"
 	}
 	return t.String()
 }
 
-//AllTypes returns all object types.
+//AllTypes returns all object types. OTAny is not included as there is no object of this type.
 func AllTypes() []Type {
 	return []Type{OTName, OTIP6Addr, OTIP4Addr, OTRedirection,
 		OTDelegation, OTNameset, OTCertInfo, OTServiceInfo,
 		OTRegistrar, OTRegistrant, OTInfraKey, OTExtraKey,
-		OTNextKey, OTScionAddr6, OTScionAddr4}
+		OTNextKey, OTScionAddr6, OTScionAddr4, OTZoneMetadata, OTText}
 }
 
 //Name contains a name associated with a name as an alias. Types specifies for which object connection the alias is valid
@@ -685,6 +871,8 @@ type ServiceInfo struct {
 }
 
 //CompareTo compares two serviceInfo objects and returns 0 if they are equal, 1 if s is greater than serviceInfo and -1 if s is smaller than serviceInfo
+//It is a total order by name, port and priority which determines the canonical order of service
+//info objects in an assertion.
 func (s ServiceInfo) CompareTo(serviceInfo ServiceInfo) int {
 	if s.Name < serviceInfo.Name {
 		return -1
@@ -701,3 +889,41 @@ func (s ServiceInfo) CompareTo(serviceInfo ServiceInfo) int {
 	}
 	return 0
 }
+
+//ZoneMetadata describes a zone similar to a DNS SOA record. It is published in an assertion at the
+//zone apex. Secondaries use the intervals, given in seconds, to decide when to transfer the zone
+//again and the serial to detect whether it changed.
+type ZoneMetadata struct {
+	//PrimaryServer is the name of the zone's primary authoritative server.
+	PrimaryServer string
+	//Serial is increased each time the zone changes.
+	Serial int64
+	//Refresh is the interval after which a secondary checks whether the zone changed.
+	Refresh int64
+	//Retry is the interval after which a secondary retries a failed refresh.
+	Retry int64
+	//Expire is the duration after which a secondary stops serving the zone if it could not be
+	//refreshed.
+	Expire int64
+}
+
+//CompareTo compares two zone metadata objects and returns 0 if they are equal, 1 if m is greater
+//than metadata and -1 if m is smaller than metadata. They are ordered by primary server, serial,
+//refresh, retry and expire.
+func (m ZoneMetadata) CompareTo(metadata ZoneMetadata) int {
+	if m.PrimaryServer < metadata.PrimaryServer {
+		return -1
+	} else if m.PrimaryServer > metadata.PrimaryServer {
+		return 1
+	}
+	v1 := []int64{m.Serial, m.Refresh, m.Retry, m.Expire}
+	v2 := []int64{metadata.Serial, metadata.Refresh, metadata.Retry, metadata.Expire}
+	for i := range v1 {
+		if v1[i] < v2[i] {
+			return -1
+		} else if v1[i] > v2[i] {
+			return 1
+		}
+	}
+	return 0
+}
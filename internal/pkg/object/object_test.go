@@ -3,9 +3,12 @@ package object
 import (
 	"fmt"
 	"math/rand"
+	"net"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
@@ -130,6 +133,130 @@ func TestServiceInfoCompareTo(t *testing.T) {
 	}
 }
 
+func TestZoneMetadataCompareTo(t *testing.T) {
+	mds := sortedZoneMetadata(3)
+	shuffled := append([]ZoneMetadata{}, mds...)
+	for i := len(shuffled) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	sort.Slice(shuffled, func(i, j int) bool { return shuffled[i].CompareTo(shuffled[j]) < 0 })
+	for i, md := range mds {
+		if !reflect.DeepEqual(md, shuffled[i]) {
+			t.Errorf("%d: zone metadata are in wrong order expected=%v actual%v", i, md, shuffled[i])
+		}
+	}
+	objs := []Object{ZoneMetadataObject(), ZoneMetadataObject()}
+	md := objs[1].Value.(ZoneMetadata)
+	md.Serial++
+	objs[1].Value = md
+	if objs[0].CompareTo(objs[1]) != -1 || objs[1].CompareTo(objs[0]) != 1 || objs[0].CompareTo(objs[0]) != 0 {
+		t.Errorf("zone metadata objects are not compared by value: %v %v", objs[0], objs[1])
+	}
+}
+
+func TestTextCompareTo(t *testing.T) {
+	objs := []Object{
+		Object{Type: OTText, Value: "b"},
+		Object{Type: OTText, Value: "a b"},
+		Object{Type: OTRegistrant, Value: "z"},
+		Object{Type: OTText, Value: ""},
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].CompareTo(objs[j]) < 0 })
+	want := []Object{
+		Object{Type: OTRegistrant, Value: "z"},
+		Object{Type: OTText, Value: ""},
+		Object{Type: OTText, Value: "a b"},
+		Object{Type: OTText, Value: "b"},
+	}
+	if !reflect.DeepEqual(objs, want) {
+		t.Errorf("text objects are in wrong order expected=%v actual=%v", want, objs)
+	}
+}
+
+func TestObjectValidate(t *testing.T) {
+	for i, o := range AllObjects() {
+		if err := o.Validate(); err != nil {
+			t.Errorf("%d: valid object of type %v rejected: %v", i, o.Type, err)
+		}
+		var mismatch interface{} = ServiceInfo{Name: "srv", Port: 49830}
+		if o.Type == OTServiceInfo {
+			mismatch = "srv"
+		}
+		o.Value = mismatch
+		if err := o.Validate(); err == nil {
+			t.Errorf("%d: object of type %v with value of type %T accepted", i, o.Type, mismatch)
+		}
+	}
+	var tests = []Object{
+		Object{Type: OTScionAddr4, Value: (*SCIONAddress)(nil)},
+		Object{Type: OTIP4Addr, Value: nil},
+		Object{Type: Type(-1), Value: "unknown"},
+	}
+	for i, o := range tests {
+		if err := o.Validate(); err == nil {
+			t.Errorf("%d: malformed object accepted: %v", i, o)
+		}
+	}
+	o := &Object{}
+	if err := o.UnmarshalArray([]interface{}{int(OTRedirection), 42}); err == nil {
+		t.Errorf("decoded redirection with an int value accepted: %v", o)
+	}
+}
+
+func TestValidateDelegationObject(t *testing.T) {
+	key := func(modify func(pk *keys.PublicKey)) Object {
+		pk := PublicKey()
+		modify(&pk)
+		return Object{Type: OTDelegation, Value: pk}
+	}
+	now := time.Now().Unix()
+	var tests = []struct {
+		input  Object
+		errMsg string
+	}{
+		{key(func(pk *keys.PublicKey) {}), ""},
+		{key(func(pk *keys.PublicKey) { pk.ValidUntil = now + 3600 }), ""},
+		{key(func(pk *keys.PublicKey) { pk.ValidUntil = now - 3600 }), "expired"},
+		{key(func(pk *keys.PublicKey) { pk.KeySpace = keys.KeySpaceID(1) }), "unsupported key space"},
+		{key(func(pk *keys.PublicKey) { pk.Algorithm = algorithmTypes.Ed448 }), "unsupported algorithm"},
+		{key(func(pk *keys.PublicKey) { pk.Key = "not a key" }), "invalid Ed25519 key"},
+		{Object{Type: OTIP4Addr, Value: net.ParseIP("192.0.2.1")}, "is not a delegation"},
+	}
+	for i, test := range tests {
+		err := ValidateDelegationObject(test.input, "ethz.ch.")
+		if test.errMsg == "" && err != nil {
+			t.Errorf("%d: usable delegation rejected: %v", i, err)
+		}
+		if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+			t.Errorf("%d: wrong error expected=%s actual=%v", i, test.errMsg, err)
+		}
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  []Type
+		valid bool
+	}{
+		{"ip4", []Type{OTIP4Addr}, true},
+		{"text", []Type{OTText}, true},
+		{"any", AllTypes(), true}, //expanded for servers not supporting OTAny
+		{"bogus", nil, false},
+	}
+	for i, test := range tests {
+		types, err := ParseTypes(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong result for %s expected valid=%v error=%v", i, test.input, test.valid, err)
+			continue
+		}
+		if test.valid && !reflect.DeepEqual(types, test.want) {
+			t.Errorf("%d: wrong types for %s expected=%v actual=%v", i, test.input, test.want, types)
+		}
+	}
+}
+
 func TestObjectCompareTo(t *testing.T) {
 	objs := SortedObjects(13)
 	shuffled := append([]Object{}, objs...)
@@ -161,6 +288,10 @@ func TestObjectCompareTo(t *testing.T) {
 	if obj1.CompareTo(objs[0]) != 0 {
 		t.Error("Error case was not hit")
 	}
+	obj1.Value = ZoneMetadata{}
+	if obj1.CompareTo(objs[0]) != 0 {
+		t.Error("Error case was not hit")
+	}
 	obj1.Value = NamesetExpr("Test")
 	if obj1.CompareTo(objs[0]) != 0 {
 		t.Error("Error case was not hit")
@@ -195,6 +326,8 @@ func TestObjectString(t *testing.T) {
 		{obj[12], fmt.Sprintf("OT:13 OV:%s", obj[12].Value.(keys.PublicKey).String())},
 		{obj[13], "OT:14 OV:1-ff00:0:111,[2001:db8::]"},
 		{obj[14], "OT:15 OV:1-ff00:0:111,[192.0.2.0]"},
+		{obj[15], "OT:16 OV:{ns.example.com 2019010100 3600 600 604800}"},
+		{obj[16], "OT:17 OV:v=rains1 contact=admin@example.com"},
 	}
 	for i, test := range tests {
 		if test.input.String() != test.want {
@@ -42,7 +42,25 @@ func AllObjects() []Object {
 	nextKey := Object{Type: OTNextKey, Value: nextPublicKey}
 	return []Object{NameObject(), ip6Object, ip4Object, redirObject, delegObject,
 		nameSetObject, CertificateObject(), ServiceObject(), registrarObject,
-		registrantObject, infraObject, extraObject, nextKey, scionip6Object, scionip4Object}
+		registrantObject, infraObject, extraObject, nextKey, scionip6Object, scionip4Object,
+		ZoneMetadataObject(), TextObject()}
+}
+
+//TextObject returns a text object with valid content
+func TextObject() Object {
+	return Object{Type: OTText, Value: "v=rains1 contact=admin@" + testDomain}
+}
+
+//ZoneMetadataObject returns a zone metadata object with valid content
+func ZoneMetadataObject() Object {
+	metadata := ZoneMetadata{
+		PrimaryServer: "ns." + testDomain,
+		Serial:        2019010100,
+		Refresh:       3600,
+		Retry:         600,
+		Expire:        604800,
+	}
+	return Object{Type: OTZoneMetadata, Value: metadata}
 }
 
 //NameObject returns a name object with valid content
@@ -237,6 +255,29 @@ func sortedServiceInfo(nof int) []ServiceInfo {
 	return sis
 }
 
+func sortedZoneMetadata(nof int) []ZoneMetadata {
+	mds := []ZoneMetadata{}
+	for i := 0; i < nof; i++ {
+		for j := 0; j < nof; j++ {
+			for k := 0; k < nof; k++ {
+				for l := 0; l < nof; l++ {
+					for m := 0; m < nof; m++ {
+						mds = append(mds, ZoneMetadata{
+							PrimaryServer: strconv.Itoa(i),
+							Serial:        int64(j),
+							Refresh:       int64(k),
+							Retry:         int64(l),
+							Expire:        int64(m),
+						})
+					}
+				}
+			}
+		}
+	}
+	mds = append(mds, mds[len(mds)-1])
+	return mds
+}
+
 func sortedIPv4(nof int) []net.IP {
 	ips := []net.IP{}
 	for i := 0; i < nof; i++ {
@@ -4,14 +4,23 @@ package object
 
 import "strconv"
 
-const _Type_name = "OTNameOTIP6AddrOTIP4AddrOTRedirectionOTDelegationOTNamesetOTCertInfoOTServiceInfoOTRegistrarOTRegistrantOTInfraKeyOTExtraKeyOTNextKeyOTScionAddr6OTScionAddr4"
+const (
+	_Type_name_0 = "OTNameOTIP6AddrOTIP4AddrOTRedirectionOTDelegationOTNamesetOTCertInfoOTServiceInfoOTRegistrarOTRegistrantOTInfraKeyOTExtraKeyOTNextKeyOTScionAddr6OTScionAddr4OTZoneMetadataOTText"
+	_Type_name_1 = "OTAny"
+)
 
-var _Type_index = [...]uint8{0, 6, 15, 24, 37, 49, 58, 68, 81, 92, 104, 114, 124, 133, 145, 157}
+var (
+	_Type_index_0 = [...]uint8{0, 6, 15, 24, 37, 49, 58, 68, 81, 92, 104, 114, 124, 133, 145, 157, 171, 177}
+)
 
 func (i Type) String() string {
-	i -= 1
-	if i < 0 || i >= Type(len(_Type_index)-1) {
-		return "Type(" + strconv.FormatInt(int64(i+1), 10) + ")"
+	switch {
+	case 1 <= i && i <= 17:
+		i -= 1
+		return _Type_name_0[_Type_index_0[i]:_Type_index_0[i+1]]
+	case i == 255:
+		return _Type_name_1
+	default:
+		return "Type(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _Type_name[_Type_index[i]:_Type_index[i+1]]
 }
@@ -189,14 +189,15 @@ func groupAssertionsToShardsBySize(subjectZone, context string, assertions []*se
 	config ShardingConfig) ([]*section.Shard, error) {
 	encoder := zonefile.IO{}
 	shards := []*section.Shard{}
+	apex, assertions := splitApex(assertions)
 	sameNameAssertions := groupAssertionByName(assertions, config)
 	prevShardAssertionSubjectName := ""
-	shard := &section.Shard{SubjectZone: subjectZone, Context: context}
+	shard := newShard(subjectZone, context, apex)
 	for i, sameNameA := range sameNameAssertions {
 		shard.Content = append(shard.Content, sameNameA...)
 		if length := len(encoder.EncodeSection(shard)); length > config.MaxShardSize {
 			shard.Content = shard.Content[:len(shard.Content)-len(sameNameA)]
-			if len(shard.Content) == 0 {
+			if len(shard.Content) == len(apex) {
 				log.Error("Assertions with the same name are larger than maxShardSize",
 					"assertions", sameNameA, "length", length, "maxShardSize", config.MaxShardSize)
 				return nil, errors.New("Assertions with the same name are too long")
@@ -204,7 +205,7 @@ func groupAssertionsToShardsBySize(subjectZone, context string, assertions []*se
 			shard.RangeFrom = prevShardAssertionSubjectName
 			shard.RangeTo = sameNameA[0].SubjectName
 			shards = append(shards, shard)
-			shard = &section.Shard{SubjectZone: subjectZone, Context: context}
+			shard = newShard(subjectZone, context, apex)
 			prevShardAssertionSubjectName = sameNameAssertions[i-1][0].SubjectName
 			shard.Content = append(shard.Content, sameNameA...)
 			if length := len(encoder.EncodeSection(shard)); length > config.MaxShardSize {
@@ -221,6 +222,39 @@ func groupAssertionsToShardsBySize(subjectZone, context string, assertions []*se
 	return shards, nil
 }
 
+//splitApex returns the apex assertions of the sorted assertions and the remaining ones. The apex
+//is in the range of every shard and thus added to each of them.
+func splitApex(assertions []*section.Assertion) ([]*section.Assertion, []*section.Assertion) {
+	i := 0
+	for i < len(assertions) && section.IsApex(assertions[i].SubjectName) {
+		i++
+	}
+	return assertions[:i], assertions[i:]
+}
+
+//newShard returns a shard of the given zone and context containing a copy of the apex assertions.
+func newShard(subjectZone, context string, apex []*section.Assertion) *section.Shard {
+	shard := &section.Shard{SubjectZone: subjectZone, Context: context}
+	for _, a := range apex {
+		shard.Content = append(shard.Content, a.Copy("", ""))
+	}
+	return shard
+}
+
+//addAssertionsToPshard adds assertions to pshard's Bloom filter.
+func addAssertionsToPshard(pshard *section.Pshard, assertions []*section.Assertion) error {
+	for _, a := range assertions {
+		a.Context = pshard.Context
+		a.SubjectZone = pshard.SubjectZone
+		err := pshard.AddAssertion(a)
+		a.RemoveContextAndSubjectZone()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 //groupAssertionByName returns a slice where each entry is a slice of assertions having the same
 //subject name.
 func groupAssertionByName(assertions []*section.Assertion,
@@ -243,10 +277,11 @@ func groupAssertionByName(assertions []*section.Assertion,
 func groupAssertionsToShardsByNumber(subjectZone, context string,
 	assertions []*section.Assertion, config ShardingConfig) []*section.Shard {
 	shards := []*section.Shard{}
+	apex, assertions := splitApex(assertions)
 	nameCount := 0
 	prevAssertionSubjectName := ""
 	prevShardAssertionSubjectName := ""
-	shard := &section.Shard{SubjectZone: subjectZone, Context: context}
+	shard := newShard(subjectZone, context, apex)
 	for i, a := range assertions {
 		if prevAssertionSubjectName != a.SubjectName {
 			nameCount++
@@ -257,7 +292,7 @@ func groupAssertionsToShardsByNumber(subjectZone, context string,
 			shard.RangeTo = a.SubjectName
 			shards = append(shards, shard)
 			nameCount = 1
-			shard = &section.Shard{SubjectZone: subjectZone, Context: context}
+			shard = newShard(subjectZone, context, apex)
 			prevShardAssertionSubjectName = assertions[i-1].SubjectName
 		}
 		shard.Content = append(shard.Content, a.Copy("", ""))
@@ -274,10 +309,14 @@ func groupAssertionsToShardsByNumber(subjectZone, context string,
 func groupAssertionsToPshards(subjectZone, context string, assertions []*section.Assertion,
 	config PShardingConfig) ([]*section.Pshard, error) {
 	pshards := []*section.Pshard{}
+	apex, assertions := splitApex(assertions)
 	nameCount := 0
 	prevAssertionSubjectName := ""
 	prevShardAssertionSubjectName := ""
 	pshard := newPshard(subjectZone, context, config.BloomFilterConf)
+	if err := addAssertionsToPshard(pshard, apex); err != nil {
+		return nil, err
+	}
 	for i, a := range assertions {
 		if prevAssertionSubjectName != a.SubjectName {
 			nameCount++
@@ -289,14 +328,14 @@ func groupAssertionsToPshards(subjectZone, context string, assertions []*section
 			pshards = append(pshards, pshard)
 			nameCount = 1
 			pshard = newPshard(subjectZone, context, config.BloomFilterConf)
+			if err := addAssertionsToPshard(pshard, apex); err != nil {
+				return nil, err
+			}
 			prevShardAssertionSubjectName = assertions[i-1].SubjectName
 		}
-		a.Context = context
-		a.SubjectZone = subjectZone
-		if err := pshard.AddAssertion(a); err != nil {
+		if err := addAssertionsToPshard(pshard, []*section.Assertion{a}); err != nil {
 			return nil, err
 		}
-		a.RemoveContextAndSubjectZone()
 	}
 	pshard.RangeFrom = prevShardAssertionSubjectName
 	pshard.RangeTo = ""
@@ -191,6 +191,8 @@ func handleResponse(conn net.Conn, n *section.Notification) bool {
 	case section.NTServerNotCapable:
 		log.Error("Other server was not capable", "data", n.Data)
 		//TODO CFE when can this occur?
+	case section.NTServerTooBusy:
+		log.Warn("Other server is too busy", "data", n.Data)
 	default:
 		log.Error("Received non existing notification type")
 	}
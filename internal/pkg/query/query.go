@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"time"
 
 	cbor "github.com/britram/borat"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
@@ -58,9 +60,9 @@ func (q *Name) UnmarshalMap(m map[int]interface{}) error {
 			}
 			q.Options = append(q.Options, Option(o))
 		}
-	} else {
-		return errors.New("cbor query map does not contain a query options array")
-	}
+	} else if _, ok := m[13]; ok {
+		return errors.New("cbor query encoding of the query options should be an array")
+	} //query options may be omitted if there are none
 	if ct, ok := m[14].(int); ok {
 		q.CurrentTime = int64(ct)
 	} else {
@@ -74,7 +76,8 @@ func (q *Name) UnmarshalMap(m map[int]interface{}) error {
 	return nil
 }
 
-// MarshalCBOR implements the CBORMarshaler interface.
+// MarshalCBOR implements the CBORMarshaler interface. The options are always encoded, even if there
+// are none, as older decoders require them.
 func (q *Name) MarshalCBOR(w *cbor.CBORWriter) error {
 	m := make(map[int]interface{})
 	m[6] = q.Context
@@ -110,6 +113,78 @@ func (q *Name) ContainsOption(option Option) bool {
 	return containsOption(option, q.Options)
 }
 
+//ObjectTypes returns the types of the objects q asks for. All object types are returned if q
+//contains object.OTAny.
+func (q *Name) ObjectTypes() []object.Type {
+	for _, t := range q.Types {
+		if t == object.OTAny {
+			return object.AllTypes()
+		}
+	}
+	return q.Types
+}
+
+//WithOptions returns a copy of q to which all options not yet contained in q have been added. q
+//itself is not modified.
+func (q *Name) WithOptions(options ...Option) *Name {
+	c := *q
+	c.Options = append([]Option{}, q.Options...)
+	for _, o := range options {
+		if !containsOption(o, c.Options) {
+			c.Options = append(c.Options, o)
+		}
+	}
+	return &c
+}
+
+//WithDefaultExpiration returns q if its expiration is set. Otherwise, it returns a copy of q which
+//expires validity from now. An unset expiration is 0 which would otherwise denote an expired
+//query. q itself is not modified.
+func (q *Name) WithDefaultExpiration(validity time.Duration) *Name {
+	if q.Expiration != 0 {
+		return q
+	}
+	c := *q
+	c.Expiration = time.Now().Add(validity).Unix()
+	return &c
+}
+
+//maximal length of a label and of a fully qualified name in bytes.
+const (
+	maxLabelLength = 63
+	maxNameLength  = 255
+)
+
+//Normalized returns a copy of q whose name is in lowercase and ends with a dot. It returns an error
+//if the name is empty, contains an empty label, or if a label or the name is too long. q itself is
+//not modified.
+func (q *Name) Normalized() (*Name, error) {
+	if q.Name == "" {
+		return nil, errors.New("query name is empty")
+	}
+	name := strings.ToLower(q.Name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	if name != "." {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			if label == "" {
+				return nil, fmt.Errorf("query name contains an empty label: %q", q.Name)
+			}
+			if len(label) > maxLabelLength {
+				return nil, fmt.Errorf("query name contains a label longer than %d bytes: %q",
+					maxLabelLength, q.Name)
+			}
+		}
+	}
+	if len(name) > maxNameLength {
+		return nil, fmt.Errorf("query name is longer than %d bytes: %q", maxNameLength, q.Name)
+	}
+	c := *q
+	c.Name = name
+	return &c, nil
+}
+
 //containsOption return true if option is contained in options
 func containsOption(option Option, options []Option) bool {
 	for _, opt := range options {
@@ -120,6 +195,25 @@ func containsOption(option Option, options []Option) bool {
 	return false
 }
 
+//conflictingOptions lists the pairs of options which contradict each other. QOMaxFreshness asks for
+//an answer as fresh as possible whereas QOCachedAnswersOnly forbids fetching a fresh answer and
+//QOExpiredAssertionsOk accepts an answer which is not fresh at all.
+var conflictingOptions = [][2]Option{
+	{QOMaxFreshness, QOCachedAnswersOnly},
+	{QOMaxFreshness, QOExpiredAssertionsOk},
+}
+
+//OptionsConsistent returns an error naming the first pair of q's options which contradict each
+//other. It returns nil if all of q's options can be honored together.
+func (q *Name) OptionsConsistent() error {
+	for _, c := range conflictingOptions {
+		if containsOption(c[0], q.Options) && containsOption(c[1], q.Options) {
+			return fmt.Errorf("query options %v and %v contradict each other", c[0], c[1])
+		}
+	}
+	return nil
+}
+
 //Sort sorts the content of the query lexicographically.
 func (q *Name) Sort() {
 	sort.Slice(q.Options, func(i, j int) bool { return q.Options[i] < q.Options[j] })
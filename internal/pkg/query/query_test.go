@@ -1,11 +1,16 @@
 package query
 
 import (
+	"bytes"
 	"math/rand"
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	cbor "github.com/britram/borat"
 
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 )
@@ -32,6 +37,86 @@ func TestContainsOptions(t *testing.T) {
 	}
 }
 
+func TestOptionsConsistent(t *testing.T) {
+	var tests = []struct {
+		input []Option
+		valid bool
+	}{
+		{nil, true},
+		{[]Option{QOCachedAnswersOnly, QOExpiredAssertionsOk, QOMinE2ELatency}, true},
+		{[]Option{QOMaxFreshness, QOMinInfoLeakage, QONoProactiveCaching}, true},
+		{[]Option{QOCachedAnswersOnly, QOMaxFreshness}, false},
+		{[]Option{QOMaxFreshness, QOTokenTracing, QOExpiredAssertionsOk}, false},
+	}
+	for i, test := range tests {
+		q := &Name{Options: test.input}
+		if err := q.OptionsConsistent(); (err == nil) != test.valid {
+			t.Errorf("%d: wrong consistency of %v expected=%v err=%v", i, test.input, test.valid, err)
+		}
+	}
+}
+
+func TestNormalized(t *testing.T) {
+	longLabel := strings.Repeat("a", maxLabelLength+1)
+	longName := strings.Repeat("a.", maxNameLength/2+1)
+	var tests = []struct {
+		input string
+		want  string
+	}{
+		{"example.com.", "example.com."},
+		{"Example.COM", "example.com."},
+		{".", "."},
+		{"", ""},
+		{"example..com.", ""},
+		{".example.com.", ""},
+		{"example.com..", ""},
+		{longLabel + ".com.", ""},
+		{longName, ""},
+	}
+	for i, test := range tests {
+		q := &Name{Name: test.input}
+		n, err := q.Normalized()
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%d: invalid name %q must return an error", i, test.input)
+			}
+			continue
+		}
+		if err != nil || n.Name != test.want {
+			t.Errorf("%d: wrong normalized name expected=%s actual=%v err=%v", i, test.want, n, err)
+		}
+		if q.Name != test.input {
+			t.Errorf("%d: Normalized modified the query: %s", i, q.Name)
+		}
+	}
+}
+
+func TestWithDefaultExpiration(t *testing.T) {
+	now := time.Now().Unix()
+	var tests = []struct {
+		expiration int64
+		wantMin    int64
+		wantMax    int64
+	}{
+		{0, now + 5, now + 6},                //unset expiration gets the default
+		{now + 100, now + 100, now + 100},    //set expiration is kept
+		{now - 100, now - 100, now - 100},    //past expiration is kept and thus rejected
+		{1, 1, 1},                            //explicit expiration in the distant past
+		{now + 3600, now + 3600, now + 3600}, //expiration beyond the default is kept
+	}
+	for i, test := range tests {
+		q := &Name{Name: "example.com.", Expiration: test.expiration}
+		c := q.WithDefaultExpiration(5 * time.Second)
+		if c.Expiration < test.wantMin || c.Expiration > test.wantMax {
+			t.Errorf("%d: wrong expiration expected=[%d,%d] actual=%d", i, test.wantMin,
+				test.wantMax, c.Expiration)
+		}
+		if q.Expiration != test.expiration {
+			t.Errorf("%d: WithDefaultExpiration modified the query: %d", i, q.Expiration)
+		}
+	}
+}
+
 func TestQuerySort(t *testing.T) {
 	var tests = []struct {
 		input  []Option
@@ -126,3 +211,45 @@ func checkQuery(q1, q2 *Name, t *testing.T) {
 		}
 	}
 }
+
+func TestObjectTypes(t *testing.T) {
+	var tests = []struct {
+		input []object.Type
+		want  []object.Type
+	}{
+		{[]object.Type{object.OTIP4Addr, object.OTName}, []object.Type{object.OTIP4Addr, object.OTName}},
+		{[]object.Type{object.OTAny}, object.AllTypes()},
+		{[]object.Type{object.OTIP4Addr, object.OTAny}, object.AllTypes()},
+		{[]object.Type{}, []object.Type{}},
+	}
+	for i, test := range tests {
+		q := &Name{Types: test.input}
+		if types := q.ObjectTypes(); !reflect.DeepEqual(types, test.want) {
+			t.Errorf("%d: wrong object types expected=%v actual=%v", i, test.want, types)
+		}
+	}
+}
+
+func TestMarshalEmptyOptions(t *testing.T) {
+	for i, opts := range [][]Option{nil, []Option{}} {
+		q := &Name{Context: ".", Name: "example.", Types: []object.Type{object.OTIP4Addr},
+			Expiration: 100, Options: opts}
+		encoding := new(bytes.Buffer)
+		if err := q.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+			t.Fatalf("%d: failed to encode query: %v", i, err)
+		}
+		m, err := cbor.NewCBORReader(encoding).ReadIntMapUntagged()
+		if err != nil {
+			t.Fatalf("%d: failed to decode query map: %v", i, err)
+		}
+		//older decoders fail if the options are missing.
+		if qopts, ok := m[13].([]interface{}); !ok || len(qopts) != 0 {
+			t.Errorf("%d: options not encoded as empty array: %v", i, m[13])
+		}
+		delete(m, 13)
+		decoded := &Name{}
+		if err := decoded.UnmarshalMap(m); err != nil {
+			t.Errorf("%d: query without options cannot be decoded: %v", i, err)
+		}
+	}
+}
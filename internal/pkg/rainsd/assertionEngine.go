@@ -94,6 +94,11 @@ func addAssertionToCache(a *section.Assertion, isAuthoritative bool, assertionsC
 	zoneKeyCache cache.ZonePublicKey) {
 	assertionsCache.Add(a, a.ValidUntil(), isAuthoritative)
 	log.Info("Added assertion to cache", "assertion", *a)
+	addPublicKeysToCache(a, isAuthoritative, zoneKeyCache)
+}
+
+//addPublicKeysToCache adds the public keys a delegates to the public key cache.
+func addPublicKeysToCache(a *section.Assertion, isAuthoritative bool, zoneKeyCache cache.ZonePublicKey) {
 	for _, obj := range a.Content {
 		if obj.Type == object.OTDelegation {
 			publicKey, _ := obj.Value.(keys.PublicKey)
@@ -130,15 +135,31 @@ func addPshardToCache(pshard *section.Pshard, isAuthoritative bool, assertionsCa
 }
 
 //addZoneToCache adds zone and all contained shards to the negAssertion cache and all contained
-//assertions to the assertionCache.
+//assertions to the assertionCache. The assertions of previously cached versions of zone are
+//replaced atomically such that a lookup never observes a mix of two versions.
 func addZoneToCache(zone *section.Zone, isAuthoritative bool, assertionsCache cache.Assertion,
 	negAssertionCache cache.NegativeAssertion, zoneKeyCache cache.ZonePublicKey) {
+	old := &section.Zone{SubjectZone: zone.SubjectZone, Context: zone.Context}
+	if cached, ok := negAssertionCache.Get(zone.SubjectZone, zone.Context, section.TotalInterval{}); ok {
+		for _, sec := range cached {
+			if z, ok := sec.(*section.Zone); ok {
+				for _, a := range z.Content {
+					old.Content = append(old.Content, a.Copy(z.Context, z.SubjectZone))
+				}
+			}
+		}
+	}
+	replacement := &section.Zone{SubjectZone: zone.SubjectZone, Context: zone.Context}
 	for _, assertion := range zone.Content {
 		if shouldAssertionBeCached(assertion) {
-			a := assertion.Copy(zone.Context, zone.SubjectZone)
-			addAssertionToCache(a, isAuthoritative, assertionsCache, zoneKeyCache)
+			replacement.Content = append(replacement.Content,
+				assertion.Copy(zone.Context, zone.SubjectZone))
 		}
 	}
+	assertionsCache.ReplaceZone(old, replacement, zone.ValidUntil(), isAuthoritative)
+	for _, a := range replacement.Content {
+		addPublicKeysToCache(a, isAuthoritative, zoneKeyCache)
+	}
 	negAssertionCache.AddZone(zone, zone.ValidUntil(), isAuthoritative)
 	log.Debug("Added zone to cache", "zone", *zone)
 }
@@ -147,6 +168,16 @@ func pendingKeysCallback(mss util.SectionWithSigSender, pendingKeys cache.Pendin
 	normalChannel chan util.MsgSectionSender) {
 	if ss, ok := pendingKeys.GetAndRemove(mss.Token); ok {
 		normalChannel <- ss
+		unparkPendingKeys(pendingKeys, normalChannel)
+	}
+}
+
+//unparkPendingKeys forwards parked sections for which a delegation query can be sent again to the
+//normal channel such that they are verified again.
+func unparkPendingKeys(pendingKeys cache.PendingKey, normalChannel chan util.MsgSectionSender) {
+	for _, ss := range pendingKeys.Unpark() {
+		log.Debug("Retry parked section", "sectionSender", ss)
+		normalChannel <- ss
 	}
 }
 
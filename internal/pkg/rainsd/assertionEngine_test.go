@@ -0,0 +1,55 @@
+package rainsd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+func TestAddZoneToCacheReplacesVersion(t *testing.T) {
+	until := time.Now().Add(time.Hour).Unix()
+	//zoneVersion returns a version of zone ch. holding an assertion for each name whose address
+	//is 192.0.2.v.
+	zoneVersion := func(v int, names ...string) *section.Zone {
+		z := &section.Zone{SubjectZone: "ch.", Context: "."}
+		for _, name := range names {
+			z.Content = append(z.Content, &section.Assertion{SubjectName: name,
+				Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+					Value: fmt.Sprintf("192.0.2.%d", v)}},
+				Signatures: []signature.Sig{signature.Sig{ValidUntil: until}}})
+		}
+		z.Signatures = []signature.Sig{signature.Sig{ValidUntil: until}}
+		z.SetValidUntil(until)
+		return z
+	}
+	assertions := cache.NewAssertion(100, 0)
+	negAssertions := cache.NewNegAssertion(100)
+	zoneKeys := cache.NewZoneKey(100, 90, 5, 0)
+	addZoneToCache(zoneVersion(1, "a", "b"), true, assertions, negAssertions, zoneKeys)
+	addZoneToCache(zoneVersion(2, "a", "c"), true, assertions, negAssertions, zoneKeys)
+	var tests = []struct {
+		fqdn  string
+		value string
+	}{
+		{"a.ch.", "192.0.2.2"},
+		{"b.ch.", ""},
+		{"c.ch.", "192.0.2.2"},
+	}
+	for i, test := range tests {
+		cached, ok := assertions.Get(test.fqdn, ".", object.OTIP4Addr, true)
+		if test.value == "" {
+			if ok {
+				t.Errorf("%d: assertion of the old zone version is still cached: %v", i, cached)
+			}
+			continue
+		}
+		if !ok || len(cached) != 1 || cached[0].Content[0].Value != test.value {
+			t.Errorf("%d: %s does not hold the new zone version: %v", i, test.fqdn, cached)
+		}
+	}
+}
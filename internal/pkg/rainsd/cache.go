@@ -2,6 +2,7 @@ package rainsd
 
 import (
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
 type Caches struct {
@@ -29,24 +30,34 @@ type Caches struct {
 	//for a shard the range is given as declared in the section.
 	//An entry is marked as extrenal if it might be evicted by a LRU caching strategy.
 	NegAssertionCache cache.NegativeAssertion
+
+	//Store backs the assertionCache of an authoritative server. It is consulted for names this
+	//server has authority over when they are not cached.
+	Store cache.Store
 }
 
 func initCaches(config Config) *Caches {
 	caches := new(Caches)
-	caches.ConnCache = cache.NewConnection(config.MaxConnections)
+	caches.ConnCache = cache.NewConnection(config.MaxConnections, config.MaxConnsPerServer)
 	caches.Capabilities = cache.NewCapability(config.CapabilitiesCacheSize)
 	caches.ZoneKeyCache = cache.NewZoneKey(config.ZoneKeyCacheSize, config.ZoneKeyCacheWarnSize,
-		config.MaxPublicKeysPerZone)
-	caches.PendingKeys = cache.NewPendingKey(config.PendingKeyCacheSize)
+		config.MaxPublicKeysPerZone, config.KeyGracePeriod)
+	caches.PendingKeys = cache.NewPendingKey(config.PendingKeyCacheSize,
+		config.MaxOutstandingDelegQueries)
 	caches.PendingQueries = cache.NewPendingQuery(config.PendingQueryCacheSize)
-	caches.AssertionsCache = cache.NewAssertion(config.AssertionCacheSize)
+	caches.AssertionsCache = cache.NewAssertion(config.AssertionCacheSize,
+		config.AssertionCacheExpiryJitter)
 	caches.NegAssertionCache = cache.NewNegAssertion(config.NegativeAssertionCacheSize)
+	caches.Store = cache.NoStore{}
 	return caches
 }
 
-func initReapers(config Config, caches *Caches, stop chan bool) {
+func initReapers(config Config, caches *Caches, normalChannel chan util.MsgSectionSender, stop chan bool) {
 	go repeatFuncCaller(caches.ZoneKeyCache.RemoveExpiredKeys, config.ReapZoneKeyCacheInterval, stop)
-	go repeatFuncCaller(caches.PendingKeys.RemoveExpiredValues, config.ReapPendingKeyCacheInterval, stop)
+	go repeatFuncCaller(func() {
+		caches.PendingKeys.RemoveExpiredValues()
+		unparkPendingKeys(caches.PendingKeys, normalChannel)
+	}, config.ReapPendingKeyCacheInterval, stop)
 	go repeatFuncCaller(caches.AssertionsCache.RemoveExpiredValues, config.ReapAssertionCacheInterval, stop)
 	go repeatFuncCaller(caches.NegAssertionCache.RemoveExpiredValues, config.ReapNegAssertionCacheInterval, stop)
 	go repeatFuncCaller(caches.PendingQueries.RemoveExpiredValues, config.ReapPendingQCacheInterval, stop)
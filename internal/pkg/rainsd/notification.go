@@ -58,6 +58,9 @@ func (s *Server) notify(msgSender util.MsgSectionSender) {
 	case section.NTServerNotCapable:
 		notifLog.Error("Other server was not capable")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
+	case section.NTServerTooBusy:
+		notifLog.Warn("Other server is too busy")
+		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
 	case section.NTNoAssertionAvail:
 		notifLog.Info("No assertion was available")
 		dropPendingSectionsAndQueries(msgSender.Token, sec, false, s)
@@ -82,6 +85,7 @@ func dropPendingSectionsAndQueries(token token.Token, notification *section.Noti
 		} else {
 			sendNotificationMsg(ss.Token, ss.Sender, notification.Type, notification.Data, s)
 		}
+		unparkPendingKeys(s.caches.PendingKeys, s.queues.Normal)
 	}
 	sectionSenders := s.caches.PendingQueries.GetAndRemove(token)
 	for _, ss := range sectionSenders {
@@ -91,7 +91,8 @@ func answerQueriesAuthoritative(qs []*query.Name, sender net.Addr, token token.T
 	for _, q := range qs {
 		for i, auth := range s.config.Authorities {
 			// check this server is authoritative for the name and the query has the right context
-			if strings.HasSuffix(q.Name, auth.Zone) && q.Name != auth.Zone && q.Context == auth.Context {
+			if name, ok := section.EnclosingZone(q.Name, auth.Zone); ok && !section.IsApex(name) &&
+				section.EqualContexts(q.Context, auth.Context) {
 				break
 			}
 			if i == len(s.config.Authorities)-1 {
@@ -152,10 +153,22 @@ func assertionCacheLookup(q *query.Name, s *Server) (assertions []section.Sectio
 		return fmt.Sprintf("%s_%s_%s", a.SubjectName, a.SubjectZone, a.Context)
 	}
 
-	for _, t := range q.Types {
-		if asserts, ok := s.caches.AssertionsCache.Get(q.Name, q.Context, t, true); ok {
+	zone, name, isAuthoritative := s.authoritativeZone(q.Name, q.Context)
+	for _, t := range q.ObjectTypes() {
+		var asserts []*section.Assertion
+		var ok bool
+		if isAuthoritative {
+			asserts, ok = cache.ReadThrough(s.caches.AssertionsCache, s.caches.Store, q.Context, zone,
+				name, t)
+		} else {
+			asserts, ok = s.caches.AssertionsCache.Get(q.Name, q.Context, t, true)
+		}
+		if ok {
+			if t == object.OTDelegation {
+				asserts = keyPhaseDelegations(asserts, q.KeyPhase)
+			}
 			for _, a := range asserts {
-				if _, ok := assertionSet[asKey(a)]; ok {
+				if _, ok := assertionSet[asKey(a)]; ok || !section.AssertionAnswers(a, q) {
 					continue
 				}
 				if a.ValidUntil() > time.Now().Unix() {
@@ -169,6 +182,35 @@ func assertionCacheLookup(q *query.Name, s *Server) (assertions []section.Sectio
 	return
 }
 
+//keyPhaseDelegations returns the delegations among asserts which delegate to a key of keyPhase. It
+//returns asserts if there are none such that a query for an unknown key phase is still answered.
+func keyPhaseDelegations(asserts []*section.Assertion, keyPhase int) []*section.Assertion {
+	var result []*section.Assertion
+	for _, a := range asserts {
+		if a.DelegatesKeyPhase(keyPhase) {
+			result = append(result, a)
+		}
+	}
+	if len(result) == 0 {
+		return asserts
+	}
+	return result
+}
+
+//authoritativeZone returns the innermost zone this server has authority over in context which
+//contains fqdn together with fqdn's subject name in it. It returns false if there is none.
+func (s *Server) authoritativeZone(fqdn, context string) (zone, name string, ok bool) {
+	for _, auth := range s.config.Authorities {
+		if !section.EqualContexts(auth.Context, context) || len(auth.Zone) <= len(zone) {
+			continue
+		}
+		if n, isIn := section.EnclosingZone(fqdn, auth.Zone); isIn {
+			zone, name, ok = auth.Zone, n, true
+		}
+	}
+	return
+}
+
 func negativeCacheLookup(q *query.Name, sender net.Addr, token token.Token, s *Server) []section.Section {
 	subject, zone, err := toSubjectZone(q.Name)
 	if err != nil {
@@ -313,7 +355,7 @@ func toSubjectZone(name string) (subject, zone string, e error) {
 	}
 	parts := strings.Split(name, ".")
 	if parts[0] == "" {
-		zone = "."
+		zone = section.RootZone
 		subject = ""
 		return
 	}
@@ -4,8 +4,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"net"
+	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/libresolve"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
@@ -13,10 +15,13 @@ import (
 )
 
 const (
-	nofReapers       = 3
+	nofReapers       = 4
 	nofCheckPointers = 3
 	noListeners      = 1
 	shutdownChannels = nofReapers + nofCheckPointers + noListeners
+	//parkedSectionsSweepInterval is the interval at which parked sections which became valid are
+	//added to the caches.
+	parkedSectionsSweepInterval = time.Second
 )
 
 //Server represents a rainsd server instance.
@@ -43,6 +48,8 @@ type Server struct {
 	caches *Caches
 	//scionConn is the server UDP socket if we are in that mode, or nil otherwise.
 	scionConn snet.Conn
+	//parked holds verified sections until they become valid.
+	parked *parkedSections
 }
 
 //New returns a pointer to a newly created rainsd server instance with the given config. The server
@@ -71,6 +78,7 @@ func New(config Config, id string) (server *Server, err error) {
 	}
 	log.Debug("Created server channels")
 	server.caches = initCaches(server.config)
+	server.parked = newParkedSections(server.config.MaxParkedSections)
 	if err = loadRootZonePublicKey(server.config.RootZonePublicKeyPath, server.caches.ZoneKeyCache,
 		server.config.MaxCacheValidity); err != nil {
 		log.Warn("Failed to load root zone public key")
@@ -95,6 +103,12 @@ func (s *Server) SetResolver(resolver *libresolve.Resolver) {
 	s.resolver = resolver
 }
 
+//SetStore adds a persistent store from which this server reads the assertions of its zones which
+//are not in its assertion cache.
+func (s *Server) SetStore(store cache.Store) {
+	s.caches.Store = store
+}
+
 //Start starts up the server and it begins to listen for incoming connections according to its
 //config.
 func (s *Server) Start(monitorResources bool, id string) error {
@@ -102,7 +116,8 @@ func (s *Server) Start(monitorResources bool, id string) error {
 	go s.workBoth()
 	go s.workNotification()
 	log.Debug("Goroutines working on input queue started")
-	initReapers(s.config, s.caches, s.shutdown)
+	initReapers(s.config, s.caches, s.queues.Normal, s.shutdown)
+	go repeatFuncCaller(s.assertParkedSections, parkedSectionsSweepInterval, s.shutdown)
 	if s.config.PreLoadCaches {
 		loadCaches(s.config.CheckPointPath, s.caches, s.config.Authorities)
 		log.Info("Caches loaded from checkpoint",
@@ -128,7 +143,7 @@ func (s *Server) Shutdown() {
 
 	// Unblock the switchboard listener to get the shutdown message delivered
 	switch s.config.ServerAddress.Type {
-	case connection.TCP:
+	case connection.TCP, connection.Unix:
 		if conn, err := net.Dial(s.Addr().Network(), s.Addr().String()); err == nil {
 			conn.Close()
 		}
@@ -139,6 +154,9 @@ func (s *Server) Shutdown() {
 	}
 
 	s.caches.ConnCache.CloseAndRemoveAllConnections()
+	if s.resolver != nil {
+		s.resolver.Close()
+	}
 	s.queues.Normal <- util.MsgSectionSender{}
 	s.queues.Prio <- util.MsgSectionSender{}
 	s.queues.Notify <- util.MsgSectionSender{}
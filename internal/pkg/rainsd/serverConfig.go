@@ -4,8 +4,10 @@ import (
 	"net"
 	"time"
 
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
 	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
@@ -20,12 +22,15 @@ type Config struct {
 	PreLoadCaches                  bool
 
 	//switchboard
-	ServerAddress      connection.Info
-	MaxConnections     int
-	KeepAlivePeriod    time.Duration //in seconds
-	TCPTimeout         time.Duration //in seconds
-	TLSCertificateFile string
-	TLSPrivateKeyFile  string
+	ServerAddress       connection.Info
+	MaxConnections      int
+	MaxConnsPerServer   int           //0 disables the limit of cached connections per destination
+	KeepAlivePeriod     time.Duration //in seconds
+	TCPTimeout          time.Duration //in seconds
+	TLSCertificateFile  string
+	TLSPrivateKeyFile   string
+	MaxMsgByteLength    int //answers sent over SCION are truncated to this size, 0 disables it
+	ZoneChunkByteLength int //zones are chunked to this size for capable clients, 0 disables it
 
 	// SCION specific settings
 	DispatcherSock string
@@ -46,12 +51,24 @@ type Config struct {
 	ZoneKeyCacheWarnSize        int
 	MaxPublicKeysPerZone        int
 	PendingKeyCacheSize         int
+	MaxOutstandingDelegQueries  int
 	DelegationQueryValidity     time.Duration //in seconds
 	ReapZoneKeyCacheInterval    time.Duration //in seconds
 	ReapPendingKeyCacheInterval time.Duration //in seconds
+	KeyGracePeriod              time.Duration //in seconds, 0 rejects keys as soon as they expire
+	MinSignatureAlgorithm       algorithmTypes.Signature
+	MaxFutureValidSince         time.Duration //in seconds
+	MinValidSignatures          int           //a section always needs at least one valid signature
+	ZonePolicies                map[string]ZonePolicy
+	VerifyObjectSignatures      bool
+	AcceptNotYetValid           NotYetValidPolicy
+	ZoneVerificationMode        ZoneVerificationMode
+	NotYetValidHorizon          time.Duration //in seconds, sections valid later are not parked
+	MaxParkedSections           int
 
 	//engine
 	AssertionCacheSize            int
+	AssertionCacheExpiryJitter    time.Duration //in seconds
 	NegativeAssertionCacheSize    int
 	PendingQueryCacheSize         int
 	QueryValidity                 time.Duration //in seconds
@@ -62,6 +79,70 @@ type Config struct {
 	ReapPendingQCacheInterval     time.Duration         //in seconds
 }
 
+//ZonePolicy overrides the verification settings of Config for sections of a zone and its
+//subzones. A field with the zero value keeps the server's setting.
+type ZonePolicy struct {
+	MinValidSignatures    int
+	MinSignatureAlgorithm algorithmTypes.Signature
+	MaxFutureValidSince   time.Duration //in seconds
+}
+
+//zonePolicy returns the verification settings for sections of zone. The entry of ZonePolicies for
+//the longest zone enclosing zone overrides the server's settings.
+func (c *Config) zonePolicy(zone string) ZonePolicy {
+	policy := ZonePolicy{
+		MinValidSignatures:    c.MinValidSignatures,
+		MinSignatureAlgorithm: c.MinSignatureAlgorithm,
+		MaxFutureValidSince:   c.MaxFutureValidSince,
+	}
+	match := ""
+	for z := range c.ZonePolicies {
+		if _, ok := section.EnclosingZone(zone, z); ok && len(z) > len(match) {
+			match = z
+		}
+	}
+	if override, ok := c.ZonePolicies[match]; ok {
+		if override.MinValidSignatures != 0 {
+			policy.MinValidSignatures = override.MinValidSignatures
+		}
+		if override.MinSignatureAlgorithm != 0 {
+			policy.MinSignatureAlgorithm = override.MinSignatureAlgorithm
+		}
+		if override.MaxFutureValidSince != 0 {
+			policy.MaxFutureValidSince = override.MaxFutureValidSince
+		}
+	}
+	return policy
+}
+
+//NotYetValidPolicy defines how the server handles a verified section whose validity starts in the
+//future.
+type NotYetValidPolicy string
+
+const (
+	//RejectNotYetValid drops the section. It applies to an unknown policy.
+	RejectNotYetValid NotYetValidPolicy = "reject"
+	//ParkNotYetValid holds the section back and caches it once it becomes valid. At most
+	//MaxParkedSections are held and only if they become valid within NotYetValidHorizon. It applies
+	//to an empty policy.
+	ParkNotYetValid NotYetValidPolicy = "park"
+	//ServeNotYetValid caches the section immediately such that it is served before it is valid.
+	ServeNotYetValid NotYetValidPolicy = "serve"
+)
+
+//ZoneVerificationMode defines how the server handles a zone containing an assertion whose signature
+//is invalid.
+type ZoneVerificationMode string
+
+const (
+	//StrictZoneVerification drops the whole zone. It applies to an empty or unknown mode.
+	StrictZoneVerification ZoneVerificationMode = "strict"
+	//LenientZoneVerification keeps the zone. A valid zone signature authenticates an assertion with
+	//an invalid signature, of which only the signature is dropped. The assertion is dropped from an
+	//unsigned zone.
+	LenientZoneVerification ZoneVerificationMode = "lenient"
+)
+
 //DefaultConfig return the default configuration for the zone publisher.
 func DefaultConfig() Config {
 	serverAddr, _ := net.ResolveTCPAddr("", "127.0.0.1:55553")
@@ -78,11 +159,14 @@ func DefaultConfig() Config {
 			Type: connection.TCP,
 			Addr: serverAddr,
 		},
-		MaxConnections:     10000,
-		KeepAlivePeriod:    time.Minute,
-		TCPTimeout:         5 * time.Minute,
-		TLSCertificateFile: "data/cert/server.crt",
-		TLSPrivateKeyFile:  "data/cert/server.key",
+		MaxConnections:      10000,
+		MaxConnsPerServer:   0,
+		KeepAlivePeriod:     time.Minute,
+		TCPTimeout:          5 * time.Minute,
+		TLSCertificateFile:  "data/cert/server.crt",
+		TLSPrivateKeyFile:   "data/cert/server.key",
+		MaxMsgByteLength:    connection.MaxUDPPacketBytes,
+		ZoneChunkByteLength: 0,
 
 		// SCION specific settings
 		DispatcherSock: "/run/shm/dispatcher/default.sock",
@@ -103,12 +187,24 @@ func DefaultConfig() Config {
 		ZoneKeyCacheWarnSize:        750,
 		MaxPublicKeysPerZone:        5,
 		PendingKeyCacheSize:         100,
+		MaxOutstandingDelegQueries:  50,
 		DelegationQueryValidity:     time.Second,
 		ReapZoneKeyCacheInterval:    15 * time.Minute,
 		ReapPendingKeyCacheInterval: 15 * time.Minute,
+		KeyGracePeriod:              0,
+		MinSignatureAlgorithm:       algorithmTypes.Ed25519,
+		MaxFutureValidSince:         24 * time.Hour,
+		MinValidSignatures:          1,
+		ZonePolicies:                map[string]ZonePolicy{},
+		VerifyObjectSignatures:      false,
+		AcceptNotYetValid:           ParkNotYetValid,
+		ZoneVerificationMode:        StrictZoneVerification,
+		NotYetValidHorizon:          time.Hour,
+		MaxParkedSections:           1000,
 
 		//engine
 		AssertionCacheSize:         10000,
+		AssertionCacheExpiryJitter: 30 * time.Second,
 		NegativeAssertionCacheSize: 1000,
 		PendingQueryCacheSize:      1000,
 		QueryValidity:              time.Second,
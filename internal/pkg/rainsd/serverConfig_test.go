@@ -0,0 +1,43 @@
+package rainsd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+)
+
+func TestZonePolicy(t *testing.T) {
+	config := Config{
+		MinValidSignatures:    1,
+		MinSignatureAlgorithm: algorithmTypes.Ed25519,
+		MaxFutureValidSince:   time.Hour,
+		ZonePolicies: map[string]ZonePolicy{
+			"ch.":      ZonePolicy{MinValidSignatures: 2},
+			"ethz.ch.": ZonePolicy{MinValidSignatures: 3, MaxFutureValidSince: time.Minute},
+			".":        ZonePolicy{MaxFutureValidSince: 2 * time.Hour},
+		},
+	}
+	var tests = []struct {
+		zone string
+		want ZonePolicy
+	}{
+		{"ethz.ch.", ZonePolicy{3, algorithmTypes.Ed25519, time.Minute}},
+		{"inf.ethz.ch.", ZonePolicy{3, algorithmTypes.Ed25519, time.Minute}},
+		{"epfl.ch.", ZonePolicy{2, algorithmTypes.Ed25519, time.Hour}},
+		{"ch.", ZonePolicy{2, algorithmTypes.Ed25519, time.Hour}},
+		{"xethz.ch.", ZonePolicy{2, algorithmTypes.Ed25519, time.Hour}}, //label boundary
+		{"com.", ZonePolicy{1, algorithmTypes.Ed25519, 2 * time.Hour}},
+		{".", ZonePolicy{1, algorithmTypes.Ed25519, 2 * time.Hour}},
+	}
+	for i, test := range tests {
+		if policy := config.zonePolicy(test.zone); policy != test.want {
+			t.Errorf("%d: wrong policy for %s expected=%v actual=%v", i, test.zone, test.want, policy)
+		}
+	}
+	config.ZonePolicies = nil
+	want := ZonePolicy{1, algorithmTypes.Ed25519, time.Hour}
+	if policy := config.zonePolicy("ethz.ch."); policy != want {
+		t.Errorf("wrong policy without zone policies expected=%v actual=%v", want, policy)
+	}
+}
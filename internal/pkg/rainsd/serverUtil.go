@@ -10,24 +10,30 @@ import (
 	"net"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/section"
 	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
 	"github.com/netsec-ethz/rains/internal/pkg/token"
 	"github.com/netsec-ethz/rains/internal/pkg/util"
 )
 
 const (
-	aCheckPointFileName = "assertionCheckPoint.gob"
-	nCheckPointFileName = "negAssertionCheckPoint.gob"
-	zCheckPointFileName = "zoneKeyCheckPoint.gob"
+	aCheckPointFileName = "assertionCheckPoint.cbor"
+	nCheckPointFileName = "negAssertionCheckPoint.cbor"
+	zCheckPointFileName = "zoneKeyCheckPoint.cbor"
+	//legacyCheckPointExt is the extension of the gob encoded checkpoints written by earlier
+	//versions. Such a checkpoint is loaded if there is none in the current format.
+	legacyCheckPointExt = ".gob"
 )
 
 type missingKeyMetaData struct {
@@ -36,12 +42,45 @@ type missingKeyMetaData struct {
 	KeyPhase int
 }
 
+//less returns true if m is ordered before data. Missing keys are ordered by context, zone and key
+//phase.
+func (m missingKeyMetaData) less(data missingKeyMetaData) bool {
+	if m.Context != data.Context {
+		return m.Context < data.Context
+	}
+	if m.Zone != data.Zone {
+		return m.Zone < data.Zone
+	}
+	return m.KeyPhase < data.KeyPhase
+}
+
+//sortedMissingKeys returns the keys of missingKeys in a deterministic order.
+func sortedMissingKeys(missingKeys map[missingKeyMetaData]bool) []missingKeyMetaData {
+	result := make([]missingKeyMetaData, 0, len(missingKeys))
+	for k := range missingKeys {
+		result = append(result, k)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].less(result[j]) })
+	return result
+}
+
+//sortedSigMetaData returns the keys of metaData in a deterministic order.
+func sortedSigMetaData(metaData map[signature.MetaData]bool) []signature.MetaData {
+	result := make([]signature.MetaData, 0, len(metaData))
+	for m := range metaData {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CompareTo(result[j]) < 0 })
+	return result
+}
+
 //ZoneContext stores a context and a zone
 type ZoneContext struct {
 	Zone    string
 	Context string
 }
 
+//checkPointValue is the content of a legacy gob encoded checkpoint.
 type checkPointValue struct {
 	Sections   []section.Section
 	ValidSince []int64
@@ -84,7 +123,13 @@ func sendCapability(destination net.Addr, capabilities []message.Capability, s *
 
 //LoadConfig loads server configuration
 func LoadConfig(configPath string) (Config, error) {
-	config := Config{}
+	//config files written before sections could be parked lack these settings and keep the defaults.
+	defaults := DefaultConfig()
+	config := Config{
+		AcceptNotYetValid:  defaults.AcceptNotYetValid,
+		NotYetValidHorizon: defaults.NotYetValidHorizon / time.Second,
+		MaxParkedSections:  defaults.MaxParkedSections,
+	}
 	file, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		log.Warn("Could not open config file...", "path", configPath, "error", err)
@@ -102,7 +147,15 @@ func LoadConfig(configPath string) (Config, error) {
 	config.DelegationQueryValidity *= time.Second
 	config.ReapZoneKeyCacheInterval *= time.Second
 	config.ReapPendingKeyCacheInterval *= time.Second
+	config.KeyGracePeriod *= time.Second
+	config.MaxFutureValidSince *= time.Second
+	config.NotYetValidHorizon *= time.Second
+	for zone, policy := range config.ZonePolicies {
+		policy.MaxFutureValidSince *= time.Second
+		config.ZonePolicies[zone] = policy
+	}
 	config.QueryValidity *= time.Second
+	config.AssertionCacheExpiryJitter *= time.Second
 	config.MaxCacheValidity.PshardValidity *= time.Hour
 	config.MaxCacheValidity.AssertionValidity *= time.Hour
 	config.MaxCacheValidity.ShardValidity *= time.Hour
@@ -210,34 +263,49 @@ func initStoreCachesContent(config Config, caches *Caches, stop chan bool) {
 	}
 	time.Sleep(100 * time.Millisecond)
 	go repeatFuncCaller(func() {
-		checkpoint(path.Join(config.CheckPointPath, aCheckPointFileName),
+		logCheckpoint(path.Join(config.CheckPointPath, aCheckPointFileName),
 			caches.AssertionsCache.Checkpoint)
 	}, config.AssertionCheckPointInterval, stop)
 	go repeatFuncCaller(func() {
-		checkpoint(path.Join(config.CheckPointPath, nCheckPointFileName),
+		logCheckpoint(path.Join(config.CheckPointPath, nCheckPointFileName),
 			caches.NegAssertionCache.Checkpoint)
 	}, config.NegAssertionCheckPointInterval, stop)
 	go repeatFuncCaller(func() {
-		checkpoint(path.Join(config.CheckPointPath, zCheckPointFileName),
+		logCheckpoint(path.Join(config.CheckPointPath, zCheckPointFileName),
 			caches.ZoneKeyCache.Checkpoint)
 	}, config.ZoneKeyCheckPointInterval, stop)
 }
 
-func checkpoint(path string, values func() []section.Section) {
-	value := checkPointValue{Sections: values()}
-	for _, s := range value.Sections {
-		value.ValidSince = append(value.ValidSince, s.(section.WithSigForward).ValidSince())
-		value.ValidUntil = append(value.ValidUntil, s.(section.WithSigForward).ValidUntil())
-	}
-	if err := util.Save(path, value); err != nil {
+//logCheckpoint writes the checkpoint of values to path and logs a failure.
+func logCheckpoint(path string, values func() []section.Section) {
+	if err := checkpoint(path, values); err != nil {
 		log.Error("Was not able to checkpoint cache", "path", path, "error", err)
 	}
 }
 
+//checkpoint writes the sections returned by values to path such that they retain their validity
+//when they are loaded.
+func checkpoint(path string, values func() []section.Section) (err error) {
+	snapshot := section.Snapshot{}
+	for _, s := range values() {
+		snapshot.Sections = append(snapshot.Sections, s.(section.WithSigForward))
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return cbor.NewWriter(file).Marshal(&snapshot)
+}
+
 func loadCaches(cpPath string, caches *Caches, authorities []ZoneContext) {
 
 	//load assertion check point
-	sections, err := readMsgFromFile(path.Join(cpPath, aCheckPointFileName))
+	sections, err := readCheckpoint(path.Join(cpPath, aCheckPointFileName))
 	if err != nil {
 		log.Warn("Was not able to load assertion check point from file", "error", err)
 	}
@@ -251,7 +319,7 @@ func loadCaches(cpPath string, caches *Caches, authorities []ZoneContext) {
 	}
 
 	//load negAssertion check point
-	sections, err = readMsgFromFile(path.Join(cpPath, nCheckPointFileName))
+	sections, err = readCheckpoint(path.Join(cpPath, nCheckPointFileName))
 	if err != nil {
 		log.Warn("Was not able to load negAssertion check point from file", "error", err)
 	}
@@ -272,7 +340,7 @@ func loadCaches(cpPath string, caches *Caches, authorities []ZoneContext) {
 	}
 
 	//load zone key check point
-	sections, err = readMsgFromFile(path.Join(cpPath, zCheckPointFileName))
+	sections, err = readCheckpoint(path.Join(cpPath, zCheckPointFileName))
 	if err != nil {
 		log.Warn("Was not able to load zone key check point from file", "error", err)
 	}
@@ -290,11 +358,47 @@ func loadCaches(cpPath string, caches *Caches, authorities []ZoneContext) {
 	}
 }
 
+//readCheckpoint returns the sections of the checkpoint at cpFile. If there is none, it falls back
+//to the legacy gob encoded checkpoint of the same name.
+func readCheckpoint(cpFile string) ([]section.Section, error) {
+	sections, err := readMsgFromFile(cpFile)
+	if os.IsNotExist(err) {
+		legacyPath := strings.TrimSuffix(cpFile, path.Ext(cpFile)) + legacyCheckPointExt
+		if _, serr := os.Stat(legacyPath); serr == nil {
+			return readLegacyCheckpoint(legacyPath)
+		}
+	}
+	return sections, err
+}
+
+//readMsgFromFile returns the sections of the checkpoint at path. The sections retain the validity
+//they had when they were checkpointed.
 func readMsgFromFile(path string) ([]section.Section, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	snapshot := section.Snapshot{}
+	if err := cbor.NewReader(file).Unmarshal(&snapshot); err != nil {
+		return nil, err
+	}
+	sections := make([]section.Section, len(snapshot.Sections))
+	for i, s := range snapshot.Sections {
+		sections[i] = s
+	}
+	return sections, nil
+}
+
+//readLegacyCheckpoint returns the sections of the gob encoded checkpoint at path.
+func readLegacyCheckpoint(path string) ([]section.Section, error) {
 	values := &checkPointValue{}
 	if err := util.Load(path, values); err != nil {
 		return nil, err
 	}
+	if len(values.ValidSince) != len(values.Sections) || len(values.ValidUntil) != len(values.Sections) {
+		return nil, fmt.Errorf("checkpoint %s has inconsistent validity entries", path)
+	}
 	for i, s := range values.Sections {
 		s.(section.WithSigForward).SetValidSince(values.ValidSince[i])
 		s.(section.WithSigForward).SetValidUntil(values.ValidUntil[i])
@@ -305,7 +409,7 @@ func readMsgFromFile(path string) ([]section.Section, error) {
 func isAuthoritative(s section.WithSigForward, authorities []ZoneContext) bool {
 	isAuthoritative := false
 	for _, auth := range authorities {
-		if auth.Zone == s.GetSubjectZone() && auth.Context == s.GetContext() {
+		if auth.Zone == s.GetSubjectZone() && section.EqualContexts(auth.Context, s.GetContext()) {
 			isAuthoritative = true
 			break
 		}
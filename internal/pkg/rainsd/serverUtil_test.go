@@ -0,0 +1,172 @@
+package rainsd
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestReadCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatalf("Was not able to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	newSection := func(validSince int64) section.Section {
+		a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: "."}
+		a.SetValidSince(validSince)
+		a.SetValidUntil(validSince + 100)
+		return a
+	}
+	legacy := checkPointValue{Sections: []section.Section{newSection(1000)}, ValidSince: []int64{1000},
+		ValidUntil: []int64{1100}}
+	if err := util.Save(path.Join(dir, "legacy.gob"), legacy); err != nil {
+		t.Fatalf("Was not able to write legacy checkpoint: %v", err)
+	}
+	if err := util.Save(path.Join(dir, "both.gob"), legacy); err != nil {
+		t.Fatalf("Was not able to write legacy checkpoint: %v", err)
+	}
+	if err := checkpoint(path.Join(dir, "both.cbor"), func() []section.Section {
+		return []section.Section{newSection(2000)}
+	}); err != nil {
+		t.Fatalf("Was not able to write checkpoint: %v", err)
+	}
+	var tests = []struct {
+		file       string
+		validSince int64 //0 if loading must fail
+	}{
+		{"legacy.cbor", 1000},
+		{"both.cbor", 2000}, //the current format takes precedence
+		{"missing.cbor", 0},
+	}
+	for i, test := range tests {
+		sections, err := readCheckpoint(path.Join(dir, test.file))
+		if test.validSince == 0 {
+			if err == nil {
+				t.Errorf("%d: loading a missing checkpoint succeeded", i)
+			}
+			continue
+		}
+		if err != nil || len(sections) != 1 {
+			t.Fatalf("%d: Was not able to load checkpoint: %v %v", i, sections, err)
+		}
+		s := sections[0].(section.WithSigForward)
+		if s.ValidSince() != test.validSince || s.ValidUntil() != test.validSince+100 {
+			t.Errorf("%d: validity not preserved expected=[%d,%d] actual=[%d,%d]", i,
+				test.validSince, test.validSince+100, s.ValidSince(), s.ValidUntil())
+		}
+	}
+}
+
+func TestMissingKeyMetaDataLess(t *testing.T) {
+	var tests = []struct {
+		m, data missingKeyMetaData
+		want    bool
+	}{
+		{missingKeyMetaData{"ch.", ".", 0}, missingKeyMetaData{"ch.", ".", 0}, false},
+		{missingKeyMetaData{"ch.", ".", 0}, missingKeyMetaData{"ch.", ".", 1}, true},
+		{missingKeyMetaData{"ch.", ".", 1}, missingKeyMetaData{"ch.", ".", 0}, false},
+		{missingKeyMetaData{"ch.", ".", 1}, missingKeyMetaData{"com.", ".", 0}, true},
+		{missingKeyMetaData{"com.", ".", 0}, missingKeyMetaData{"ch.", ".", 1}, false},
+		{missingKeyMetaData{"com.", ".", 0}, missingKeyMetaData{"ch.", "cx-", 0}, true},
+		{missingKeyMetaData{"ch.", "cx-", 0}, missingKeyMetaData{"com.", ".", 0}, false},
+	}
+	for i, test := range tests {
+		if less := test.m.less(test.data); less != test.want {
+			t.Errorf("%d: wrong order of %v and %v expected=%v actual=%v", i, test.m, test.data,
+				test.want, less)
+		}
+	}
+}
+
+func TestSortedMissingKeys(t *testing.T) {
+	sorted := []missingKeyMetaData{
+		{"ch.", ".", 0},
+		{"ch.", ".", 1},
+		{"com.", ".", 0},
+		{"ethz.ch.", ".", 0},
+		{"ch.", "cx-", 0},
+	}
+	for i := 0; i < 20; i++ {
+		missingKeys := make(map[missingKeyMetaData]bool)
+		for _, j := range rand.Perm(len(sorted)) {
+			missingKeys[sorted[j]] = true
+		}
+		if result := sortedMissingKeys(missingKeys); !reflect.DeepEqual(result, sorted) {
+			t.Fatalf("%d: wrong order expected=%v actual=%v", i, sorted, result)
+		}
+	}
+}
+
+func TestSortedSigMetaData(t *testing.T) {
+	sorted := []signature.MetaData{
+		{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeyPhase: 0}, ValidSince: 1},
+		{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeyPhase: 0}, ValidSince: 2},
+		{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeyPhase: 0}, ValidSince: 2,
+			ValidUntil: 3},
+		{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519, KeyPhase: 1}},
+		{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed448, KeyPhase: 0}},
+	}
+	for i := 0; i < 20; i++ {
+		metaData := make(map[signature.MetaData]bool)
+		for _, j := range rand.Perm(len(sorted)) {
+			metaData[sorted[j]] = true
+		}
+		if result := sortedSigMetaData(metaData); !reflect.DeepEqual(result, sorted) {
+			t.Fatalf("%d: wrong order expected=%v actual=%v", i, sorted, result)
+		}
+	}
+}
+
+func TestLoadConfigNotYetValid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("Was not able to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	var tests = []struct {
+		content   string
+		policy    NotYetValidPolicy
+		horizon   time.Duration
+		maxParked int
+	}{
+		{`{}`, ParkNotYetValid, time.Hour, 1000}, //written before sections could be parked
+		{`{"AcceptNotYetValid": "reject", "NotYetValidHorizon": 60, "MaxParkedSections": 10}`,
+			RejectNotYetValid, time.Minute, 10},
+		{`{"NotYetValidHorizon": 0, "MaxParkedSections": 0}`, ParkNotYetValid, 0, 0},
+	}
+	for i, test := range tests {
+		configPath := path.Join(dir, "server.conf")
+		if err := ioutil.WriteFile(configPath, []byte(test.content), 0600); err != nil {
+			t.Fatalf("%d: Was not able to write config: %v", i, err)
+		}
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			t.Fatalf("%d: Was not able to load config: %v", i, err)
+		}
+		if config.AcceptNotYetValid != test.policy || config.NotYetValidHorizon != test.horizon ||
+			config.MaxParkedSections != test.maxParked {
+			t.Errorf("%d: wrong settings expected=%s,%v,%d actual=%s,%v,%d", i, test.policy,
+				test.horizon, test.maxParked, config.AcceptNotYetValid, config.NotYetValidHorizon,
+				config.MaxParkedSections)
+		}
+	}
+	config, err := LoadConfig("../../../cmd/rainsd/config/server-tcp.conf")
+	if err != nil {
+		t.Fatalf("Was not able to load the shipped config: %v", err)
+	}
+	if config.AcceptNotYetValid != ParkNotYetValid || config.NotYetValidHorizon != time.Hour ||
+		config.MaxParkedSections != 1000 {
+		t.Errorf("wrong settings in the shipped config: %v", config)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"syscall"
 	"time"
 
 	log "github.com/inconshreveable/log15"
@@ -27,6 +29,13 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 	backoffMilliSeconds int) (err error) {
 	// In any case we add the capabilities of this server to the message.
 	msg.Capabilities = []message.Capability{message.Capability(s.capabilityHash)}
+	msgs, err := s.zoneChunks(msg, receiver)
+	if err != nil {
+		return fmt.Errorf("failed to split zone into chunks: %v", err)
+	}
+	if err := truncateMsgs(msgs, maxMsgBytes(s.config.MaxMsgByteLength, receiver), receiver); err != nil {
+		return err
+	}
 	// SCION is a special case because it is operating on a connectionless protocol so we
 	// keep the server socket in the Server struct and use that to send.
 	if saddr, ok := receiver.(*snet.Addr); ok {
@@ -34,13 +43,15 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 		if conn == nil {
 			return errors.New("underlying scion connection was nil")
 		}
-		encoding := new(bytes.Buffer)
-		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
-			return fmt.Errorf("failed to marshal message to conn: %v", err)
-		}
-		if _, err := conn.WriteToSCION(encoding.Bytes(), saddr); err != nil {
-			log.Warn("Was not able to send encoded message")
-			return fmt.Errorf("unable to send encoded message: %v", err)
+		for i := range msgs {
+			encoding := new(bytes.Buffer)
+			if err := cbor.NewWriter(encoding).Marshal(&msgs[i]); err != nil {
+				return fmt.Errorf("failed to marshal message to conn: %v", err)
+			}
+			if _, err := conn.WriteToSCION(encoding.Bytes(), saddr); err != nil {
+				log.Warn("Was not able to send encoded message")
+				return fmt.Errorf("unable to send encoded message: %v", err)
+			}
 		}
 		return nil
 	}
@@ -55,16 +66,24 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 				log.Warn("Could not establish connection", "error", err, "receiver", receiver)
 				return err
 			}
-			s.caches.ConnCache.AddConnection(conn)
+			if !s.caches.ConnCache.AddConnection(conn) {
+				//another goroutine opened a connection to receiver in the mean time.
+				conn.Close()
+				if conns, ok = s.caches.ConnCache.GetConnection(receiver); !ok {
+					return fmt.Errorf("no connection to %v could be cached or reused", receiver)
+				}
+				break
+			}
 			//handle connection
 			if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
 				go s.handleConnection(conn, tcpAddr)
 			} else {
 				log.Warn("Type assertion failed. Expected *net.TCPAddr", "addr", conn.RemoteAddr())
 			}
-			//add capabilities to message
-			msg.Capabilities = []message.Capability{message.Capability(s.capabilityHash)}
 			conns = []net.Conn{conn}
+		case *net.UnixAddr:
+			//clients of a Unix socket cannot be dialed, the connection has been closed by the client.
+			return fmt.Errorf("no connection to Unix socket client %v", receiver)
 		}
 	}
 	for _, conn := range conns {
@@ -74,7 +93,13 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 		//receiver only listens for one message. Is there a way for the receiver to determine when a
 		//message is processed and then stop listening?
 		encoding := new(bytes.Buffer)
-		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		writer := cbor.NewWriter(encoding)
+		for i := range msgs {
+			if err = writer.Marshal(&msgs[i]); err != nil {
+				break
+			}
+		}
+		if err != nil {
 			log.Warn(fmt.Sprintf("failed to marshal message to conn: %v", err))
 			s.caches.ConnCache.CloseAndRemoveConnection(conn)
 			continue
@@ -93,6 +118,60 @@ func (s *Server) sendTo(msg message.Message, receiver net.Addr, retries,
 	return errors.New("Was not able to send the mesage. No retries left")
 }
 
+//zoneChunks splits msg into chunks of at most ZoneChunkByteLength bytes if receiver supports
+//chunked zones. Otherwise, msg is returned as the only message.
+func (s *Server) zoneChunks(msg message.Message, receiver net.Addr) ([]message.Message, error) {
+	if s.config.ZoneChunkByteLength <= 0 {
+		return []message.Message{msg}, nil
+	}
+	if caps, _ := s.caches.ConnCache.GetCapabilityList(receiver); supportsChunkedZones(caps) {
+		return msg.Chunk(s.config.ZoneChunkByteLength)
+	}
+	return []message.Message{msg}, nil
+}
+
+//maxMsgBytes returns the maximum encoded length of a message sent to receiver given the configured
+//maxLength or 0 if the length is unlimited. Only messages sent over SCION are limited as they must
+//fit into a single packet, stream transports deliver messages of any size.
+func maxMsgBytes(maxLength int, receiver net.Addr) int {
+	if _, ok := receiver.(*snet.Addr); !ok || maxLength < 0 {
+		return 0
+	}
+	if maxLength > connection.MaxUDPPacketBytes {
+		return connection.MaxUDPPacketBytes
+	}
+	return maxLength
+}
+
+//truncateMsgs truncates each of msgs whose encoding exceeds maxBytes such that the receiver can
+//retry over a transport without a size restriction. A maxBytes of 0 disables truncation.
+func truncateMsgs(msgs []message.Message, maxBytes int, receiver net.Addr) error {
+	if maxBytes == 0 {
+		return nil
+	}
+	for i := range msgs {
+		if truncated, err := msgs[i].Truncate(maxBytes); err != nil {
+			return fmt.Errorf("failed to truncate message: %v", err)
+		} else if truncated {
+			log.Info("Message exceeds maximum length and was truncated", "receiver", receiver,
+				"maxLength", maxBytes)
+		}
+	}
+	return nil
+}
+
+//supportsChunkedZones returns true if caps contains the capability to reassemble chunked zones.
+//The capabilities are recorded for the sender's connection such that zones can be chunked when
+//answering it.
+func supportsChunkedZones(caps []message.Capability) bool {
+	for _, c := range caps {
+		if c == message.ChunkedZones {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) sendToRecursiveResolver(msg message.Message) {
 	for _, sec := range msg.Content {
 		if q, ok := sec.(*query.Name); ok {
@@ -101,6 +180,24 @@ func (s *Server) sendToRecursiveResolver(msg message.Message) {
 	}
 }
 
+//removeStaleSocket removes the socket file at addr if it has been left behind by a previous run as
+//it prevents listening on addr. A socket is only removed if connecting to it is refused. It returns
+//an error if another process is listening on addr.
+func removeStaleSocket(addr *net.UnixAddr) error {
+	if info, err := os.Stat(addr.Name); err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.Dial(addr.Network(), addr.Name)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("another process is listening on %s", addr.Name)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return os.Remove(addr.Name)
+	}
+	return nil
+}
+
 //createConnection establishes a connection with receiver
 func createConnection(receiver net.Addr, keepAlive time.Duration, pool *x509.CertPool) (net.Conn, error) {
 	switch receiver.(type) {
@@ -152,13 +249,59 @@ func (s *Server) listen(id string) {
 			if isIPBlacklisted(conn.RemoteAddr()) {
 				continue
 			}
-			s.caches.ConnCache.AddConnection(conn)
+			if !s.caches.ConnCache.AddConnection(conn) {
+				//the cache already holds the maximum number of connections to this peer.
+				log.Warn("Could not cache connection, closing it", "remoteAddr", conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
 			if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
 				go s.handleConnection(conn, tcpAddr)
 			} else {
 				log.Warn("Type assertion failed. Expected *net.TCPAddr", "addr", conn.RemoteAddr())
 			}
 		}
+	case connection.Unix:
+		addr, ok := s.config.ServerAddress.Addr.(*net.UnixAddr)
+		if !ok {
+			log.Warn(fmt.Sprintf("Type assertion failed. Expected *net.UnixAddr, got %T", addr))
+			return
+		}
+		if err := removeStaleSocket(addr); err != nil {
+			srvLogger.Error("Unix socket is in use", "socket", addr.Name, "error", err)
+			return
+		}
+		srvLogger.Info("Start Unix socket listener")
+		listener, err := net.Listen(addr.Network(), addr.Name)
+		if err != nil {
+			srvLogger.Error("Listener error on startup", "error", err)
+			return
+		}
+		defer listener.Close()
+		defer srvLogger.Info("Unix socket Shutdown listener")
+		for clientID := 0; ; clientID++ {
+			select {
+			case <-s.shutdown:
+				// break out of the loop when receiving shutdown
+				srvLogger.Info("Received shutdown signal from Unix socket")
+				return
+			default:
+			}
+			conn, err := listener.Accept()
+			if err != nil {
+				srvLogger.Error("listener could not accept connection", "error", err)
+				continue
+			}
+			//clients are unnamed, a unique address keeps their answers apart.
+			clientAddr := &net.UnixAddr{Name: fmt.Sprintf("%s#%d", addr.Name, clientID), Net: addr.Net}
+			unixConn := connection.NewUnixConn(conn, clientAddr)
+			if !s.caches.ConnCache.AddConnection(unixConn) {
+				log.Warn("Could not cache connection, closing it", "remoteAddr", clientAddr)
+				unixConn.Close()
+				continue
+			}
+			go s.handleConnection(unixConn, clientAddr)
+		}
 	case connection.SCION:
 		addr, ok := s.config.ServerAddress.Addr.(*snet.Addr)
 		if !ok {
@@ -230,6 +373,9 @@ func (s *Server) handleConnection(conn net.Conn, dstAddr net.Addr) {
 			}
 			break
 		}
+		if supportsChunkedZones(msg.Capabilities) {
+			s.caches.ConnCache.AddCapabilityList(conn.RemoteAddr(), msg.Capabilities)
+		}
 		deliver(&msg, conn.RemoteAddr(),
 			s.queues.Prio, s.queues.Normal, s.queues.Notify, s.caches.PendingKeys)
 	}
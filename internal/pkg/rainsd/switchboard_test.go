@@ -0,0 +1,120 @@
+package rainsd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/scionproto/scion/go/lib/snet"
+
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
+	"github.com/netsec-ethz/rains/internal/pkg/message"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+)
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatalf("Was not able to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stale := &net.UnixAddr{Name: path.Join(dir, "stale.sock"), Net: "unix"}
+	l, err := net.ListenUnix("unix", stale)
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	live := &net.UnixAddr{Name: path.Join(dir, "live.sock"), Net: "unix"}
+	l, err = net.ListenUnix("unix", live)
+	if err != nil {
+		t.Fatalf("Was not able to listen: %v", err)
+	}
+	defer l.Close()
+	file := &net.UnixAddr{Name: path.Join(dir, "file"), Net: "unix"}
+	if err := ioutil.WriteFile(file.Name, nil, 0600); err != nil {
+		t.Fatalf("Was not able to create file: %v", err)
+	}
+	var tests = []struct {
+		addr    *net.UnixAddr
+		removed bool
+		valid   bool
+	}{
+		{stale, true, true},
+		{live, false, false},
+		{file, false, true},
+		{&net.UnixAddr{Name: path.Join(dir, "missing.sock"), Net: "unix"}, true, true},
+	}
+	for i, test := range tests {
+		err := removeStaleSocket(test.addr)
+		if (err == nil) != test.valid {
+			t.Errorf("%d: wrong result for %s expected valid=%v error=%v", i, test.addr.Name,
+				test.valid, err)
+		}
+		if _, err := os.Stat(test.addr.Name); os.IsNotExist(err) != test.removed {
+			t.Errorf("%d: wrong removal of %s expected=%v", i, test.addr.Name, test.removed)
+		}
+	}
+}
+
+func TestTruncateMsgs(t *testing.T) {
+	const largeAnswer = 5000 //number of assertions encoded in more than 64 KiB
+	tcpAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5022}
+	unixAddr := &net.UnixAddr{Name: "/tmp/rains.sock", Net: "unix"}
+	scionAddr := &snet.Addr{}
+	var tests = []struct {
+		maxLength   int
+		receiver    net.Addr
+		nAssertions int
+		maxBytes    int
+		truncated   bool
+	}{
+		{65536, tcpAddr, largeAnswer, 0, false}, //answers over 64 KiB arrive whole over stream transports
+		{65536, unixAddr, largeAnswer, 0, false},
+		{200, tcpAddr, 20, 0, false},
+		{200, scionAddr, 20, 200, true},
+		{0, scionAddr, 20, 0, false},
+		{-1, scionAddr, 20, 0, false},
+		{65536, scionAddr, 20, connection.MaxUDPPacketBytes, false},
+		{65536, scionAddr, largeAnswer, connection.MaxUDPPacketBytes, true},
+	}
+	for i, test := range tests {
+		maxBytes := maxMsgBytes(test.maxLength, test.receiver)
+		if maxBytes != test.maxBytes {
+			t.Errorf("%d: wrong maximum length expected=%d actual=%d", i, test.maxBytes, maxBytes)
+		}
+		var content []section.Section
+		for j := 0; j < test.nAssertions; j++ {
+			content = append(content, &section.Assertion{SubjectName: fmt.Sprintf("www%d", j),
+				SubjectZone: "ethz.ch.", Context: "."})
+		}
+		msgs := []message.Message{message.Message{Token: token.New(), Content: content}}
+		if err := truncateMsgs(msgs, maxBytes, test.receiver); err != nil {
+			t.Fatalf("%d: Was not able to truncate messages: %v", i, err)
+		}
+		if msgs[0].IsTruncated() != test.truncated {
+			t.Errorf("%d: wrong truncation expected=%v actual=%v", i, test.truncated,
+				msgs[0].IsTruncated())
+		}
+		if !test.truncated && len(msgs[0].Content) != test.nAssertions {
+			t.Errorf("%d: answer was not sent whole expected=%d actual=%d sections", i,
+				test.nAssertions, len(msgs[0].Content))
+		}
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(&msgs[0]); err != nil {
+			t.Fatalf("%d: Was not able to marshal message: %v", i, err)
+		}
+		if test.nAssertions == largeAnswer && !test.truncated && encoding.Len() <= 65536 {
+			t.Errorf("%d: answer is not larger than 64 KiB: %d", i, encoding.Len())
+		}
+		if maxBytes > 0 && encoding.Len() > maxBytes {
+			t.Errorf("%d: message exceeds the maximum length %d: %d", i, maxBytes, encoding.Len())
+		}
+	}
+}
@@ -3,6 +3,7 @@ package rainsd
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/inconshreveable/log15"
@@ -24,21 +25,20 @@ import (
 //signature remains on an assertion, shard, zone, addressAssertion or addressZone it gets dropped
 //(signatures of contained sections are not taken into account). If there happens an error in the
 //signature verification process of any signature, the whole msgSender gets dropped (signatures of
-//contained sections are also considered)
+//contained sections are also considered). An authoritative server rejects sections outside of its
+//authority with a notification unless they answer a query it issued.
 func (s *Server) verify(msgSender util.MsgSectionSender) {
 	log.Info(fmt.Sprintf("Verify %T", msgSender.Sections), "server", s.Addr(), "util.MsgSectionSender", msgSender)
 	//msgSender.Sections contains either Queries or Assertions. It gets separated in the inbox.
 	switch msgSender.Sections[0].(type) {
 	case *section.Assertion, *section.Shard, *section.Pshard, *section.Zone:
-		isAuthoritative := hasAuthority(msgSender, s)
-		if len(s.config.Authorities) != 0 {
-			//An authoritative server drops all messages containing sections over which it has no
-			//authority and are not a response to a query issued by this server
-			if !isAuthoritative && !s.caches.PendingKeys.ContainsToken(msgSender.Token) {
-				log.Info("Drop message not part of authority", "msgSender", msgSender)
-				return
-			}
+		if n := authorityNotification(msgSender, s.config.Authorities, s.caches.PendingKeys); n != nil {
+			log.Warn("Reject message not part of authority", "sender", msgSender.Sender,
+				"reason", n.Data, "authorities", s.config.Authorities)
+			sendNotificationMsg(msgSender.Token, msgSender.Sender, n.Type, n.Data, s)
+			return
 		}
+		isAuthoritative := outOfAuthority(msgSender, s.config.Authorities) == nil
 		verifySections(msgSender, s, isAuthoritative)
 	case *query.Name:
 		verifyQueries(msgSender, s)
@@ -47,13 +47,33 @@ func (s *Server) verify(msgSender util.MsgSectionSender) {
 	}
 }
 
-func hasAuthority(msgSender util.MsgSectionSender, s *Server) bool {
+//outOfAuthority returns the first section of msgSender whose subject zone and context are not
+//covered by authorities or nil if the server is authoritative for all sections.
+func outOfAuthority(msgSender util.MsgSectionSender, authorities []ZoneContext) section.WithSigForward {
 	for _, sec := range msgSender.Sections {
-		if !isAuthoritative(sec.(section.WithSigForward), s.config.Authorities) {
-			return false
+		if sec := sec.(section.WithSigForward); !isAuthoritative(sec, authorities) {
+			return sec
 		}
 	}
-	return true
+	return nil
+}
+
+//authorityNotification returns the notification with which an authoritative server rejects
+//msgSender or nil if msgSender is accepted. An authoritative server rejects all messages containing
+//sections over which it has no authority and are not a response to a query issued by this server.
+//Otherwise, a peer could inject data outside of the server's authority.
+func authorityNotification(msgSender util.MsgSectionSender, authorities []ZoneContext,
+	pendingKeys cache.PendingKey) *section.Notification {
+	if len(authorities) == 0 {
+		return nil
+	}
+	outside := outOfAuthority(msgSender, authorities)
+	if outside == nil || pendingKeys.ContainsToken(msgSender.Token) {
+		return nil
+	}
+	return &section.Notification{Type: section.NTRcvInconsistentMsg, Token: msgSender.Token,
+		Data: fmt.Sprintf("zone %s in context %s is outside of the server's authority",
+			outside.GetSubjectZone(), outside.GetContext())}
 }
 
 //verifySections first checks the internal consistency of all sections. It then determines if all
@@ -76,7 +96,8 @@ func verifySections(ss util.MsgSectionSender, s *Server, isAuthoritative bool) {
 				"invalid context", s)
 			return //already logged, that context is invalid
 		}
-		publicKeysPresent(sec, s.caches.ZoneKeyCache, keys, missingKeys)
+		publicKeysPresent(sec, s.caches.ZoneKeyCache, keys, missingKeys,
+			s.config.VerifyObjectSignatures)
 	}
 	if len(missingKeys) != 0 {
 		handleMissingKeys(ss, missingKeys, s, isAuthoritative)
@@ -85,36 +106,165 @@ func verifySections(ss util.MsgSectionSender, s *Server, isAuthoritative bool) {
 
 	log.Info("All public keys are present.", "msgSectionWithSig", ss.Sections)
 	if sections, ok := verifySignatures(ss, keys, s); ok {
-		s.assert(util.SectionWithSigSender{
+		assertValidSections(util.SectionWithSigSender{
 			Sender:   ss.Sender,
 			Token:    ss.Token,
 			Sections: sections,
-		})
+		}, s)
 		return
 	}
 	log.Info("Invalid signature")
 }
 
-//verifyQueries forwards the received query to be processed if it is consistent and not expired.
+//assertValidSections forwards the sections of ss which are already valid to the engine. The
+//sections whose validity starts in the future are handled according to the configured
+//NotYetValidPolicy such that they are not served early unless the policy explicitly allows it.
+func assertValidSections(ss util.SectionWithSigSender, s *Server) {
+	now := time.Now().Unix()
+	valid := []section.WithSigForward{}
+	for _, sec := range ss.Sections {
+		switch notYetValidAction(sec.ValidSince(), now, s.config.AcceptNotYetValid,
+			s.config.NotYetValidHorizon) {
+		case assertSection:
+			valid = append(valid, sec)
+		case parkSection:
+			parked := util.SectionWithSigSender{Sender: ss.Sender, Token: ss.Token,
+				Sections: []section.WithSigForward{sec}}
+			if s.parked.add(parked) {
+				log.Info("Park section until it becomes valid", "validSince", sec.ValidSince(),
+					"section", sec)
+				continue
+			}
+			log.Warn("Drop section which is not yet valid. Parked section cache is full",
+				"validSince", sec.ValidSince(), "section", sec)
+		default:
+			log.Warn("Drop section which is not yet valid", "validSince", sec.ValidSince(),
+				"section", sec)
+		}
+	}
+	if len(valid) > 0 {
+		ss.Sections = valid
+		s.assert(ss)
+	}
+}
+
+//sectionAction defines how a verified section is handled depending on the start of its validity.
+type sectionAction int
+
+const (
+	assertSection sectionAction = iota
+	parkSection
+	dropSection
+)
+
+//notYetValidAction returns how a section valid since validSince is handled at now according to
+//policy. A section is only parked if it becomes valid within horizon. A horizon of 0 does not
+//restrict parking.
+func notYetValidAction(validSince, now int64, policy NotYetValidPolicy,
+	horizon time.Duration) sectionAction {
+	switch {
+	case validSince <= now || policy == ServeNotYetValid:
+		return assertSection
+	case (policy == ParkNotYetValid || policy == "") &&
+		(horizon == 0 || validSince-now <= int64(horizon/time.Second)):
+		return parkSection
+	default:
+		return dropSection
+	}
+}
+
+//parkedSections holds verified sections until they become valid.
+type parkedSections struct {
+	mu sync.Mutex
+	//size is the number of sections held at most. 0 disables the limit.
+	size     int
+	sections []util.SectionWithSigSender
+}
+
+func newParkedSections(size int) *parkedSections {
+	return &parkedSections{size: size}
+}
+
+//add parks ss which must contain a single section. It returns false if the cache is full.
+func (p *parkedSections) add(ss util.SectionWithSigSender) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size > 0 && len(p.sections) >= p.size {
+		return false
+	}
+	p.sections = append(p.sections, ss)
+	return true
+}
+
+//removeValid removes and returns the parked sections which are valid at now.
+func (p *parkedSections) removeValid(now int64) []util.SectionWithSigSender {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var valid []util.SectionWithSigSender
+	parked := p.sections[:0]
+	for _, ss := range p.sections {
+		if ss.Sections[0].ValidSince() <= now {
+			valid = append(valid, ss)
+		} else {
+			parked = append(parked, ss)
+		}
+	}
+	for i := len(parked); i < len(p.sections); i++ {
+		p.sections[i] = util.SectionWithSigSender{}
+	}
+	p.sections = parked
+	return valid
+}
+
+//assertParkedSections forwards the parked sections which became valid to the engine.
+func (s *Server) assertParkedSections() {
+	for _, ss := range s.parked.removeValid(time.Now().Unix()) {
+		log.Info("Parked section became valid", "section", ss.Sections[0])
+		s.assert(ss)
+	}
+}
+
+//verifyQueries forwards the received query to be processed if it is consistent and not expired. A
+//query is inconsistent if its context is malformed or its options contradict each other. A query
+//without expiration expires after the configured QueryValidity. Queries in a message with an
+//all-zero token are malformed as their answers could not be told apart from those to other such
+//queries.
 func verifyQueries(msgSender util.MsgSectionSender, s *Server) {
-	for i, q := range msgSender.Sections {
-		q := q.(*query.Name)
+	if msgSender.Token.IsZero() {
+		log.Warn("Query token is zero", "sender", msgSender.Sender)
+		sendNotificationMsg(msgSender.Token, msgSender.Sender, section.NTRcvInconsistentMsg,
+			"query token must not be zero", s)
+		return
+	}
+	queries := []section.Section{}
+	for _, q := range msgSender.Sections {
+		q := q.(*query.Name).WithDefaultExpiration(s.config.QueryValidity)
 		if contextInvalid(q.GetContext()) {
 			sendNotificationMsg(msgSender.Token, msgSender.Sender, section.NTRcvInconsistentMsg,
 				"invalid context", s)
 			return //already logged, that context is invalid
 		}
-		if isQueryExpired(q.GetExpiration()) {
-			msgSender.Sections = append(msgSender.Sections[:i], msgSender.Sections[i+1:]...)
+		if err := q.OptionsConsistent(); err != nil {
+			log.Warn("Query options are inconsistent", "query", q, "error", err)
+			sendNotificationMsg(msgSender.Token, msgSender.Sender, section.NTRcvInconsistentMsg,
+				err.Error(), s)
+			return
+		}
+		if !isQueryExpired(q.GetExpiration()) {
+			queries = append(queries, q)
 		}
 	}
+	if len(queries) == 0 {
+		return
+	}
+	msgSender.Sections = queries
 	s.processQuery(msgSender)
 }
 
 //contextInvalid return true if it is not the global context and the context does not contain a
 //context marker '-cx'.
 func contextInvalid(context string) bool {
-	if context != "." && !strings.Contains(context, "cx-") {
+	if !section.IsGlobalContext(context) && !strings.Contains(context, "cx-") {
 		log.Warn("Context is malformed.", "context", context)
 		return true
 	}
@@ -132,12 +282,17 @@ func isQueryExpired(expires int64) bool {
 }
 
 //publicKeysPresent adds all public keys that are cached to keys and for all that are not, the
-//corresponding signature meta data is added to missingKeys
+//corresponding signature meta data is added to missingKeys. The keys of per-object signatures are
+//only considered if objectKeys is true.
 func publicKeysPresent(s section.WithSigForward, zoneKeyCache cache.ZonePublicKey,
-	keys map[keys.PublicKeyID][]keys.PublicKey, missingKeys map[missingKeyMetaData]bool) {
+	keys map[keys.PublicKeyID][]keys.PublicKey, missingKeys map[missingKeyMetaData]bool,
+	objectKeys bool) {
 	keysNeeded := make(map[signature.MetaData]bool)
 	s.NeededKeys(keysNeeded)
-	for sigData := range keysNeeded {
+	if objectKeys {
+		section.NeededObjectKeys(s, keysNeeded)
+	}
+	for _, sigData := range sortedSigMetaData(keysNeeded) {
 		if key, _, ok := zoneKeyCache.Get(s.GetSubjectZone(), s.GetContext(), sigData); ok {
 			//returned public key is guaranteed to be valid
 			log.Debug("Corresponding Public key in cache.", "cacheKey=sigMetaData", sigData, "publicKey", key)
@@ -145,21 +300,47 @@ func publicKeysPresent(s section.WithSigForward, zoneKeyCache cache.ZonePublicKe
 		} else {
 			log.Debug("Public key not in zoneKeyCache", "zone", s.GetSubjectZone(),
 				"cacheKey=sigMetaData", sigData)
-			missingKeys[missingKeyMetaData{Zone: s.GetSubjectZone(), Context: s.GetContext(),
-				KeyPhase: sigData.KeyPhase}] = true
+			missingKeys[missingKeyMetaData{Zone: s.GetSubjectZone(),
+				Context: section.CanonicalContext(s.GetContext()), KeyPhase: sigData.KeyPhase}] = true
 		}
 	}
 }
 
-//verifySignatures verifies all signatures of ss.Section and strips off expired signatures. It
-//returns false if there is no signature left any of the messages
-func verifySignatures(ss util.MsgSectionSender, keys map[keys.PublicKeyID][]keys.PublicKey, s *Server) (
+//verifySignatures verifies all signatures of ss.Section and strips off expired signatures. If
+//configured, per-object signatures are verified as well. The settings of the zone policy matching
+//a section's zone apply. In lenient zone verification mode, an assertion of a zone whose signature
+//is invalid does not fail the zone. It returns false if there is no signature left any of the
+//messages or if fewer signatures than the policy requires are valid.
+func verifySignatures(ss util.MsgSectionSender, pkeys map[keys.PublicKeyID][]keys.PublicKey, s *Server) (
 	[]section.WithSigForward, bool) {
 	sections := []section.WithSigForward{}
 	for _, sec := range ss.Sections {
 		sec := sec.(section.WithSigForward)
 		sections = append(sections, sec)
-		if !siglib.CheckSectionSignatures(sec, keys, s.config.MaxCacheValidity) {
+		policy := s.config.zonePolicy(sec.GetSubjectZone())
+		if !siglib.DropWeakSignatures(sec, policy.MinSignatureAlgorithm) {
+			return nil, false
+		}
+		if policy.MaxFutureValidSince > 0 &&
+			!siglib.DropFutureSignatures(sec, policy.MaxFutureValidSince) {
+			return nil, false
+		}
+		if z, ok := sec.(*section.Zone); ok && s.config.ZoneVerificationMode == LenientZoneVerification {
+			if !siglib.CheckZoneSignaturesLenient(z, pkeys, s.config.MaxCacheValidity,
+				s.config.KeyGracePeriod) {
+				return nil, false
+			}
+		} else if !siglib.CheckSectionSignaturesWithGrace(sec, pkeys, s.config.MaxCacheValidity,
+			s.config.KeyGracePeriod) {
+			return nil, false
+		}
+		if n := len(sec.Sigs(keys.RainsKeySpace)); n < policy.MinValidSignatures {
+			log.Warn("Section has too few valid signatures", "zone", sec.GetSubjectZone(),
+				"valid", n, "required", policy.MinValidSignatures)
+			return nil, false
+		}
+		if s.config.VerifyObjectSignatures &&
+			!siglib.CheckObjectSignatures(sec, pkeys, s.config.MaxCacheValidity) {
 			return nil, false
 		}
 	}
@@ -176,9 +357,13 @@ func handleMissingKeys(ss util.MsgSectionSender, missingKeys map[missingKeyMetaD
 	exp := getQueryValidity(sec[0].(section.WithSigForward).Sigs(keys.RainsKeySpace),
 		s.config.DelegationQueryValidity)
 	t := token.New()
-	s.caches.PendingKeys.Add(ss, t, exp)
+	if !s.caches.PendingKeys.Add(ss, t, exp) {
+		log.Info("Delegation query not sent. Section is parked or pending key cache is full", "sections",
+			ss.Sections)
+		return
+	}
 	queries := []section.Section{}
-	for k := range missingKeys {
+	for _, k := range sortedMissingKeys(missingKeys) {
 		log.Info("MissingKeys", "key", k)
 		queries = append(queries, &query.Name{
 			Name:       k.Zone,
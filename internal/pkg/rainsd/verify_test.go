@@ -0,0 +1,218 @@
+package rainsd
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/cache"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/section"
+	"github.com/netsec-ethz/rains/internal/pkg/siglib"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+	"github.com/netsec-ethz/rains/internal/pkg/token"
+	"github.com/netsec-ethz/rains/internal/pkg/util"
+)
+
+func TestNotYetValidAction(t *testing.T) {
+	now := time.Now().Unix()
+	var tests = []struct {
+		validSince int64
+		policy     NotYetValidPolicy
+		horizon    time.Duration
+		want       sectionAction
+	}{
+		{now, RejectNotYetValid, time.Hour, assertSection},
+		{now - 10, ParkNotYetValid, time.Hour, assertSection},
+		{now + 10, ServeNotYetValid, time.Hour, assertSection},
+		{now + 10, ParkNotYetValid, time.Hour, parkSection},
+		{now + 3600, ParkNotYetValid, time.Hour, parkSection},
+		{now + 3601, ParkNotYetValid, time.Hour, dropSection}, //beyond the horizon
+		{now + 3601, ParkNotYetValid, 0, parkSection},
+		{now + 10, RejectNotYetValid, time.Hour, dropSection},
+		{now + 10, "", time.Hour, parkSection}, //config files without a policy
+		{now + 10, "unknown", time.Hour, dropSection},
+	}
+	for i, test := range tests {
+		if action := notYetValidAction(test.validSince, now, test.policy, test.horizon); action != test.want {
+			t.Errorf("%d: wrong action expected=%d actual=%d", i, test.want, action)
+		}
+	}
+}
+
+func TestParkedSections(t *testing.T) {
+	now := time.Now().Unix()
+	parkedSection := func(validSince int64) util.SectionWithSigSender {
+		a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: "."}
+		a.SetValidSince(validSince)
+		return util.SectionWithSigSender{Sections: []section.WithSigForward{a}}
+	}
+	parked := newParkedSections(3)
+	for i, validSince := range []int64{now + 20, now + 10, now + 30} {
+		if !parked.add(parkedSection(validSince)) {
+			t.Fatalf("%d: section not parked", i)
+		}
+	}
+	if parked.add(parkedSection(now + 10)) {
+		t.Error("section parked beyond the cache size")
+	}
+	var tests = []struct {
+		now  int64
+		want []int64 //validSince of the returned sections
+	}{
+		{now, nil},
+		{now + 20, []int64{now + 20, now + 10}},
+		{now + 25, nil},
+		{now + 30, []int64{now + 30}},
+	}
+	for i, test := range tests {
+		valid := parked.removeValid(test.now)
+		if len(valid) != len(test.want) {
+			t.Fatalf("%d: wrong number of valid sections expected=%d actual=%d", i, len(test.want),
+				len(valid))
+		}
+		for j, ss := range valid {
+			if ss.Sections[0].ValidSince() != test.want[j] {
+				t.Errorf("%d: wrong section expected validSince=%d actual=%d", i, test.want[j],
+					ss.Sections[0].ValidSince())
+			}
+		}
+	}
+	if !parked.add(parkedSection(now + 10)) {
+		t.Error("section not parked after the cache has been emptied")
+	}
+}
+
+func TestVerifySignaturesMinValidSignatures(t *testing.T) {
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	privateKeys := make(map[keys.PublicKeyID]interface{})
+	var sigs []keys.PublicKeyID
+	for phase := 0; phase < 2; phase++ {
+		pub, priv, _ := ed25519.GenerateKey(nil)
+		sig := section.Signature()
+		sig.KeyPhase = phase
+		pkeys[sig.PublicKeyID] = []keys.PublicKey{keys.PublicKey{PublicKeyID: sig.PublicKeyID,
+			ValidSince: time.Now().Add(-time.Hour).Unix(), ValidUntil: time.Now().Add(time.Hour).Unix(),
+			Key: pub}}
+		privateKeys[sig.PublicKeyID] = priv
+		sigs = append(sigs, sig.PublicKeyID)
+	}
+	s := &Server{config: Config{
+		MinValidSignatures: 2,
+		ZonePolicies: map[string]ZonePolicy{
+			"ethz.ch.": ZonePolicy{MinValidSignatures: 1},
+			"ed448.ch.": ZonePolicy{MinValidSignatures: 1,
+				MinSignatureAlgorithm: algorithmTypes.Ed448},
+		},
+		MaxCacheValidity: util.MaxCacheValidity{AssertionValidity: time.Hour},
+	}}
+	var tests = []struct {
+		zone  string
+		nSigs int
+		valid bool
+	}{
+		{"ch.", 2, true},
+		{"ch.", 1, false},
+		{"ethz.ch.", 1, true},
+		{"inf.ethz.ch.", 1, true},
+		{"ed448.ch.", 2, false}, //only verifies under an algorithm below the minimum
+	}
+	for i, test := range tests {
+		a := &section.Assertion{SubjectName: "www", SubjectZone: test.zone, Context: ".",
+			Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")}}}
+		for _, id := range sigs[:test.nSigs] {
+			sig := section.Signature()
+			sig.PublicKeyID = id
+			a.AddSig(sig)
+		}
+		if err := siglib.SignSectionUnsafe(a, privateKeys); err != nil {
+			t.Fatalf("%d: Was not able to sign assertion: %v", i, err)
+		}
+		ss := util.MsgSectionSender{Sections: []section.Section{a}}
+		if _, valid := verifySignatures(ss, pkeys, s); valid != test.valid {
+			t.Errorf("%d: wrong verification result for %d signatures in %s expected=%v", i,
+				test.nSigs, test.zone, test.valid)
+		}
+	}
+}
+
+func TestAuthorityNotification(t *testing.T) {
+	authorities := []ZoneContext{ZoneContext{Zone: "ch.", Context: "."}}
+	pendingToken := token.New()
+	pendingKeys := cache.NewPendingKey(10, 0)
+	pendingKeys.Add(util.MsgSectionSender{}, pendingToken, time.Now().Add(time.Minute).Unix())
+	assertion := func(zone, context string) section.Section {
+		return &section.Assertion{SubjectName: "ethz", SubjectZone: zone, Context: context}
+	}
+	var tests = []struct {
+		authorities []ZoneContext
+		sections    []section.Section
+		tok         token.Token
+		reject      bool
+	}{
+		{authorities, []section.Section{assertion("ch.", ".")}, token.New(), false},
+		{authorities, []section.Section{assertion("ch.", "")}, token.New(), false}, //global context
+		{authorities, []section.Section{assertion("com.", ".")}, token.New(), true},
+		{authorities, []section.Section{assertion("ch.", "cx-")}, token.New(), true},
+		{authorities, []section.Section{assertion("ch.", "."), assertion("com.", ".")}, token.New(),
+			true},
+		{authorities, []section.Section{&section.Shard{SubjectZone: "com.", Context: "."}},
+			token.New(), true},
+		{authorities, []section.Section{assertion("com.", ".")}, pendingToken, false}, //answer to a key query
+		{nil, []section.Section{assertion("com.", ".")}, token.New(), false},          //not authoritative
+	}
+	for i, test := range tests {
+		ss := util.MsgSectionSender{Sections: test.sections, Token: test.tok}
+		n := authorityNotification(ss, test.authorities, pendingKeys)
+		if !test.reject {
+			if n != nil {
+				t.Errorf("%d: section within the authority rejected: %v", i, n)
+			}
+			continue
+		}
+		if n == nil {
+			t.Errorf("%d: section outside of the authority accepted", i)
+		} else if n.Type != section.NTRcvInconsistentMsg || n.Token != test.tok {
+			t.Errorf("%d: wrong notification expected type=%v token=%v actual=%v", i,
+				section.NTRcvInconsistentMsg, test.tok, n)
+		}
+	}
+}
+
+func TestPublicKeysPresentObjectKeys(t *testing.T) {
+	sectionSig := section.Signature()
+	sectionSig.KeyPhase = 2
+	objectSig := section.Signature()
+	objectSig.KeyPhase = 3
+	var tests = []struct {
+		objectKeys bool
+		want       []int //key phases of the missing keys
+	}{
+		{false, []int{2}},
+		{true, []int{2, 3}},
+	}
+	for i, test := range tests {
+		a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+			Signatures: []signature.Sig{sectionSig}, Content: []object.Object{object.Object{
+				Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1"),
+				Signatures: []signature.Sig{objectSig}}}}
+		zone := &section.Zone{SubjectZone: "ch.", Context: ".", Content: []*section.Assertion{a}}
+		for j, sec := range []section.WithSigForward{a, zone} {
+			missingKeys := make(map[missingKeyMetaData]bool)
+			publicKeysPresent(sec, cache.NewZoneKey(10, 9, 1, 0),
+				make(map[keys.PublicKeyID][]keys.PublicKey), missingKeys, test.objectKeys)
+			var phases []int
+			for _, k := range sortedMissingKeys(missingKeys) {
+				phases = append(phases, k.KeyPhase)
+			}
+			if !reflect.DeepEqual(phases, test.want) {
+				t.Errorf("%d.%d: wrong missing keys expected=%v actual=%v", i, j, test.want, phases)
+			}
+		}
+	}
+}
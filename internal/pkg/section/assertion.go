@@ -1,7 +1,6 @@
 package section
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"sort"
@@ -11,6 +10,7 @@ import (
 
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
 
@@ -53,10 +53,14 @@ func (a *Assertion) UnmarshalMap(m map[int]interface{}) error {
 		a.Context = ctx
 	} //context is omitted in a contained assertion
 
-	if objs, ok := m[7]; ok {
-		a.Content = make([]object.Object, len(objs.([]interface{})))
-		for i, obj := range objs.([]interface{}) {
-			if err := a.Content[i].UnmarshalArray(obj.([]interface{})); err != nil {
+	if objs, ok := m[7].([]interface{}); ok {
+		a.Content = make([]object.Object, len(objs))
+		for i, obj := range objs {
+			objVal, ok := obj.([]interface{})
+			if !ok {
+				return errors.New("cbor assertion object entry is not an array")
+			}
+			if err := a.Content[i].UnmarshalArray(objVal); err != nil {
 				return err
 			}
 		}
@@ -72,7 +76,9 @@ func (a *Assertion) MarshalCBOR(w *cbor.CBORWriter) error {
 	if len(a.Signatures) > 0 && !a.sign {
 		m[0] = a.Signatures
 	}
-	if a.SubjectName != "" {
+	if IsApex(a.SubjectName) {
+		m[3] = ApexName
+	} else {
 		m[3] = a.SubjectName
 	}
 	if a.SubjectZone != "" {
@@ -81,7 +87,16 @@ func (a *Assertion) MarshalCBOR(w *cbor.CBORWriter) error {
 	if a.Context != "" {
 		m[6] = a.Context
 	}
-	m[7] = a.Content
+	if a.sign {
+		//section signatures do not cover per-object signatures
+		content := make([]object.Object, len(a.Content))
+		for i, o := range a.Content {
+			content[i] = o.WithoutSigs()
+		}
+		m[7] = content
+	} else {
+		m[7] = a.Content
+	}
 	return w.WriteIntMap(m)
 }
 
@@ -95,9 +110,10 @@ func (a *Assertion) Sigs(keySpace keys.KeySpaceID) []signature.Sig {
 	return filterSigs(a.Signatures, keySpace)
 }
 
-//AddSig adds the given signature
+//AddSig adds a copy of the given signature such that a later change to the caller's signature data
+//does not affect the stored signature.
 func (a *Assertion) AddSig(sig signature.Sig) {
-	a.Signatures = append(a.Signatures, sig)
+	a.Signatures = append(a.Signatures, sig.Copy())
 }
 
 //DeleteSig deletes ith signature
@@ -120,17 +136,45 @@ func (a *Assertion) GetSubjectZone() string {
 	return a.SubjectZone
 }
 
-//FQDN returns the fully qualified domain name of this assertion
+//FQDN returns the fully qualified domain name of this assertion. The name of an apex assertion is
+//its zone.
 func (a *Assertion) FQDN() string {
-	if a.SubjectName == "@" {
+	if IsApex(a.SubjectName) {
 		return a.SubjectZone
 	}
-	if a.SubjectZone == "." {
+	if a.SubjectZone == RootZone {
 		return a.SubjectName + a.SubjectZone
 	}
 	return fmt.Sprintf("%s.%s", a.SubjectName, a.SubjectZone)
 }
 
+//AssertionAnswers returns true if a's fully qualified name is the name of q and a contains an
+//object of one of q's types. An assertion with any object answers a query for object.OTAny.
+func AssertionAnswers(a *Assertion, q *query.Name) bool {
+	if a == nil || q == nil || a.FQDN() != q.Name {
+		return false
+	}
+	for _, o := range a.Content {
+		for _, t := range q.ObjectTypes() {
+			if o.Type == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//DelegatesKeyPhase returns true if a contains a delegation to a public key of keyPhase.
+func (a *Assertion) DelegatesKeyPhase(keyPhase int) bool {
+	for _, o := range a.Content {
+		if pk, ok := o.Value.(keys.PublicKey); ok && o.Type == object.OTDelegation &&
+			pk.KeyPhase == keyPhase {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Assertion) SetContext(ctx string) {
 	a.Context = ctx
 }
@@ -188,15 +232,12 @@ func (a *Assertion) SetValidUntil(validUntil int64) {
 	a.validUntil = validUntil
 }
 
-//Hash returns a string containing all information uniquely identifying an assertion.
+//Hash returns a hex encoded digest uniquely identifying an assertion.
 func (a *Assertion) Hash() string {
 	if a == nil {
 		return "A_nil"
 	}
-	encoding := new(bytes.Buffer)
-	w := cbor.NewCBORWriter(encoding)
-	w.WriteArray([]interface{}{1, a})
-	return encoding.String()
+	return hashAssertion(a)
 }
 
 //EqualContextZoneName return true if the given assertion has the same context, subjectZone,
@@ -205,9 +246,41 @@ func (a *Assertion) EqualContextZoneName(assertion *Assertion) bool {
 	if assertion == nil {
 		return false
 	}
-	return a.Context == assertion.Context &&
+	return EqualContexts(a.Context, assertion.Context) &&
 		a.SubjectZone == assertion.SubjectZone &&
-		a.SubjectName == assertion.SubjectName
+		sortName(a.SubjectName) == sortName(assertion.SubjectName)
+}
+
+//MergeAssertionContent returns a new assertion about the name of a and b containing the union of
+//their objects in sorted order. An object contained in both is added once. The section and object
+//signatures of a and b do not cover the merged assertion and are dropped such that it must be
+//signed anew. An error is returned if a and b differ in subject name, zone or context.
+func MergeAssertionContent(a, b *Assertion) (*Assertion, error) {
+	if a == nil || b == nil {
+		return nil, errors.New("assertion to merge is nil")
+	}
+	if !a.EqualContextZoneName(b) {
+		return nil, fmt.Errorf("cannot merge assertions about %s in context %s and %s in context %s",
+			a.FQDN(), a.Context, b.FQDN(), b.Context)
+	}
+	merged := &Assertion{SubjectName: a.SubjectName, SubjectZone: a.SubjectZone, Context: a.Context}
+	for _, o := range append(append([]object.Object{}, a.Content...), b.Content...) {
+		o.Signatures = nil
+		contained := false
+		for _, m := range merged.Content {
+			if m.CompareTo(o) == 0 {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			merged.Content = append(merged.Content, o)
+		}
+	}
+	sort.Slice(merged.Content, func(i, j int) bool {
+		return merged.Content[i].CompareTo(merged.Content[j]) < 0
+	})
+	return merged, nil
 }
 
 //Sort sorts the content of the assertion lexicographically.
@@ -219,19 +292,20 @@ func (a *Assertion) Sort() {
 }
 
 //CompareTo compares two assertions and returns 0 if they are equal, 1 if a is greater than
-//assertion and -1 if a is smaller than assertion
+//assertion and -1 if a is smaller than assertion. Apex assertions are smaller than all other
+//assertions and an empty subject name is equal to ApexName.
 func (a *Assertion) CompareTo(assertion *Assertion) int {
-	if a.SubjectName < assertion.SubjectName {
+	if sortName(a.SubjectName) < sortName(assertion.SubjectName) {
 		return -1
-	} else if a.SubjectName > assertion.SubjectName {
+	} else if sortName(a.SubjectName) > sortName(assertion.SubjectName) {
 		return 1
 	} else if a.SubjectZone < assertion.SubjectZone {
 		return -1
 	} else if a.SubjectZone > assertion.SubjectZone {
 		return 1
-	} else if a.Context < assertion.Context {
+	} else if CanonicalContext(a.Context) < CanonicalContext(assertion.Context) {
 		return -1
-	} else if a.Context > assertion.Context {
+	} else if CanonicalContext(a.Context) > CanonicalContext(assertion.Context) {
 		return 1
 	} else if len(a.Content) < len(assertion.Content) {
 		return -1
@@ -261,10 +335,34 @@ func (a *Assertion) IsConsistent() bool {
 }
 
 //NeededKeys adds to keysNeeded key meta data which is necessary to verify all a's signatures.
+//The keys of per-object signatures are only added by NeededObjectKeys.
 func (a *Assertion) NeededKeys(keysNeeded map[signature.MetaData]bool) {
 	extractNeededKeys(a, keysNeeded)
 }
 
+//NeededObjectKeys adds to keysNeeded key meta data which is necessary to verify the per-object
+//signatures of the assertions contained in s.
+func NeededObjectKeys(s Section, keysNeeded map[signature.MetaData]bool) {
+	var assertions []*Assertion
+	switch s := s.(type) {
+	case *Assertion:
+		assertions = []*Assertion{s}
+	case *Shard:
+		assertions = s.Content
+	case *Zone:
+		assertions = s.Content
+	}
+	for _, a := range assertions {
+		for _, o := range a.Content {
+			for _, sig := range o.Signatures {
+				if sig.KeySpace == keys.RainsKeySpace {
+					keysNeeded[sig.MetaData()] = true
+				}
+			}
+		}
+	}
+}
+
 //extractNeededKeys adds all key metadata to sigData which are necessary to verify all section's
 //signatures.
 func extractNeededKeys(section WithSig, sigData map[signature.MetaData]bool) {
@@ -1,15 +1,19 @@
 package section
 
 import (
+	"bytes"
 	"math/rand"
 	"net"
 	"reflect"
 	"sort"
 	"testing"
 
+	cbor "github.com/britram/borat"
+
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 	"golang.org/x/crypto/ed25519"
 )
@@ -65,6 +69,46 @@ func TestEqualContextZoneName(t *testing.T) {
 	}
 }
 
+func TestMergeAssertionContent(t *testing.T) {
+	ip := object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1"),
+		Signatures: []signature.Sig{Signature()}}
+	a := &Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{ip, object.NameObject()}, Signatures: []signature.Sig{Signature()}}
+	b := &Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content:    []object.Object{object.ServiceObject(), object.NameObject()},
+		Signatures: []signature.Sig{Signature()}}
+	merged, err := MergeAssertionContent(a, b)
+	if err != nil {
+		t.Fatalf("Was not able to merge assertions: %v", err)
+	}
+	want := []object.Type{object.OTName, object.OTIP4Addr, object.OTServiceInfo}
+	if len(merged.Content) != len(want) {
+		t.Fatalf("merged assertion does not contain the union of both objects sets: %v", merged)
+	}
+	for i, o := range merged.Content {
+		if o.Type != want[i] || len(o.Signatures) != 0 {
+			t.Errorf("%d: wrong object in merged assertion expected type=%v actual=%v", i, want[i], o)
+		}
+	}
+	if len(merged.AllSigs()) != 0 || !merged.EqualContextZoneName(a) {
+		t.Errorf("merged assertion must be unsigned and about the same name: %v", merged)
+	}
+	if len(a.Content[0].Signatures) != 1 || len(a.AllSigs()) != 1 {
+		t.Errorf("merged assertions must not be modified: %v", a)
+	}
+	var tests = []*Assertion{
+		nil,
+		&Assertion{SubjectName: "epfl", SubjectZone: "ch.", Context: "."},
+		&Assertion{SubjectName: "ethz", SubjectZone: "com.", Context: "."},
+		&Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: "cx-test"},
+	}
+	for i, test := range tests {
+		if _, err := MergeAssertionContent(a, test); err == nil {
+			t.Errorf("%d: assertions about different names must not be merged: %v", i, test)
+		}
+	}
+}
+
 func TestAssertionCompareTo(t *testing.T) {
 	assertions := sortedAssertions(10)
 	shuffled := append([]*Assertion{}, assertions...)
@@ -107,6 +151,36 @@ func TestAssertionSort(t *testing.T) {
 	}
 }
 
+func TestAssertionSortServiceInfo(t *testing.T) {
+	srv := func(name string, port uint16, prio uint) object.Object {
+		return object.Object{Type: object.OTServiceInfo,
+			Value: object.ServiceInfo{Name: name, Port: port, Priority: prio}}
+	}
+	content := []object.Object{srv("b.ch.", 80, 0), srv("a.ch.", 443, 1), srv("a.ch.", 80, 2),
+		srv("a.ch.", 80, 1), srv("b.ch.", 80, 0)}
+	var want []byte
+	for i := 0; i < 20; i++ {
+		permuted := append([]object.Object{}, content...)
+		rand.Shuffle(len(permuted), func(i, j int) { permuted[i], permuted[j] = permuted[j], permuted[i] })
+		a := &Assertion{SubjectName: "_rains._tcp", SubjectZone: "ch.", Context: ".", Content: permuted}
+		a.Sort()
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewCBORWriter(encoding).Marshal(a); err != nil {
+			t.Fatalf("%d: Was not able to encode assertion: %v", i, err)
+		}
+		if want == nil {
+			want = encoding.Bytes()
+			sorted := []object.Object{srv("a.ch.", 80, 1), srv("a.ch.", 80, 2), srv("a.ch.", 443, 1),
+				srv("b.ch.", 80, 0), srv("b.ch.", 80, 0)}
+			if !reflect.DeepEqual(a.Content, sorted) {
+				t.Fatalf("service info objects are in wrong order expected=%v actual=%v", sorted, a.Content)
+			}
+		} else if !bytes.Equal(encoding.Bytes(), want) {
+			t.Errorf("%d: permuted service info objects canonicalize differently: %v", i, a.Content)
+		}
+	}
+}
+
 func checkAssertion(a1, a2 *Assertion, t *testing.T) {
 	if a1.Context != a2.Context {
 		t.Errorf("Assertion Context mismatch a1.Context=%s a2.Context=%s", a1.Context, a2.Context)
@@ -245,12 +319,20 @@ func checkObjects(objs1, objs2 []object.Object, t *testing.T) {
 			if o1.Value.(string) != o2.Value.(string) {
 				t.Errorf("Object Value registrant mismatch at position %d of content slice. v1=%s v2=%s", i, o1.Value, o2.Value)
 			}
+		case object.OTText:
+			if o1.Value.(string) != o2.Value.(string) {
+				t.Errorf("Object Value text mismatch at position %d of content slice. v1=%s v2=%s", i, o1.Value, o2.Value)
+			}
 		case object.OTInfraKey:
 			checkPublicKey(o1.Value.(keys.PublicKey), o2.Value.(keys.PublicKey), t)
 		case object.OTExtraKey:
 			checkPublicKey(o1.Value.(keys.PublicKey), o2.Value.(keys.PublicKey), t)
 		case object.OTNextKey:
 			checkPublicKey(o1.Value.(keys.PublicKey), o2.Value.(keys.PublicKey), t)
+		case object.OTZoneMetadata:
+			if o1.Value.(object.ZoneMetadata) != o2.Value.(object.ZoneMetadata) {
+				t.Errorf("Object Value zone metadata mismatch at position %d of content slice. v1=%v v2=%v", i, o1.Value, o2.Value)
+			}
 		default:
 			t.Errorf("Unsupported object type. got=%T", o1.Type)
 		}
@@ -306,3 +388,98 @@ func TestFQDN(t *testing.T) {
 		t.Errorf("Wrong FQDN() = %s", assertion.FQDN())
 	}
 }
+
+func TestApexAssertion(t *testing.T) {
+	ip := object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1").To4()}
+	empty := &Assertion{SubjectZone: "ethz.ch.", Context: ".", Content: []object.Object{ip}}
+	at := &Assertion{SubjectName: "@", SubjectZone: "ethz.ch.", Context: ".", Content: []object.Object{ip}}
+	other := &Assertion{SubjectName: "0", SubjectZone: "ethz.ch.", Context: ".", Content: []object.Object{ip}}
+	if empty.FQDN() != "ethz.ch." || at.FQDN() != "ethz.ch." {
+		t.Errorf("apex FQDN must be the zone: empty=%s at=%s", empty.FQDN(), at.FQDN())
+	}
+	if empty.CompareTo(at) != 0 || !empty.EqualContextZoneName(at) || empty.Hash() != at.Hash() {
+		t.Error("empty and \"@\" subject name must denote the same apex assertion")
+	}
+	if at.CompareTo(other) != -1 || other.CompareTo(empty) != 1 {
+		t.Error("apex assertions must sort before all other assertions of the zone")
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewCBORWriter(encoding).Marshal(empty); err != nil {
+		t.Fatalf("Was not able to encode apex assertion: %v", err)
+	}
+	decoded := &Assertion{}
+	m, err := cbor.NewCBORReader(encoding).ReadIntMapUntagged()
+	if err != nil {
+		t.Fatalf("Was not able to decode apex assertion: %v", err)
+	}
+	if err := decoded.UnmarshalMap(m); err != nil || decoded.SubjectName != ApexName {
+		t.Errorf("apex assertion must be encoded with subject name %s: actual=%v err=%v",
+			ApexName, decoded, err)
+	}
+}
+
+func TestAssertionAnswers(t *testing.T) {
+	a := &Assertion{
+		SubjectName: "example",
+		SubjectZone: "com.",
+		Context:     ".",
+		Content:     []object.Object{object.NameObject(), object.ServiceObject()},
+	}
+	var tests = []struct {
+		a    *Assertion
+		q    *query.Name
+		want bool
+	}{
+		{a, &query.Name{Name: "example.com.", Types: []object.Type{object.OTName}}, true},
+		{a, &query.Name{Name: "example.com.", Types: []object.Type{object.OTIP4Addr, object.OTServiceInfo}}, true},
+		{a, &query.Name{Name: "other.com.", Types: []object.Type{object.OTName}}, false},      //wrong name
+		{a, &query.Name{Name: "com.", Types: []object.Type{object.OTName}}, false},            //wrong name
+		{a, &query.Name{Name: "example.com.", Types: []object.Type{object.OTIP6Addr}}, false}, //wrong type
+		{a, &query.Name{Name: "example.com.", Types: []object.Type{}}, false},                 //no type
+		{nil, &query.Name{Name: "example.com.", Types: []object.Type{object.OTName}}, false},
+	}
+	for i, test := range tests {
+		if AssertionAnswers(test.a, test.q) != test.want {
+			t.Errorf("%d: unexpected result expected=%v assertion=%v query=%v", i, test.want,
+				test.a, test.q)
+		}
+	}
+}
+
+func TestDelegatesKeyPhase(t *testing.T) {
+	phase1 := object.PublicKey()
+	phase1.KeyPhase = 1
+	a := &Assertion{Content: []object.Object{
+		object.Object{Type: object.OTDelegation, Value: object.PublicKey()},
+		object.Object{Type: object.OTDelegation, Value: phase1},
+	}}
+	var tests = []struct {
+		a     *Assertion
+		phase int
+		want  bool
+	}{
+		{a, 0, true},
+		{a, 1, true},
+		{a, 2, false},
+		{&Assertion{Content: []object.Object{object.NameObject()}}, 0, false},
+	}
+	for i, test := range tests {
+		if test.a.DelegatesKeyPhase(test.phase) != test.want {
+			t.Errorf("%d: wrong result for key phase %d expected=%v", i, test.phase, test.want)
+		}
+	}
+}
+
+func TestAddSigCopiesData(t *testing.T) {
+	var tests = []WithSig{&Assertion{}, &Shard{}, &Pshard{}, &Zone{}}
+	for i, sec := range tests {
+		data := []byte{1, 2, 3}
+		sec.AddSig(signature.Sig{Data: data})
+		//the caller reuses its buffer
+		data[0] = 9
+		if stored := sec.AllSigs()[0].Data.([]byte); !bytes.Equal(stored, []byte{1, 2, 3}) {
+			t.Errorf("%d: stored signature data of %T changed with the caller's buffer actual=%v",
+				i, sec, stored)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package section
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+//Value kinds written before an object value or signature data such that values of different go
+//types never share a representation.
+const (
+	hkNil byte = iota
+	hkString
+	hkBytes
+	hkIP
+	hkName
+	hkPublicKey
+	hkSCIONAddress
+	hkCertificate
+	hkServiceInfo
+	hkZoneMetadata
+	hkOther
+)
+
+//Section kinds written first such that sections of different types never share a digest.
+const (
+	hkAssertion byte = iota + 1
+	hkShard
+	hkPshard
+	hkZone
+)
+
+//sectionHasher accumulates an unambiguous byte representation of a section from which a compact
+//digest is computed. Variable length fields are length prefixed such that distinct sections never
+//share a representation. Fields are appended to a single buffer which avoids the allocations of
+//encoding the section.
+type sectionHasher struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+//newSectionHasher returns a hasher for a section of the given kind.
+func newSectionHasher(kind byte) *sectionHasher {
+	h := &sectionHasher{buf: make([]byte, 0, 256)}
+	h.buf = append(h.buf, kind)
+	return h
+}
+
+//digest returns the hex encoded SHA-256 digest of the accumulated representation.
+func (h *sectionHasher) digest() string {
+	sum := sha256.Sum256(h.buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *sectionHasher) int(i int64) {
+	n := binary.PutVarint(h.scratch[:], i)
+	h.buf = append(h.buf, h.scratch[:n]...)
+}
+
+func (h *sectionHasher) string(s string) {
+	h.int(int64(len(s)))
+	h.buf = append(h.buf, s...)
+}
+
+func (h *sectionHasher) bytes(b []byte) {
+	h.int(int64(len(b)))
+	h.buf = append(h.buf, b...)
+}
+
+//value writes a value of unknown type. keys and signature data are byte slices for all supported
+//algorithms. Absent data is written as an empty byte slice as both have the same encoding.
+func (h *sectionHasher) value(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		h.buf = append(h.buf, hkBytes)
+		h.bytes(nil)
+	case []byte:
+		h.buf = append(h.buf, hkBytes)
+		h.bytes(v)
+	default:
+		h.buf = append(h.buf, hkOther)
+		h.string(fmt.Sprintf("%T %v", v, v))
+	}
+}
+
+func (h *sectionHasher) signatures(sigs []signature.Sig) {
+	h.int(int64(len(sigs)))
+	for _, sig := range sigs {
+		h.publicKeyID(sig.PublicKeyID)
+		h.int(sig.ValidSince)
+		h.int(sig.ValidUntil)
+		h.value(sig.Data)
+	}
+}
+
+func (h *sectionHasher) publicKeyID(id keys.PublicKeyID) {
+	h.int(int64(id.Algorithm))
+	h.int(int64(id.KeySpace))
+	h.int(int64(id.KeyPhase))
+}
+
+func (h *sectionHasher) object(o object.Object) {
+	h.int(int64(o.Type))
+	switch v := o.Value.(type) {
+	case string:
+		h.buf = append(h.buf, hkString)
+		h.string(v)
+	case object.NamesetExpr:
+		h.buf = append(h.buf, hkString)
+		h.string(string(v))
+	case net.IP:
+		h.buf = append(h.buf, hkIP)
+		h.bytes(v)
+	case object.Name:
+		h.buf = append(h.buf, hkName)
+		h.string(v.Name)
+		h.int(int64(len(v.Types)))
+		for _, t := range v.Types {
+			h.int(int64(t))
+		}
+	case keys.PublicKey:
+		h.buf = append(h.buf, hkPublicKey)
+		h.publicKeyID(v.PublicKeyID)
+		h.int(v.ValidSince)
+		h.int(v.ValidUntil)
+		h.value(v.Key)
+	case *object.SCIONAddress:
+		h.buf = append(h.buf, hkSCIONAddress)
+		h.string(v.String())
+	case object.Certificate:
+		h.buf = append(h.buf, hkCertificate)
+		h.int(int64(v.Type))
+		h.int(int64(v.Usage))
+		h.int(int64(v.HashAlgo))
+		h.bytes(v.Data)
+	case object.ServiceInfo:
+		h.buf = append(h.buf, hkServiceInfo)
+		h.string(v.Name)
+		h.int(int64(v.Port))
+		h.int(int64(v.Priority))
+	case object.ZoneMetadata:
+		h.buf = append(h.buf, hkZoneMetadata)
+		h.string(v.PrimaryServer)
+		h.int(v.Serial)
+		h.int(v.Refresh)
+		h.int(v.Retry)
+		h.int(v.Expire)
+	default:
+		h.value(v)
+	}
+	h.signatures(o.Signatures)
+}
+
+//assertion writes a. The empty subject name and ApexName are written identically as they denote
+//the same apex assertion, just as the empty context and GlobalContext.
+func (h *sectionHasher) assertion(a *Assertion) {
+	if IsApex(a.SubjectName) {
+		h.string(ApexName)
+	} else {
+		h.string(a.SubjectName)
+	}
+	h.string(a.SubjectZone)
+	h.string(CanonicalContext(a.Context))
+	h.int(int64(len(a.Content)))
+	for _, o := range a.Content {
+		h.object(o)
+	}
+	h.signatures(a.Signatures)
+}
+
+//hashAssertion returns the digest of a.
+func hashAssertion(a *Assertion) string {
+	h := newSectionHasher(hkAssertion)
+	h.assertion(a)
+	return h.digest()
+}
+
+func (h *sectionHasher) assertions(as []*Assertion) {
+	h.int(int64(len(as)))
+	for _, a := range as {
+		if a == nil {
+			h.buf = append(h.buf, hkNil)
+			continue
+		}
+		h.buf = append(h.buf, hkOther)
+		h.assertion(a)
+	}
+}
+
+//hashShard returns the digest of s.
+func hashShard(s *Shard) string {
+	h := newSectionHasher(hkShard)
+	h.string(s.SubjectZone)
+	h.string(CanonicalContext(s.Context))
+	h.string(s.RangeFrom)
+	h.string(s.RangeTo)
+	h.assertions(s.Content)
+	h.signatures(s.Signatures)
+	return h.digest()
+}
+
+//hashPshard returns the digest of s.
+func hashPshard(s *Pshard) string {
+	h := newSectionHasher(hkPshard)
+	h.string(s.SubjectZone)
+	h.string(CanonicalContext(s.Context))
+	h.string(s.RangeFrom)
+	h.string(s.RangeTo)
+	h.int(int64(s.BloomFilter.Algorithm))
+	h.int(int64(s.BloomFilter.Hash))
+	h.bytes(s.BloomFilter.Filter)
+	h.signatures(s.Signatures)
+	return h.digest()
+}
+
+//hashZone returns the digest of z.
+func hashZone(z *Zone) string {
+	h := newSectionHasher(hkZone)
+	h.string(z.SubjectZone)
+	h.string(CanonicalContext(z.Context))
+	h.assertions(z.Content)
+	h.signatures(z.Signatures)
+	return h.digest()
+}
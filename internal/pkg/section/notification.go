@@ -105,5 +105,6 @@ const (
 	NTMsgTooLarge        NotificationType = 413
 	NTUnspecServerErr    NotificationType = 500
 	NTServerNotCapable   NotificationType = 501
+	NTServerTooBusy      NotificationType = 503
 	NTNoAssertionAvail   NotificationType = 504
 )
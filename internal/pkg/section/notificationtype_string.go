@@ -10,13 +10,14 @@ const (
 	_NotificationType_name_2 = "NTRcvInconsistentMsgNTNoAssertionsExist"
 	_NotificationType_name_3 = "NTMsgTooLarge"
 	_NotificationType_name_4 = "NTUnspecServerErrNTServerNotCapable"
-	_NotificationType_name_5 = "NTNoAssertionAvail"
+	_NotificationType_name_5 = "NTServerTooBusyNTNoAssertionAvail"
 )
 
 var (
 	_NotificationType_index_1 = [...]uint8{0, 17, 29}
 	_NotificationType_index_2 = [...]uint8{0, 20, 39}
 	_NotificationType_index_4 = [...]uint8{0, 17, 35}
+	_NotificationType_index_5 = [...]uint8{0, 15, 33}
 )
 
 func (i NotificationType) String() string {
@@ -34,8 +35,9 @@ func (i NotificationType) String() string {
 	case 500 <= i && i <= 501:
 		i -= 500
 		return _NotificationType_name_4[_NotificationType_index_4[i]:_NotificationType_index_4[i+1]]
-	case i == 504:
-		return _NotificationType_name_5
+	case 503 <= i && i <= 504:
+		i -= 503
+		return _NotificationType_name_5[_NotificationType_index_5[i]:_NotificationType_index_5[i+1]]
 	default:
 		return "NotificationType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
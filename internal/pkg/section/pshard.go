@@ -1,10 +1,8 @@
 package section
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	cbor "github.com/britram/borat"
@@ -52,7 +50,7 @@ func (s *Pshard) UnmarshalMap(m map[int]interface{}) error {
 	} else {
 		return errors.New("cbor pshard map does not contain a context")
 	}
-	if srange, ok := m[11].([]interface{}); ok {
+	if srange, ok := m[11].([]interface{}); ok && len(srange) == 2 {
 		begin, ok := srange[0].(string)
 		if !ok {
 			return errors.New("cbor pshard encoding of rangeFrom should be a string")
@@ -102,9 +100,10 @@ func (s *Pshard) Sigs(keySpace keys.KeySpaceID) []signature.Sig {
 	return filterSigs(s.Signatures, keySpace)
 }
 
-//AddSig adds the given signature
+//AddSig adds a copy of the given signature such that a later change to the caller's signature data
+//does not affect the stored signature.
 func (s *Pshard) AddSig(sig signature.Sig) {
-	s.Signatures = append(s.Signatures, sig)
+	s.Signatures = append(s.Signatures, sig.Copy())
 }
 
 //DeleteSig deletes ith signature
@@ -164,15 +163,12 @@ func (s *Pshard) SetValidUntil(validUntil int64) {
 	s.validUntil = validUntil
 }
 
-//Hash returns a string containing all information uniquely identifying a pshard.
+//Hash returns a hex encoded digest uniquely identifying a pshard.
 func (s *Pshard) Hash() string {
 	if s == nil {
 		return "P_nil"
 	}
-	encoding := new(bytes.Buffer)
-	w := cbor.NewCBORWriter(encoding)
-	w.WriteArray([]interface{}{3, s})
-	return encoding.String()
+	return hashPshard(s)
 }
 
 //Sort sorts the content of the pshard lexicographically.
@@ -189,8 +185,12 @@ func (s *Pshard) String() string {
 		s.SubjectZone, s.Context, s.RangeFrom, s.RangeTo, s.BloomFilter, s.Signatures)
 }
 
-//InRange returns true if subjectName is inside the shard range
+//InRange returns true if subjectName is inside the shard range. The apex is in the range of every
+//shard.
 func (s *Pshard) InRange(subjectName string) bool {
+	if IsApex(subjectName) {
+		return true
+	}
 	return (s.RangeFrom == "" && s.RangeTo == "") || (s.RangeFrom == "" && s.RangeTo > subjectName) ||
 		(s.RangeTo == "" && s.RangeFrom < subjectName) ||
 		(s.RangeFrom < subjectName && s.RangeTo > subjectName)
@@ -227,17 +227,17 @@ func (s *Pshard) Copy(context, subjectZone string) *Pshard {
 //IsNonexistent returns true if all types of q do not exist. An error is returned, when q is not
 //within the pshard's range or if its context and zone does not match the pshard.
 func (s *Pshard) IsNonexistent(q *query.Name) (bool, error) {
-	if q.Context != s.Context {
+	if !EqualContexts(q.Context, s.Context) {
 		return false, errors.New("query has different context")
 	}
-	if strings.HasSuffix(q.Name, s.SubjectZone) {
-		return false, errors.New("query has different suffix")
+	name, ok := EnclosingZone(q.Name, s.SubjectZone)
+	if !ok {
+		return false, errors.New("query is not in the pshard's zone")
 	}
-	name := strings.TrimSuffix(q.Name, s.SubjectZone)
 	if !s.InRange(name) {
 		return false, errors.New("query is not in pshard's range")
 	}
-	for _, t := range q.Types {
+	for _, t := range q.ObjectTypes() {
 		if val, err := s.BloomFilter.Contains(name, s.SubjectZone, s.Context, t); err != nil || val {
 			return false, nil
 		}
@@ -248,7 +248,7 @@ func (s *Pshard) IsNonexistent(q *query.Name) (bool, error) {
 //AddAssertion adds a to the s' Bloom filter. An error is returned, if a is not within s' range or
 //if they have a different context or zone.
 func (s *Pshard) AddAssertion(a *Assertion) error {
-	if a.Context != s.Context {
+	if !EqualContexts(a.Context, s.Context) {
 		return fmt.Errorf("assertion has different context pshardCtx=%s aCtx=%s", s.Context, a.Context)
 	}
 	if a.SubjectZone != s.SubjectZone {
@@ -272,9 +272,9 @@ func (s *Pshard) CompareTo(pshard *Pshard) int {
 		return -1
 	} else if s.SubjectZone > pshard.SubjectZone {
 		return 1
-	} else if s.Context < pshard.Context {
+	} else if CanonicalContext(s.Context) < CanonicalContext(pshard.Context) {
 		return -1
-	} else if s.Context > pshard.Context {
+	} else if CanonicalContext(s.Context) > CanonicalContext(pshard.Context) {
 		return 1
 	} else if s.RangeFrom < pshard.RangeFrom {
 		return -1
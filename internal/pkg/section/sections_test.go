@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
 
@@ -35,3 +36,144 @@ func TestSigs(t *testing.T) {
 		}
 	}
 }
+
+func TestSectionHash(t *testing.T) {
+	var sections []WithSig
+	for _, a := range sortedAssertions(2) {
+		sections = append(sections, a)
+	}
+	for _, s := range sortedShards(2) {
+		sections = append(sections, s)
+	}
+	for _, s := range sortedPshards(2) {
+		sections = append(sections, s)
+	}
+	for _, z := range sortedZones(2) {
+		sections = append(sections, z)
+	}
+	seen := make(map[string]WithSig)
+	for i, s := range sections {
+		h := s.Hash()
+		if len(h) != 64 {
+			t.Fatalf("%d: hash is not a hex encoded SHA-256 digest: %q", i, h)
+		}
+		if other, ok := seen[h]; ok && (reflect.TypeOf(other) != reflect.TypeOf(s) ||
+			compareSections(other, s) != 0) {
+			t.Fatalf("%d: distinct sections have the same hash %s: %v and %v", i, h, other, s)
+		}
+		seen[h] = s
+	}
+	if len(seen) != len(sections)-4 {
+		t.Errorf("equal sections have different hashes: expected=%d actual=%d",
+			len(sections)-4, len(seen))
+	}
+}
+
+//compareSections compares two sections of the same type.
+func compareSections(s1, s2 WithSig) int {
+	switch s1 := s1.(type) {
+	case *Assertion:
+		return s1.CompareTo(s2.(*Assertion))
+	case *Shard:
+		return s1.CompareTo(s2.(*Shard))
+	case *Pshard:
+		return s1.CompareTo(s2.(*Pshard))
+	case *Zone:
+		return s1.CompareTo(s2.(*Zone))
+	}
+	return -1
+}
+
+func TestIsGlobalContext(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  bool
+	}{
+		{".", true},
+		{GlobalContext, true},
+		{"", true},
+		{"..", false},
+		{"cx-example.com.", false},
+	}
+	for i, test := range tests {
+		if IsGlobalContext(test.input) != test.want {
+			t.Errorf("%d: unexpected result for context=%q expected=%v", i, test.input, test.want)
+		}
+	}
+}
+
+func TestGlobalContextComparison(t *testing.T) {
+	assertion := func(ctx string) *Assertion {
+		return &Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ctx,
+			Content: []object.Object{object.Object{Type: object.OTName, Value: object.Name{
+				Name: "a", Types: []object.Type{object.OTIP4Addr}}}}}
+	}
+	var tests = []struct {
+		a, b  string
+		equal bool
+	}{
+		{".", ".", true},
+		{".", "", true},
+		{"", GlobalContext, true},
+		{".", "cx-example.com.", false},
+		{"", "cx-example.com.", false},
+	}
+	for i, test := range tests {
+		if EqualContexts(test.a, test.b) != test.equal {
+			t.Errorf("%d: wrong context equality for %q and %q expected=%v", i, test.a, test.b,
+				test.equal)
+		}
+		a, b := assertion(test.a), assertion(test.b)
+		if (a.CompareTo(b) == 0) != test.equal || a.EqualContextZoneName(b) != test.equal ||
+			(a.Hash() == b.Hash()) != test.equal {
+			t.Errorf("%d: assertions with contexts %q and %q are compared inconsistently", i,
+				test.a, test.b)
+		}
+		shardA := &Shard{SubjectZone: "ch.", Context: test.a}
+		shardB := &Shard{SubjectZone: "ch.", Context: test.b}
+		if (shardA.CompareTo(shardB) == 0) != test.equal || shardA.Contains(shardB) != test.equal ||
+			(shardA.Hash() == shardB.Hash()) != test.equal {
+			t.Errorf("%d: shards with contexts %q and %q are compared inconsistently", i, test.a,
+				test.b)
+		}
+		pshardA := &Pshard{SubjectZone: "ch.", Context: test.a}
+		pshardB := &Pshard{SubjectZone: "ch.", Context: test.b}
+		if (pshardA.CompareTo(pshardB) == 0) != test.equal {
+			t.Errorf("%d: pshards with contexts %q and %q are compared inconsistently", i, test.a,
+				test.b)
+		}
+		zoneA := &Zone{SubjectZone: "ch.", Context: test.a}
+		zoneB := &Zone{SubjectZone: "ch.", Context: test.b}
+		if (zoneA.CompareTo(zoneB) == 0) != test.equal || (zoneA.Hash() == zoneB.Hash()) != test.equal {
+			t.Errorf("%d: zones with contexts %q and %q are compared inconsistently", i, test.a,
+				test.b)
+		}
+	}
+}
+
+func TestEnclosingZone(t *testing.T) {
+	var tests = []struct {
+		name    string
+		zone    string
+		subname string
+		ok      bool
+	}{
+		{"www.ethz.ch.", "ethz.ch.", "www", true},
+		{"a.b.ethz.ch.", "ethz.ch.", "a.b", true},
+		{"ethz.ch.", "ethz.ch.", ApexName, true},
+		{"ch.", ".", "ch", true},
+		{".", ".", ApexName, true},
+		{"foobar.com.", "bar.com.", "", false}, //not label aligned
+		{"bar.com.", "foobar.com.", "", false},
+		{"com.", "bar.com.", "", false},
+		{".bar.com.", "bar.com.", "", false}, //empty label
+		{"bar.com", "bar.com.", "", false},
+	}
+	for i, test := range tests {
+		subname, ok := EnclosingZone(test.name, test.zone)
+		if subname != test.subname || ok != test.ok {
+			t.Errorf("%d: wrong result for name=%s zone=%s expected=(%q,%v) actual=(%q,%v)", i,
+				test.name, test.zone, test.subname, test.ok, subname, ok)
+		}
+	}
+}
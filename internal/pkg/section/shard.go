@@ -1,7 +1,6 @@
 package section
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"sort"
@@ -56,7 +55,7 @@ func (s *Shard) UnmarshalMap(m map[int]interface{}) error {
 		return errors.New("cbor shard map does not contain a context")
 	}
 	// RangeFrom/RangeTo
-	if srange, ok := m[11].([]interface{}); ok {
+	if srange, ok := m[11].([]interface{}); ok && len(srange) == 2 {
 		begin, ok := srange[0].(string)
 		if !ok {
 			return errors.New("cbor shard encoding of rangeFrom should be a string")
@@ -117,9 +116,10 @@ func (s *Shard) Sigs(keySpace keys.KeySpaceID) []signature.Sig {
 	return filterSigs(s.Signatures, keySpace)
 }
 
-//AddSig adds the given signature
+//AddSig adds a copy of the given signature such that a later change to the caller's signature data
+//does not affect the stored signature.
 func (s *Shard) AddSig(sig signature.Sig) {
-	s.Signatures = append(s.Signatures, sig)
+	s.Signatures = append(s.Signatures, sig.Copy())
 }
 
 //DeleteSig deletes ith signature
@@ -202,15 +202,12 @@ func (s *Shard) SetValidUntil(validUntil int64) {
 	s.validUntil = validUntil
 }
 
-//Hash returns a string containing all information uniquely identifying a shard.
+//Hash returns a hex encoded digest uniquely identifying a shard.
 func (s *Shard) Hash() string {
 	if s == nil {
 		return "S_nil"
 	}
-	encoding := new(bytes.Buffer)
-	w := cbor.NewCBORWriter(encoding)
-	w.WriteArray([]interface{}{2, s})
-	return encoding.String()
+	return hashShard(s)
 }
 
 //Sort sorts the content of the shard lexicographically.
@@ -228,9 +225,9 @@ func (s *Shard) CompareTo(shard *Shard) int {
 		return -1
 	} else if s.SubjectZone > shard.SubjectZone {
 		return 1
-	} else if s.Context < shard.Context {
+	} else if CanonicalContext(s.Context) < CanonicalContext(shard.Context) {
 		return -1
-	} else if s.Context > shard.Context {
+	} else if CanonicalContext(s.Context) > CanonicalContext(shard.Context) {
 		return 1
 	} else if s.RangeFrom < shard.RangeFrom {
 		return -1
@@ -262,8 +259,12 @@ func (s *Shard) String() string {
 		s.SubjectZone, s.Context, s.RangeFrom, s.RangeTo, s.Content, s.Signatures)
 }
 
-//InRange returns true if subjectName is inside the shard range
+//InRange returns true if subjectName is inside the shard range. The apex is in the range of every
+//shard.
 func (s *Shard) InRange(subjectName string) bool {
+	if IsApex(subjectName) {
+		return true
+	}
 	return (s.RangeFrom == "<" && s.RangeTo == ">") || (s.RangeFrom == "<" && s.RangeTo > subjectName) ||
 		(s.RangeTo == ">" && s.RangeFrom < subjectName) ||
 		(s.RangeFrom < subjectName && s.RangeTo > subjectName) ||
@@ -271,6 +272,20 @@ func (s *Shard) InRange(subjectName string) bool {
 		(s.RangeTo == "" && s.RangeFrom < subjectName)
 }
 
+//Contains returns true if s and other are about the same zone and context and s's range includes
+//other's range. An empty bound or "<" and ">" respectively leaves the range open on that side. A
+//shard containing other answers every query other answers such that other is redundant.
+func (s *Shard) Contains(other *Shard) bool {
+	if !EqualContexts(s.Context, other.Context) || s.SubjectZone != other.SubjectZone {
+		return false
+	}
+	fromOpen := func(from string) bool { return from == "" || from == "<" }
+	toOpen := func(to string) bool { return to == "" || to == ">" }
+	coversFrom := fromOpen(s.RangeFrom) || !fromOpen(other.RangeFrom) && s.RangeFrom <= other.RangeFrom
+	coversTo := toOpen(s.RangeTo) || !toOpen(other.RangeTo) && s.RangeTo >= other.RangeTo
+	return coversFrom && coversTo
+}
+
 //IsConsistent returns true if all contained assertions have no subjectZone and context and are
 //within the shards range.
 func (s *Shard) IsConsistent() bool {
@@ -98,6 +98,14 @@ func TestShardInRange(t *testing.T) {
 			Input:  "zzz",
 			Output: false,
 		},
+		{
+			Input:  "@",
+			Output: true,
+		},
+		{
+			Input:  "",
+			Output: true,
+		},
 	}
 	for i, testCase := range testMatrix {
 		if out := ss.InRange(testCase.Input); out != testCase.Output {
@@ -107,6 +115,41 @@ func TestShardInRange(t *testing.T) {
 	}
 }
 
+func TestShardContains(t *testing.T) {
+	var tests = []struct {
+		s     *Shard
+		other *Shard
+		want  bool
+	}{
+		//nested
+		{&Shard{RangeFrom: "a", RangeTo: "z"}, &Shard{RangeFrom: "c", RangeTo: "x"}, true},
+		{&Shard{RangeFrom: "c", RangeTo: "x"}, &Shard{RangeFrom: "a", RangeTo: "z"}, false},
+		{&Shard{RangeFrom: "a", RangeTo: "z"}, &Shard{RangeFrom: "a", RangeTo: "z"}, true},
+		{&Shard{RangeFrom: "", RangeTo: ""}, &Shard{RangeFrom: "c", RangeTo: "x"}, true},
+		{&Shard{RangeFrom: "<", RangeTo: ">"}, &Shard{RangeFrom: "", RangeTo: ""}, true},
+		{&Shard{RangeFrom: "", RangeTo: "z"}, &Shard{RangeFrom: "<", RangeTo: "x"}, true},
+		{&Shard{RangeFrom: "a", RangeTo: "z"}, &Shard{RangeFrom: "", RangeTo: "x"}, false},
+		{&Shard{RangeFrom: "a", RangeTo: "z"}, &Shard{RangeFrom: "c", RangeTo: ">"}, false},
+		//partially overlapping
+		{&Shard{RangeFrom: "a", RangeTo: "m"}, &Shard{RangeFrom: "c", RangeTo: "x"}, false},
+		{&Shard{RangeFrom: "c", RangeTo: ""}, &Shard{RangeFrom: "a", RangeTo: "x"}, false},
+		//disjoint
+		{&Shard{RangeFrom: "a", RangeTo: "c"}, &Shard{RangeFrom: "x", RangeTo: "z"}, false},
+		{&Shard{RangeFrom: "x", RangeTo: ""}, &Shard{RangeFrom: "", RangeTo: "c"}, false},
+		//different zone or context
+		{&Shard{SubjectZone: "ch", RangeFrom: "a", RangeTo: "z"},
+			&Shard{SubjectZone: "org", RangeFrom: "c", RangeTo: "x"}, false},
+		{&Shard{Context: ".", RangeFrom: "a", RangeTo: "z"},
+			&Shard{Context: "cx-test", RangeFrom: "c", RangeTo: "x"}, false},
+	}
+	for i, test := range tests {
+		if got := test.s.Contains(test.other); got != test.want {
+			t.Errorf("%d: wrong result of Contains for %s and %s expected=%v actual=%v", i,
+				test.s, test.other, test.want, got)
+		}
+	}
+}
+
 func TestShardIsConsistent(t *testing.T) {
 	testMatrix := []struct {
 		section    *Shard
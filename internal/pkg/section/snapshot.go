@@ -0,0 +1,101 @@
+package section
+
+import (
+	"errors"
+	"fmt"
+
+	cbor "github.com/britram/borat"
+)
+
+//Snapshot is the internal representation of signed sections which is used to persist them locally,
+//e.g. in a cache checkpoint. In contrast to the wire format, the encoding contains the validity
+//window of each section which has been computed during signature verification. Reloaded sections
+//thus retain their validity without being verified again. A Snapshot must never be sent to
+//another server.
+type Snapshot struct {
+	Sections []WithSigForward
+}
+
+//snapshot section types. They correspond to the section types of the message format.
+const (
+	snapshotAssertion = 1
+	snapshotShard     = 2
+	snapshotPshard    = 3
+	snapshotZone      = 4
+)
+
+// MarshalCBOR implements the CBORMarshaler interface.
+func (s *Snapshot) MarshalCBOR(w *cbor.CBORWriter) error {
+	entries := make([]interface{}, len(s.Sections))
+	for i, sec := range s.Sections {
+		var t int
+		switch sec.(type) {
+		case *Assertion:
+			t = snapshotAssertion
+		case *Shard:
+			t = snapshotShard
+		case *Pshard:
+			t = snapshotPshard
+		case *Zone:
+			t = snapshotZone
+		default:
+			return fmt.Errorf("unsupported section type in snapshot: %T", sec)
+		}
+		entries[i] = []interface{}{t, sec, sec.ValidSince(), sec.ValidUntil()}
+	}
+	return w.WriteArray(entries)
+}
+
+// UnmarshalCBOR implements the CBORUnmarshaler interface.
+func (s *Snapshot) UnmarshalCBOR(r *cbor.CBORReader) error {
+	arr, err := r.ReadArray()
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot array: %v", err)
+	}
+	s.Sections = nil
+	for _, entry := range r.UntagArray(arr) {
+		entry, ok := entry.([]interface{})
+		if !ok || len(entry) != 4 {
+			return errors.New("cbor snapshot entry should be an array of length 4")
+		}
+		t, ok := entry[0].(int)
+		if !ok {
+			return errors.New("cbor snapshot entry must start with the section type")
+		}
+		m, ok := entry[1].(map[int]interface{})
+		if !ok {
+			return errors.New("cbor snapshot entry must contain a section map")
+		}
+		validSince, ok := entry[2].(int)
+		if !ok {
+			return errors.New("cbor snapshot entry must contain validSince")
+		}
+		validUntil, ok := entry[3].(int)
+		if !ok {
+			return errors.New("cbor snapshot entry must contain validUntil")
+		}
+		var sec interface {
+			WithSigForward
+			UnmarshalMap(map[int]interface{}) error
+		}
+		switch t {
+		case snapshotAssertion:
+			sec = &Assertion{}
+		case snapshotShard:
+			sec = &Shard{}
+		case snapshotPshard:
+			sec = &Pshard{}
+		case snapshotZone:
+			sec = &Zone{}
+		default:
+			return fmt.Errorf("unsupported section type in snapshot: %d", t)
+		}
+		if err := sec.UnmarshalMap(m); err != nil {
+			return err
+		}
+		sec.SetValidSince(int64(validSince))
+		sec.SetValidUntil(int64(validUntil))
+		s.Sections = append(s.Sections, sec)
+	}
+	return nil
+}
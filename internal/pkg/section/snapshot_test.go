@@ -0,0 +1,38 @@
+package section
+
+import (
+	"bytes"
+	"testing"
+
+	cbor "github.com/britram/borat"
+)
+
+func TestSnapshotTransfer(t *testing.T) {
+	sections := []WithSigForward{GetAssertion(), GetShard(), GetPshard(), GetZone()}
+	for i, s := range sections {
+		s.AddSig(Signature())
+		s.SetValidSince(int64(1000 * (i + 1)))
+		s.SetValidUntil(int64(2000 * (i + 1)))
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewCBORWriter(encoding).Marshal(&Snapshot{Sections: sections}); err != nil {
+		t.Fatalf("Was not able to encode snapshot: %v", err)
+	}
+	snapshot := &Snapshot{}
+	if err := cbor.NewCBORReader(encoding).Unmarshal(snapshot); err != nil {
+		t.Fatalf("Was not able to decode snapshot: %v", err)
+	}
+	if len(snapshot.Sections) != len(sections) {
+		t.Fatalf("wrong number of sections expected=%d actual=%d", len(sections),
+			len(snapshot.Sections))
+	}
+	for i, s := range snapshot.Sections {
+		if s.ValidSince() != sections[i].ValidSince() || s.ValidUntil() != sections[i].ValidUntil() {
+			t.Errorf("%d: validity not preserved expected=[%d,%d] actual=[%d,%d]", i,
+				sections[i].ValidSince(), sections[i].ValidUntil(), s.ValidSince(), s.ValidUntil())
+		}
+		if s.Hash() != sections[i].Hash() {
+			t.Errorf("%d: decoded section differs expected=%v actual=%v", i, sections[i], s)
+		}
+	}
+}
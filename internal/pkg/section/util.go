@@ -2,11 +2,75 @@ package section
 
 import (
 	"math"
+	"strings"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 )
 
+//GlobalContext is the canonical representation of the global context. An empty context denotes the
+//global context as well. Only contained assertions of a shard or zone have an empty context which
+//they inherit from the containing section instead.
+const GlobalContext = "."
+
+//RootZone is the name of the root zone. It is spelled like GlobalContext but denotes a zone.
+const RootZone = "."
+
+//CanonicalContext returns the canonical representation of ctx, i.e. GlobalContext for an empty
+//context. Contexts must be compared in their canonical representation.
+func CanonicalContext(ctx string) string {
+	if ctx == "" {
+		return GlobalContext
+	}
+	return ctx
+}
+
+//IsGlobalContext returns true if ctx denotes the global context.
+func IsGlobalContext(ctx string) bool {
+	return CanonicalContext(ctx) == GlobalContext
+}
+
+//EqualContexts returns true if a and b denote the same context.
+func EqualContexts(a, b string) bool {
+	return CanonicalContext(a) == CanonicalContext(b)
+}
+
+//ApexName is the canonical subject name of an assertion about its zone itself, i.e. about the zone
+//apex. An empty subject name also denotes the apex and is encoded as ApexName. Apex assertions sort
+//before all other assertions of a zone and are in the range of every shard of the zone, i.e. every
+//shard of a zone must contain all of its apex assertions.
+const ApexName = "@"
+
+//IsApex returns true if subjectName denotes the apex of a zone.
+func IsApex(subjectName string) bool {
+	return subjectName == "" || subjectName == ApexName
+}
+
+//EnclosingZone returns the subject name of name in zone and true if name is zone or a name below
+//it. The subject name of the zone itself is ApexName. Only whole labels are matched, e.g.
+//foobar.com. is not in the zone bar.com.
+func EnclosingZone(name, zone string) (string, bool) {
+	if name == zone {
+		return ApexName, true
+	}
+	suffix := "." + zone
+	if zone == RootZone {
+		suffix = zone
+	}
+	if len(name) <= len(suffix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(name, suffix), true
+}
+
+//sortName returns the name by which subjectName is ordered. The apex sorts before all other names.
+func sortName(subjectName string) string {
+	if IsApex(subjectName) {
+		return ""
+	}
+	return subjectName
+}
+
 func UpdateValidity(validSince, validUntil, oldValidSince, oldValidUntil int64,
 	maxValidity time.Duration) (int64, int64) {
 	if oldValidSince == 0 {
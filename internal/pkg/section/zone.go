@@ -1,16 +1,17 @@
 package section
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
 
@@ -95,9 +96,10 @@ func (z *Zone) Sigs(keySpace keys.KeySpaceID) []signature.Sig {
 	return filterSigs(z.Signatures, keySpace)
 }
 
-//AddSig adds the given signature
+//AddSig adds a copy of the given signature such that a later change to the caller's signature data
+//does not affect the stored signature.
 func (z *Zone) AddSig(sig signature.Sig) {
-	z.Signatures = append(z.Signatures, sig)
+	z.Signatures = append(z.Signatures, sig.Copy())
 }
 
 //DeleteSig deletes ith signature
@@ -170,15 +172,12 @@ func (z *Zone) SetValidUntil(validUntil int64) {
 	z.validUntil = validUntil
 }
 
-//Hash returns a string containing all information uniquely identifying a shard.
+//Hash returns a hex encoded digest uniquely identifying a zone.
 func (z *Zone) Hash() string {
 	if z == nil {
 		return "Z_nil"
 	}
-	encoding := new(bytes.Buffer)
-	w := cbor.NewCBORWriter(encoding)
-	w.WriteArray([]interface{}{4, z})
-	return encoding.String()
+	return hashZone(z)
 }
 
 //Sort sorts the content of the zone lexicographically.
@@ -198,9 +197,9 @@ func (z *Zone) CompareTo(zone *Zone) int {
 		return -1
 	} else if z.SubjectZone > zone.SubjectZone {
 		return 1
-	} else if z.Context < zone.Context {
+	} else if CanonicalContext(z.Context) < CanonicalContext(zone.Context) {
 		return -1
-	} else if z.Context > zone.Context {
+	} else if CanonicalContext(z.Context) > CanonicalContext(zone.Context) {
 		return 1
 	} else if len(z.Content) < len(zone.Content) {
 		return -1
@@ -235,6 +234,59 @@ func (z *Zone) IsConsistent() bool {
 	return true
 }
 
+//Validate returns an error listing all contained assertions which are nil or whose context or
+//subject zone is neither empty nor equal to z's. Such a zone cannot be signed or verified as the
+//context and subject zone of its content are replaced by z's.
+func (z *Zone) Validate() error {
+	problems := []string{}
+	for i, a := range z.Content {
+		if a == nil {
+			problems = append(problems, fmt.Sprintf("content[%d] is nil", i))
+			continue
+		}
+		if a.SubjectZone != "" && a.SubjectZone != z.SubjectZone {
+			problems = append(problems, fmt.Sprintf("content[%d] %s has subject zone %s",
+				i, a.SubjectName, a.SubjectZone))
+		}
+		if a.Context != "" && !EqualContexts(a.Context, z.Context) {
+			problems = append(problems, fmt.Sprintf("content[%d] %s has context %s",
+				i, a.SubjectName, a.Context))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("zone %s in context %s is invalid: %s", z.SubjectZone, z.Context,
+			strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+//Project returns a new zone containing copies of z's assertions which answer q together with their
+//signatures. The zone's signatures are only kept if all assertions answer q as they do not cover a
+//subset of the content. A client can then only verify the assertions which are signed themselves.
+//If no assertion answers q, a copy of z is returned as the whole signed zone is needed to prove
+//that the name does not exist.
+func (z *Zone) Project(q *query.Name) *Zone {
+	projection := &Zone{
+		SubjectZone: z.SubjectZone,
+		Context:     z.Context,
+		validSince:  z.validSince,
+		validUntil:  z.validUntil,
+	}
+	for _, a := range z.Content {
+		if AssertionAnswers(a.Copy(z.Context, z.SubjectZone), q) {
+			projection.Content = append(projection.Content, a.Copy(a.Context, a.SubjectZone))
+		}
+	}
+	if len(projection.Content) == 0 || len(projection.Content) == len(z.Content) {
+		projection.Content = make([]*Assertion, len(z.Content))
+		for i, a := range z.Content {
+			projection.Content[i] = a.Copy(a.Context, a.SubjectZone)
+		}
+		projection.Signatures = append([]signature.Sig{}, z.Signatures...)
+	}
+	return projection
+}
+
 //NeededKeys adds to keysNeeded key meta data which is necessary to verify all z's signatures.
 func (z *Zone) NeededKeys(keysNeeded map[signature.MetaData]bool) {
 	extractNeededKeys(z, keysNeeded)
@@ -255,3 +307,68 @@ func (z *Zone) DontAddSigInMarshaller() {
 		s.DontAddSigInMarshaller()
 	}
 }
+
+//DiffZones compares the content of oldZone and newZone. Assertions are matched by their subject
+//name and the set of object types they contain. Several assertions of a zone may share a subject
+//name and type set. Such assertions are first matched by equal content. Added contains assertions
+//only present in newZone, removed those only present in oldZone and changed the newZone's version of
+//matched assertions whose content differs. An assertion is only reported as changed if its subject
+//name and type set occur exactly once in both zones, such that it is unambiguous which assertion it
+//replaces. Otherwise, the differing assertions are reported as removed and added. Signatures are
+//not taken into account.
+func DiffZones(oldZone, newZone *Zone) (added, removed, changed []*Assertion) {
+	var oldContent, newContent []*Assertion
+	if oldZone != nil {
+		oldContent = oldZone.Content
+	}
+	if newZone != nil {
+		newContent = newZone.Content
+	}
+	//oldIndices maps the key of an assertion to the indices of old assertions having it.
+	oldIndices := make(map[string][]int)
+	for i, a := range oldContent {
+		oldIndices[diffKey(a)] = append(oldIndices[diffKey(a)], i)
+	}
+	newCount := make(map[string]int)
+	for _, a := range newContent {
+		newCount[diffKey(a)]++
+	}
+	oldMatched := make([]bool, len(oldContent))
+	newMatched := make([]bool, len(newContent))
+	for i, a := range newContent {
+		for _, j := range oldIndices[diffKey(a)] {
+			if !oldMatched[j] && oldContent[j].CompareTo(a) == 0 {
+				oldMatched[j], newMatched[i] = true, true
+				break
+			}
+		}
+	}
+	for i, a := range newContent {
+		if newMatched[i] {
+			continue
+		}
+		key := diffKey(a)
+		if indices := oldIndices[key]; len(indices) == 1 && newCount[key] == 1 {
+			oldMatched[indices[0]] = true
+			changed = append(changed, a)
+			continue
+		}
+		added = append(added, a)
+	}
+	for i, a := range oldContent {
+		if !oldMatched[i] {
+			removed = append(removed, a)
+		}
+	}
+	return
+}
+
+//diffKey returns a string identifying a by its subject name and the set of its object types.
+func diffKey(a *Assertion) string {
+	types := []int{}
+	for _, o := range a.Content {
+		types = append(types, int(o.Type))
+	}
+	sort.Ints(types)
+	return fmt.Sprintf("%s %v", a.SubjectName, types)
+}
@@ -0,0 +1,167 @@
+package section
+
+import (
+	"errors"
+	"fmt"
+
+	cbor "github.com/britram/borat"
+
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+//MaxZoneChunks is the maximal number of chunks into which a zone can be split.
+const MaxZoneChunks = 1024
+
+//ZoneChunk is a part of a zone which is too large to be sent in a single message. The chunks of a
+//zone are sent in separate messages with the same token. Each chunk carries the zone's signatures
+//and the information needed to reassemble the zone, but only the reassembled zone can be verified.
+type ZoneChunk struct {
+	Signatures  []signature.Sig
+	SubjectZone string
+	Context     string
+	Seq         int //position of this chunk starting at 0
+	Total       int //number of chunks of the zone
+	Content     []*Assertion
+}
+
+//SplitZone returns the chunks of zone. Each chunk contains the assertions of zone in the given
+//order, where sizes[i] is the number of assertions of the i-th chunk.
+func SplitZone(zone *Zone, sizes []int) []*ZoneChunk {
+	chunks := make([]*ZoneChunk, len(sizes))
+	content := zone.Content
+	for i, size := range sizes {
+		chunks[i] = &ZoneChunk{
+			Signatures:  zone.Signatures,
+			SubjectZone: zone.SubjectZone,
+			Context:     zone.Context,
+			Seq:         i,
+			Total:       len(sizes),
+			Content:     content[:size:size],
+		}
+		content = content[size:]
+	}
+	return chunks
+}
+
+//AssembleZone returns the zone from which chunks were split. The chunks can be in any order. It
+//returns an error if chunks are missing, duplicated or belong to different zones. The signatures
+//of the resulting zone are not verified.
+func AssembleZone(chunks []*ZoneChunk) (*Zone, error) {
+	if len(chunks) == 0 {
+		return nil, errors.New("no zone chunks to assemble")
+	}
+	first := chunks[0]
+	if first.Total != len(chunks) {
+		return nil, fmt.Errorf("wrong number of zone chunks expected=%d actual=%d", first.Total,
+			len(chunks))
+	}
+	ordered := make([]*ZoneChunk, len(chunks))
+	for _, c := range chunks {
+		if c.SubjectZone != first.SubjectZone || !EqualContexts(c.Context, first.Context) || c.Total != first.Total {
+			return nil, fmt.Errorf("zone chunks belong to different zones: %s %s and %s %s",
+				first.Context, first.SubjectZone, c.Context, c.SubjectZone)
+		}
+		if c.Seq < 0 || c.Seq >= len(chunks) || ordered[c.Seq] != nil {
+			return nil, fmt.Errorf("invalid or duplicate zone chunk sequence number %d", c.Seq)
+		}
+		ordered[c.Seq] = c
+	}
+	zone := &Zone{
+		Signatures:  first.Signatures,
+		SubjectZone: first.SubjectZone,
+		Context:     first.Context,
+		Content:     []*Assertion{},
+	}
+	for _, c := range ordered {
+		zone.Content = append(zone.Content, c.Content...)
+	}
+	return zone, nil
+}
+
+// UnmarshalMap decodes the output from the CBOR decoder into this struct.
+func (c *ZoneChunk) UnmarshalMap(m map[int]interface{}) error {
+	if sigs, ok := m[0].([]interface{}); ok {
+		c.Signatures = make([]signature.Sig, len(sigs))
+		for i, sig := range sigs {
+			sigVal, ok := sig.([]interface{})
+			if !ok {
+				return errors.New("cbor zone chunk signatures entry is not an array")
+			}
+			if err := c.Signatures[i].UnmarshalArray(sigVal); err != nil {
+				return err
+			}
+		}
+	} else if _, ok := m[0]; ok {
+		return errors.New("cbor zone chunk signatures should be an array")
+	} //signatures may be omitted if there are none
+	if zone, ok := m[4].(string); ok {
+		c.SubjectZone = zone
+	} else {
+		return errors.New("cbor zone chunk map does not contain a subject zone")
+	}
+	if ctx, ok := m[6].(string); ok {
+		c.Context = ctx
+	} else {
+		return errors.New("cbor zone chunk map does not contain a context")
+	}
+	seq, ok := m[27].(int)
+	if !ok {
+		return errors.New("cbor zone chunk map does not contain a sequence number")
+	}
+	total, ok := m[28].(int)
+	if !ok || total <= 0 || total > MaxZoneChunks || seq < 0 || seq >= total {
+		return fmt.Errorf("cbor zone chunk map contains an invalid sequence number %d of %v", seq,
+			m[28])
+	}
+	c.Seq, c.Total = seq, total
+	cont, ok := m[23].([]interface{})
+	if !ok {
+		return errors.New("cbor zone chunk map does not contain a content")
+	}
+	c.Content = []*Assertion{}
+	for _, obj := range cont {
+		a, ok := obj.(map[int]interface{})
+		if !ok {
+			return errors.New("cbor zone chunk content entry is not a map")
+		}
+		as := &Assertion{}
+		if err := as.UnmarshalMap(a); err != nil {
+			return err
+		}
+		c.Content = append(c.Content, as)
+	}
+	return nil
+}
+
+// MarshalCBOR implements the CBORMarshaler interface.
+func (c *ZoneChunk) MarshalCBOR(w *cbor.CBORWriter) error {
+	m := make(map[int]interface{})
+	//the signatures are encoded even if there are none as older decoders require them.
+	m[0] = c.Signatures
+	if len(c.Signatures) == 0 {
+		m[0] = []signature.Sig{}
+	}
+	m[4] = c.SubjectZone
+	m[6] = c.Context
+	m[23] = nonNilAssertions(c.Content)
+	m[27] = c.Seq
+	m[28] = c.Total
+	return w.WriteIntMap(m)
+}
+
+//Sort sorts the content of each assertion of the chunk. The order of the assertions is preserved as
+//it is determined by the zone.
+func (c *ZoneChunk) Sort() {
+	for _, a := range c.Content {
+		a.Sort()
+	}
+}
+
+//String implements Stringer interface
+func (c *ZoneChunk) String() string {
+	if c == nil {
+		return "ZoneChunk:nil"
+	}
+	return fmt.Sprintf("ZoneChunk:[SZ=%s CTX=%s SEQ=%d/%d CONTENT=%v SIG=%v]",
+		c.SubjectZone, c.Context, c.Seq, c.Total, c.Content, c.Signatures)
+}
@@ -0,0 +1,198 @@
+package section
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	cbor "github.com/britram/borat"
+
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+//ZoneDelta contains the difference between two versions of a zone. It allows a client which
+//already holds the base version of a zone to obtain the new version without a full transfer. The
+//signatures are those of the new version of the zone. A zone delta is not yet a section type of
+//the message encoding as rainsd does not keep signed zone versions to compute deltas from.
+type ZoneDelta struct {
+	Signatures  []signature.Sig
+	SubjectZone string
+	Context     string
+	BaseHash    string //Hash() of the zone this delta applies to
+	Added       []*Assertion
+	Removed     []*Assertion
+	Changed     []*Assertion
+}
+
+//NewZoneDelta returns the delta which transforms oldZone into newZone. It carries newZone's
+//signatures such that the resulting zone can be verified by the client.
+func NewZoneDelta(oldZone, newZone *Zone) *ZoneDelta {
+	added, removed, changed := DiffZones(oldZone, newZone)
+	return &ZoneDelta{
+		Signatures:  newZone.Signatures,
+		SubjectZone: newZone.SubjectZone,
+		Context:     newZone.Context,
+		BaseHash:    oldZone.Hash(),
+		Added:       added,
+		Removed:     removed,
+		Changed:     changed,
+	}
+}
+
+//Apply returns a new zone obtained by applying d to base. It returns an error if base is not the
+//zone from which d was computed. The resulting zone is sorted but its signatures are not verified.
+func (d *ZoneDelta) Apply(base *Zone) (*Zone, error) {
+	if base == nil || base.Hash() != d.BaseHash {
+		return nil, errors.New("zone delta does not apply to the given base zone")
+	}
+	if base.SubjectZone != d.SubjectZone || !EqualContexts(base.Context, d.Context) {
+		return nil, fmt.Errorf("zone delta is for a different zone: expected=%s %s actual=%s %s",
+			d.Context, d.SubjectZone, base.Context, base.SubjectZone)
+	}
+	//removed maps a key to the removed assertions having it. Each removes one equal assertion.
+	removed := make(map[string][]*Assertion)
+	for _, a := range d.Removed {
+		removed[diffKey(a)] = append(removed[diffKey(a)], a)
+	}
+	changed := make(map[string]*Assertion)
+	for _, a := range d.Changed {
+		changed[diffKey(a)] = a
+	}
+	zone := &Zone{
+		Signatures:  append([]signature.Sig{}, d.Signatures...),
+		SubjectZone: d.SubjectZone,
+		Context:     d.Context,
+	}
+	for _, a := range base.Content {
+		key := diffKey(a)
+		if i := indexOfEqual(removed[key], a); i >= 0 {
+			removed[key] = append(removed[key][:i], removed[key][i+1:]...)
+			continue
+		}
+		if c, ok := changed[key]; ok {
+			a = c
+		}
+		zone.Content = append(zone.Content, a)
+	}
+	zone.Content = append(zone.Content, d.Added...)
+	zone.Sort()
+	return zone, nil
+}
+
+//indexOfEqual returns the index of the first assertion of assertions whose content equals a's or -1
+//if there is none.
+func indexOfEqual(assertions []*Assertion, a *Assertion) int {
+	for i, r := range assertions {
+		if r.CompareTo(a) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// UnmarshalMap decodes the output from the CBOR decoder into this struct.
+func (d *ZoneDelta) UnmarshalMap(m map[int]interface{}) error {
+	if sigs, ok := m[0].([]interface{}); ok {
+		d.Signatures = make([]signature.Sig, len(sigs))
+		for i, sig := range sigs {
+			sigVal, ok := sig.([]interface{})
+			if !ok {
+				return errors.New("cbor zone delta signatures entry is not an array")
+			}
+			if err := d.Signatures[i].UnmarshalArray(sigVal); err != nil {
+				return err
+			}
+		}
+	} else if _, ok := m[0]; ok {
+		return errors.New("cbor zone delta signatures should be an array")
+	} //signatures may be omitted if there are none
+	if zone, ok := m[4].(string); ok {
+		d.SubjectZone = zone
+	} else {
+		return errors.New("cbor zone delta map does not contain a subject zone")
+	}
+	if ctx, ok := m[6].(string); ok {
+		d.Context = ctx
+	} else {
+		return errors.New("cbor zone delta map does not contain a context")
+	}
+	if hash, ok := m[26].(string); ok {
+		d.BaseHash = hash
+	} else {
+		return errors.New("cbor zone delta map does not contain a base hash")
+	}
+	var err error
+	if d.Added, err = unmarshalDeltaContent(m[23]); err != nil {
+		return err
+	}
+	if d.Removed, err = unmarshalDeltaContent(m[24]); err != nil {
+		return err
+	}
+	d.Changed, err = unmarshalDeltaContent(m[25])
+	return err
+}
+
+func unmarshalDeltaContent(value interface{}) ([]*Assertion, error) {
+	cont, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("cbor zone delta map does not contain a content")
+	}
+	var assertions []*Assertion
+	for _, obj := range cont {
+		as := &Assertion{}
+		a, ok := obj.(map[int]interface{})
+		if !ok {
+			return nil, errors.New("cbor zone delta content entry is not a map")
+		}
+		if err := as.UnmarshalMap(a); err != nil {
+			return nil, err
+		}
+		assertions = append(assertions, as)
+	}
+	return assertions, nil
+}
+
+// MarshalCBOR implements the CBORMarshaler interface.
+func (d *ZoneDelta) MarshalCBOR(w *cbor.CBORWriter) error {
+	m := make(map[int]interface{})
+	//the signatures are encoded even if there are none as older decoders require them.
+	m[0] = d.Signatures
+	if len(d.Signatures) == 0 {
+		m[0] = []signature.Sig{}
+	}
+	m[4] = d.SubjectZone
+	m[6] = d.Context
+	m[23] = nonNilAssertions(d.Added)
+	m[24] = nonNilAssertions(d.Removed)
+	m[25] = nonNilAssertions(d.Changed)
+	m[26] = d.BaseHash
+	return w.WriteIntMap(m)
+}
+
+func nonNilAssertions(assertions []*Assertion) []*Assertion {
+	if assertions == nil {
+		return []*Assertion{}
+	}
+	return assertions
+}
+
+//Sort sorts the content of the zone delta lexicographically.
+func (d *ZoneDelta) Sort() {
+	for _, list := range [][]*Assertion{d.Added, d.Removed, d.Changed} {
+		for _, a := range list {
+			a.Sort()
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].CompareTo(list[j]) < 0
+		})
+	}
+}
+
+//String implements Stringer interface
+func (d *ZoneDelta) String() string {
+	if d == nil {
+		return "ZoneDelta:nil"
+	}
+	return fmt.Sprintf("ZoneDelta:[SZ=%s CTX=%s BASE=%x ADDED=%v REMOVED=%v CHANGED=%v SIG=%v]",
+		d.SubjectZone, d.Context, d.BaseHash, d.Added, d.Removed, d.Changed, d.Signatures)
+}
@@ -0,0 +1,110 @@
+package section
+
+import (
+	"bytes"
+	"testing"
+
+	cbor "github.com/britram/borat"
+
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/keys"
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
+)
+
+func TestZoneDeltaTransfer(t *testing.T) {
+	sig := func(data string) signature.Sig {
+		return signature.Sig{
+			PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519},
+			ValidSince:  1000,
+			ValidUntil:  2000,
+			Data:        []byte(data),
+		}
+	}
+	redir := func(name, value string) *Assertion {
+		return &Assertion{SubjectName: name,
+			Content: []object.Object{object.Object{Type: object.OTRedirection, Value: value}}}
+	}
+	var tests = []struct {
+		oldContent, newContent []*Assertion
+	}{
+		{[]*Assertion{redir("a", "ns1"), redir("b", "ns1"), redir("c", "ns1")},
+			[]*Assertion{redir("a", "ns1"), redir("b", "ns2"), redir("d", "ns1")}},
+		//several assertions share a subject name and type set
+		{[]*Assertion{redir("a", "ns1"), redir("a", "ns2"), redir("b", "ns1")},
+			[]*Assertion{redir("a", "ns1"), redir("a", "ns3"), redir("b", "ns1"),
+				redir("b", "ns2")}},
+		{[]*Assertion{redir("a", "ns1"), redir("a", "ns1"), redir("a", "ns2")},
+			[]*Assertion{redir("a", "ns1")}},
+		{[]*Assertion{redir("a", "ns1")},
+			[]*Assertion{redir("a", "ns1"), redir("a", "ns1"), redir("a", "ns2")}},
+	}
+	for i, test := range tests {
+		oldZone := &Zone{
+			Signatures:  []signature.Sig{sig("old")},
+			SubjectZone: testDomain,
+			Context:     globalContext,
+			Content:     test.oldContent,
+		}
+		newZone := &Zone{
+			Signatures:  []signature.Sig{sig("new")},
+			SubjectZone: testDomain,
+			Context:     globalContext,
+			Content:     test.newContent,
+		}
+		oldZone.Sort()
+		newZone.Sort()
+
+		clientZone := transferZone(oldZone, t)
+		fullZone := transferZone(newZone, t)
+		delta := transferZoneDelta(NewZoneDelta(oldZone, newZone), t)
+		ixfrZone, err := delta.Apply(clientZone)
+		if err != nil {
+			t.Fatalf("%d: Was not able to apply zone delta, err=%v", i, err)
+		}
+		if ixfrZone.CompareTo(fullZone) != 0 || ixfrZone.Hash() != fullZone.Hash() {
+			t.Fatalf("%d: incremental transfer differs from full transfer expected=%s actual=%s", i,
+				fullZone, ixfrZone)
+		}
+		if _, err := delta.Apply(fullZone); err == nil {
+			t.Errorf("%d: zone delta must not apply to a different base zone", i)
+		}
+	}
+}
+
+func TestZoneDeltaNoSignatures(t *testing.T) {
+	delta := transferZoneDelta(&ZoneDelta{SubjectZone: "ch.", Context: "."}, t)
+	if len(delta.Signatures) != 0 || delta.SubjectZone != "ch." || delta.Context != "." {
+		t.Errorf("unsigned zone delta was not transferred correctly: %v", delta)
+	}
+}
+
+// transferZone encodes z and returns the zone decoded from it.
+func transferZone(z *Zone, t *testing.T) *Zone {
+	decoded := &Zone{}
+	if err := decoded.UnmarshalMap(transferMap(z, t)); err != nil {
+		t.Fatalf("Was not able to decode zone, err=%v", err)
+	}
+	return decoded
+}
+
+// transferZoneDelta encodes d and returns the zone delta decoded from it.
+func transferZoneDelta(d *ZoneDelta, t *testing.T) *ZoneDelta {
+	decoded := &ZoneDelta{}
+	if err := decoded.UnmarshalMap(transferMap(d, t)); err != nil {
+		t.Fatalf("Was not able to decode zone delta, err=%v", err)
+	}
+	return decoded
+}
+
+func transferMap(s interface{}, t *testing.T) map[int]interface{} {
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewCBORWriter(encoding).Marshal(s); err != nil {
+		t.Fatalf("Was not able to encode section, err=%v", err)
+	}
+	m, err := cbor.NewCBORReader(encoding).ReadIntMapUntagged()
+	if err != nil {
+		t.Fatalf("Was not able to decode section, err=%v", err)
+	}
+	return m
+}
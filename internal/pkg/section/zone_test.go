@@ -2,9 +2,15 @@ package section
 
 import (
 	"math/rand"
+	"net"
 	"reflect"
 	"sort"
+	"strconv"
 	"testing"
+
+	"github.com/netsec-ethz/rains/internal/pkg/object"
+	"github.com/netsec-ethz/rains/internal/pkg/query"
+	"github.com/netsec-ethz/rains/internal/pkg/signature"
 )
 
 func TestZoneInterval(t *testing.T) {
@@ -38,6 +44,81 @@ func TestZoneIsConsistent(t *testing.T) {
 	}
 }
 
+func TestZoneValidate(t *testing.T) {
+	var tests = []struct {
+		input *Zone
+		want  string
+	}{
+		{new(Zone), ""},
+		{&Zone{SubjectZone: "ch.", Context: ".", Content: []*Assertion{
+			&Assertion{SubjectName: "a"},
+			&Assertion{SubjectName: "b", SubjectZone: "ch.", Context: "."}}}, ""},
+		{&Zone{SubjectZone: "ch.", Context: ".", Content: []*Assertion{
+			&Assertion{SubjectName: "a", SubjectZone: "de."}}},
+			"zone ch. in context . is invalid: content[0] a has subject zone de."},
+		{&Zone{SubjectZone: "ch.", Context: ".", Content: []*Assertion{
+			&Assertion{SubjectName: "a"},
+			&Assertion{SubjectName: "b", Context: "cx-ch."},
+			nil}},
+			"zone ch. in context . is invalid: content[1] b has context cx-ch., content[2] is nil"},
+	}
+	for i, test := range tests {
+		err := test.input.Validate()
+		if test.want == "" && err != nil {
+			t.Errorf("%d: valid zone returned an error: %v", i, err)
+		} else if test.want != "" && (err == nil || err.Error() != test.want) {
+			t.Errorf("%d: unexpected error expected=%s actual=%v", i, test.want, err)
+		}
+	}
+}
+
+func TestZoneProject(t *testing.T) {
+	zoneSig := signature.Sig{ValidUntil: 1000, Data: []byte("zoneSig")}
+	large := &Zone{SubjectZone: "ch.", Context: ".", Signatures: []signature.Sig{zoneSig}}
+	for i := 0; i < 100; i++ {
+		large.Content = append(large.Content, &Assertion{SubjectName: "name" + strconv.Itoa(i),
+			Content:    []object.Object{object.Object{Type: object.OTIP4Addr, Value: "192.0.2.1"}},
+			Signatures: []signature.Sig{signature.Sig{ValidUntil: 1000, Data: []byte(strconv.Itoa(i))}}})
+	}
+	single := &Zone{SubjectZone: "ch.", Context: ".", Signatures: []signature.Sig{zoneSig},
+		Content: []*Assertion{large.Content[42]}}
+	var tests = []struct {
+		zone    *Zone
+		query   *query.Name
+		content []*Assertion
+		sigs    []signature.Sig
+	}{
+		{large, &query.Name{Name: "name42.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}},
+			[]*Assertion{large.Content[42]}, nil},
+		{large, &query.Name{Name: "name100.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}},
+			large.Content, []signature.Sig{zoneSig}}, //negative answer needs the whole zone
+		{large, &query.Name{Name: "name42.ch.", Context: ".", Types: []object.Type{object.OTIP6Addr}},
+			large.Content, []signature.Sig{zoneSig}}, //no object of the queried type
+		{single, &query.Name{Name: "name42.ch.", Context: ".", Types: []object.Type{object.OTIP4Addr}},
+			single.Content, []signature.Sig{zoneSig}}, //content unchanged
+	}
+	for i, test := range tests {
+		p := test.zone.Project(test.query)
+		if p.SubjectZone != test.zone.SubjectZone || p.Context != test.zone.Context {
+			t.Errorf("%d: projection has wrong zone or context: %v", i, p)
+		}
+		if len(p.Content) != len(test.content) {
+			t.Fatalf("%d: wrong number of assertions expected=%d actual=%d", i, len(test.content),
+				len(p.Content))
+		}
+		for j, a := range p.Content {
+			if a == test.content[j] || a.CompareTo(test.content[j]) != 0 ||
+				!reflect.DeepEqual(a.Signatures, test.content[j].Signatures) {
+				t.Errorf("%d: assertion %d is not an intact copy expected=%v actual=%v", i, j,
+					test.content[j], a)
+			}
+		}
+		if !reflect.DeepEqual(p.Signatures, test.sigs) {
+			t.Errorf("%d: wrong zone signatures expected=%v actual=%v", i, test.sigs, p.Signatures)
+		}
+	}
+}
+
 func TestZoneCompareTo(t *testing.T) {
 	zones := sortedZones(5)
 	shuffled := append([]*Zone{}, zones...)
@@ -78,6 +159,56 @@ func TestZoneSort(t *testing.T) {
 	}
 }
 
+func TestDiffZones(t *testing.T) {
+	ip4 := object.Object{Type: object.OTIP4Addr, Value: ip4TestAddr}
+	ip4New := object.Object{Type: object.OTIP4Addr, Value: ip4TestAddr2}
+	ip6 := object.Object{Type: object.OTIP6Addr, Value: ip6TestAddr}
+	unchanged := &Assertion{SubjectName: "a", Content: []object.Object{ip4}}
+	resigned := &Assertion{SubjectName: "a", Content: []object.Object{ip4},
+		Signatures: []signature.Sig{signature.Sig{ValidUntil: 10}}}
+	oldB := &Assertion{SubjectName: "b", Content: []object.Object{ip4}}
+	newB := &Assertion{SubjectName: "b", Content: []object.Object{ip4New}}
+	oldC := &Assertion{SubjectName: "c", Content: []object.Object{ip4}}
+	newC := &Assertion{SubjectName: "c", Content: []object.Object{ip6}}
+	var tests = []struct {
+		oldZone, newZone *Zone
+		added            []*Assertion
+		removed          []*Assertion
+		changed          []*Assertion
+	}{
+		{nil, nil, nil, nil, nil},
+		{&Zone{}, &Zone{Content: []*Assertion{unchanged}}, []*Assertion{unchanged}, nil, nil},
+		{&Zone{Content: []*Assertion{unchanged}}, &Zone{}, nil, []*Assertion{unchanged}, nil},
+		{&Zone{Content: []*Assertion{unchanged}}, &Zone{Content: []*Assertion{resigned}}, nil, nil, nil},
+		{&Zone{Content: []*Assertion{unchanged, oldB}}, &Zone{Content: []*Assertion{unchanged, newB}},
+			nil, nil, []*Assertion{newB}},
+		//the set of types differs, hence it is a removal and an addition
+		{&Zone{Content: []*Assertion{oldC}}, &Zone{Content: []*Assertion{newC}},
+			[]*Assertion{newC}, []*Assertion{oldC}, nil},
+		{&Zone{Content: []*Assertion{unchanged, oldB, oldC}}, &Zone{Content: []*Assertion{newB, newC}},
+			[]*Assertion{newC}, []*Assertion{unchanged, oldC}, []*Assertion{newB}},
+		//several assertions share a subject name and type set
+		{&Zone{Content: []*Assertion{oldB, oldB}}, &Zone{Content: []*Assertion{oldB}},
+			nil, []*Assertion{oldB}, nil},
+		{&Zone{Content: []*Assertion{oldB}}, &Zone{Content: []*Assertion{oldB, oldB, newB}},
+			[]*Assertion{oldB, newB}, nil, nil},
+		{&Zone{Content: []*Assertion{oldB, newB}}, &Zone{Content: []*Assertion{newB, newB}},
+			[]*Assertion{newB}, []*Assertion{oldB}, nil},
+	}
+	for i, test := range tests {
+		added, removed, changed := DiffZones(test.oldZone, test.newZone)
+		if !reflect.DeepEqual(added, test.added) {
+			t.Errorf("%d: unexpected added assertions expected=%v actual=%v", i, test.added, added)
+		}
+		if !reflect.DeepEqual(removed, test.removed) {
+			t.Errorf("%d: unexpected removed assertions expected=%v actual=%v", i, test.removed, removed)
+		}
+		if !reflect.DeepEqual(changed, test.changed) {
+			t.Errorf("%d: unexpected changed assertions expected=%v actual=%v", i, test.changed, changed)
+		}
+	}
+}
+
 func checkZone(z1, z2 *Zone, t *testing.T) {
 	if z1.Context != z2.Context {
 		t.Error("Zone context mismatch")
@@ -93,3 +224,17 @@ func checkZone(z1, z2 *Zone, t *testing.T) {
 		checkAssertion(s1, z2.Content[i], t)
 	}
 }
+
+func BenchmarkZoneHash(b *testing.B) {
+	ip := object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1").To4()}
+	zone := &Zone{SubjectZone: "ch.", Context: ".", Signatures: []signature.Sig{Signature()}}
+	for i := 0; i < 5000; i++ {
+		zone.Content = append(zone.Content, &Assertion{SubjectName: strconv.Itoa(i),
+			Content: []object.Object{ip}})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zone.Hash()
+	}
+}
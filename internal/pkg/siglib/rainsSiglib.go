@@ -5,13 +5,17 @@ package siglib
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
 	"time"
 
 	cbor "github.com/britram/borat"
 	log "github.com/inconshreveable/log15"
 
+	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	rcbor "github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
@@ -22,18 +26,31 @@ import (
 )
 
 //CheckSectionSignatures verifies all signatures on s and its content. It assumes that s is sorted.
-//Expired signatures are removed. Returns true if all non expired signatures are correct.
+//Expired signatures are removed. Returns true if all non expired signatures are correct. It returns
+//false for a zone which does not pass Validate and for a section containing an object whose value
+//does not match its type.
 func CheckSectionSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys.PublicKey,
 	maxVal util.MaxCacheValidity) bool {
+	return CheckSectionSignaturesWithGrace(s, pkeys, maxVal, 0)
+}
+
+//CheckSectionSignaturesWithGrace behaves like CheckSectionSignatures but a public key which expired
+//less than grace ago still vouches for the section during grace. As before, a key is only used for
+//signatures whose validity starts before the key expired.
+func CheckSectionSignaturesWithGrace(s section.WithSig, pkeys map[keys.PublicKeyID][]keys.PublicKey,
+	maxVal util.MaxCacheValidity, grace time.Duration) bool {
+	if !validZone(s) || !validObjects(s) {
+		return false
+	}
 	s.DontAddSigInMarshaller()
-	if !checkSectionSignatures(s, pkeys, maxVal) {
+	if !checkSectionSignatures(s, pkeys, maxVal, grace) {
 		return false
 	}
 	switch s := s.(type) {
 	case *section.Shard:
 		s.AddCtxAndZoneToContent()
 		for _, a := range s.Content {
-			if len(a.Sigs(keys.RainsKeySpace)) > 0 && !checkSectionSignatures(a, pkeys, maxVal) {
+			if len(a.Sigs(keys.RainsKeySpace)) > 0 && !checkSectionSignatures(a, pkeys, maxVal, grace) {
 				return false
 			}
 		}
@@ -41,7 +58,7 @@ func CheckSectionSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys
 	case *section.Zone:
 		s.AddCtxAndZoneToContent()
 		for _, a := range s.Content {
-			if len(a.Sigs(keys.RainsKeySpace)) > 0 && !checkSectionSignatures(a, pkeys, maxVal) {
+			if len(a.Sigs(keys.RainsKeySpace)) > 0 && !checkSectionSignatures(a, pkeys, maxVal, grace) {
 				return false
 			}
 		}
@@ -51,11 +68,165 @@ func CheckSectionSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys
 	return true
 }
 
+//CheckZoneSignaturesLenient behaves like CheckSectionSignaturesWithGrace for zone but an assertion
+//of zone whose own signature is invalid does not fail the zone. If zone carries a valid signature,
+//it authenticates the assertion and only the assertion's signatures are removed. The zone's
+//signatures do not cover the ones of its assertions and thus remain valid. Otherwise, the assertion
+//is removed from zone. The content of zone is left unchanged if it returns false.
+func CheckZoneSignaturesLenient(zone *section.Zone, pkeys map[keys.PublicKeyID][]keys.PublicKey,
+	maxVal util.MaxCacheValidity, grace time.Duration) bool {
+	if !validZone(zone) || !validObjects(zone) {
+		return false
+	}
+	zone.DontAddSigInMarshaller()
+	defer zone.AddSigInMarshaller()
+	if !checkSectionSignatures(zone, pkeys, maxVal, grace) {
+		return false
+	}
+	signed := len(zone.Sigs(keys.RainsKeySpace)) > 0
+	zone.AddCtxAndZoneToContent()
+	content := []*section.Assertion{}
+	for _, a := range zone.Content {
+		if len(a.Sigs(keys.RainsKeySpace)) > 0 && !checkSectionSignatures(a, pkeys, maxVal, grace) {
+			if !signed {
+				log.Warn("Drop assertion with invalid signature from zone", "zone", zone.SubjectZone,
+					"assertion", a)
+				continue
+			}
+			log.Warn("Drop invalid signatures of assertion authenticated by its zone", "zone",
+				zone.SubjectZone, "assertion", a)
+			a.DeleteAllSigs()
+		}
+		content = append(content, a)
+	}
+	zone.RemoveCtxAndZoneFromContent()
+	zone.Content = content
+	return true
+}
+
+//ApplyZoneDelta applies d to base and verifies the signatures of the resulting zone. It returns
+//the new zone and true if d applies to base and the signatures on the new zone are valid.
+func ApplyZoneDelta(base *section.Zone, d *section.ZoneDelta,
+	pkeys map[keys.PublicKeyID][]keys.PublicKey, maxVal util.MaxCacheValidity) (*section.Zone, bool) {
+	zone, err := d.Apply(base)
+	if err != nil {
+		log.Warn("Was not able to apply zone delta", "error", err)
+		return nil, false
+	}
+	if len(zone.Sigs(keys.RainsKeySpace)) == 0 {
+		log.Warn("Zone obtained from zone delta is not signed", "zone", zone)
+		return nil, false
+	}
+	if !CheckSectionSignatures(zone, pkeys, maxVal) {
+		return nil, false
+	}
+	return zone, true
+}
+
+//CheckObjectSignatures verifies the per-object signatures of all assertions in s. It must be called
+//after the section signatures have been verified with CheckSectionSignatures. A valid section
+//signature takes precedence over per-object signatures: an object of an assertion covered by a
+//valid section signature is authenticated by it and invalid object signatures are only stripped off.
+//An object of an assertion which is not covered by a section signature is only authenticated by
+//its own signatures and is removed if none of them is valid. Returns false if an assertion which
+//is not covered by a section signature does not contain any authenticated object.
+func CheckObjectSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys.PublicKey,
+	maxVal util.MaxCacheValidity) bool {
+	signed := len(s.Sigs(keys.RainsKeySpace)) > 0
+	switch s := s.(type) {
+	case *section.Assertion:
+		return checkObjectSignatures(s, signed, pkeys, maxVal)
+	case *section.Shard:
+		s.AddCtxAndZoneToContent()
+		defer s.RemoveCtxAndZoneFromContent()
+		for _, a := range s.Content {
+			if !checkObjectSignatures(a, signed || len(a.Sigs(keys.RainsKeySpace)) > 0, pkeys, maxVal) {
+				return false
+			}
+		}
+	case *section.Zone:
+		s.AddCtxAndZoneToContent()
+		defer s.RemoveCtxAndZoneFromContent()
+		for _, a := range s.Content {
+			if !checkObjectSignatures(a, signed || len(a.Sigs(keys.RainsKeySpace)) > 0, pkeys, maxVal) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//checkObjectSignatures verifies the per-object signatures of a. authenticated must be true if a is
+//covered by a valid section signature. It assumes that a's context and zone are set.
+func checkObjectSignatures(a *section.Assertion, authenticated bool,
+	pkeys map[keys.PublicKeyID][]keys.PublicKey, maxVal util.MaxCacheValidity) bool {
+	content := []object.Object{}
+	for _, o := range a.Content {
+		if len(o.Signatures) == 0 {
+			if authenticated {
+				content = append(content, o)
+			}
+			continue
+		}
+		sigs := validObjectSigs(a, o, pkeys, maxVal, !authenticated)
+		if len(sigs) == 0 && !authenticated {
+			log.Warn("Object has no valid signature", "assertion", a, "object", o)
+			continue
+		}
+		o.Signatures = sigs
+		content = append(content, o)
+	}
+	a.Content = content
+	return len(a.Content) > 0
+}
+
+//validObjectSigs returns the non expired signatures in the rains key space on o which are valid. If
+//updateValidity is true, a's validity is restricted to the one of the valid signatures.
+func validObjectSigs(a *section.Assertion, o object.Object, pkeys map[keys.PublicKeyID][]keys.PublicKey,
+	maxVal util.MaxCacheValidity, updateValidity bool) []signature.Sig {
+	encoding, err := objectSigEncoding(a, o)
+	if err != nil {
+		log.Warn("Was not able to marshal object.", "error", err)
+		return nil
+	}
+	var sigs []signature.Sig
+	var verified []VerifiedSignature
+	for _, sig := range o.Signatures {
+		if sig.KeySpace != keys.RainsKeySpace || int64(sig.ValidUntil) < time.Now().Unix() {
+			continue
+		}
+		key, ok := getPublicKey(pkeys[sig.PublicKeyID], sig.MetaData())
+		if !ok {
+			log.Warn("No publicKey in keymap matching object signature", "signature", sig)
+			continue
+		}
+		if !sig.VerifySignature(key.Key, encoding) {
+			log.Warn("Object sig does not match", "object", o, "signature", sig)
+			continue
+		}
+		sigs = append(sigs, sig)
+		verified = append(verified, VerifiedSignature{Sig: sig, Key: key})
+	}
+	if updateValidity {
+		updateSectionValidity(a, verified, maxVal)
+	}
+	return sigs
+}
+
+//objectSigEncoding returns the data over which a per-object signature of o is computed. It binds o
+//to a's context, zone and name such that a signed object cannot be moved to another assertion.
+func objectSigEncoding(a *section.Assertion, o object.Object) ([]byte, error) {
+	encoding := new(bytes.Buffer)
+	err := cbor.NewCBORWriter(encoding).WriteArray([]interface{}{a.Context, a.SubjectZone,
+		a.SubjectName, o.WithoutSigs()})
+	return encoding.Bytes(), err
+}
+
 //checkSectionSignatures verifies all signatures on the section (but not signatures on the section's
 //content). It assumes that the section is sorted. Expired signatures are removed. Returns true if
 //all non expired signatures are correct.
 func checkSectionSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys.PublicKey,
-	maxVal util.MaxCacheValidity) bool {
+	maxVal util.MaxCacheValidity, grace time.Duration) bool {
 	log.Debug(fmt.Sprintf("Check %T signature", s), "section", s)
 	if s == nil {
 		log.Warn("section is nil")
@@ -79,32 +250,264 @@ func checkSectionSignatures(s section.WithSig, pkeys map[keys.PublicKeyID][]keys
 		log.Warn("Was not able to marshal section.", "error", err)
 		return false
 	}
+	now := time.Now().Unix()
+	var verified []VerifiedSignature
 	for _, sig := range sigs {
-		if keys, ok := pkeys[sig.PublicKeyID]; ok {
-			if int64(sig.ValidUntil) < time.Now().Unix() {
-				log.Info("signature is expired", "signature", sig)
-				continue
-			}
-			if key, ok := getPublicKey(keys, sig.MetaData()); ok {
-				if !sig.VerifySignature(key.Key, encoding.Bytes()) {
-					log.Warn("Sig does not match", "section", s, "encoding", encoding.Bytes(), "signature", sig)
-					return false
-				}
-				log.Debug("Sig was valid", "section", s, "encoding", encoding.Bytes(), "signature", sig)
-				s.AddSig(sig)
-				updateSectionValidity(s, key.ValidSince, key.ValidUntil, sig.ValidSince, sig.ValidUntil, maxVal)
-			} else {
-				log.Warn("No time overlapping publicKey in keys for signature", "keys", keys, "signature", sig)
-				return false
-			}
-		} else {
-			log.Warn("No publicKey in keymap matching algorithm type", "keymap", pkeys, "publicKeyID", sig.PublicKeyID)
+		key, err := verifySignature(sig, pkeys, encoding.Bytes(), now)
+		if err == errSigExpired {
+			log.Info("signature is expired", "signature", sig)
+			continue
+		}
+		if err != nil {
+			log.Warn("Sig is invalid", "section", s, "encoding", encoding.Bytes(), "signature", sig,
+				"error", err)
 			return false
 		}
+		log.Debug("Sig was valid", "section", s, "encoding", encoding.Bytes(), "signature", sig)
+		s.AddSig(sig)
+		key.ValidUntil += int64(grace / time.Second)
+		verified = append(verified, VerifiedSignature{Sig: sig, Key: key})
 	}
+	updateSectionValidity(s, verified, maxVal)
 	return len(s.Sigs(keys.RainsKeySpace)) > 0
 }
 
+//errSigExpired is returned by verifySignature for a signature which expired before the time of
+//verification.
+var errSigExpired = errors.New("signature is expired")
+
+//verifySignature checks that sig is valid at now and that it is a signature over encoding by a key
+//of pkeys. It returns the key which verified sig. It returns errSigExpired if sig expired before
+//now.
+func verifySignature(sig signature.Sig, pkeys map[keys.PublicKeyID][]keys.PublicKey, encoding []byte,
+	now int64) (keys.PublicKey, error) {
+	ks, ok := pkeys[sig.PublicKeyID]
+	if !ok {
+		return keys.PublicKey{}, fmt.Errorf("no public key matching %v", sig.PublicKeyID)
+	}
+	if sig.ValidUntil < now {
+		return keys.PublicKey{}, errSigExpired
+	}
+	key, ok := getPublicKey(ks, sig.MetaData())
+	if !ok {
+		return keys.PublicKey{}, errors.New("no public key overlapping the signature's validity")
+	}
+	if !sig.VerifySignature(key.Key, encoding) {
+		return keys.PublicKey{}, errors.New("signature does not match")
+	}
+	return key, nil
+}
+
+//VerifySectionWithKeys verifies the signatures in the RAINS key space on s against pkeys at time
+//at. It neither consults a cache nor uses the current time, such that a section can be verified
+//offline against a known key. In contrast to CheckSectionSignatures, s is not modified and
+//signatures on its content are not checked. It returns true if s has at least one signature valid
+//at at and all its signatures not expired at at verify. Otherwise, the error states the reason.
+func VerifySectionWithKeys(s section.WithSig, pkeys map[keys.PublicKeyID]keys.PublicKey,
+	at time.Time) (bool, error) {
+	if s == nil {
+		return false, errors.New("section is nil")
+	}
+	ks := make(map[keys.PublicKeyID][]keys.PublicKey)
+	for id, k := range pkeys {
+		ks[id] = []keys.PublicKey{k}
+	}
+	s.DontAddSigInMarshaller()
+	encoding := new(bytes.Buffer)
+	err := s.MarshalCBOR(cbor.NewCBORWriter(encoding))
+	s.AddSigInMarshaller()
+	if err != nil {
+		return false, fmt.Errorf("was not able to marshal section: %v", err)
+	}
+	valid := 0
+	for _, sig := range s.Sigs(keys.RainsKeySpace) {
+		if _, err := verifySignature(sig, ks, encoding.Bytes(), at.Unix()); err == errSigExpired {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		valid++
+	}
+	if valid == 0 {
+		return false, errors.New("section has no signature valid at the time of verification")
+	}
+	return true, nil
+}
+
+//DropFutureSignatures removes all signatures in the rains key space on s and its content whose
+//ValidSince is more than maxFuture ahead of now. Such signatures are most likely the result of a
+//clock error or an attack. It returns false if s was signed and none of its signatures remains.
+func DropFutureSignatures(s section.WithSig, maxFuture time.Duration) bool {
+	if s == nil {
+		return true
+	}
+	signed := len(s.Sigs(keys.RainsKeySpace)) > 0
+	dropFutureSignatures(s, maxFuture)
+	switch s := s.(type) {
+	case *section.Shard:
+		for _, a := range s.Content {
+			dropFutureSignatures(a, maxFuture)
+		}
+	case *section.Zone:
+		for _, a := range s.Content {
+			dropFutureSignatures(a, maxFuture)
+		}
+	}
+	return !signed || len(s.Sigs(keys.RainsKeySpace)) > 0
+}
+
+func dropFutureSignatures(s section.WithSig, maxFuture time.Duration) {
+	maxValidSince := time.Now().Add(maxFuture).Unix()
+	sigs := s.AllSigs()
+	for i := len(sigs) - 1; i >= 0; i-- {
+		if sigs[i].KeySpace == keys.RainsKeySpace && sigs[i].ValidSince > maxValidSince {
+			log.Warn("Drop signature with validSince too far in the future", "signature", sigs[i],
+				"maxValidSince", maxValidSince)
+			s.DeleteSig(i)
+		}
+	}
+}
+
+//DropWeakSignatures removes all signatures in the rains key space on s and its content which use a
+//signature algorithm weaker than minAlgo. Such signatures are ignored such that a section which is
+//additionally signed with a weak algorithm, e.g. during an algorithm rollover, is still accepted
+//while a section whose stronger signatures have been stripped in a downgrade attack is rejected.
+//It returns false if s was signed and none of its signatures remains.
+func DropWeakSignatures(s section.WithSig, minAlgo algorithmTypes.Signature) bool {
+	if s == nil {
+		return true
+	}
+	signed := len(s.Sigs(keys.RainsKeySpace)) > 0
+	dropWeakSignatures(s, minAlgo)
+	switch s := s.(type) {
+	case *section.Shard:
+		for _, a := range s.Content {
+			dropWeakSignatures(a, minAlgo)
+		}
+	case *section.Zone:
+		for _, a := range s.Content {
+			dropWeakSignatures(a, minAlgo)
+		}
+	}
+	if signed && len(s.Sigs(keys.RainsKeySpace)) == 0 {
+		log.Warn("Section has no signature with an algorithm at least as strong as the minimum",
+			"minAlgorithm", minAlgo)
+		return false
+	}
+	return true
+}
+
+func dropWeakSignatures(s section.WithSig, minAlgo algorithmTypes.Signature) {
+	sigs := s.AllSigs()
+	for i := len(sigs) - 1; i >= 0; i-- {
+		if sigs[i].KeySpace == keys.RainsKeySpace && sigs[i].Algorithm < minAlgo {
+			log.Debug("Drop signature weaker than the required minimum", "signature", sigs[i],
+				"minAlgorithm", minAlgo)
+			s.DeleteSig(i)
+		}
+	}
+}
+
+//VerifyDelegationChain checks that chain is an unbroken sequence of delegation assertions from the
+//root zone down to leafZone. The first delegation must be signed by root and each following
+//delegation by a key delegated in the previous one. It returns an error describing the first
+//broken link, an invalid signature or a delegation whose signatures are all expired.
+func VerifyDelegationChain(root keys.PublicKey, chain []*section.Assertion, leafZone string) error {
+	zone := section.RootZone
+	pkeys := map[keys.PublicKeyID][]keys.PublicKey{root.PublicKeyID: []keys.PublicKey{root}}
+	for i, a := range chain {
+		if a == nil {
+			return fmt.Errorf("delegation %d of the chain is nil", i)
+		}
+		if a.SubjectZone != zone || section.IsApex(a.SubjectName) {
+			return fmt.Errorf("delegation %d for %s does not descend from zone %s", i, a.FQDN(), zone)
+		}
+		if err := verifyDelegation(a, pkeys); err != nil {
+			return fmt.Errorf("delegation %d for %s is invalid: %v", i, a.FQDN(), err)
+		}
+		zone = a.FQDN()
+		pkeys = delegatedKeys(a)
+		if len(pkeys) == 0 {
+			return fmt.Errorf("delegation %d for %s does not contain a public key", i, zone)
+		}
+	}
+	if zone != leafZone {
+		return fmt.Errorf("delegation chain ends at zone %s instead of %s", zone, leafZone)
+	}
+	return nil
+}
+
+//MinimalProof returns answer followed by the delegations in delegations which are needed to verify
+//it, i.e. the delegation of answer's subject zone and those of all enclosing zones up to the root
+//ordered from the subject zone upwards. delegations maps a zone to its delegation assertion. The
+//walk stops at the root's delegation or at the first zone whose delegation is missing.
+func MinimalProof(answer *section.Assertion, delegations map[string]*section.Assertion) []section.Section {
+	proof := []section.Section{answer}
+	visited := make(map[string]bool)
+	for zone := answer.SubjectZone; !visited[zone]; {
+		visited[zone] = true
+		a, ok := delegations[zone]
+		if !ok {
+			break
+		}
+		proof = append(proof, a)
+		zone = a.SubjectZone
+	}
+	return proof
+}
+
+//verifyDelegation returns an error if a is not signed by one of pkeys, if one of its signatures is
+//invalid, or if all its signatures are expired. It does not modify a.
+func verifyDelegation(a *section.Assertion, pkeys map[keys.PublicKeyID][]keys.PublicKey) error {
+	sigs := a.Sigs(keys.RainsKeySpace)
+	if len(sigs) == 0 {
+		return errors.New("delegation is not signed")
+	}
+	if !CheckStringFields(a) {
+		return errors.New("delegation contains a forbidden string")
+	}
+	a.DontAddSigInMarshaller()
+	encoding := new(bytes.Buffer)
+	err := a.MarshalCBOR(cbor.NewCBORWriter(encoding))
+	a.AddSigInMarshaller()
+	if err != nil {
+		return fmt.Errorf("was not able to marshal delegation: %v", err)
+	}
+	notExpired := false
+	for _, sig := range sigs {
+		if sig.ValidUntil < time.Now().Unix() {
+			continue
+		}
+		key, ok := getPublicKey(pkeys[sig.PublicKeyID], sig.MetaData())
+		if !ok {
+			return fmt.Errorf("no public key of the delegating zone matches signature %v", sig)
+		}
+		if !sig.VerifySignature(key.Key, encoding.Bytes()) {
+			return fmt.Errorf("signature %v does not match", sig)
+		}
+		notExpired = true
+	}
+	if !notExpired {
+		return errors.New("all signatures are expired")
+	}
+	return nil
+}
+
+//delegatedKeys returns the public keys delegated in a. Their validity is set to the overlapping
+//validity of a's signatures.
+func delegatedKeys(a *section.Assertion) map[keys.PublicKeyID][]keys.PublicKey {
+	since, until := util.GetOverlapValidityForSignatures(a.Sigs(keys.RainsKeySpace))
+	pkeys := make(map[keys.PublicKeyID][]keys.PublicKey)
+	for _, o := range a.Content {
+		if pk, ok := o.Value.(keys.PublicKey); ok && o.Type == object.OTDelegation {
+			pk.ValidSince = since
+			pk.ValidUntil = until
+			pkeys[pk.PublicKeyID] = append(pkeys[pk.PublicKeyID], pk)
+		}
+	}
+	return pkeys
+}
+
 //SignSectionUnsafe signs a section and all contained assertions with the given private Key and
 //adds the resulting bytestring to the given signatures. s must be sorted. It does not check the
 //validity of s or sig. Returns false if the signature was not added to the section.
@@ -139,6 +542,304 @@ func SignSectionUnsafe(s section.WithSig, ks map[keys.PublicKeyID]interface{}) e
 	return nil
 }
 
+//SignZonePresized signs z and all contained assertions like SignSectionUnsafe and produces the same
+//signatures. It does not bound the memory needed to sign a zone: Ed25519 hashes the signed data
+//twice, such that the full encoding of z is materialized and held in memory while it is signed. It
+//only avoids the reallocations of SignSectionUnsafe by writing the encoding of z assertion by
+//assertion into a buffer which is allocated once with the exact size of the encoding and the
+//signature meta data. z must be sorted.
+func SignZonePresized(z *section.Zone, ks map[keys.PublicKeyID]interface{}) error {
+	z.DontAddSigInMarshaller()
+	defer z.AddSigInMarshaller()
+	size := &countingWriter{}
+	if err := writeZoneEncoding(size, z); err != nil {
+		return fmt.Errorf("Was not able to marshal zone: %v", err)
+	}
+	sigs := z.Sigs(keys.RainsKeySpace)
+	metaSize := 0
+	for _, sig := range sigs {
+		c := &countingWriter{}
+		if err := sig.MarshalCBOR(cbor.NewCBORWriter(c)); err != nil {
+			return err
+		}
+		if c.n > metaSize {
+			metaSize = c.n
+		}
+	}
+	encoding := bytes.NewBuffer(make([]byte, 0, size.n+metaSize))
+	if err := writeZoneEncoding(encoding, z); err != nil {
+		return fmt.Errorf("Was not able to marshal zone: %v", err)
+	}
+	z.DeleteAllSigs()
+	for _, sig := range sigs {
+		if err := (&sig).SignData(ks[sig.PublicKeyID], encoding.Bytes()); err != nil {
+			return err
+		}
+		z.AddSig(sig)
+	}
+	z.AddCtxAndZoneToContent()
+	defer z.RemoveCtxAndZoneFromContent()
+	for _, a := range z.Content {
+		if len(a.Sigs(keys.RainsKeySpace)) > 0 {
+			if err := signSectionUnsafe(a, ks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//writeZoneEncoding writes the encoding of z without its signatures to w, i.e. the encoding
+//z.MarshalCBOR writes while z's sign flag is set. The contained assertions are written one at a
+//time.
+func writeZoneEncoding(w io.Writer, z *section.Zone) error {
+	cw := cbor.NewCBORWriter(w)
+	if _, err := w.Write(rcbor.Head(rcbor.MajorMap, 3)); err != nil {
+		return err
+	}
+	if err := cw.WriteInt(4); err != nil {
+		return err
+	}
+	if err := cw.WriteString(z.SubjectZone); err != nil {
+		return err
+	}
+	if err := cw.WriteInt(6); err != nil {
+		return err
+	}
+	if err := cw.WriteString(z.Context); err != nil {
+		return err
+	}
+	if err := cw.WriteInt(23); err != nil {
+		return err
+	}
+	if _, err := w.Write(rcbor.Head(rcbor.MajorArray, uint64(len(z.Content)))); err != nil {
+		return err
+	}
+	for _, a := range z.Content {
+		if err := cw.Marshal(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//countingWriter counts the bytes written to it and discards them.
+type countingWriter struct {
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+
+//Reseal replaces all signatures on s by a single signature described by meta and computed with key.
+//Signatures of assertions contained in a shard or zone are removed as well as they are covered by
+//the new signature. The content is sorted and, for shards and zones, the context and subject zone
+//of the contained assertions are removed before signing. It must be called after s's content has
+//been modified as the existing signatures no longer verify.
+func Reseal(s section.WithSig, key interface{}, meta signature.MetaData) error {
+	if s == nil {
+		return errors.New("section is nil")
+	}
+	if meta.ValidSince >= meta.ValidUntil {
+		return fmt.Errorf("signature validity is empty: [%d,%d]", meta.ValidSince, meta.ValidUntil)
+	}
+	s.DeleteAllSigs()
+	switch s := s.(type) {
+	case *section.Shard:
+		s.RemoveCtxAndZoneFromContent()
+		for _, a := range s.Content {
+			a.DeleteAllSigs()
+		}
+	case *section.Zone:
+		s.RemoveCtxAndZoneFromContent()
+		for _, a := range s.Content {
+			a.DeleteAllSigs()
+		}
+	}
+	s.AddSig(signature.Sig{
+		PublicKeyID: meta.PublicKeyID,
+		ValidSince:  meta.ValidSince,
+		ValidUntil:  meta.ValidUntil,
+	})
+	if !ValidSectionAndSignature(s) {
+		s.DeleteAllSigs()
+		return errors.New("section cannot be signed")
+	}
+	return SignSectionUnsafe(s, map[keys.PublicKeyID]interface{}{meta.PublicKeyID: key})
+}
+
+//SignMessageUnsafe canonicalizes msg and signs it with the given private keys. Each signature on
+//msg serves as a template whose data is replaced by the signature over msg's encoding without
+//signatures. It does not check the validity of msg or the signatures.
+func SignMessageUnsafe(msg *message.Message, ks map[keys.PublicKeyID]interface{}) error {
+	if err := msg.Canonicalize(); err != nil {
+		return fmt.Errorf("Was not able to canonicalize message: %v", err)
+	}
+	sigs := msg.Signatures
+	encoding, err := messageSigEncoding(msg)
+	if err != nil {
+		return err
+	}
+	msg.Signatures = make([]signature.Sig, len(sigs))
+	for i, sig := range sigs {
+		if err := (&sig).SignData(ks[sig.PublicKeyID], encoding); err != nil {
+			return err
+		}
+		msg.Signatures[i] = sig
+	}
+	return nil
+}
+
+//SignMessage canonicalizes msg, signs its encoding without signatures with key and appends the
+//resulting infrastructure signature described by meta to msg. Signatures already present on msg
+//stay valid as they are computed over the same encoding. Returns an error if meta's validity is
+//empty or if msg contains a string field which must not be signed.
+func SignMessage(msg *message.Message, key interface{}, meta signature.MetaData) error {
+	if msg == nil {
+		return errors.New("message is nil")
+	}
+	if meta.ValidSince >= meta.ValidUntil {
+		return fmt.Errorf("signature validity is empty: [%d,%d]", meta.ValidSince, meta.ValidUntil)
+	}
+	if err := msg.Canonicalize(); err != nil {
+		return fmt.Errorf("Was not able to canonicalize message: %v", err)
+	}
+	if !checkMessageStringFields(msg) {
+		return errors.New("message contains a string field which must not be signed")
+	}
+	sigs := msg.Signatures
+	msg.Signatures = []signature.Sig{signature.Sig{
+		PublicKeyID: meta.PublicKeyID,
+		ValidSince:  meta.ValidSince,
+		ValidUntil:  meta.ValidUntil,
+	}}
+	err := SignMessageUnsafe(msg, map[keys.PublicKeyID]interface{}{meta.PublicKeyID: key})
+	if err != nil {
+		msg.Signatures = sigs
+		return err
+	}
+	msg.Signatures = append(sigs[:len(sigs):len(sigs)], msg.Signatures...)
+	return nil
+}
+
+//SelectSignatureAlgorithm returns the strongest algorithm of preferred for which ks contains a
+//private key and which is at least as strong as minAlgo, the weakest algorithm a verifier accepts.
+//Signing with it avoids signatures which are dropped by the verifier's downgrade protection.
+func SelectSignatureAlgorithm(ks map[algorithmTypes.Signature]interface{},
+	preferred []algorithmTypes.Signature, minAlgo algorithmTypes.Signature) (
+	algorithmTypes.Signature, error) {
+	selected, ok := algorithmTypes.Signature(0), false
+	for _, algo := range preferred {
+		if _, hasKey := ks[algo]; hasKey && algo >= minAlgo && (!ok || algo > selected) {
+			selected, ok = algo, true
+		}
+	}
+	if !ok {
+		return 0, fmt.Errorf("no key for a preferred algorithm %v at least as strong as %v",
+			preferred, minAlgo)
+	}
+	return selected, nil
+}
+
+//SignMessageWithPreferredAlgorithm signs msg as SignMessage with the key of ks for the algorithm
+//selected by SelectSignatureAlgorithm. The selected algorithm is recorded in the signature's
+//public key identifier, meta's algorithm is ignored.
+func SignMessageWithPreferredAlgorithm(msg *message.Message,
+	ks map[algorithmTypes.Signature]interface{}, meta signature.MetaData,
+	preferred []algorithmTypes.Signature, minAlgo algorithmTypes.Signature) error {
+	algo, err := SelectSignatureAlgorithm(ks, preferred, minAlgo)
+	if err != nil {
+		return err
+	}
+	meta.Algorithm = algo
+	return SignMessage(msg, ks[algo], meta)
+}
+
+//CheckMessageSignatures returns true if msg has at least one non expired signature and all non
+//expired signatures on msg are valid for one of pkeys. The signatures are checked over the
+//encoding of a canonicalized copy of msg such that they are verified over the same encoding the
+//signer used even if the content of msg arrived in a different order. msg is not modified.
+func CheckMessageSignatures(msg *message.Message, pkeys map[keys.PublicKeyID][]keys.PublicKey) bool {
+	if msg == nil || !checkMessageStringFields(msg) {
+		return false
+	}
+	encoding, err := canonicalSigEncoding(msg)
+	if err != nil {
+		log.Warn("Was not able to marshal message.", "error", err)
+		return false
+	}
+	valid := false
+	for _, sig := range msg.Signatures {
+		if int64(sig.ValidUntil) < time.Now().Unix() {
+			log.Info("message signature is expired", "signature", sig)
+			continue
+		}
+		key, ok := getPublicKey(pkeys[sig.PublicKeyID], sig.MetaData())
+		if !ok || !sig.VerifySignature(key.Key, encoding) {
+			log.Warn("Message signature does not match", "signature", sig)
+			return false
+		}
+		valid = true
+	}
+	return valid
+}
+
+//messageSigEncoding returns the encoding of msg without its signatures over which message
+//signatures are computed. msg must be in canonical form, otherwise the encoding differs between
+//signer and verifier.
+func messageSigEncoding(msg *message.Message) ([]byte, error) {
+	unsigned := *msg
+	unsigned.Signatures = nil
+	encoding := new(bytes.Buffer)
+	if err := unsigned.MarshalCBOR(cbor.NewCBORWriter(encoding)); err != nil {
+		return nil, fmt.Errorf("Was not able to marshal message: %v", err)
+	}
+	return encoding.Bytes(), nil
+}
+
+//canonicalSigEncoding returns the signature encoding of the canonical form of msg. It is computed
+//on a decoded copy of msg such that msg is not modified.
+func canonicalSigEncoding(msg *message.Message) ([]byte, error) {
+	encoding, err := messageSigEncoding(msg)
+	if err != nil {
+		return nil, err
+	}
+	c := &message.Message{}
+	if err := c.UnmarshalCBOR(cbor.NewCBORReader(bytes.NewReader(encoding))); err != nil {
+		return nil, fmt.Errorf("Was not able to unmarshal message: %v", err)
+	}
+	if err := c.Canonicalize(); err != nil {
+		return nil, fmt.Errorf("Was not able to canonicalize message: %v", err)
+	}
+	return messageSigEncoding(c)
+}
+
+//SignObjectsUnsafe signs all per-object signatures present on the objects of a with the given
+//private keys. a's context and zone must be set. It does not check the validity of a or the
+//signatures.
+func SignObjectsUnsafe(a *section.Assertion, ks map[keys.PublicKeyID]interface{}) error {
+	for i, o := range a.Content {
+		if len(o.Signatures) == 0 {
+			continue
+		}
+		encoding, err := objectSigEncoding(a, o)
+		if err != nil {
+			return fmt.Errorf("Was not able to marshal object: %v", err)
+		}
+		sigs := make([]signature.Sig, len(o.Signatures))
+		for j, sig := range o.Signatures {
+			if err := (&sig).SignData(ks[sig.PublicKeyID], encoding); err != nil {
+				return err
+			}
+			sigs[j] = sig
+		}
+		a.Content[i].Signatures = sigs
+	}
+	return nil
+}
+
 //signSectionUnsafe signs a section with the given private Key and adds the resulting bytestring to
 //the given signatures. It assumes that s is sorted, the sign flag is set to true, and contained
 //assertions have a non-empty zone and context values. It does not check the validity of s or sig.
@@ -161,8 +862,9 @@ func signSectionUnsafe(s section.WithSig, ks map[keys.PublicKeyID]interface{}) e
 }
 
 //ValidSectionAndSignature returns true if the section is not nil, all the signatures ValidUntil are
-//in the future, the string fields do not contain  <whitespace>:<non whitespace>:<whitespace>, and
-//the section's content is sorted (by sorting it).
+//in the future, the string fields do not contain  <whitespace>:<non whitespace>:<whitespace>, the
+//content of a zone is consistent with it, the values of all objects match their types, and the
+//section's content is sorted (by sorting it).
 func ValidSectionAndSignature(s section.WithSig) bool {
 	log.Debug("Validating section and signature before signing")
 	if s == nil {
@@ -172,13 +874,49 @@ func ValidSectionAndSignature(s section.WithSig) bool {
 	if !CheckSignatureNotExpired(s) {
 		return false
 	}
-	if !CheckStringFields(s) {
+	if !validZone(s) || !validObjects(s) || !CheckStringFields(s) {
 		return false
 	}
 	s.Sort()
 	return true
 }
 
+//validObjects returns false if s contains an object whose value does not match its type.
+func validObjects(s section.WithSig) bool {
+	var assertions []*section.Assertion
+	switch s := s.(type) {
+	case *section.Assertion:
+		assertions = []*section.Assertion{s}
+	case *section.Shard:
+		assertions = s.Content
+	case *section.Zone:
+		assertions = s.Content
+	}
+	for _, a := range assertions {
+		if a == nil {
+			continue
+		}
+		for _, o := range a.Content {
+			if err := o.Validate(); err != nil {
+				log.Warn("Section contains a malformed object", "error", err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//validZone returns false if s is a zone whose content is inconsistent with it.
+func validZone(s section.WithSig) bool {
+	if z, ok := s.(*section.Zone); ok {
+		if err := z.Validate(); err != nil {
+			log.Warn("Zone content is inconsistent with the zone", "error", err)
+			return false
+		}
+	}
+	return true
+}
+
 //CheckSignatureNotExpired returns true if s is nil or all the signatures ValidUntil are in the
 //future
 func CheckSignatureNotExpired(s section.WithSig) bool {
@@ -316,9 +1054,21 @@ func checkObjectFields(objs []object.Object) bool {
 				log.Warn("Section contains an object with a string field containing forbidden content", "registrant", obj.Value)
 				return false
 			}
+		case object.OTText:
+			if containsZoneFileType(obj.Value.(string)) {
+				log.Warn("Section contains an object with a string field containing forbidden content", "text", obj.Value)
+				return false
+			}
 		case object.OTInfraKey:
 		case object.OTExtraKey:
 		case object.OTNextKey:
+		case object.OTZoneMetadata:
+			if md, ok := obj.Value.(object.ZoneMetadata); ok {
+				if containsZoneFileType(md.PrimaryServer) {
+					log.Warn("Section contains an object with a string field containing forbidden content", "primaryServer", md.PrimaryServer)
+					return false
+				}
+			}
 		default:
 			log.Warn("Unsupported obj type", "type", fmt.Sprintf("%T", obj.Type))
 			return false
@@ -355,37 +1105,59 @@ func getPublicKey(pkeys []keys.PublicKey, sigMetaData signature.MetaData) (keys.
 	return keys.PublicKey{}, false
 }
 
-//updateSectionValidity updates the validity of the section according to the signature validity and the publicKey validity used to verify this signature
-func updateSectionValidity(sec section.WithSig, pkeyValidSince, pkeyValidUntil, sigValidSince,
-	sigValidUntil int64, maxVal util.MaxCacheValidity) {
-	if sec != nil {
-		var maxValidity time.Duration
-		switch sec.(type) {
-		case *section.Assertion:
-			maxValidity = maxVal.AssertionValidity
-		case *section.Shard:
-			maxValidity = maxVal.ShardValidity
-		case *section.Pshard:
-			maxValidity = maxVal.PshardValidity
-		case *section.Zone:
-			maxValidity = maxVal.ZoneValidity
-		default:
-			log.Warn("Not supported section", "type", fmt.Sprintf("%T", sec))
-			return
-		}
-		if pkeyValidSince < sigValidSince {
-			if pkeyValidUntil < sigValidUntil {
-				sec.UpdateValidity(sigValidSince, pkeyValidUntil, maxValidity)
-			} else {
-				sec.UpdateValidity(sigValidSince, sigValidUntil, maxValidity)
-			}
+//VerifiedSignature is a signature together with the public key which verified it.
+type VerifiedSignature struct {
+	Sig signature.Sig
+	Key keys.PublicKey
+}
 
-		} else {
-			if pkeyValidUntil < sigValidUntil {
-				sec.UpdateValidity(pkeyValidSince, pkeyValidUntil, maxValidity)
-			} else {
-				sec.UpdateValidity(pkeyValidSince, sigValidUntil, maxValidity)
-			}
+//EffectiveValidity returns the validity of a section derived from its verified signatures. A
+//signature vouches for the section during the intersection of its validity and the validity of the
+//public key which verified it. A section can be used as long as it is verifiable with one of its
+//signatures. Thus, across signatures the union of these windows applies and not their
+//intersection: since is the earliest and until the latest instant any of the signatures vouches
+//for the section. A gap between disjoint windows is included in the result as the section is kept
+//until its last signature expires. It returns 0, 0 if there are no verified signatures.
+func EffectiveValidity(verified []VerifiedSignature) (since, until int64) {
+	for i, v := range verified {
+		sigSince, sigUntil := v.Sig.ValidSince, v.Sig.ValidUntil
+		if v.Key.ValidSince > sigSince {
+			sigSince = v.Key.ValidSince
+		}
+		if v.Key.ValidUntil < sigUntil {
+			sigUntil = v.Key.ValidUntil
+		}
+		if i == 0 || sigSince < since {
+			since = sigSince
+		}
+		if i == 0 || sigUntil > until {
+			until = sigUntil
 		}
 	}
+	return since, until
+}
+
+//updateSectionValidity extends the validity of the section by its EffectiveValidity over the
+//verified signatures, bounded by the maximal cache validity for the section's type.
+func updateSectionValidity(sec section.WithSig, verified []VerifiedSignature,
+	maxVal util.MaxCacheValidity) {
+	if sec == nil || len(verified) == 0 {
+		return
+	}
+	var maxValidity time.Duration
+	switch sec.(type) {
+	case *section.Assertion:
+		maxValidity = maxVal.AssertionValidity
+	case *section.Shard:
+		maxValidity = maxVal.ShardValidity
+	case *section.Pshard:
+		maxValidity = maxVal.PshardValidity
+	case *section.Zone:
+		maxValidity = maxVal.ZoneValidity
+	default:
+		log.Warn("Not supported section", "type", fmt.Sprintf("%T", sec))
+		return
+	}
+	since, until := EffectiveValidity(verified)
+	sec.UpdateValidity(since, until, maxValidity)
 }
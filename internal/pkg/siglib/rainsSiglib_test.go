@@ -1,6 +1,9 @@
 package siglib
 
 import (
+	"bytes"
+	"net"
+	"reflect"
 	"testing"
 	"time"
 
@@ -8,6 +11,7 @@ import (
 	"golang.org/x/crypto/ed25519"
 
 	"github.com/netsec-ethz/rains/internal/pkg/algorithmTypes"
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
 	"github.com/netsec-ethz/rains/internal/pkg/keys"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/object"
@@ -46,6 +50,615 @@ func TestSignSectionUnsafe(t *testing.T) {
 	}
 }
 
+func TestSignZonePresized(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	ks := map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}
+	newZone := func() *section.Zone {
+		z := &section.Zone{SubjectZone: "ethz.ch.", Context: "."}
+		for _, name := range []string{"c", "a", "b"} {
+			a := &section.Assertion{SubjectName: name, Content: []object.Object{
+				object.ServiceObject(), object.NameObject(), object.CertificateObject()}}
+			a.AddSig(sig)
+			z.Content = append(z.Content, a)
+		}
+		z.AddSig(sig)
+		z.Sort()
+		return z
+	}
+	batch, presized := newZone(), newZone()
+	if err := SignSectionUnsafe(batch, ks); err != nil {
+		t.Fatalf("Was not able to sign zone: %v", err)
+	}
+	if err := SignZonePresized(presized, ks); err != nil {
+		t.Fatalf("Was not able to sign presized zone: %v", err)
+	}
+	if !reflect.DeepEqual(batch.Signatures, presized.Signatures) {
+		t.Fatalf("zone signatures differ expected=%v actual=%v", batch.Signatures, presized.Signatures)
+	}
+	for i := range batch.Content {
+		if !reflect.DeepEqual(batch.Content[i].Signatures, presized.Content[i].Signatures) {
+			t.Errorf("%d: assertion signatures differ expected=%v actual=%v", i,
+				batch.Content[i].Signatures, presized.Content[i].Signatures)
+		}
+	}
+	pubKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  sig.ValidSince,
+		ValidUntil:  sig.ValidUntil,
+		Key:         genPublicKey,
+	}
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{pubKey}}
+	if !CheckSectionSignatures(presized, ksPub, util.MaxCacheValidity{ZoneValidity: time.Hour}) {
+		t.Error("presized signed zone does not verify")
+	}
+}
+
+func TestReseal(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	meta := section.Signature().MetaData()
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{meta.PublicKeyID: []keys.PublicKey{keys.PublicKey{
+		PublicKeyID: meta.PublicKeyID,
+		ValidSince:  time.Now().Add(-time.Hour).Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}}}
+	maxVal := util.MaxCacheValidity{AssertionValidity: time.Hour, ShardValidity: time.Hour,
+		ZoneValidity: time.Hour}
+	newAssertion := func() *section.Assertion {
+		return &section.Assertion{SubjectName: "new", Content: []object.Object{object.NameObject()}}
+	}
+	assertion := section.GetAssertion()
+	shard := section.GetShard()
+	zone := section.GetZone()
+	var tests = []struct {
+		sec    section.WithSig
+		modify func()
+	}{
+		{assertion, func() { assertion.Content = append(assertion.Content, object.NameObject()) }},
+		{shard, func() { shard.Content = append(shard.Content, newAssertion()) }},
+		{zone, func() { zone.Content = append(zone.Content, newAssertion()) }},
+	}
+	for i, test := range tests {
+		if err := Reseal(test.sec, genPrivateKey, meta); err != nil {
+			t.Fatalf("%d: Was not able to seal %T: %v", i, test.sec, err)
+		}
+		if !CheckSectionSignatures(test.sec, ksPub, maxVal) {
+			t.Fatalf("%d: sealed %T does not verify", i, test.sec)
+		}
+		test.modify()
+		if CheckSectionSignatures(test.sec, ksPub, maxVal) {
+			t.Errorf("%d: modified %T must not verify before resealing", i, test.sec)
+		}
+		if err := Reseal(test.sec, genPrivateKey, meta); err != nil {
+			t.Fatalf("%d: Was not able to reseal %T: %v", i, test.sec, err)
+		}
+		if sigs := test.sec.AllSigs(); len(sigs) != 1 || sigs[0].MetaData() != meta {
+			t.Errorf("%d: resealed %T must only carry the new signature: %v", i, test.sec, sigs)
+		}
+		if !CheckSectionSignatures(test.sec, ksPub, maxVal) {
+			t.Errorf("%d: resealed %T does not verify", i, test.sec)
+		}
+	}
+	if err := Reseal(section.GetAssertion(), genPrivateKey, signature.MetaData{}); err == nil {
+		t.Error("resealing with an empty validity must fail")
+	}
+}
+
+func TestApplyZoneDelta(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	ks := map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}
+	pubKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{pubKey}}
+	oldZone := section.GetZone()
+	oldZone.RemoveCtxAndZoneFromContent()
+	newZone := section.GetZone()
+	newZone.RemoveCtxAndZoneFromContent()
+	newZone.Content = append(newZone.Content, &section.Assertion{SubjectName: "new",
+		Content: []object.Object{object.NameObject()}})
+	for _, z := range []*section.Zone{oldZone, newZone} {
+		z.Sort()
+		z.AddSig(sig)
+		if err := SignSectionUnsafe(z, ks); err != nil {
+			t.Fatalf("Was not able to sign zone, err=%v", err)
+		}
+	}
+	delta := section.NewZoneDelta(oldZone, newZone)
+	zone, ok := ApplyZoneDelta(oldZone, delta, ksPub, util.MaxCacheValidity{ZoneValidity: time.Hour})
+	if !ok || zone.CompareTo(newZone) != 0 {
+		t.Fatalf("zone delta was not correctly applied expected=%s actual=%s", newZone, zone)
+	}
+	delta.Added[0].SubjectName = "tampered"
+	if _, ok := ApplyZoneDelta(oldZone, delta, ksPub, util.MaxCacheValidity{}); ok {
+		t.Error("zone with tampered content must not verify")
+	}
+}
+
+func TestCheckZoneSignaturesLenient(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	_, forgerPrivateKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	ks := map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}
+	pubKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{pubKey}}
+	maxVal := util.MaxCacheValidity{AssertionValidity: time.Hour, ZoneValidity: time.Hour}
+	var tests = []struct {
+		signedZone  bool
+		forgedZone  bool
+		lenient     bool
+		valid       bool
+		wantContent int
+	}{
+		{false, false, false, false, 2}, //strict mode fails the zone on the bad assertion
+		{false, false, true, true, 1},   //bad assertion is dropped
+		{true, false, false, false, 2},
+		{true, false, true, true, 2}, //zone signature authenticates the bad assertion
+		{true, true, true, false, 2},
+	}
+	for i, test := range tests {
+		zone := &section.Zone{SubjectZone: "ch.", Context: ".", Content: []*section.Assertion{
+			&section.Assertion{SubjectName: "epfl", SubjectZone: "ch.", Context: ".",
+				Content: []object.Object{object.NameObject()}},
+			&section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+				Content: []object.Object{object.NameObject()}},
+		}}
+		for j, a := range zone.Content {
+			a.AddSig(sig)
+			signKey := ks
+			if j == 1 {
+				signKey = map[keys.PublicKeyID]interface{}{sig.PublicKeyID: forgerPrivateKey}
+			}
+			if err := SignSectionUnsafe(a, signKey); err != nil {
+				t.Fatalf("%d: Was not able to sign assertion: %v", i, err)
+			}
+		}
+		zone.RemoveCtxAndZoneFromContent()
+		if test.signedZone {
+			zone.AddSig(sig)
+			zone.DontAddSigInMarshaller()
+			signKey := ks
+			if test.forgedZone {
+				signKey = map[keys.PublicKeyID]interface{}{sig.PublicKeyID: forgerPrivateKey}
+			}
+			if err := signSectionUnsafe(zone, signKey); err != nil {
+				t.Fatalf("%d: Was not able to sign zone: %v", i, err)
+			}
+			zone.AddSigInMarshaller()
+		}
+		before := encodeZoneContent(zone)
+		var valid bool
+		if test.lenient {
+			valid = CheckZoneSignaturesLenient(zone, ksPub, maxVal, 0)
+		} else {
+			valid = CheckSectionSignatures(zone, ksPub, maxVal)
+		}
+		if valid != test.valid {
+			t.Errorf("%d: unexpected zone verification result expected=%v", i, test.valid)
+		}
+		if len(zone.Content) != test.wantContent || zone.Content[0].SubjectName != "epfl" {
+			t.Errorf("%d: wrong remaining zone content expected=%d assertions actual=%v", i,
+				test.wantContent, zone.Content)
+		}
+		if test.lenient && test.valid && test.signedZone &&
+			len(zone.Content[1].Sigs(keys.RainsKeySpace)) != 0 {
+			t.Errorf("%d: invalid assertion signature not dropped: %v", i, zone.Content[1])
+		}
+		if test.lenient && !test.valid && !bytes.Equal(before, encodeZoneContent(zone)) {
+			t.Errorf("%d: zone content modified by failed verification", i)
+		}
+	}
+}
+
+//encodeZoneContent returns the concatenated encodings of the assertions of zone.
+func encodeZoneContent(zone *section.Zone) []byte {
+	encoding := new(bytes.Buffer)
+	for _, a := range zone.Content {
+		cbor.NewWriter(encoding).Marshal(a)
+	}
+	return encoding.Bytes()
+}
+
+func TestObjectSignaturesLegacyPeer(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID, ValidSince: time.Now().Unix(),
+		ValidUntil: time.Now().Add(time.Hour).Unix(), Key: pub}}}
+	ks := map[keys.PublicKeyID]interface{}{sig.PublicKeyID: priv}
+	maxVal := util.MaxCacheValidity{AssertionValidity: time.Hour}
+	var tests = []struct {
+		objectSigs []bool
+	}{
+		{[]bool{false, false}},
+		{[]bool{true, false}},
+		{[]bool{true, true}},
+	}
+	for i, test := range tests {
+		a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+			Content: []object.Object{object.NameObject(), object.ServiceObject()}}
+		for j, signed := range test.objectSigs {
+			if signed {
+				a.Content[j].Signatures = []signature.Sig{sig}
+			}
+		}
+		a.AddSig(sig)
+		if err := SignSectionUnsafe(a, ks); err != nil {
+			t.Fatalf("%d: Was not able to sign assertion: %v", i, err)
+		}
+		if err := SignObjectsUnsafe(a, ks); err != nil {
+			t.Fatalf("%d: Was not able to sign objects: %v", i, err)
+		}
+		encoding := new(bytes.Buffer)
+		if err := cbor.NewWriter(encoding).Marshal(a); err != nil {
+			t.Fatalf("%d: Was not able to encode assertion: %v", i, err)
+		}
+		m, err := cbor.NewReader(encoding).ReadIntMapUntagged()
+		if err != nil {
+			t.Fatalf("%d: Was not able to decode assertion: %v", i, err)
+		}
+		decoded := &section.Assertion{}
+		if err := decoded.UnmarshalMap(m); err != nil {
+			t.Fatalf("%d: Was not able to decode assertion: %v", i, err)
+		}
+		for j, signed := range test.objectSigs {
+			if (len(decoded.Content[j].Signatures) != 0) != signed {
+				t.Errorf("%d: object signatures not transferred: %v", i, decoded.Content[j])
+			}
+		}
+		if !CheckObjectSignatures(decoded, ksPub, maxVal) {
+			t.Errorf("%d: object signatures not valid after the transfer", i)
+		}
+		//a peer not supporting per-object signatures ignores them.
+		for j := range decoded.Content {
+			decoded.Content[j].Signatures = nil
+		}
+		if !CheckSectionSignatures(decoded, ksPub, maxVal) {
+			t.Errorf("%d: section signature not valid for a peer ignoring object signatures", i)
+		}
+	}
+}
+
+func TestCheckObjectSignatures(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	_, forgerPrivateKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	pubKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{pubKey}}
+	maxVal := util.MaxCacheValidity{AssertionValidity: time.Hour}
+	var tests = []struct {
+		sectionSig  bool
+		valid       bool
+		wantObjects int
+	}{
+		{false, true, 1}, //forged object is removed
+		{true, true, 2},  //section signature authenticates both objects
+	}
+	for i, test := range tests {
+		a := &section.Assertion{
+			SubjectName: "ethz",
+			SubjectZone: "ch.",
+			Context:     ".",
+			Content: []object.Object{
+				object.Object{Type: object.OTName, Value: object.NameObject().Value,
+					Signatures: []signature.Sig{sig}},
+				object.Object{Type: object.OTServiceInfo, Value: object.ServiceObject().Value,
+					Signatures: []signature.Sig{sig}},
+			},
+		}
+		if test.sectionSig {
+			a.AddSig(sig)
+			if err := SignSectionUnsafe(a, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}); err != nil {
+				t.Fatalf("%d: Was not able to sign assertion: %v", i, err)
+			}
+		}
+		if err := SignObjectsUnsafe(a, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}); err != nil {
+			t.Fatalf("%d: Was not able to sign objects: %v", i, err)
+		}
+		forged := &section.Assertion{SubjectName: a.SubjectName, SubjectZone: a.SubjectZone,
+			Context: a.Context, Content: []object.Object{a.Content[1]}}
+		if err := SignObjectsUnsafe(forged, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: forgerPrivateKey}); err != nil {
+			t.Fatalf("%d: Was not able to forge object signature: %v", i, err)
+		}
+		a.Content[1] = forged.Content[0]
+		if !CheckSectionSignatures(a, ksPub, maxVal) {
+			t.Fatalf("%d: section signature must not cover object signatures", i)
+		}
+		if CheckObjectSignatures(a, ksPub, maxVal) != test.valid {
+			t.Errorf("%d: unexpected object signature verification result expected=%v", i, test.valid)
+		}
+		if len(a.Content) != test.wantObjects {
+			t.Fatalf("%d: wrong number of remaining objects expected=%d actual=%v", i, test.wantObjects,
+				a.Content)
+		}
+		if a.Content[0].Type != object.OTName || len(a.Content[0].Signatures) != 1 {
+			t.Errorf("%d: object with valid signature was not kept: %v", i, a.Content[0])
+		}
+		if test.sectionSig && len(a.Content[1].Signatures) != 0 {
+			t.Errorf("%d: forged object signature was not stripped: %v", i, a.Content[1])
+		}
+	}
+}
+
+func TestSignMessageUnsafe(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	pubKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{pubKey}}
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.ServiceObject(), object.NameObject()}}
+	msg := &message.Message{
+		Capabilities: []message.Capability{message.TLSOverTCP, message.NoCapability},
+		Content:      []section.Section{a, a},
+		Signatures:   []signature.Sig{sig},
+	}
+	if err := SignMessageUnsafe(msg, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}); err != nil {
+		t.Fatalf("Was not able to sign message: %v", err)
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(msg); err != nil {
+		t.Fatalf("Was not able to marshal message: %v", err)
+	}
+	received := &message.Message{}
+	if err := cbor.NewReader(encoding).Unmarshal(received); err != nil {
+		t.Fatalf("Was not able to unmarshal message: %v", err)
+	}
+	if !CheckMessageSignatures(received, ksPub) {
+		t.Errorf("signature on canonicalized message is not valid: %v", received)
+	}
+	//content arriving out of canonical order is sorted before the signatures are checked.
+	received.Content[0].(*section.Assertion).Content = []object.Object{object.ServiceObject(),
+		object.NameObject()}
+	if !CheckMessageSignatures(received, ksPub) {
+		t.Errorf("signature on message with unsorted content is not valid: %v", received)
+	}
+	if o := received.Content[0].(*section.Assertion).Content; o[0].Type != object.OTServiceInfo {
+		t.Errorf("checking the signatures modified the message: %v", o)
+	}
+	received.Capabilities = []message.Capability{message.TLSOverTCP}
+	if CheckMessageSignatures(received, ksPub) {
+		t.Error("signature on tampered message must not be valid")
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	meta := section.Signature().MetaData()
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{meta.PublicKeyID: []keys.PublicKey{keys.PublicKey{
+		PublicKeyID: meta.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}}}
+	msg := message.GetMessage()
+	msg.Capabilities = []message.Capability{message.TLSOverTCP, message.NoCapability}
+	msg.Signatures = nil
+	if err := SignMessage(&msg, genPrivateKey, meta); err != nil {
+		t.Fatalf("Was not able to sign message: %v", err)
+	}
+	if len(msg.Signatures) != 1 || msg.Signatures[0].MetaData() != meta {
+		t.Fatalf("message signature was not appended: %v", msg.Signatures)
+	}
+	encoding := new(bytes.Buffer)
+	if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
+		t.Fatalf("Was not able to marshal message: %v", err)
+	}
+	received := &message.Message{}
+	if err := cbor.NewReader(encoding).Unmarshal(received); err != nil {
+		t.Fatalf("Was not able to unmarshal message: %v", err)
+	}
+	if !CheckMessageSignatures(received, ksPub) {
+		t.Errorf("signature on full message is not valid: %v", received)
+	}
+	received.Token[0]++
+	if CheckMessageSignatures(received, ksPub) {
+		t.Error("signature on tampered message must not be valid")
+	}
+
+	secondPublicKey, secondPrivateKey, _ := ed25519.GenerateKey(nil)
+	second := meta
+	second.KeyPhase++
+	ksPub[second.PublicKeyID] = []keys.PublicKey{keys.PublicKey{
+		PublicKeyID: second.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         secondPublicKey,
+	}}
+	first := msg.Signatures[0]
+	if err := SignMessage(&msg, secondPrivateKey, second); err != nil {
+		t.Fatalf("Was not able to add a second signature: %v", err)
+	}
+	if len(msg.Signatures) != 2 || !reflect.DeepEqual(msg.Signatures[0], first) ||
+		msg.Signatures[1].MetaData() != second {
+		t.Fatalf("existing signature was not kept: %v", msg.Signatures)
+	}
+	if !CheckMessageSignatures(&msg, ksPub) {
+		t.Errorf("signatures on twice signed message are not valid: %v", msg.Signatures)
+	}
+
+	empty := meta
+	empty.ValidUntil = empty.ValidSince
+	if err := SignMessage(&message.Message{}, genPrivateKey, empty); err == nil {
+		t.Error("signing with an empty validity must fail")
+	}
+	if err := SignMessage(nil, genPrivateKey, meta); err == nil {
+		t.Error("signing a nil message must fail")
+	}
+}
+
+func TestSelectSignatureAlgorithm(t *testing.T) {
+	ed25519Algo, ed448Algo := algorithmTypes.Ed25519, algorithmTypes.Ed448
+	both := map[algorithmTypes.Signature]interface{}{ed25519Algo: "k1", ed448Algo: "k2"}
+	ed25519Only := map[algorithmTypes.Signature]interface{}{ed25519Algo: "k1"}
+	var tests = []struct {
+		ks        map[algorithmTypes.Signature]interface{}
+		preferred []algorithmTypes.Signature
+		minAlgo   algorithmTypes.Signature
+		want      algorithmTypes.Signature
+	}{
+		{both, []algorithmTypes.Signature{ed25519Algo}, ed25519Algo, ed25519Algo},
+		{both, []algorithmTypes.Signature{ed25519Algo, ed448Algo}, ed25519Algo, ed448Algo},
+		{ed25519Only, []algorithmTypes.Signature{ed448Algo, ed25519Algo}, ed25519Algo, ed25519Algo},
+		{ed25519Only, []algorithmTypes.Signature{ed448Algo}, ed25519Algo, 0}, //no key
+		{both, []algorithmTypes.Signature{ed25519Algo}, ed448Algo, 0},        //policy
+		{both, nil, ed25519Algo, 0}, //no preference
+	}
+	for i, test := range tests {
+		algo, err := SelectSignatureAlgorithm(test.ks, test.preferred, test.minAlgo)
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("%d: selection must fail but returned %v", i, algo)
+			}
+		} else if err != nil || algo != test.want {
+			t.Errorf("%d: wrong algorithm expected=%v actual=%v err=%v", i, test.want, algo, err)
+		}
+	}
+
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	ks := map[algorithmTypes.Signature]interface{}{algorithmTypes.Ed25519: genPrivateKey,
+		algorithmTypes.Ed448: "unused ed448 key"}
+	meta := section.Signature().MetaData()
+	meta.Algorithm = algorithmTypes.Ed448
+	msg := &message.Message{Content: []section.Section{&query.Name{Name: "ethz.ch.",
+		Context: ".", Types: []object.Type{object.OTIP4Addr}}}}
+	err := SignMessageWithPreferredAlgorithm(msg, ks, meta,
+		[]algorithmTypes.Signature{algorithmTypes.Ed25519}, algorithmTypes.Ed25519)
+	if err != nil {
+		t.Fatalf("Was not able to sign message: %v", err)
+	}
+	if len(msg.Signatures) != 1 || msg.Signatures[0].Algorithm != algorithmTypes.Ed25519 {
+		t.Fatalf("preferred algorithm was not recorded in the signature: %v", msg.Signatures)
+	}
+	meta.Algorithm = algorithmTypes.Ed25519
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{meta.PublicKeyID: []keys.PublicKey{keys.PublicKey{
+		PublicKeyID: meta.PublicKeyID,
+		ValidSince:  time.Now().Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         genPublicKey,
+	}}}
+	if !CheckMessageSignatures(msg, ksPub) {
+		t.Error("signature with the preferred algorithm is not valid")
+	}
+}
+
+func TestVerifyDelegationChain(t *testing.T) {
+	rootPub, rootPriv, _ := ed25519.GenerateKey(nil)
+	chPub, chPriv, _ := ed25519.GenerateKey(nil)
+	ethzPub, _, _ := ed25519.GenerateKey(nil)
+	root := keys.PublicKey{
+		PublicKeyID: section.Signature().PublicKeyID,
+		ValidSince:  time.Now().Add(-time.Hour).Unix(),
+		ValidUntil:  time.Now().Add(time.Hour).Unix(),
+		Key:         rootPub,
+	}
+	valid := time.Now().Add(time.Hour).Unix()
+	expired := time.Now().Add(-time.Minute).Unix()
+	ch := newDelegation(".", "ch", chPub, rootPriv, valid, t)
+	ethz := newDelegation("ch.", "ethz", ethzPub, chPriv, valid, t)
+	var tests = []struct {
+		chain []*section.Assertion
+		leaf  string
+		valid bool
+	}{
+		{[]*section.Assertion{}, ".", true},
+		{[]*section.Assertion{ch}, "ch.", true},
+		{[]*section.Assertion{ch, ethz}, "ethz.ch.", true},
+		//wrong leaf
+		{[]*section.Assertion{ch, ethz}, "ch.", false},
+		//missing link
+		{[]*section.Assertion{ethz}, "ethz.ch.", false},
+		//broken link
+		{[]*section.Assertion{ch, newDelegation("ch.", "ethz", ethzPub, rootPriv, valid, t)}, "ethz.ch.", false},
+		//wrong zone
+		{[]*section.Assertion{ch, newDelegation("com.", "ethz", ethzPub, chPriv, valid, t)}, "ethz.com.", false},
+		//expired
+		{[]*section.Assertion{ch, newDelegation("ch.", "ethz", ethzPub, chPriv, expired, t)}, "ethz.ch.", false},
+	}
+	for i, test := range tests {
+		err := VerifyDelegationChain(root, test.chain, test.leaf)
+		if (err == nil) != test.valid {
+			t.Errorf("%d: unexpected delegation chain verification result expected=%v err=%v", i,
+				test.valid, err)
+		}
+	}
+}
+
+func TestMinimalProof(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	valid := time.Now().Add(time.Hour).Unix()
+	root := newDelegation(".", "@", pub, priv, valid, t)
+	ch := newDelegation(".", "ch", pub, priv, valid, t)
+	ethz := newDelegation("ch.", "ethz", pub, priv, valid, t)
+	delegations := map[string]*section.Assertion{
+		".":            root,
+		"ch.":          ch,
+		"ethz.ch.":     ethz,
+		"com.":         newDelegation(".", "com", pub, priv, valid, t),
+		"example.com.": newDelegation("com.", "example", pub, priv, valid, t),
+		"inf.ethz.ch.": newDelegation("ethz.ch.", "inf", pub, priv, valid, t),
+	}
+	answer := &section.Assertion{SubjectName: "www", SubjectZone: "ethz.ch.", Context: "."}
+	var tests = []struct {
+		delegations map[string]*section.Assertion
+		want        []section.Section
+	}{
+		{delegations, []section.Section{answer, ethz, ch, root}},
+		{map[string]*section.Assertion{}, []section.Section{answer}},
+		//missing link
+		{map[string]*section.Assertion{".": root, "ethz.ch.": ethz}, []section.Section{answer, ethz}},
+	}
+	for i, test := range tests {
+		proof := MinimalProof(answer, test.delegations)
+		if len(proof) != len(test.want) {
+			t.Fatalf("%d: wrong proof length expected=%v actual=%v", i, test.want, proof)
+		}
+		for j, s := range proof {
+			if s != test.want[j] {
+				t.Errorf("%d: wrong section %d in proof expected=%v actual=%v", i, j, test.want[j], s)
+			}
+		}
+	}
+}
+
+//newDelegation returns an assertion delegating zone.name to delegKey signed by signKey.
+func newDelegation(zone, name string, delegKey ed25519.PublicKey, signKey ed25519.PrivateKey,
+	validUntil int64, t *testing.T) *section.Assertion {
+	pubKey := keys.PublicKey{PublicKeyID: section.Signature().PublicKeyID, Key: delegKey}
+	a := &section.Assertion{
+		SubjectZone: zone,
+		SubjectName: name,
+		Context:     ".",
+		Content:     []object.Object{object.Object{Type: object.OTDelegation, Value: pubKey}},
+	}
+	sig := section.Signature()
+	sig.ValidUntil = validUntil
+	a.AddSig(sig)
+	if err := SignSectionUnsafe(a, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: signKey}); err != nil {
+		t.Fatalf("Was not able to sign delegation: %v", err)
+	}
+	return a
+}
+
 func TestSignErrors(t *testing.T) {
 	var tests = []struct {
 		section section.WithSig
@@ -88,13 +701,93 @@ func TestCheckSectionSignaturesErrors(t *testing.T) {
 			ValidUntil: time.Now().Add(time.Minute).Unix()}}}, keys1, false}, //VerifySignature invalid
 	}
 	for _, test := range tests {
-		res := checkSectionSignatures(test.input, test.inputPublicKeys, util.MaxCacheValidity{})
+		res := checkSectionSignatures(test.input, test.inputPublicKeys, util.MaxCacheValidity{}, 0)
 		if res != test.want {
 			t.Fatalf("expected=%v, actual=%v, value=%v", test.want, res, test.input)
 		}
 	}
 }
 
+func TestCheckSectionSignaturesWithGrace(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	sig.ValidSince = time.Now().Add(-time.Hour).Unix()
+	sig.ValidUntil = time.Now().Add(time.Hour).Unix()
+	//the key expired a minute ago, after the signature had been created.
+	pubKey := keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID,
+		ValidSince:  time.Now().Add(-2 * time.Hour).Unix(),
+		ValidUntil:  time.Now().Add(-time.Minute).Unix(),
+		Key:         genPublicKey,
+	}
+	ksPub := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{pubKey}}
+	maxVal := util.MaxCacheValidity{AssertionValidity: 24 * time.Hour}
+	var tests = []struct {
+		grace time.Duration
+		valid bool
+	}{
+		{0, false},
+		{time.Hour, true},
+	}
+	for i, test := range tests {
+		a := section.GetAssertion()
+		a.AddSig(sig)
+		ks := map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}
+		if err := SignSectionUnsafe(a, ks); err != nil {
+			t.Fatalf("%d: Was not able to sign assertion: %v", i, err)
+		}
+		if !CheckSectionSignaturesWithGrace(a, ksPub, maxVal, test.grace) {
+			t.Fatalf("%d: signature created while the key was valid does not verify", i)
+		}
+		if valid := a.ValidUntil() > time.Now().Unix(); valid != test.valid {
+			t.Errorf("%d: wrong validity after verification expected=%v actual=%v", i,
+				test.valid, valid)
+		}
+	}
+}
+
+func TestVerifySectionWithKeys(t *testing.T) {
+	genPublicKey, genPrivateKey, _ := ed25519.GenerateKey(nil)
+	otherPublicKey, _, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	sig.ValidSince = time.Now().Add(-time.Hour).Unix()
+	sig.ValidUntil = time.Now().Add(time.Hour).Unix()
+	a := section.GetAssertion()
+	a.AddSig(sig)
+	ks := map[keys.PublicKeyID]interface{}{sig.PublicKeyID: genPrivateKey}
+	if err := SignSectionUnsafe(a, ks); err != nil {
+		t.Fatalf("Was not able to sign assertion: %v", err)
+	}
+	pubKey := func(key ed25519.PublicKey) map[keys.PublicKeyID]keys.PublicKey {
+		return map[keys.PublicKeyID]keys.PublicKey{sig.PublicKeyID: keys.PublicKey{
+			PublicKeyID: sig.PublicKeyID,
+			ValidSince:  sig.ValidSince,
+			ValidUntil:  sig.ValidUntil,
+			Key:         key,
+		}}
+	}
+	var tests = []struct {
+		pkeys map[keys.PublicKeyID]keys.PublicKey
+		at    time.Time
+		valid bool
+	}{
+		{pubKey(genPublicKey), time.Now(), true},
+		{pubKey(otherPublicKey), time.Now(), false},                  //wrong key
+		{map[keys.PublicKeyID]keys.PublicKey{}, time.Now(), false},   //no key
+		{pubKey(genPublicKey), time.Now().Add(2 * time.Hour), false}, //signature expired
+	}
+	for i, test := range tests {
+		valid, err := VerifySectionWithKeys(a, test.pkeys, test.at)
+		if valid != test.valid || (err == nil) != test.valid {
+			t.Errorf("%d: wrong verification result expected=%v actual=%v err=%v", i, test.valid,
+				valid, err)
+		}
+		if len(a.Signatures) != 1 {
+			t.Fatalf("%d: verification modified the signatures: %v", i, a.Signatures)
+		}
+	}
+}
+
 func TestCheckMessageStringFields(t *testing.T) {
 	log.Root().SetHandler(log.DiscardHandler())
 	msg := message.GetMessage()
@@ -251,6 +944,15 @@ func TestValidSectionAndSignature(t *testing.T) {
 		{&section.Assertion{}, signature.Sig{}, false},
 		{&section.Shard{RangeFrom: ":A:"}, signature.Sig{ValidUntil: time.Now().Add(time.Minute).Unix()}, false},
 		{&section.Assertion{}, signature.Sig{ValidUntil: time.Now().Add(time.Minute).Unix()}, true},
+		{&section.Zone{SubjectZone: "ch.", Content: []*section.Assertion{&section.Assertion{SubjectZone: "de."}}},
+			signature.Sig{ValidUntil: time.Now().Add(time.Minute).Unix()}, false},
+		{&section.Zone{SubjectZone: "ch.", Content: []*section.Assertion{&section.Assertion{SubjectZone: "ch."}}},
+			signature.Sig{ValidUntil: time.Now().Add(time.Minute).Unix()}, true},
+		{&section.Assertion{Content: []object.Object{object.Object{Type: object.OTIP4Addr,
+			Value: object.ServiceInfo{}}}}, signature.Sig{ValidUntil: time.Now().Add(time.Minute).Unix()}, false},
+		{&section.Shard{Content: []*section.Assertion{&section.Assertion{Content: []object.Object{
+			object.Object{Type: object.OTRedirection, Value: 42}}}}},
+			signature.Sig{ValidUntil: time.Now().Add(time.Minute).Unix()}, false},
 	}
 	for i, test := range tests {
 		if test.s != nil {
@@ -284,6 +986,102 @@ func TestCheckSignatureNotExpired(t *testing.T) {
 	}
 }
 
+func TestDropFutureSignatures(t *testing.T) {
+	now := signature.Sig{ValidSince: time.Now().Unix()}
+	soon := signature.Sig{ValidSince: time.Now().Add(time.Hour).Unix()}
+	absurd := signature.Sig{ValidSince: time.Now().Add(10 * 365 * 24 * time.Hour).Unix()}
+	var tests = []struct {
+		s        section.WithSig
+		expected bool
+		nofSigs  int
+	}{
+		{&section.Assertion{}, true, 0},
+		{&section.Assertion{Signatures: []signature.Sig{now}}, true, 1},
+		{&section.Assertion{Signatures: []signature.Sig{soon}}, true, 1},
+		{&section.Assertion{Signatures: []signature.Sig{absurd}}, false, 0},
+		{&section.Assertion{Signatures: []signature.Sig{absurd, soon, absurd}}, true, 1},
+		{&section.Shard{Signatures: []signature.Sig{soon}, Content: []*section.Assertion{
+			&section.Assertion{Signatures: []signature.Sig{absurd}}}}, true, 1},
+		{&section.Zone{Signatures: []signature.Sig{soon}, Content: []*section.Assertion{
+			&section.Assertion{Signatures: []signature.Sig{absurd}}}}, true, 1},
+	}
+	for i, test := range tests {
+		ok := DropFutureSignatures(test.s, 24*time.Hour)
+		if ok != test.expected {
+			t.Fatalf("%d: unexpected result. expected=%v actual=%v", i, test.expected, ok)
+		}
+		if len(test.s.AllSigs()) != test.nofSigs {
+			t.Fatalf("%d: unexpected number of remaining signatures. expected=%d actual=%d", i,
+				test.nofSigs, len(test.s.AllSigs()))
+		}
+		switch s := test.s.(type) {
+		case *section.Shard:
+			if len(s.Content[0].AllSigs()) != 0 {
+				t.Fatalf("%d: future signature on contained assertion was not dropped", i)
+			}
+		case *section.Zone:
+			if len(s.Content[0].AllSigs()) != 0 {
+				t.Fatalf("%d: future signature on contained assertion was not dropped", i)
+			}
+		}
+	}
+}
+
+func TestDropWeakSignatures(t *testing.T) {
+	ed25519Sig := signature.Sig{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519}}
+	ed448Sig := signature.Sig{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed448}}
+	var tests = []struct {
+		s            section.WithSig
+		minAlgo      algorithmTypes.Signature
+		expected     bool
+		nofSigs      int
+		nofInnerSigs int
+	}{
+		{nil, algorithmTypes.Ed448, true, 0, 0},
+		{&section.Assertion{}, algorithmTypes.Ed448, true, 0, 0},
+		{&section.Assertion{Signatures: []signature.Sig{ed25519Sig}}, algorithmTypes.Ed25519, true, 1, 0},
+		{&section.Assertion{Signatures: []signature.Sig{ed448Sig}}, algorithmTypes.Ed448, true, 1, 0},
+		//downgrade: the ed448 signature has been stripped
+		{&section.Assertion{Signatures: []signature.Sig{ed25519Sig}}, algorithmTypes.Ed448, false, 0, 0},
+		//dual-signed during an algorithm rollover or a junk weak signature appended
+		{&section.Assertion{Signatures: []signature.Sig{ed448Sig, ed25519Sig}}, algorithmTypes.Ed448, true, 1, 0},
+		{&section.Assertion{Signatures: []signature.Sig{ed25519Sig, ed448Sig}}, algorithmTypes.Ed25519, true, 2, 0},
+		{&section.Shard{Signatures: []signature.Sig{ed448Sig}, Content: []*section.Assertion{
+			&section.Assertion{Signatures: []signature.Sig{ed25519Sig}}}}, algorithmTypes.Ed448, true, 1, 0},
+		{&section.Shard{Signatures: []signature.Sig{ed25519Sig}, Content: []*section.Assertion{
+			&section.Assertion{Signatures: []signature.Sig{ed448Sig}}}}, algorithmTypes.Ed448, false, 0, 1},
+		{&section.Zone{Signatures: []signature.Sig{ed448Sig}, Content: []*section.Assertion{
+			&section.Assertion{Signatures: []signature.Sig{ed25519Sig}}}}, algorithmTypes.Ed448, true, 1, 0},
+		{&section.Zone{Signatures: []signature.Sig{ed448Sig}, Content: []*section.Assertion{
+			&section.Assertion{Signatures: []signature.Sig{ed448Sig, ed25519Sig}}}}, algorithmTypes.Ed448, true, 1, 1},
+	}
+	for i, test := range tests {
+		ok := DropWeakSignatures(test.s, test.minAlgo)
+		if ok != test.expected {
+			t.Fatalf("%d: unexpected result. expected=%v actual=%v", i, test.expected, ok)
+		}
+		if test.s == nil {
+			continue
+		}
+		if n := len(test.s.Sigs(keys.RainsKeySpace)); n != test.nofSigs {
+			t.Errorf("%d: wrong number of remaining signatures expected=%d actual=%d", i, test.nofSigs, n)
+		}
+		var inner []*section.Assertion
+		switch s := test.s.(type) {
+		case *section.Shard:
+			inner = s.Content
+		case *section.Zone:
+			inner = s.Content
+		}
+		for _, a := range inner {
+			if n := len(a.Sigs(keys.RainsKeySpace)); n != test.nofInnerSigs {
+				t.Errorf("%d: wrong number of remaining inner signatures expected=%d actual=%d", i,
+					test.nofInnerSigs, n)
+			}
+		}
+	}
+}
+
 func TestUpdateSectionValidity(t *testing.T) {
 	now := time.Now().Unix()
 	var tests = []struct {
@@ -319,7 +1117,11 @@ func TestUpdateSectionValidity(t *testing.T) {
 		{new(section.Zone), now + 2, now + 4, now + 1, now + 3, util.MaxCacheValidity{ZoneValidity: 1 * time.Second}, now + 1, now + 1},
 	}
 	for i, test := range tests {
-		updateSectionValidity(test.input, test.pkeyValidSince, test.pkeyValidUntil, test.sigValidSince, test.sigValidUntil, test.maxVal)
+		verified := []VerifiedSignature{VerifiedSignature{
+			Sig: signature.Sig{ValidSince: test.sigValidSince, ValidUntil: test.sigValidUntil},
+			Key: keys.PublicKey{ValidSince: test.pkeyValidSince, ValidUntil: test.pkeyValidUntil},
+		}}
+		updateSectionValidity(test.input, verified, test.maxVal)
 		if test.input != nil && test.input.ValidSince() != test.wantValidSince {
 			t.Errorf("%d: ValidSince does not match. expected=%d actual=%d", i, test.wantValidSince, test.input.ValidSince())
 		}
@@ -328,3 +1130,66 @@ func TestUpdateSectionValidity(t *testing.T) {
 		}
 	}
 }
+
+func TestEffectiveValidity(t *testing.T) {
+	verified := func(sigSince, sigUntil, keySince, keyUntil int64) VerifiedSignature {
+		return VerifiedSignature{
+			Sig: signature.Sig{PublicKeyID: keys.PublicKeyID{KeySpace: keys.RainsKeySpace},
+				ValidSince: sigSince, ValidUntil: sigUntil},
+			Key: keys.PublicKey{ValidSince: keySince, ValidUntil: keyUntil},
+		}
+	}
+	var tests = []struct {
+		verified  []VerifiedSignature
+		wantSince int64
+		wantUntil int64
+	}{
+		{nil, 0, 0},
+		{[]VerifiedSignature{verified(10, 20, 0, 100)}, 10, 20},
+		//key window within signature's
+		{[]VerifiedSignature{verified(10, 20, 12, 18)}, 12, 18},
+		//overlapping
+		{[]VerifiedSignature{verified(10, 20, 0, 100), verified(15, 30, 0, 100)}, 10, 30},
+		//overlapping, unordered
+		{[]VerifiedSignature{verified(15, 30, 0, 100), verified(10, 20, 0, 100)}, 10, 30},
+		//nested
+		{[]VerifiedSignature{verified(10, 20, 0, 100), verified(12, 18, 0, 100)}, 10, 20},
+		//disjoint, the gap is included
+		{[]VerifiedSignature{verified(30, 40, 0, 100), verified(10, 20, 0, 100)}, 10, 40},
+		//clipped by the keys
+		{[]VerifiedSignature{verified(10, 30, 0, 25), verified(20, 40, 22, 35)}, 10, 35},
+	}
+	for i, test := range tests {
+		since, until := EffectiveValidity(test.verified)
+		if since != test.wantSince || until != test.wantUntil {
+			t.Errorf("%d: wrong effective validity expected=[%d,%d] actual=[%d,%d]", i,
+				test.wantSince, test.wantUntil, since, until)
+		}
+	}
+}
+
+func TestSectionValidityFromVerifiedSignatures(t *testing.T) {
+	now := time.Now().Unix()
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	sig := section.Signature()
+	sig.ValidSince, sig.ValidUntil = now-10, now+100
+	expired := sig
+	expired.ValidSince, expired.ValidUntil = now-1000, now-500
+	pkeys := map[keys.PublicKeyID][]keys.PublicKey{sig.PublicKeyID: []keys.PublicKey{keys.PublicKey{
+		PublicKeyID: sig.PublicKeyID, ValidSince: now - 2000, ValidUntil: now + 50, Key: pub}}}
+	a := &section.Assertion{SubjectName: "ethz", SubjectZone: "ch.", Context: ".",
+		Content: []object.Object{object.Object{Type: object.OTIP4Addr, Value: net.ParseIP("192.0.2.1")}}}
+	a.AddSig(sig)
+	if err := SignSectionUnsafe(a, map[keys.PublicKeyID]interface{}{sig.PublicKeyID: priv}); err != nil {
+		t.Fatalf("Was not able to sign assertion: %v", err)
+	}
+	a.AddSig(expired)
+	if !CheckSectionSignatures(a, pkeys, util.MaxCacheValidity{AssertionValidity: time.Hour}) {
+		t.Fatal("valid signature was rejected")
+	}
+	//only the verified signature counts and it is clipped by the key's validity
+	if a.ValidSince() != now-10 || a.ValidUntil() != now+50 {
+		t.Errorf("wrong section validity expected=[%d,%d] actual=[%d,%d]", now-10, now+50,
+			a.ValidSince(), a.ValidUntil())
+	}
+}
@@ -68,6 +68,34 @@ type MetaData struct {
 	ValidUntil int64
 }
 
+//CompareTo compares two signature meta data objects and returns 0 if they are equal, 1 if m is
+//greater than data and -1 if m is smaller than data. They are ordered by algorithm, key space, key
+//phase, validSince and validUntil.
+func (m MetaData) CompareTo(data MetaData) int {
+	if m.Algorithm < data.Algorithm {
+		return -1
+	} else if m.Algorithm > data.Algorithm {
+		return 1
+	} else if m.KeySpace < data.KeySpace {
+		return -1
+	} else if m.KeySpace > data.KeySpace {
+		return 1
+	} else if m.KeyPhase < data.KeyPhase {
+		return -1
+	} else if m.KeyPhase > data.KeyPhase {
+		return 1
+	} else if m.ValidSince < data.ValidSince {
+		return -1
+	} else if m.ValidSince > data.ValidSince {
+		return 1
+	} else if m.ValidUntil < data.ValidUntil {
+		return -1
+	} else if m.ValidUntil > data.ValidUntil {
+		return 1
+	}
+	return 0
+}
+
 //Sig contains meta data of the signature and the signature data itself.
 type Sig struct {
 	keys.PublicKeyID
@@ -89,14 +117,51 @@ func (sig Sig) MetaData() MetaData {
 	}
 }
 
+//Copy returns a copy of sig whose data does not share memory with the data of sig. It allows to
+//store a signature whose data slice might be reused by its owner.
+func (sig Sig) Copy() Sig {
+	if data, ok := sig.Data.([]byte); ok {
+		sig.Data = append([]byte(nil), data...)
+	}
+	return sig
+}
+
+//sigDataLength maps the known signature algorithms to the length of their signature data in bytes.
+var sigDataLength = map[algorithmTypes.Signature]int{
+	algorithmTypes.Ed25519: ed25519.SignatureSize,
+	algorithmTypes.Ed448:   114,
+}
+
+//Validate returns an error describing why sig is malformed. A signature is well formed if its
+//algorithm is known, its data has the length of a signature of this algorithm and its validity is
+//not empty. It does not check whether the signature is expired or verifies.
+func (sig Sig) Validate() error {
+	length, ok := sigDataLength[sig.Algorithm]
+	if !ok {
+		return fmt.Errorf("unknown signature algorithm: %v", sig.Algorithm)
+	}
+	data, ok := sig.Data.([]byte)
+	if !ok {
+		return fmt.Errorf("signature data must be a byte slice but is %T", sig.Data)
+	}
+	if len(data) != length {
+		return fmt.Errorf("signature data of %v must be %d bytes long but is %d", sig.Algorithm,
+			length, len(data))
+	}
+	if sig.ValidSince > sig.ValidUntil {
+		return fmt.Errorf("validSince=%d is after validUntil=%d", sig.ValidSince, sig.ValidUntil)
+	}
+	return nil
+}
+
 //String implements Stringer interface
 func (sig Sig) String() string {
 	data := "notYetImplementedInStringMethod"
 	if sig.Algorithm == algorithmTypes.Ed25519 {
 		if sig.Data == nil {
 			data = "nil"
-		} else {
-			data = hex.EncodeToString(sig.Data.([]byte))
+		} else if d, ok := sig.Data.([]byte); ok {
+			data = hex.EncodeToString(d)
 		}
 	}
 	return fmt.Sprintf("{KS=%d AT=%d VS=%d VU=%d KP=%d data=%s}",
@@ -106,26 +171,8 @@ func (sig Sig) String() string {
 //CompareTo compares two signature objects and returns 0 if they are equal, 1 if sig is greater than
 //s and -1 if sig is smaller than s
 func (sig Sig) CompareTo(s Sig) int {
-	if sig.Algorithm < s.Algorithm {
-		return -1
-	} else if sig.Algorithm > s.Algorithm {
-		return 1
-	} else if sig.KeySpace < s.KeySpace {
-		return -1
-	} else if sig.KeySpace > s.KeySpace {
-		return 1
-	} else if sig.KeyPhase < s.KeyPhase {
-		return -1
-	} else if sig.KeyPhase > s.KeyPhase {
-		return 1
-	} else if sig.ValidSince < s.ValidSince {
-		return -1
-	} else if sig.ValidSince > s.ValidSince {
-		return 1
-	} else if sig.ValidUntil < s.ValidUntil {
-		return -1
-	} else if sig.ValidUntil > s.ValidUntil {
-		return 1
+	if comp := sig.MetaData().CompareTo(s.MetaData()); comp != 0 {
+		return comp
 	}
 	switch sig.Algorithm {
 	case algorithmTypes.Ed25519:
@@ -163,8 +210,8 @@ func (sig *Sig) SignData(privateKey interface{}, encoding []byte) error {
 //VerifySignature adds signature meta data to the encoding. It then signs the encoding with privateKey and compares the resulting signature with the sig.Data.
 //Returns true if there exist signatures and they are identical
 func (sig *Sig) VerifySignature(publicKey interface{}, encoding []byte) bool {
-	if sig.Data == nil {
-		log.Warn("sig does not contain signature data", "sig", sig)
+	if err := sig.Validate(); err != nil {
+		log.Warn("Signature is malformed", "sig", sig, "reason", err)
 		return false
 	}
 	if publicKey == nil {
@@ -50,6 +50,38 @@ func TestVerifySignatureErrors(t *testing.T) {
 	}
 }
 
+func TestSigValidate(t *testing.T) {
+	ed25519ID := keys.PublicKeyID{Algorithm: algorithmTypes.Ed25519}
+	data := make([]byte, ed25519.SignatureSize)
+	var tests = []struct {
+		sig      Sig
+		expected string
+	}{
+		{Sig{PublicKeyID: ed25519ID, ValidSince: 1, ValidUntil: 2, Data: data}, ""},
+		{Sig{PublicKeyID: ed25519ID, ValidSince: 2, ValidUntil: 2, Data: data}, ""},
+		{Sig{PublicKeyID: keys.PublicKeyID{Algorithm: algorithmTypes.Ed448}, Data: make([]byte, 114)}, ""},
+		{Sig{Data: data}, "unknown signature algorithm: Signature(0)"},
+		{Sig{PublicKeyID: keys.PublicKeyID{Algorithm: 42}, Data: data},
+			"unknown signature algorithm: Signature(42)"},
+		{Sig{PublicKeyID: ed25519ID}, "signature data must be a byte slice but is <nil>"},
+		{Sig{PublicKeyID: ed25519ID, Data: "sd"}, "signature data must be a byte slice but is string"},
+		{Sig{PublicKeyID: ed25519ID, Data: []byte{}},
+			"signature data of Ed25519 must be 64 bytes long but is 0"},
+		{Sig{PublicKeyID: ed25519ID, Data: data[:63]},
+			"signature data of Ed25519 must be 64 bytes long but is 63"},
+		{Sig{PublicKeyID: ed25519ID, ValidSince: 3, ValidUntil: 2, Data: data},
+			"validSince=3 is after validUntil=2"},
+	}
+	for i, test := range tests {
+		err := test.sig.Validate()
+		if test.expected == "" && err != nil {
+			t.Errorf("%d: well formed signature is rejected: %v", i, err)
+		} else if test.expected != "" && (err == nil || err.Error() != test.expected) {
+			t.Errorf("%d: wrong validation result expected=%s actual=%v", i, test.expected, err)
+		}
+	}
+}
+
 func TestSigCompareTo(t *testing.T) {
 	sigs := sortedSigs()
 	shuffled := append([]Sig{}, sigs...)
@@ -67,6 +99,31 @@ func TestSigCompareTo(t *testing.T) {
 	}
 }
 
+func TestMetaDataCompareTo(t *testing.T) {
+	var sorted []MetaData
+	for _, sig := range sortedSigs() {
+		if len(sorted) == 0 || sorted[len(sorted)-1] != sig.MetaData() {
+			sorted = append(sorted, sig.MetaData())
+		}
+	}
+	for run := 0; run < 10; run++ {
+		set := make(map[MetaData]bool)
+		for _, m := range sorted {
+			set[m] = true
+		}
+		var result []MetaData
+		for m := range set {
+			result = append(result, m)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].CompareTo(result[j]) < 0
+		})
+		if !reflect.DeepEqual(sorted, result) {
+			t.Fatalf("%d: compareTo did not work correctly: sorted=%v result=%v", run, sorted, result)
+		}
+	}
+}
+
 func sortedSigs() []Sig {
 	sigs := []Sig{}
 	for i := 0; i < 1; i++ {
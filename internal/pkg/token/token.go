@@ -22,6 +22,12 @@ func Compare(a, b Token) int {
 	return bytes.Compare(a[:], b[:])
 }
 
+//IsZero returns true if all bytes of t are zero. Such a token is not unique and must not be used
+//to correlate messages.
+func (t Token) IsZero() bool {
+	return t == Token{}
+}
+
 //New generates a new unique Token
 func New() Token {
 	token := [16]byte{}
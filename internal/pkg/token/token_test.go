@@ -11,3 +11,19 @@ func TestGenerateToken(t *testing.T) {
 		t.Errorf("Subsequent generated tokens should not have the same value t1=%s t2=%s", t1, t2)
 	}
 }
+
+func TestIsZero(t *testing.T) {
+	var tests = []struct {
+		input Token
+		want  bool
+	}{
+		{Token{}, true},
+		{New(), false},
+		{Token{15: 1}, false},
+	}
+	for i, test := range tests {
+		if test.input.IsZero() != test.want {
+			t.Errorf("%d: wrong result for %s expected=%v", i, test.input, test.want)
+		}
+	}
+}
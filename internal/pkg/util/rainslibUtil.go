@@ -133,41 +133,67 @@ func NewNotificationMessage(tok token.Token, t section.NotificationType, data st
 //or an error.
 func SendQuery(msg message.Message, addr net.Addr, timeout time.Duration) (
 	message.Message, error) {
-	conn, err := connection.CreateConnection(addr)
+	answer, _, err := SendQueryRaw(msg, addr, timeout)
+	return answer, err
+}
+
+//SendQueryRaw behaves like SendQuery but additionally returns the encoding of the answer as it
+//has been received.
+func SendQueryRaw(msg message.Message, addr net.Addr, timeout time.Duration) (
+	message.Message, []byte, error) {
+	return SendQueryVia(msg, addr, timeout, nil)
+}
+
+//SendQueryVia behaves like SendQueryRaw but opens the connection to addr with dialer, e.g. through
+//a proxy. The connection is opened directly if dialer is nil.
+func SendQueryVia(msg message.Message, addr net.Addr, timeout time.Duration,
+	dialer connection.Dialer) (message.Message, []byte, error) {
+	conn, err := connection.CreateConnectionVia(addr, dialer)
 	if err != nil {
-		return message.Message{}, err
+		return message.Message{}, nil, err
 	}
 	defer conn.Close()
 
-	done := make(chan message.Message)
-	ec := make(chan error)
-	go connection.Listen(conn, msg.Token, done, ec)
+	type response struct {
+		msg  message.Message
+		data []byte
+	}
+	done := make(chan response, 1)
+	ec := make(chan error, 1)
+	go func() {
+		answer, data, err := connection.Receive(conn, msg.Token)
+		if err != nil {
+			ec <- err
+			return
+		}
+		done <- response{msg: answer, data: data}
+	}()
 
 	switch addr.(type) {
-	case *net.TCPAddr:
+	case *net.TCPAddr, *net.UnixAddr:
 		writer := cbor.NewWriter(conn)
 		if err := writer.Marshal(&msg); err != nil {
-			return message.Message{}, fmt.Errorf("failed to marshal message: %v", err)
+			return message.Message{}, nil, fmt.Errorf("failed to marshal message: %v", err)
 		}
 	case *snet.Addr:
 		encoding := new(bytes.Buffer)
 		if err := cbor.NewWriter(encoding).Marshal(&msg); err != nil {
-			return message.Message{}, fmt.Errorf("failed to marshal message to conn: %v", err)
+			return message.Message{}, nil, fmt.Errorf("failed to marshal message to conn: %v", err)
 		}
 		if _, err := conn.Write(encoding.Bytes()); err != nil {
-			return message.Message{}, fmt.Errorf("unable to write encoded message to connection: %v", err)
+			return message.Message{}, nil, fmt.Errorf("unable to write encoded message to connection: %v", err)
 		}
 	default:
 		log.Error("Unsupported connection information type.", "conn", conn)
 	}
 
 	select {
-	case msg := <-done:
-		return msg, nil
+	case r := <-done:
+		return r.msg, r.data, nil
 	case err := <-ec:
-		return message.Message{}, err
+		return message.Message{}, nil, err
 	case <-time.After(timeout):
-		return message.Message{}, fmt.Errorf("timed out waiting for response")
+		return message.Message{}, nil, fmt.Errorf("timed out waiting for response")
 	}
 }
 
@@ -1,9 +1,16 @@
 package util
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/netsec-ethz/rains/internal/pkg/cbor"
+	"github.com/netsec-ethz/rains/internal/pkg/connection"
 	"github.com/netsec-ethz/rains/internal/pkg/message"
 	"github.com/netsec-ethz/rains/internal/pkg/signature"
 
@@ -167,3 +174,48 @@ func TestGetOverlapValidityForSignatures(t *testing.T) {
 		t.Errorf("Wrong range for validity of signature collection (%d, %d)", since, until)
 	}
 }
+
+func TestSendQueryUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rains")
+	if err != nil {
+		t.Fatalf("Was not able to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for i, network := range []string{"unix", "unixpacket"} {
+		addr := &net.UnixAddr{Name: filepath.Join(dir, network+".sock"), Net: network}
+		listener, err := net.Listen(network, addr.Name)
+		if err != nil {
+			t.Fatalf("%d: Was not able to listen: %v", i, err)
+		}
+		answer := &section.Assertion{SubjectName: testSubjectName, SubjectZone: testZone,
+			Context: globalContext, Content: []object.Object{object.NameObject()}}
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn = connection.NewUnixConn(conn, nil)
+			msg := message.Message{}
+			if err := cbor.NewReader(conn).Unmarshal(&msg); err != nil {
+				return
+			}
+			reply := message.Message{Token: msg.Token, Content: []section.Section{answer}}
+			cbor.NewWriter(conn).Marshal(&reply)
+		}()
+		tok := token.New()
+		msg := NewQueryMessage(testSubjectName+"."+testZone, globalContext, time.Now().Add(time.Second).Unix(),
+			[]object.Type{object.OTName}, nil, tok)
+		reply, err := SendQuery(msg, addr, time.Second)
+		listener.Close()
+		if err != nil {
+			t.Fatalf("%d: query over %s socket failed: %v", i, network, err)
+		}
+		if reply.Token != tok || len(reply.Content) != 1 {
+			t.Fatalf("%d: unexpected answer over %s socket: %v", i, network, reply)
+		}
+		if a, ok := reply.Content[0].(*section.Assertion); !ok || a.CompareTo(answer) != 0 {
+			t.Errorf("%d: answer has wrong content expected=%v actual=%v", i, answer, reply.Content[0])
+		}
+	}
+}
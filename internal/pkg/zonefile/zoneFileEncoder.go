@@ -196,6 +196,9 @@ func encodeObjects(o []object.Object, indent string) string {
 			encoding += fmt.Sprintf("%s%s", addIndentToType(TypeRegistrar), obj.Value)
 		case object.OTRegistrant:
 			encoding += fmt.Sprintf("%s%s", addIndentToType(TypeRegistrant), obj.Value)
+		case object.OTText:
+			//text is quoted as it may contain whitespace.
+			encoding += fmt.Sprintf("%s%q", addIndentToType(TypeText), obj.Value)
 		case object.OTInfraKey:
 			if pkey, ok := obj.Value.(keys.PublicKey); ok {
 				encoding += fmt.Sprintf("%s%s", addIndentToType(TypeInfraKey), encodeEd25519PublicKey(pkey))
@@ -217,6 +220,13 @@ func encodeObjects(o []object.Object, indent string) string {
 				log.Warn("Type assertion failed. Expected object.OTNextKey ", "actualType", fmt.Sprintf("%T", obj.Value))
 				return ""
 			}
+		case object.OTZoneMetadata:
+			if md, ok := obj.Value.(object.ZoneMetadata); ok {
+				encoding += fmt.Sprintf("%s%s %d %d %d %d", addIndentToType(TypeZoneMetadata), md.PrimaryServer, md.Serial, md.Refresh, md.Retry, md.Expire)
+			} else {
+				log.Warn("Type assertion failed. Expected object.ZoneMetadata", "actualType", fmt.Sprintf("%T", obj.Value))
+				return ""
+			}
 		default:
 			log.Warn("Unsupported obj type", "type", fmt.Sprintf("%T", obj.Type))
 			return ""
@@ -268,6 +278,10 @@ func encodeNameObject(no object.Name) string {
 			nameObject = append(nameObject, TypeExternalKey)
 		case object.OTNextKey:
 			nameObject = append(nameObject, TypeNextKey)
+		case object.OTZoneMetadata:
+			nameObject = append(nameObject, TypeZoneMetadata)
+		case object.OTText:
+			nameObject = append(nameObject, TypeText)
 		default:
 			log.Warn("Unsupported object type in nameObject", "actualType", oType, "nameObject", no)
 		}
@@ -35,6 +35,8 @@ const (
 	TypeInfraKey      = ":infra:"
 	TypeExternalKey   = ":extra:"
 	TypeNextKey       = ":next:"
+	TypeZoneMetadata  = ":meta:"
+	TypeText          = ":text:"
 	TypeEd25519       = ":ed25519:"
 	TypeUnspecified   = ":unspecified:"
 	TypePTTLS         = ":tls:"
@@ -24,6 +24,8 @@ const (
 	OTNextKey
 	OTScionAddr6
 	OTScionAddr4
+	OTZoneMetadata
+	OTText
 )
 
 //AllTypes returns all object types
@@ -31,7 +33,7 @@ func AllTypes() []Type {
 	return []Type{OTName, OTIP6Addr, OTIP4Addr, OTRedirection,
 		OTDelegation, OTNameset, OTCertInfo, OTServiceInfo,
 		OTRegistrar, OTRegistrant, OTInfraKey, OTExtraKey,
-		OTNextKey, OTScionAddr6, OTScionAddr4}
+		OTNextKey, OTScionAddr6, OTScionAddr4, OTZoneMetadata, OTText}
 }
 
 func convertTyps(types []Type) []object.Type {
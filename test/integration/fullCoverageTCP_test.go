@@ -260,7 +260,7 @@ func keySetup(t *testing.T, keyPath string) {
 }
 
 func checkCheckpoint(t *testing.T) {
-	checkpointPath := "testdata/checkpoint/resolver/zoneKeyCheckPoint.gob"
+	checkpointPath := "testdata/checkpoint/resolver/zoneKeyCheckPoint.cbor"
 	var modTime time.Time
 	if info, err := os.Stat(checkpointPath); err == nil {
 		modTime = info.ModTime()